@@ -1,15 +1,22 @@
 package main
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 func main() {
@@ -18,6 +25,9 @@ func main() {
 		migrationType = flag.String("type", "base", "Migration type: 'base' or 'tenant'")
 		tenantSchema = flag.String("tenant-schema", "", "Tenant schema name (required for tenant migrations)")
 		sqlFile      = flag.String("sql-file", "", "SQL file to execute")
+		migrationsDir = flag.String("dir", "../sql", "Directory of numbered migration files for base and tenant migrations")
+		noTransaction = flag.Bool("no-transaction", false, "Run the -type custom file outside a transaction (needed for e.g. CREATE INDEX CONCURRENTLY)")
+		status        = flag.Bool("status", false, "Print applied and pending migrations for -type without running them")
 	)
 	flag.Parse()
 
@@ -36,9 +46,30 @@ func main() {
 		log.Fatalf("Failed to ping database: %v", err)
 	}
 
+	if err := ensureMigrationsTable(db); err != nil {
+		log.Fatalf("Failed to create schema_migrations table: %v", err)
+	}
+
+	// Numbered files opt out of a transaction one at a time with
+	// noTransactionMarker, so the flag can't also take it from the rest
+	if *noTransaction && *migrationType != "custom" {
+		log.Fatalf("-no-transaction only applies to -type custom; start numbered files with %q instead", noTransactionMarker)
+	}
+
+	if *status {
+		files, err := migrationFiles(*migrationType, *migrationsDir, *tenantSchema, *sqlFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := printStatus(os.Stdout, db, files, *tenantSchema); err != nil {
+			log.Fatalf("Failed to read migration status: %v", err)
+		}
+		return
+	}
+
 	switch *migrationType {
 	case "base":
-		if err := runBaseMigrations(db); err != nil {
+		if err := runBaseMigrations(db, *migrationsDir, true); err != nil {
 			log.Fatalf("Failed to run base migrations: %v", err)
 		}
 		fmt.Println("Base migrations completed successfully")
@@ -47,7 +78,7 @@ func main() {
 		if *tenantSchema == "" {
 			log.Fatal("tenant-schema is required for tenant migrations")
 		}
-		if err := runTenantMigrations(db, *tenantSchema); err != nil {
+		if err := runTenantMigrations(db, *migrationsDir, *tenantSchema, true); err != nil {
 			log.Fatalf("Failed to run tenant migrations: %v", err)
 		}
 		fmt.Printf("Tenant migrations completed successfully for schema: %s\n", *tenantSchema)
@@ -56,7 +87,7 @@ func main() {
 		if *sqlFile == "" {
 			log.Fatal("sql-file is required for custom migrations")
 		}
-		if err := runCustomMigration(db, *sqlFile, *tenantSchema); err != nil {
+		if err := runCustomMigration(db, *sqlFile, *tenantSchema, !*noTransaction); err != nil {
 			log.Fatalf("Failed to run custom migration: %v", err)
 		}
 		fmt.Printf("Custom migration completed successfully: %s\n", *sqlFile)
@@ -66,57 +97,424 @@ func main() {
 	}
 }
 
-func runBaseMigrations(db *sql.DB) error {
-	sqlFile := "../sql/001_create_base_schema.sql"
-	return executeSQLFile(db, sqlFile, "")
+func runBaseMigrations(db *sql.DB, dir string, useTransaction bool) error {
+	files, err := migrationFiles("base", dir, "", "")
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := applyMigrationFile(db, file, "", useTransaction); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func runTenantMigrations(db *sql.DB, tenantSchema string) error {
+func runTenantMigrations(db *sql.DB, dir string, tenantSchema string, useTransaction bool) error {
+	if err := validateSchemaName(tenantSchema); err != nil {
+		return err
+	}
+	files, err := migrationFiles("tenant", dir, tenantSchema, "")
+	if err != nil {
+		return err
+	}
+
 	// First create the schema
-	_, err := db.Exec(fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", tenantSchema))
+	_, err = db.Exec(fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", pq.QuoteIdentifier(tenantSchema)))
 	if err != nil {
 		return fmt.Errorf("failed to create schema %s: %v", tenantSchema, err)
 	}
 
-	// Run the tenant template migration
-	sqlFile := "../sql/002_create_tenant_schema_template.sql"
-	return executeSQLFile(db, sqlFile, tenantSchema)
+	// Run the tenant template migrations
+	for _, file := range files {
+		if err := applyMigrationFile(db, file, tenantSchema, useTransaction); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func runCustomMigration(db *sql.DB, sqlFile string, tenantSchema string) error {
-	return executeSQLFile(db, sqlFile, tenantSchema)
+func runCustomMigration(db *sql.DB, sqlFile string, tenantSchema string, useTransaction bool) error {
+	return applyMigrationFile(db, sqlFile, tenantSchema, useTransaction)
+}
+
+// schemaNamePattern restricts tenant schemas to plain lowercase identifiers,
+// since the name is substituted into SQL for {{TENANT_SCHEMA}}
+var schemaNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// validateSchemaName rejects tenant schema names that aren't safe to use
+// unquoted in SQL
+func validateSchemaName(name string) error {
+	if !schemaNamePattern.MatchString(name) || len(name) > 63 {
+		return fmt.Errorf("invalid tenant schema name %q: must match %s and be at most 63 characters", name, schemaNamePattern)
+	}
+	return nil
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// ensureMigrationsTable creates the table recording applied migrations
+func ensureMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS public.schema_migrations (
+		version VARCHAR(255) PRIMARY KEY,
+		applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		checksum VARCHAR(64) NOT NULL
+	)`)
+	return err
+}
+
+// migrationVersion names a migration file in schema_migrations. Tenant
+// templates are applied once per schema, so the schema is part of the version.
+func migrationVersion(filename string, tenantSchema string) string {
+	version := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	if tenantSchema != "" {
+		version = tenantSchema + "/" + version
+	}
+	return version
 }
 
-func executeSQLFile(db *sql.DB, filename string, tenantSchema string) error {
+// noTransactionMarker, as the first line of a SQL file, runs the file outside
+// a transaction
+const noTransactionMarker = "-- migrate:no-transaction"
+
+// applyMigrationFile executes a SQL file unless schema_migrations shows it
+// was already applied. A recorded file whose contents changed is an error.
+// With useTransaction the file's statements and its schema_migrations row are
+// committed together or not at all, unless the file starts with
+// noTransactionMarker.
+func applyMigrationFile(db *sql.DB, filename string, tenantSchema string, useTransaction bool) error {
+	if tenantSchema != "" {
+		if err := validateSchemaName(tenantSchema); err != nil {
+			return err
+		}
+	}
+
 	// Read SQL file
 	content, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return fmt.Errorf("failed to read SQL file %s: %v", filename, err)
 	}
+	if hasNoTransactionMarker(string(content)) {
+		useTransaction = false
+	}
+
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+	version := migrationVersion(filename, tenantSchema)
+
+	appliedChecksum, applied, err := lookupMigration(db, version)
+	if err != nil {
+		return err
+	}
+	if applied {
+		if appliedChecksum != checksum {
+			return fmt.Errorf("migration %s was modified after it was applied (checksum %s, recorded %s)", version, checksum, appliedChecksum)
+		}
+		fmt.Printf("Skipping %s, already applied\n", version)
+		return nil
+	}
+
+	if !useTransaction {
+		if err := executeSQL(db, string(content), tenantSchema); err != nil {
+			return err
+		}
+		return recordMigration(db, version, checksum)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+
+	if err := executeSQL(tx, string(content), tenantSchema); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("migration %s: %v (rollback failed: %v)", version, err, rbErr)
+		}
+		fmt.Printf("Rolled back %s\n", version)
+		return fmt.Errorf("migration %s: %v", version, err)
+	}
+	if err := recordMigration(tx, version, checksum); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %s: %v", version, err)
+	}
+	return nil
+}
+
+// hasNoTransactionMarker reports whether content starts with
+// noTransactionMarker
+func hasNoTransactionMarker(content string) bool {
+	firstLine := content
+	if i := strings.IndexByte(content, '\n'); i >= 0 {
+		firstLine = content[:i]
+	}
+	return strings.TrimSpace(firstLine) == noTransactionMarker
+}
+
+// lookupMigration returns the checksum recorded for version, if it was applied
+func lookupMigration(db *sql.DB, version string) (string, bool, error) {
+	var checksum string
+	err := db.QueryRow("SELECT checksum FROM public.schema_migrations WHERE version = $1", version).Scan(&checksum)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", false, nil
+	case err != nil:
+		return "", false, fmt.Errorf("failed to look up migration %s: %v", version, err)
+	}
+	return checksum, true, nil
+}
+
+// migrationFiles returns the SQL files a run of the given type would apply
+func migrationFiles(migrationType string, dir string, tenantSchema string, sqlFile string) ([]string, error) {
+	switch migrationType {
+	case "base":
+		return scanMigrations(dir, false)
+	case "tenant":
+		if tenantSchema == "" {
+			return nil, fmt.Errorf("tenant-schema is required for tenant migrations")
+		}
+		return scanMigrations(dir, true)
+	case "custom":
+		if sqlFile == "" {
+			return nil, fmt.Errorf("sql-file is required for custom migrations")
+		}
+		return []string{sqlFile}, nil
+	}
+	return nil, fmt.Errorf("invalid migration type: %s. Must be 'base', 'tenant', or 'custom'", migrationType)
+}
+
+// scanMigrations lists the numbered .sql files in dir, ordered by their
+// numeric prefix. Files named like 002_tenant_*.sql are tenant templates and
+// are returned only when tenant is set; the rest are base migrations. A
+// tenant template must use the {{TENANT_SCHEMA}} placeholder and a base
+// migration must not, so a misnamed file is an error rather than being run
+// against the wrong schema. Files without a numeric prefix are ignored.
+func scanMigrations(dir string, tenant bool) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations directory %s: %v", dir, err)
+	}
+
+	type migration struct {
+		number int
+		path   string
+	}
+	var migrations []migration
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+		number, ok := migrationNumber(entry.Name())
+		if !ok {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SQL file %s: %v", path, err)
+		}
+		isTenant := isTenantTemplate(entry.Name())
+		if strings.Contains(string(content), "{{TENANT_SCHEMA}}") != isTenant {
+			if isTenant {
+				return nil, fmt.Errorf("tenant template %s does not use {{TENANT_SCHEMA}}", path)
+			}
+			return nil, fmt.Errorf("migration %s uses {{TENANT_SCHEMA}} but is not named as a tenant template (NNN_tenant_*.sql)", path)
+		}
+		if isTenant != tenant {
+			continue
+		}
+		migrations = append(migrations, migration{number: number, path: path})
+	}
+
+	sort.SliceStable(migrations, func(i, j int) bool {
+		return migrations[i].number < migrations[j].number
+	})
 
-	sqlContent := string(content)
+	files := make([]string, len(migrations))
+	for i, m := range migrations {
+		files[i] = m.path
+	}
+	return files, nil
+}
+
+// migrationNumber parses the numeric prefix of a name like 010_add_index.sql
+func migrationNumber(name string) (int, bool) {
+	prefix := name
+	if i := strings.IndexByte(name, '_'); i >= 0 {
+		prefix = name[:i]
+	}
+	number, err := strconv.Atoi(prefix)
+	return number, err == nil
+}
+
+// isTenantTemplate reports whether name, after its numeric prefix, starts
+// with "tenant"
+func isTenantTemplate(name string) bool {
+	i := strings.IndexByte(name, '_')
+	return i >= 0 && strings.HasPrefix(name[i+1:], "tenant")
+}
+
+// printStatus writes one line per file saying whether it is applied, pending,
+// or was modified after being applied
+func printStatus(w io.Writer, db *sql.DB, files []string, tenantSchema string) error {
+	for _, filename := range files {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return fmt.Errorf("failed to read SQL file %s: %v", filename, err)
+		}
+		sum := sha256.Sum256(content)
+		version := migrationVersion(filename, tenantSchema)
 
+		appliedChecksum, applied, err := lookupMigration(db, version)
+		if err != nil {
+			return err
+		}
+
+		state := "pending"
+		if applied {
+			state = "applied"
+			if appliedChecksum != hex.EncodeToString(sum[:]) {
+				state = "modified"
+			}
+		}
+		fmt.Fprintf(w, "%-9s %s\n", state, version)
+	}
+	return nil
+}
+
+func recordMigration(db execer, version string, checksum string) error {
+	_, err := db.Exec("INSERT INTO public.schema_migrations (version, checksum) VALUES ($1, $2)", version, checksum)
+	if err != nil {
+		return fmt.Errorf("failed to record migration %s: %v", version, err)
+	}
+	return nil
+}
+
+func executeSQL(db execer, sqlContent string, tenantSchema string) error {
 	// Replace tenant schema placeholder if provided
 	if tenantSchema != "" {
 		sqlContent = strings.ReplaceAll(sqlContent, "{{TENANT_SCHEMA}}", tenantSchema)
 	}
 
 	// Split SQL content into individual statements
-	statements := strings.Split(sqlContent, ";")
+	statements := splitStatements(sqlContent)
 
 	// Execute each statement
 	for i, statement := range statements {
-		statement = strings.TrimSpace(statement)
-		if statement == "" || strings.HasPrefix(statement, "--") {
-			continue
-		}
-
-		fmt.Printf("Executing statement %d...\n", i+1)
+		fmt.Printf("Executing statement %d of %d...\n", i+1, len(statements))
 		_, err := db.Exec(statement)
 		if err != nil {
-			return fmt.Errorf("failed to execute statement %d: %v\nStatement: %s", i+1, err, statement)
+			fmt.Printf("Statement %d of %d failed: %v\n", i+1, len(statements), err)
+			return fmt.Errorf("failed to execute statement %d of %d: %v\nStatement: %s", i+1, len(statements), err, statement)
 		}
 	}
 
 	return nil
 }
+
+// splitStatements splits SQL content on top-level semicolons. Semicolons inside
+// quoted strings and identifiers, dollar-quoted bodies, and comments don't end
+// a statement. Statements made up only of whitespace and comments are dropped.
+func splitStatements(sqlContent string) []string {
+	var statements []string
+	start := 0
+	hasCode := false
+
+	emit := func(end int) {
+		if hasCode {
+			statements = append(statements, strings.TrimSpace(sqlContent[start:end]))
+		}
+		start = end + 1
+		hasCode = false
+	}
+
+	for i := 0; i < len(sqlContent); i++ {
+		c := sqlContent[i]
+		switch {
+		case c == ';':
+			emit(i)
+		case c == '-' && strings.HasPrefix(sqlContent[i:], "--"):
+			end := strings.IndexByte(sqlContent[i:], '\n')
+			if end < 0 {
+				i = len(sqlContent) - 1
+			} else {
+				i += end
+			}
+		case c == '/' && strings.HasPrefix(sqlContent[i:], "/*"):
+			i = skipBlockComment(sqlContent, i) - 1
+		case c == '\'' || c == '"':
+			// A doubled quote is an escape, which is the same as closing and
+			// reopening, so matching the next quote is enough
+			end := strings.IndexByte(sqlContent[i+1:], c)
+			if end < 0 {
+				i = len(sqlContent) - 1
+			} else {
+				i += end + 1
+			}
+			hasCode = true
+		case c == '$':
+			hasCode = true
+			tag, ok := dollarQuoteTag(sqlContent[i:])
+			if !ok {
+				continue
+			}
+			end := strings.Index(sqlContent[i+len(tag):], tag)
+			if end < 0 {
+				i = len(sqlContent) - 1
+			} else {
+				i += len(tag) + end + len(tag) - 1
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+		default:
+			hasCode = true
+		}
+	}
+	emit(len(sqlContent))
+
+	return statements
+}
+
+// skipBlockComment returns the index just past the block comment opening at
+// start. PostgreSQL block comments nest.
+func skipBlockComment(sqlContent string, start int) int {
+	depth := 0
+	for i := start; i < len(sqlContent)-1; i++ {
+		switch sqlContent[i : i+2] {
+		case "/*":
+			depth++
+			i++
+		case "*/":
+			depth--
+			i++
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(sqlContent)
+}
+
+// dollarQuoteTag returns the $tag$ opening a dollar-quoted string, if s starts
+// with one. Tags follow identifier rules, so positional parameters like $1
+// aren't mistaken for them.
+func dollarQuoteTag(s string) (string, bool) {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '$':
+			return s[:i+1], true
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80:
+		case c >= '0' && c <= '9' && i > 1:
+		default:
+			return "", false
+		}
+	}
+	return "", false
+}
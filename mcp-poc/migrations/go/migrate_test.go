@@ -0,0 +1,568 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDB is an in-memory stand-in for Postgres. It records executed
+// statements and understands the schema_migrations queries used by the tool.
+// Work done inside a transaction only becomes visible on commit.
+type fakeDB struct {
+	mutex      sync.Mutex
+	executed   []string
+	migrations map[string]string
+
+	// failOn makes any statement containing it fail
+	failOn string
+}
+
+var (
+	fakeDBsMutex sync.Mutex
+	fakeDBs      = make(map[string]*fakeDB)
+)
+
+func init() {
+	sql.Register("fakepg", fakeDriver{})
+}
+
+// openFakeDB returns a database handle backed by a fresh fakeDB
+func openFakeDB(t *testing.T) (*sql.DB, *fakeDB) {
+	t.Helper()
+
+	fake := &fakeDB{migrations: make(map[string]string)}
+	fakeDBsMutex.Lock()
+	fakeDBs[t.Name()] = fake
+	fakeDBsMutex.Unlock()
+
+	db, err := sql.Open("fakepg", t.Name())
+	if err != nil {
+		t.Fatalf("failed to open fake database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db, fake
+}
+
+func (f *fakeDB) statements() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string(nil), f.executed...)
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDBsMutex.Lock()
+	defer fakeDBsMutex.Unlock()
+
+	fake, ok := fakeDBs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown fake database %q", name)
+	}
+	return &fakeConn{db: fake}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+	tx *fakeTx
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	if c.tx != nil {
+		return nil, errors.New("transaction already in progress")
+	}
+	c.tx = &fakeTx{conn: c, migrations: make(map[string]string)}
+	return c.tx, nil
+}
+
+// fakeTx buffers statements and recorded migrations until commit
+type fakeTx struct {
+	conn       *fakeConn
+	executed   []string
+	migrations map[string]string
+}
+
+func (tx *fakeTx) Commit() error {
+	db := tx.conn.db
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	db.executed = append(db.executed, tx.executed...)
+	for version, checksum := range tx.migrations {
+		db.migrations[version] = checksum
+	}
+	tx.conn.tx = nil
+	return nil
+}
+
+func (tx *fakeTx) Rollback() error {
+	tx.conn.tx = nil
+	return nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	db := s.conn.db
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.failOn != "" && strings.Contains(s.query, db.failOn) {
+		return nil, fmt.Errorf("simulated failure")
+	}
+
+	executed, migrations := &db.executed, db.migrations
+	if tx := s.conn.tx; tx != nil {
+		executed, migrations = &tx.executed, tx.migrations
+	}
+
+	if strings.HasPrefix(s.query, "INSERT INTO public.schema_migrations") {
+		migrations[args[0].(string)] = args[1].(string)
+		return driver.RowsAffected(1), nil
+	}
+	if !strings.Contains(s.query, "schema_migrations") {
+		*executed = append(*executed, s.query)
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	db := s.conn.db
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if !strings.HasPrefix(s.query, "SELECT checksum FROM public.schema_migrations") {
+		return nil, fmt.Errorf("unexpected query: %s", s.query)
+	}
+
+	rows := &fakeRows{columns: []string{"checksum"}}
+	if checksum, ok := db.migrations[args[0].(string)]; ok {
+		rows.values = [][]driver.Value{{checksum}}
+	}
+	return rows, nil
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func writeMigration(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write migration: %v", err)
+	}
+	return path
+}
+
+func TestApplyMigrationFileIsIdempotent(t *testing.T) {
+	db, fake := openFakeDB(t)
+	if err := ensureMigrationsTable(db); err != nil {
+		t.Fatalf("ensureMigrationsTable: %v", err)
+	}
+
+	path := writeMigration(t, t.TempDir(), "001_create_things.sql",
+		"CREATE TABLE things (id INT);\nCREATE INDEX things_id ON things (id);\n")
+
+	if err := applyMigrationFile(db, path, "", true); err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	if got := len(fake.statements()); got != 2 {
+		t.Fatalf("first run executed %d statements, want 2", got)
+	}
+	if _, ok := fake.migrations["001_create_things"]; !ok {
+		t.Fatalf("migration was not recorded: %v", fake.migrations)
+	}
+
+	if err := applyMigrationFile(db, path, "", true); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if got := len(fake.statements()); got != 2 {
+		t.Fatalf("second run executed statements again, total %d", got)
+	}
+}
+
+func TestApplyMigrationFileDetectsChangedChecksum(t *testing.T) {
+	db, fake := openFakeDB(t)
+
+	dir := t.TempDir()
+	path := writeMigration(t, dir, "001_create_things.sql", "CREATE TABLE things (id INT);")
+	if err := applyMigrationFile(db, path, "", true); err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+
+	writeMigration(t, dir, "001_create_things.sql", "CREATE TABLE things (id BIGINT);")
+	err := applyMigrationFile(db, path, "", true)
+	if err == nil || !strings.Contains(err.Error(), "modified") {
+		t.Fatalf("expected checksum error, got %v", err)
+	}
+	if got := len(fake.statements()); got != 1 {
+		t.Fatalf("changed migration was executed, total %d statements", got)
+	}
+}
+
+func TestApplyMigrationFileTracksTenantsSeparately(t *testing.T) {
+	db, fake := openFakeDB(t)
+
+	path := writeMigration(t, t.TempDir(), "002_tenant.sql", "CREATE TABLE {{TENANT_SCHEMA}}.contexts (id INT);")
+	for _, schema := range []string{"tenant_a", "tenant_b", "tenant_a"} {
+		if err := applyMigrationFile(db, path, schema, true); err != nil {
+			t.Fatalf("apply for %s: %v", schema, err)
+		}
+	}
+
+	want := []string{
+		"CREATE TABLE tenant_a.contexts (id INT)",
+		"CREATE TABLE tenant_b.contexts (id INT)",
+	}
+	if got := fake.statements(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("executed %v, want %v", got, want)
+	}
+	if len(fake.migrations) != 2 {
+		t.Fatalf("recorded %v, want one entry per tenant", fake.migrations)
+	}
+}
+
+func TestApplyMigrationFileRollsBackOnFailure(t *testing.T) {
+	content := `CREATE TABLE one (id INT);
+CREATE TABLE two (id INT);
+CREATE TABLE three (id INT);
+CREATE TABLE four (id INT);
+CREATE TABLE five (id INT);`
+
+	t.Run("In a transaction", func(t *testing.T) {
+		db, fake := openFakeDB(t)
+		fake.failOn = "three"
+
+		path := writeMigration(t, t.TempDir(), "001_five_tables.sql", content)
+		err := applyMigrationFile(db, path, "", true)
+		if err == nil || !strings.Contains(err.Error(), "statement 3") {
+			t.Fatalf("expected failure in statement 3, got %v", err)
+		}
+
+		if got := fake.statements(); len(got) != 0 {
+			t.Fatalf("statements 1-2 were not rolled back: %v", got)
+		}
+		if len(fake.migrations) != 0 {
+			t.Fatalf("failed migration was recorded: %v", fake.migrations)
+		}
+
+		// Once fixed, the whole file applies
+		fake.failOn = ""
+		if err := applyMigrationFile(db, path, "", true); err != nil {
+			t.Fatalf("retry: %v", err)
+		}
+		if got := len(fake.statements()); got != 5 {
+			t.Fatalf("retry executed %d statements, want 5", got)
+		}
+	})
+
+	t.Run("Without a transaction", func(t *testing.T) {
+		db, fake := openFakeDB(t)
+		fake.failOn = "three"
+
+		path := writeMigration(t, t.TempDir(), "001_five_tables.sql", content)
+		if err := applyMigrationFile(db, path, "", false); err == nil {
+			t.Fatal("expected failure")
+		}
+
+		want := []string{"CREATE TABLE one (id INT)", "CREATE TABLE two (id INT)"}
+		if got := fake.statements(); strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Fatalf("executed %v, want %v", got, want)
+		}
+	})
+
+	t.Run("File marked to run without a transaction", func(t *testing.T) {
+		db, fake := openFakeDB(t)
+		fake.failOn = "three"
+
+		path := writeMigration(t, t.TempDir(), "001_five_tables.sql", noTransactionMarker+"\n"+content)
+		if err := applyMigrationFile(db, path, "", true); err == nil {
+			t.Fatal("expected failure")
+		}
+
+		if got := fake.statements(); len(got) != 2 {
+			t.Fatalf("executed %v, want statements 1-2 left applied", got)
+		}
+	})
+}
+
+func TestPrintStatus(t *testing.T) {
+	db, _ := openFakeDB(t)
+	dir := t.TempDir()
+	first := writeMigration(t, dir, "001_first.sql", "CREATE TABLE a (id INT);")
+	second := writeMigration(t, dir, "002_second.sql", "CREATE TABLE b (id INT);")
+
+	status := func() string {
+		var out bytes.Buffer
+		if err := printStatus(&out, db, []string{first, second}, "tenant_acme"); err != nil {
+			t.Fatalf("printStatus: %v", err)
+		}
+		return out.String()
+	}
+
+	if got, want := status(), "pending   tenant_acme/001_first\npending   tenant_acme/002_second\n"; got != want {
+		t.Fatalf("before applying:\n%s\nwant:\n%s", got, want)
+	}
+
+	if err := applyMigrationFile(db, first, "tenant_acme", true); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if got, want := status(), "applied   tenant_acme/001_first\npending   tenant_acme/002_second\n"; got != want {
+		t.Fatalf("after applying:\n%s\nwant:\n%s", got, want)
+	}
+
+	writeMigration(t, dir, "001_first.sql", "CREATE TABLE a (id BIGINT);")
+	if got, want := status(), "modified  tenant_acme/001_first\npending   tenant_acme/002_second\n"; got != want {
+		t.Fatalf("after editing:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestApplyMigrationFileReportsFailingStatement(t *testing.T) {
+	db, fake := openFakeDB(t)
+	fake.failOn = "bogus"
+
+	path := writeMigration(t, t.TempDir(), "002_bad_second.sql", `CREATE TABLE tenants (id INT);
+CREATE TABLE bogus (id INT);
+CREATE TABLE users (id INT);`)
+
+	err := applyMigrationFile(db, path, "tenant_acme", true)
+	if err == nil {
+		t.Fatal("expected failure")
+	}
+	for _, want := range []string{"tenant_acme/002_bad_second", "statement 2 of 3", "CREATE TABLE bogus"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+
+	if got := fake.statements(); len(got) != 0 {
+		t.Fatalf("first statement was not rolled back: %v", got)
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{
+			name:    "Simple statements",
+			content: "CREATE TABLE a (id INT);\nCREATE TABLE b (id INT);\n",
+			want:    []string{"CREATE TABLE a (id INT)", "CREATE TABLE b (id INT)"},
+		},
+		{
+			name:    "Semicolon in a string literal",
+			content: "INSERT INTO notes VALUES ('first; second', 'it''s; fine');\nSELECT 1;",
+			want:    []string{"INSERT INTO notes VALUES ('first; second', 'it''s; fine')", "SELECT 1"},
+		},
+		{
+			name:    "Semicolon in a quoted identifier",
+			content: `CREATE TABLE "odd;name" (id INT); SELECT 1`,
+			want:    []string{`CREATE TABLE "odd;name" (id INT)`, "SELECT 1"},
+		},
+		{
+			name: "Dollar-quoted function body",
+			content: `CREATE FUNCTION touch() RETURNS trigger AS $$
+BEGIN
+    NEW.updated_at = NOW();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER touch BEFORE UPDATE ON a FOR EACH ROW EXECUTE FUNCTION touch();`,
+			want: []string{
+				"CREATE FUNCTION touch() RETURNS trigger AS $$\nBEGIN\n    NEW.updated_at = NOW();\n    RETURN NEW;\nEND;\n$$ LANGUAGE plpgsql",
+				"CREATE TRIGGER touch BEFORE UPDATE ON a FOR EACH ROW EXECUTE FUNCTION touch()",
+			},
+		},
+		{
+			name:    "Tagged dollar quote containing $$",
+			content: "DO $body$ BEGIN PERFORM '$$;'; END; $body$; SELECT $1;",
+			want:    []string{"DO $body$ BEGIN PERFORM '$$;'; END; $body$", "SELECT $1"},
+		},
+		{
+			name:    "Comments",
+			content: "-- leading comment; not a statement\nCREATE TABLE a (id INT); /* block; /* nested; */ still comment; */\n-- trailing comment;\n",
+			want:    []string{"-- leading comment; not a statement\nCREATE TABLE a (id INT)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitStatements(tt.content)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("splitStatements() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanMigrationsOrdersNumerically(t *testing.T) {
+	dir := t.TempDir()
+	writeMigration(t, dir, "10_tenth.sql", "CREATE TABLE tenth (id INT);")
+	writeMigration(t, dir, "2_second.sql", "CREATE TABLE second (id INT);")
+	writeMigration(t, dir, "001_first.sql", "CREATE TABLE first (id INT);")
+	writeMigration(t, dir, "003_tenant_template.sql", "CREATE TABLE {{TENANT_SCHEMA}}.items (id INT);")
+	writeMigration(t, dir, "notes.sql", "-- not a migration")
+	writeMigration(t, dir, "README.md", "docs")
+
+	base, err := scanMigrations(dir, false)
+	if err != nil {
+		t.Fatalf("scanMigrations: %v", err)
+	}
+	want := []string{
+		filepath.Join(dir, "001_first.sql"),
+		filepath.Join(dir, "2_second.sql"),
+		filepath.Join(dir, "10_tenth.sql"),
+	}
+	if !reflect.DeepEqual(base, want) {
+		t.Fatalf("base migrations = %v, want %v", base, want)
+	}
+
+	tenant, err := scanMigrations(dir, true)
+	if err != nil {
+		t.Fatalf("scanMigrations: %v", err)
+	}
+	if want := []string{filepath.Join(dir, "003_tenant_template.sql")}; !reflect.DeepEqual(tenant, want) {
+		t.Fatalf("tenant migrations = %v, want %v", tenant, want)
+	}
+}
+
+func TestScanMigrationsRejectsMisnamedFiles(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		content string
+	}{
+		{"003_add_items.sql", "CREATE TABLE {{TENANT_SCHEMA}}.items (id INT);"},
+		{"003_tenant_items.sql", "CREATE TABLE items (id INT);"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeMigration(t, dir, "001_first.sql", "CREATE TABLE first (id INT);")
+			writeMigration(t, dir, tt.name, tt.content)
+
+			// Neither run may skip the file as belonging to the other
+			for _, tenant := range []bool{false, true} {
+				if _, err := scanMigrations(dir, tenant); err == nil || !strings.Contains(err.Error(), tt.name) {
+					t.Fatalf("scanMigrations(tenant=%v): expected an error naming %s, got %v", tenant, tt.name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRunMigrationsFromDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeMigration(t, dir, "10_tenth.sql", "CREATE TABLE tenth (id INT);")
+	writeMigration(t, dir, "2_second.sql", "CREATE TABLE second (id INT);")
+	writeMigration(t, dir, "003_tenant_template.sql", "CREATE TABLE {{TENANT_SCHEMA}}.items (id INT);")
+
+	db, fake := openFakeDB(t)
+	if err := runBaseMigrations(db, dir, true); err != nil {
+		t.Fatalf("base: %v", err)
+	}
+	want := []string{"CREATE TABLE second (id INT)", "CREATE TABLE tenth (id INT)"}
+	if got := fake.statements(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("executed %v, want %v", got, want)
+	}
+
+	// Only the new file runs on the next invocation
+	writeMigration(t, dir, "11_eleventh.sql", "CREATE TABLE eleventh (id INT);")
+	if err := runBaseMigrations(db, dir, true); err != nil {
+		t.Fatalf("base rerun: %v", err)
+	}
+	want = append(want, "CREATE TABLE eleventh (id INT)")
+	if got := fake.statements(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("executed %v, want %v", got, want)
+	}
+
+	if err := runTenantMigrations(db, dir, "tenant_acme", true); err != nil {
+		t.Fatalf("tenant: %v", err)
+	}
+	want = append(want, `CREATE SCHEMA IF NOT EXISTS "tenant_acme"`, "CREATE TABLE tenant_acme.items (id INT)")
+	if got := fake.statements(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("executed %v, want %v", got, want)
+	}
+	if _, ok := fake.migrations["tenant_acme/003_tenant_template"]; !ok {
+		t.Fatalf("tenant template not recorded: %v", fake.migrations)
+	}
+}
+
+func TestTenantSchemaNameValidation(t *testing.T) {
+	dir := t.TempDir()
+	writeMigration(t, dir, "002_tenant_template.sql", "CREATE TABLE {{TENANT_SCHEMA}}.items (id INT);")
+
+	t.Run("Valid name", func(t *testing.T) {
+		db, fake := openFakeDB(t)
+		if err := runTenantMigrations(db, dir, "tenant_acme_2", true); err != nil {
+			t.Fatalf("runTenantMigrations: %v", err)
+		}
+		want := []string{`CREATE SCHEMA IF NOT EXISTS "tenant_acme_2"`, "CREATE TABLE tenant_acme_2.items (id INT)"}
+		if got := fake.statements(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("executed %v, want %v", got, want)
+		}
+	})
+
+	for _, name := range []string{
+		"tenant; DROP SCHEMA public CASCADE; --",
+		"Tenant_Acme",
+		"tenant acme",
+		"1tenant",
+		`tenant"acme`,
+		strings.Repeat("t", 64),
+	} {
+		t.Run(name, func(t *testing.T) {
+			db, fake := openFakeDB(t)
+			err := runTenantMigrations(db, dir, name, true)
+			if err == nil || !strings.Contains(err.Error(), "invalid tenant schema name") {
+				t.Fatalf("expected invalid schema name error, got %v", err)
+			}
+			if got := fake.statements(); len(got) != 0 {
+				t.Fatalf("executed %v for an invalid schema name", got)
+			}
+
+			path := filepath.Join(dir, "002_tenant_template.sql")
+			if err := applyMigrationFile(db, path, name, true); err == nil {
+				t.Fatal("applyMigrationFile accepted an invalid schema name")
+			}
+		})
+	}
+}
@@ -1,4 +1,4 @@
--- 002_create_tenant_schema_template.sql
+-- 002_tenant_schema_template.sql
 -- Template for creating tenant-specific schemas
 -- Creates contexts and summaries tables for a specific tenant
 -- This script should be run with the tenant schema name substituted
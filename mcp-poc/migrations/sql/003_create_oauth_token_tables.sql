@@ -0,0 +1,35 @@
+-- 003_create_oauth_token_tables.sql
+-- Persistent storage for the auth-service token store
+-- Creates public schema tables: oauth_authorization_codes, oauth_refresh_tokens
+
+-- Create authorization codes table
+CREATE TABLE IF NOT EXISTS public.oauth_authorization_codes (
+    code VARCHAR(255) PRIMARY KEY,
+    client_id VARCHAR(255) NOT NULL,
+    redirect_uri TEXT NOT NULL,
+    scope TEXT NOT NULL DEFAULT '',
+    state TEXT NOT NULL DEFAULT '',
+    code_challenge VARCHAR(255) NOT NULL DEFAULT '',
+    code_challenge_method VARCHAR(10) NOT NULL DEFAULT '',
+    nonce TEXT NOT NULL DEFAULT '',
+    user_id VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Create indexes for authorization codes table
+CREATE INDEX IF NOT EXISTS idx_oauth_code_expires_at ON public.oauth_authorization_codes(expires_at);
+
+-- Create refresh tokens table
+CREATE TABLE IF NOT EXISTS public.oauth_refresh_tokens (
+    token VARCHAR(255) PRIMARY KEY,
+    client_id VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    scope TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Create indexes for refresh tokens table
+CREATE INDEX IF NOT EXISTS idx_oauth_refresh_client_user ON public.oauth_refresh_tokens(client_id, user_id);
+CREATE INDEX IF NOT EXISTS idx_oauth_refresh_expires_at ON public.oauth_refresh_tokens(expires_at);
@@ -0,0 +1,13 @@
+-- 004_create_oauth_revoked_jtis_table.sql
+-- Access token denylist for the auth-service token store
+-- Creates public schema table: oauth_revoked_jtis
+
+-- Create revoked JWT IDs table; rows are only needed until the token expires
+CREATE TABLE IF NOT EXISTS public.oauth_revoked_jtis (
+    jti VARCHAR(255) PRIMARY KEY,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Create indexes for revoked JWT IDs table
+CREATE INDEX IF NOT EXISTS idx_oauth_revoked_jtis_expires_at ON public.oauth_revoked_jtis(expires_at);
@@ -0,0 +1,12 @@
+-- 005_create_oauth_consents_table.sql
+-- User consent records for the auth-service token store
+-- Creates public schema table: oauth_consents
+
+-- Create consents table; one row per scope a user approved for a client
+CREATE TABLE IF NOT EXISTS public.oauth_consents (
+    user_id VARCHAR(255) NOT NULL,
+    client_id VARCHAR(255) NOT NULL,
+    scope VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, client_id, scope)
+);
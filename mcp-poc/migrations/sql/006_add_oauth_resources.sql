@@ -0,0 +1,7 @@
+-- 006_add_oauth_resources.sql
+-- Resource indicators (RFC 8707) bound to authorization grants
+-- Alters public schema tables: oauth_authorization_codes, oauth_refresh_tokens
+
+-- Space-separated resource URIs, like scope; empty when the grant isn't bound to any
+ALTER TABLE public.oauth_authorization_codes ADD COLUMN IF NOT EXISTS resources TEXT NOT NULL DEFAULT '';
+ALTER TABLE public.oauth_refresh_tokens ADD COLUMN IF NOT EXISTS resources TEXT NOT NULL DEFAULT '';
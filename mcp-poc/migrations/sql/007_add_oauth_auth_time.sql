@@ -0,0 +1,6 @@
+-- 007_add_oauth_auth_time.sql
+-- When the user authenticated, for the ID token "auth_time" claim
+-- Alters public schema table: oauth_authorization_codes
+
+-- NULL when the authorization endpoint didn't know the time
+ALTER TABLE public.oauth_authorization_codes ADD COLUMN IF NOT EXISTS auth_time TIMESTAMP WITH TIME ZONE;
@@ -9,6 +9,7 @@ require (
 	github.com/hashicorp/vault/api v1.10.0
 	github.com/prometheus/client_golang v1.17.0
 	github.com/stretchr/testify v1.8.4
+	golang.org/x/time v0.0.0-20200416051211-89c76fbcd5d1
 )
 
 require (
@@ -40,7 +41,6 @@ require (
 	golang.org/x/net v0.10.0 // indirect
 	golang.org/x/sys v0.17.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
-	golang.org/x/time v0.0.0-20200416051211-89c76fbcd5d1 // indirect
 	google.golang.org/protobuf v1.31.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
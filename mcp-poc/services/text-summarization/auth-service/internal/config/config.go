@@ -1,77 +1,338 @@
 package config
 
 import (
+	"encoding/json"
+	"log"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
-	Server ServerConfig
-	Vault  VaultConfig
-	JWT    JWTConfig
-	OAuth  OAuthConfig
+	Server    ServerConfig
+	Vault     VaultConfig
+	JWT       JWTConfig
+	OAuth     OAuthConfig
+	CORS      CORSConfig
+	RateLimit RateLimitConfig
 }
 
+// ServerConfig holds the listener settings. TLSMinVersion is "1.2" or
+// "1.3", TLSCipherSuites are crypto/tls suite names for TLS 1.2 and
+// TLSNextProtos are the ALPN protocols offered, so HTTP/2 can be turned off
+// by leaving out "h2". TLSOptionalClientCert accepts connections without a
+// client certificate, leaving routes to require one.
 type ServerConfig struct {
-	Port         string
-	TLSCertFile  string
-	TLSKeyFile   string
-	ReadTimeout  time.Duration
-	WriteTimeout time.Duration
+	Port                  string
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSMinVersion         string
+	TLSCipherSuites       []string
+	TLSNextProtos         []string
+	TLSOptionalClientCert bool
+	ReadTimeout           time.Duration
+	WriteTimeout          time.Duration
 }
 
+// VaultConfig locates Vault. The retry settings map to vault.RetryPolicy,
+// which retries connection errors and 5xx responses with exponential backoff.
 type VaultConfig struct {
-	Address    string
-	Token      string
-	TransitKey string
+	Address             string
+	Token               string
+	TransitKey          string
+	MaxRetries          int
+	RetryInitialBackoff time.Duration
+	RetryMaxBackoff     time.Duration
+	RetryTimeout        time.Duration
+	// TokenFile, when set, holds the token in place of Token and is reread
+	// every TokenFileInterval so a rotated token is picked up
+	TokenFile         string
+	TokenFileInterval time.Duration
 }
 
 type JWTConfig struct {
-	Issuer           string
-	Audience         string
-	TokenExpiration  time.Duration
-	RefreshTokenTTL  time.Duration
+	Issuer              string
+	Audience            string
+	Algorithm           string
+	TokenExpiration     time.Duration
+	RefreshTokenTTL     time.Duration
 	KeyRotationInterval time.Duration
+	// LocalVerification checks signatures against the cached public keys
+	// instead of calling Vault's verify endpoint
+	LocalVerification bool
+	// ValidateAudience rejects access tokens whose "aud" claim doesn't
+	// contain Audience. It can be turned off while clients are migrated.
+	ValidateAudience bool
+	// JWKSCacheTTL is how long the published JWKS is served before it is
+	// refreshed in the background
+	JWKSCacheTTL time.Duration
+	// ScopeTokenTTLs shortens the lifetime of access tokens carrying a
+	// sensitive scope. The shortest matching lifetime wins, and none can
+	// exceed TokenExpiration.
+	ScopeTokenTTLs map[string]time.Duration
+	// KeysInJWKS limits the JWKS to the most recent key versions, so the
+	// previous key stays published after a rotation. It is raised to cover
+	// the versions tokens still alive may be signed with, given
+	// TokenExpiration and KeyRotationInterval. Zero publishes every version
+	// Vault can still verify.
+	KeysInJWKS int
+	// ClockSkew is the leeway allowed when checking "exp" and "nbf", so
+	// tokens minted on a host whose clock drifts are not spuriously rejected
+	ClockSkew time.Duration
+	// AccessTokenJWTType marks access tokens with the "at+jwt" header type of
+	// RFC 9068 so they can't be mistaken for ID tokens. It is off by default
+	// for resource servers that expect "JWT".
+	AccessTokenJWTType bool
+	// PublicBaseURL and IssuerPath derive the issuer for deployments behind a
+	// path-based gateway, where the externally visible URL isn't Issuer. See
+	// EffectiveIssuer.
+	PublicBaseURL string
+	IssuerPath    string
+	// TenantIssuers gives tenants their own issuer, so a token issued for
+	// one tenant is refused by anything expecting another tenant's issuer.
+	// Tenants not listed use EffectiveIssuer.
+	TenantIssuers map[string]string
+}
+
+// EffectiveIssuer returns the issuer used in tokens and the discovery
+// document: PublicBaseURL, or Issuer when it is unset, followed by
+// IssuerPath. Without a path the base is returned verbatim.
+func (c *JWTConfig) EffectiveIssuer() string {
+	base := c.PublicBaseURL
+	if base == "" {
+		base = c.Issuer
+	}
+	path := strings.Trim(c.IssuerPath, "/")
+	if path == "" {
+		return base
+	}
+	return strings.TrimSuffix(base, "/") + "/" + path
+}
+
+// IssuerForTenant returns the issuer of tokens for tenantID: its entry in
+// TenantIssuers, or else EffectiveIssuer
+func (c *JWTConfig) IssuerForTenant(tenantID string) string {
+	if issuer, ok := c.TenantIssuers[tenantID]; ok && tenantID != "" {
+		return issuer
+	}
+	return c.EffectiveIssuer()
 }
 
 type OAuthConfig struct {
-	ClientID           string
-	RedirectURIs       []string
-	SupportedScopes    []string
-	CodeExpiration     time.Duration
-	PKCERequired       bool
+	// ClientID and RedirectURIs describe a single legacy client and are only
+	// consulted when Clients is empty
+	ClientID        string
+	RedirectURIs    []string
+	Clients         []ClientConfig
+	// SupportedScopes are the scopes this server grants. Load adds
+	// IntrospectionScope, so tokens for the introspect endpoint can be issued.
+	SupportedScopes []string
+	CodeExpiration  time.Duration
+	PKCERequired    bool
+	AllowPlainPKCE  bool
+	RequireS256     bool
+	NonceTTL        time.Duration
+	NonceCacheSize  int
+	PARExpiration   time.Duration
+	CleanupInterval time.Duration
+	// IntrospectionScope must be carried by Bearer tokens used to call the
+	// introspect endpoint. Empty accepts any valid access token, so a
+	// deployment has to opt out explicitly.
+	IntrospectionScope string
+	// MaxBatchIntrospection caps the tokens in one batch introspection
+	// request; defaults to 100
+	MaxBatchIntrospection int
+	// IntrospectionCacheTTL is how long the introspection response for an
+	// active token is reused, capped by the token's expiry. Zero disables
+	// the cache.
+	IntrospectionCacheTTL time.Duration
+	// RedirectURIAllowedParams names query parameters clients may add to a
+	// registered redirect URI, such as a per-request locale
+	RedirectURIAllowedParams []string
+	// AllowLoopbackPortFlexibility lets native apps redirect to a registered
+	// loopback IP URI on any port (RFC 8252 section 7.3)
+	AllowLoopbackPortFlexibility bool
+	// RequireState rejects authorization requests without a state. A
+	// non-empty state must be MinStateLength to MaxStateLength characters
+	// long; MaxStateLength defaults to 1024.
+	RequireState   bool
+	MinStateLength int
+	MaxStateLength int
+	// AllowedResources lists the resource indicators (RFC 8707) clients may
+	// request tokens for. Tokens for a resource carry it as their audience.
+	AllowedResources []string
+	// MaxAuthCodes and MaxRefreshTokens cap what the in-memory token store
+	// holds, so a flood of requests can't exhaust memory. Zero means no cap.
+	MaxAuthCodes     int
+	MaxRefreshTokens int
+	// PairwiseSalt keys the pairwise subject identifiers given to clients
+	// with the pairwise subject type. Changing it changes every such
+	// subject, so it must be kept secret and stable. Pairwise clients are
+	// refused while it is empty.
+	PairwiseSalt string
+}
+
+// CORSConfig controls cross-origin access. An empty AllowedOrigins allows any
+// origin without credentials, and empty AllowedMethods and AllowedHeaders
+// fall back to GET, POST and OPTIONS with Content-Type and Authorization.
+type CORSConfig struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+}
+
+// RateLimitConfig sizes the per-caller token buckets. Every request draws
+// from its remote IP's bucket and, when ByClientID is set, also from the
+// bucket of its client_id at that IP.
+type RateLimitConfig struct {
+	RequestsPerSecond float64
+	Burst             int
+	ByClientID        bool
+}
+
+// Subject types a client can be registered with (OpenID Connect Core
+// section 8)
+const (
+	SubjectTypePublic   = "public"
+	SubjectTypePairwise = "pairwise"
+)
+
+// ClientConfig describes a registered OAuth client. ClientSecret is empty for
+// public clients, and an empty AllowedScopes permits every supported scope.
+// SubjectType defaults to public; pairwise clients are told a subject derived
+// from SectorIdentifier, which defaults to the host of their redirect URIs.
+type ClientConfig struct {
+	ClientID         string   `json:"client_id"`
+	ClientSecret     string   `json:"client_secret,omitempty"`
+	RedirectURIs     []string `json:"redirect_uris"`
+	AllowedScopes    []string `json:"allowed_scopes,omitempty"`
+	SubjectType      string   `json:"subject_type,omitempty"`
+	SectorIdentifier string   `json:"sector_identifier,omitempty"`
+	// JWK is the public key of a client that authenticates at the token
+	// endpoint with private_key_jwt (RFC 7523) instead of a secret
+	JWK json.RawMessage `json:"jwk,omitempty"`
+}
+
+// GetClient looks up a registered client by ID, falling back to the legacy
+// single-client fields when no client list is configured
+func (o *OAuthConfig) GetClient(clientID string) (*ClientConfig, bool) {
+	if len(o.Clients) == 0 {
+		if clientID == "" || clientID != o.ClientID {
+			return nil, false
+		}
+		return &ClientConfig{
+			ClientID:      o.ClientID,
+			RedirectURIs:  o.RedirectURIs,
+			AllowedScopes: o.SupportedScopes,
+		}, true
+	}
+
+	for i := range o.Clients {
+		if o.Clients[i].ClientID == clientID {
+			return &o.Clients[i], true
+		}
+	}
+	return nil, false
 }
 
 func Load() *Config {
-	return &Config{
+	cfg := &Config{
 		Server: ServerConfig{
-			Port:         getEnv("SERVER_PORT", "8443"),
-			TLSCertFile:  getEnv("TLS_CERT_FILE", "server.crt"),
-			TLSKeyFile:   getEnv("TLS_KEY_FILE", "server.key"),
-			ReadTimeout:  getDurationEnv("SERVER_READ_TIMEOUT", 30*time.Second),
-			WriteTimeout: getDurationEnv("SERVER_WRITE_TIMEOUT", 30*time.Second),
+			Port:                  getEnv("SERVER_PORT", "8443"),
+			TLSCertFile:           getEnv("TLS_CERT_FILE", "server.crt"),
+			TLSKeyFile:            getEnv("TLS_KEY_FILE", "server.key"),
+			TLSMinVersion:         getEnv("TLS_MIN_VERSION", "1.2"),
+			TLSCipherSuites:       getListEnv("TLS_CIPHER_SUITES"),
+			TLSNextProtos:         getListEnv("TLS_NEXT_PROTOS"),
+			TLSOptionalClientCert: getBoolEnv("TLS_OPTIONAL_CLIENT_CERT", false),
+			ReadTimeout:           getDurationEnv("SERVER_READ_TIMEOUT", 30*time.Second),
+			WriteTimeout:          getDurationEnv("SERVER_WRITE_TIMEOUT", 30*time.Second),
 		},
 		Vault: VaultConfig{
-			Address:    getEnv("VAULT_ADDR", "http://localhost:8200"),
-			Token:      getEnv("VAULT_TOKEN", ""),
-			TransitKey: getEnv("VAULT_TRANSIT_KEY", "jwt-signing-key"),
+			Address:             getEnv("VAULT_ADDR", "http://localhost:8200"),
+			Token:               getEnv("VAULT_TOKEN", ""),
+			TransitKey:          getEnv("VAULT_TRANSIT_KEY", "jwt-signing-key"),
+			MaxRetries:          getIntEnv("VAULT_MAX_RETRIES", 3),
+			RetryInitialBackoff: getDurationEnv("VAULT_RETRY_INITIAL_BACKOFF", 100*time.Millisecond),
+			RetryMaxBackoff:     getDurationEnv("VAULT_RETRY_MAX_BACKOFF", time.Second),
+			RetryTimeout:        getDurationEnv("VAULT_RETRY_TIMEOUT", 5*time.Second),
+			TokenFile:           getEnv("VAULT_TOKEN_FILE", ""),
+			TokenFileInterval:   getDurationEnv("VAULT_TOKEN_FILE_INTERVAL", 30*time.Second),
 		},
 		JWT: JWTConfig{
 			Issuer:              getEnv("JWT_ISSUER", "https://auth-service"),
+			PublicBaseURL:       getEnv("JWT_PUBLIC_BASE_URL", ""),
+			IssuerPath:          getEnv("JWT_ISSUER_PATH", ""),
+			TenantIssuers:       getMapEnv("JWT_TENANT_ISSUERS"),
 			Audience:            getEnv("JWT_AUDIENCE", "api"),
+			ValidateAudience:    getBoolEnv("JWT_VALIDATE_AUDIENCE", true),
+			Algorithm:           getEnv("JWT_ALGORITHM", "RS256"),
 			TokenExpiration:     getDurationEnv("JWT_TOKEN_EXPIRATION", 24*time.Hour),
 			RefreshTokenTTL:     getDurationEnv("JWT_REFRESH_TOKEN_TTL", 7*24*time.Hour),
 			KeyRotationInterval: getDurationEnv("JWT_KEY_ROTATION_INTERVAL", 24*time.Hour),
+			LocalVerification:   getBoolEnv("JWT_LOCAL_VERIFICATION", false),
+			JWKSCacheTTL:        getDurationEnv("JWT_JWKS_CACHE_TTL", 5*time.Minute),
+			ScopeTokenTTLs:      getDurationMapEnv("JWT_SCOPE_TOKEN_TTLS"),
+			KeysInJWKS:          getIntEnv("JWT_KEYS_IN_JWKS", 2),
+			ClockSkew:           getDurationEnv("JWT_CLOCK_SKEW", 60*time.Second),
+			AccessTokenJWTType:  getBoolEnv("JWT_ACCESS_TOKEN_TYP", false),
 		},
 		OAuth: OAuthConfig{
-			ClientID:        getEnv("OAUTH_CLIENT_ID", "default-client"),
-			RedirectURIs:    []string{getEnv("OAUTH_REDIRECT_URI", "http://localhost:3000/callback")},
-			SupportedScopes: []string{"openid", "profile", "email"},
-			CodeExpiration:  getDurationEnv("OAUTH_CODE_EXPIRATION", 10*time.Minute),
-			PKCERequired:    getBoolEnv("OAUTH_PKCE_REQUIRED", true),
+			ClientID:                     getEnv("OAUTH_CLIENT_ID", "default-client"),
+			RedirectURIs:                 []string{getEnv("OAUTH_REDIRECT_URI", "http://localhost:3000/callback")},
+			SupportedScopes:              getListEnvDefault("OAUTH_SUPPORTED_SCOPES", []string{"openid", "profile", "email"}),
+			CodeExpiration:               getDurationEnv("OAUTH_CODE_EXPIRATION", 10*time.Minute),
+			PKCERequired:                 getBoolEnv("OAUTH_PKCE_REQUIRED", true),
+			AllowPlainPKCE:               getBoolEnv("OAUTH_ALLOW_PLAIN_PKCE", false),
+			RequireS256:                  getBoolEnv("OAUTH_REQUIRE_S256", false),
+			NonceTTL:                     getDurationEnv("OAUTH_NONCE_TTL", 10*time.Minute),
+			NonceCacheSize:               getIntEnv("OAUTH_NONCE_CACHE_SIZE", 10000),
+			PARExpiration:                getDurationEnv("OAUTH_PAR_EXPIRATION", 60*time.Second),
+			CleanupInterval:              getDurationEnv("OAUTH_CLEANUP_INTERVAL", 5*time.Minute),
+			IntrospectionScope:           getEnvAllowEmpty("OAUTH_INTROSPECTION_SCOPE", "introspect"),
+			MaxBatchIntrospection:        getIntEnv("OAUTH_MAX_BATCH_INTROSPECTION", 100),
+			IntrospectionCacheTTL:        getDurationEnv("OAUTH_INTROSPECTION_CACHE_TTL", 30*time.Second),
+			RedirectURIAllowedParams:     getListEnv("OAUTH_REDIRECT_URI_ALLOWED_PARAMS"),
+			AllowLoopbackPortFlexibility: getBoolEnv("OAUTH_ALLOW_LOOPBACK_PORT_FLEXIBILITY", false),
+			RequireState:                 getBoolEnv("OAUTH_REQUIRE_STATE", false),
+			MinStateLength:               getIntEnv("OAUTH_MIN_STATE_LENGTH", 0),
+			MaxStateLength:               getIntEnv("OAUTH_MAX_STATE_LENGTH", 1024),
+			AllowedResources:             getListEnv("OAUTH_ALLOWED_RESOURCES"),
+			PairwiseSalt:                 getEnv("OAUTH_PAIRWISE_SALT", ""),
+			MaxAuthCodes:                 getIntEnv("OAUTH_MAX_AUTH_CODES", 100000),
+			MaxRefreshTokens:             getIntEnv("OAUTH_MAX_REFRESH_TOKENS", 1000000),
 		},
+		CORS: CORSConfig{
+			AllowedOrigins:   getListEnv("CORS_ALLOWED_ORIGINS"),
+			AllowedMethods:   getListEnv("CORS_ALLOWED_METHODS"),
+			AllowedHeaders:   getListEnv("CORS_ALLOWED_HEADERS"),
+			AllowCredentials: getBoolEnv("CORS_ALLOW_CREDENTIALS", false),
+		},
+		RateLimit: RateLimitConfig{
+			RequestsPerSecond: getFloatEnv("RATE_LIMIT_RPS", 10),
+			Burst:             getIntEnv("RATE_LIMIT_BURST", 20),
+			ByClientID:        getBoolEnv("RATE_LIMIT_BY_CLIENT_ID", false),
+		},
+	}
+
+	if scope := cfg.OAuth.IntrospectionScope; scope != "" && !slices.Contains(cfg.OAuth.SupportedScopes, scope) {
+		cfg.OAuth.SupportedScopes = append(cfg.OAuth.SupportedScopes, scope)
 	}
+
+	cfg.OAuth.Clients = getClientsEnv("OAUTH_CLIENTS")
+	if len(cfg.OAuth.Clients) == 0 {
+		// Synthesize a single client from the legacy variables
+		cfg.OAuth.Clients = []ClientConfig{{
+			ClientID:      cfg.OAuth.ClientID,
+			RedirectURIs:  cfg.OAuth.RedirectURIs,
+			AllowedScopes: cfg.OAuth.SupportedScopes,
+		}}
+	}
+
+	return cfg
 }
 
 func getEnv(key, defaultValue string) string {
@@ -81,6 +342,15 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvAllowEmpty is getEnv for variables that may be set to an empty value
+// to override a non-empty default
+func getEnvAllowEmpty(key, defaultValue string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
 func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 	if value := os.Getenv(key); value != "" {
 		if duration, err := time.ParseDuration(value); err == nil {
@@ -90,6 +360,90 @@ func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 	return defaultValue
 }
 
+func getFloatEnv(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
+func getIntEnv(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if intValue, err := strconv.Atoi(value); err == nil {
+			return intValue
+		}
+	}
+	return defaultValue
+}
+
+// getListEnv splits a comma-separated variable, dropping empty entries
+func getListEnv(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getListEnvDefault is getListEnv with defaultValue for an unset or empty
+// variable
+func getListEnvDefault(key string, defaultValue []string) []string {
+	if values := getListEnv(key); len(values) > 0 {
+		return values
+	}
+	return defaultValue
+}
+
+// getDurationMapEnv parses comma-separated name=duration pairs, such as
+// "admin=5m,write=1h", skipping invalid entries
+func getDurationMapEnv(key string) map[string]time.Duration {
+	durations := make(map[string]time.Duration)
+	for _, entry := range getListEnv(key) {
+		name, value, ok := strings.Cut(entry, "=")
+		duration, err := time.ParseDuration(strings.TrimSpace(value))
+		if !ok || strings.TrimSpace(name) == "" || err != nil {
+			log.Printf("Ignoring invalid %s entry %q", key, entry)
+			continue
+		}
+		durations[strings.TrimSpace(name)] = duration
+	}
+	return durations
+}
+
+// getMapEnv parses comma-separated name=value pairs, skipping invalid entries
+func getMapEnv(key string) map[string]string {
+	values := make(map[string]string)
+	for _, entry := range getListEnv(key) {
+		name, value, ok := strings.Cut(entry, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" || value == "" {
+			log.Printf("Ignoring invalid %s entry %q", key, entry)
+			continue
+		}
+		values[name] = value
+	}
+	return values
+}
+
+// getClientsEnv parses a JSON array of client registrations
+func getClientsEnv(key string) []ClientConfig {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+
+	var clients []ClientConfig
+	if err := json.Unmarshal([]byte(value), &clients); err != nil {
+		log.Printf("Ignoring invalid %s: %v", key, err)
+		return nil
+	}
+	return clients
+}
+
 func getBoolEnv(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
 		if boolValue, err := strconv.ParseBool(value); err == nil {
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"auth-service/internal/models"
+	"auth-service/pkg/metrics"
+)
+
+// DemoUserID is the user DemoAuthenticator signs every request in as
+const DemoUserID = "demo-user"
+
+// Authenticator establishes the resource owner of an authorization request,
+// for instance from a session cookie
+type Authenticator interface {
+	// Authenticate returns the ID of the user signed in to r. When nobody is,
+	// it writes a response that starts the login, such as a redirect to a
+	// login page that returns to r's URL, and returns false.
+	Authenticate(w http.ResponseWriter, r *http.Request) (userID string, ok bool)
+}
+
+// SessionAuthenticator is an optional extension of Authenticator that lets
+// /authorize honour the OpenID Connect prompt and max_age parameters. With
+// an Authenticator without it, requests with prompt=none or max_age fail
+// with interaction_required, ones with prompt=login with login_required,
+// and ID tokens have no auth_time.
+type SessionAuthenticator interface {
+	// Session returns the user signed in to r and when they last
+	// authenticated, without writing a response
+	Session(r *http.Request) (userID string, authTime time.Time, ok bool)
+
+	// Login writes a response that makes the user authenticate, even when
+	// already signed in, and then returns them to returnTo
+	Login(w http.ResponseWriter, r *http.Request, returnTo string)
+}
+
+// AuthenticatorFunc adapts a function to the Authenticator interface
+type AuthenticatorFunc func(w http.ResponseWriter, r *http.Request) (string, bool)
+
+func (f AuthenticatorFunc) Authenticate(w http.ResponseWriter, r *http.Request) (string, bool) {
+	return f(w, r)
+}
+
+// DemoAuthenticator signs every request in as DemoUserID without any login.
+// It is the default so the service can be tried out, and must be replaced
+// with WithAuthenticator in any real deployment.
+type DemoAuthenticator struct{}
+
+func (DemoAuthenticator) Authenticate(w http.ResponseWriter, r *http.Request) (string, bool) {
+	return DemoUserID, true
+}
+
+// Session reports DemoUserID as having just authenticated
+func (DemoAuthenticator) Session(r *http.Request) (string, time.Time, bool) {
+	return DemoUserID, time.Now(), true
+}
+
+// Login has nothing to ask for, so it returns the user straight away
+func (DemoAuthenticator) Login(w http.ResponseWriter, r *http.Request, returnTo string) {
+	http.Redirect(w, r, returnTo, http.StatusFound)
+}
+
+// HandlerOption customizes an OAuthHandler at construction time
+type HandlerOption func(*OAuthHandler)
+
+// WithAuthenticator sets how /authorize establishes the user that codes are
+// issued to. Defaults to DemoAuthenticator.
+func WithAuthenticator(authenticator Authenticator) HandlerOption {
+	return func(h *OAuthHandler) {
+		h.authenticator = authenticator
+	}
+}
+
+// authenticate establishes the user of req and when they authenticated,
+// honouring its prompt and max_age. When it returns false the request has
+// been answered, with a login or, for prompt=none, an error for the client.
+// Without a SessionAuthenticator prompt and max_age can't be honoured, so
+// requests with them fail rather than silently ignoring them.
+func (h *OAuthHandler) authenticate(w http.ResponseWriter, r *http.Request, req *models.AuthorizationRequest) (string, time.Time, bool) {
+	sessions, ok := h.authenticator.(SessionAuthenticator)
+	if !ok {
+		var errorResp *models.ErrorResponse
+		switch {
+		case req.HasPrompt("none"):
+			errorResp = models.NewInteractionRequired("The user can't be authenticated without interaction")
+		case req.HasPrompt("login"):
+			errorResp = models.NewLoginRequired("The user can't be asked to log in again")
+		case req.MaxAge != "":
+			errorResp = models.NewInteractionRequired("max_age can't be enforced")
+		}
+		if errorResp != nil {
+			metrics.RecordAuthorizationRequest(req.ClientID, req.ResponseType, "error")
+			h.sendErrorResponse(w, r, errorResp.WithState(req.State), req.RedirectURI)
+			return "", time.Time{}, false
+		}
+
+		userID, ok := h.authenticator.Authenticate(w, r)
+		if !ok {
+			metrics.RecordAuthorizationRequest(req.ClientID, req.ResponseType, "login_required")
+		}
+		return userID, time.Time{}, ok
+	}
+
+	userID, authTime, ok := sessions.Session(r)
+	if ok && h.oauthService.SessionSatisfies(req, authTime, time.Now()) {
+		return userID, authTime, true
+	}
+
+	if req.HasPrompt("none") {
+		metrics.RecordAuthorizationRequest(req.ClientID, req.ResponseType, "error")
+		errorResp := models.NewLoginRequired("The user must log in").WithState(req.State)
+		h.sendErrorResponse(w, r, errorResp, req.RedirectURI)
+		return "", time.Time{}, false
+	}
+	metrics.RecordAuthorizationRequest(req.ClientID, req.ResponseType, "login_required")
+	sessions.Login(w, r, loginReturnURI(r))
+	return "", time.Time{}, false
+}
+
+// loginReturnURI returns r's URI without prompt and max_age, which the login
+// about to happen satisfies, so the user isn't sent to log in again
+func loginReturnURI(r *http.Request) string {
+	returnTo := *r.URL
+	query := returnTo.Query()
+	query.Del("prompt")
+	query.Del("max_age")
+	returnTo.RawQuery = query.Encode()
+	return returnTo.RequestURI()
+}
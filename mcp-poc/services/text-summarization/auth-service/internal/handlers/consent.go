@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+)
+
+// consentTokenLifetime is how long the user has to answer a consent prompt
+const consentTokenLifetime = 10 * time.Minute
+
+// ConsentPrompter asks the user to approve the scopes of an authorization
+// request they haven't approved for the client before
+type ConsentPrompter interface {
+	// PromptConsent writes a response showing req.Scope for req.ClientID.
+	// Once the user approves, the prompt records it with POST /consent,
+	// passing req.ConsentToken as csrf_token, and sends the user back to the
+	// original /authorize URL.
+	PromptConsent(w http.ResponseWriter, r *http.Request, req *models.AuthorizationRequest)
+}
+
+// ConsentPrompterFunc adapts a function to the ConsentPrompter interface
+type ConsentPrompterFunc func(w http.ResponseWriter, r *http.Request, req *models.AuthorizationRequest)
+
+func (f ConsentPrompterFunc) PromptConsent(w http.ResponseWriter, r *http.Request, req *models.AuthorizationRequest) {
+	f(w, r, req)
+}
+
+// WithConsentPrompter sets how /authorize asks for missing consent. Without
+// one, the client is sent a consent_required error instead.
+func WithConsentPrompter(prompter ConsentPrompter) HandlerOption {
+	return func(h *OAuthHandler) {
+		h.consentPrompter = prompter
+	}
+}
+
+// WithConsentKey sets the key consent tokens are signed with. Replicas
+// behind a load balancer need the same key, since the prompt and its POST
+// may reach different ones. Defaults to a random key.
+func WithConsentKey(key []byte) HandlerOption {
+	return func(h *OAuthHandler) {
+		h.consentKey = key
+	}
+}
+
+// newConsentKey returns a random key for consent tokens
+func newConsentKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic("failed to generate consent key: " + err.Error())
+	}
+	return key
+}
+
+// consentToken returns the CSRF token for userID approving scope for
+// clientID, valid until expiresAt. Another site can't learn it, so it can't
+// make the user's browser approve scopes they were never shown.
+func (h *OAuthHandler) consentToken(userID, clientID, scope string, expiresAt time.Time) string {
+	expiry := binary.BigEndian.AppendUint64(nil, uint64(expiresAt.Unix()))
+	mac := hmac.New(sha256.New, h.consentKey)
+	for _, value := range []string{userID, clientID, scope} {
+		mac.Write([]byte(value))
+		mac.Write([]byte{0})
+	}
+	mac.Write(expiry)
+	return base64.RawURLEncoding.EncodeToString(append(expiry, mac.Sum(nil)...))
+}
+
+// newConsentToken returns the CSRF token for the consent prompt for req
+func (h *OAuthHandler) newConsentToken(req *models.AuthorizationRequest) string {
+	return h.consentToken(req.UserID, req.ClientID, req.Scope, time.Now().Add(consentTokenLifetime))
+}
+
+// validConsentToken reports whether token was issued for userID approving
+// scope for clientID and hasn't expired
+func (h *OAuthHandler) validConsentToken(token, userID, clientID, scope string) bool {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) != 8+sha256.Size {
+		return false
+	}
+	expiresAt := time.Unix(int64(binary.BigEndian.Uint64(raw[:8])), 0)
+	if time.Now().After(expiresAt) {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(h.consentToken(userID, clientID, scope, expiresAt)))
+}
+
+// sameOrigin reports whether r's Origin header, when it has one, is the
+// service itself
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	return err == nil && parsed.Host == r.Host
+}
+
+// HandleConsent records the authenticated user's approval of the scope form
+// parameter for client_id. The csrf_token parameter must be the
+// ConsentToken the prompt was given for the same user, client and scope,
+// and a cross-origin Origin header is refused, so that other sites can't
+// approve scopes on the user's behalf.
+func (h *OAuthHandler) HandleConsent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		sendConsentError(w, http.StatusBadRequest, "invalid_request", "Invalid form data")
+		return
+	}
+
+	if !sameOrigin(r) {
+		sendConsentError(w, http.StatusForbidden, "access_denied", "Cross-origin consent is not allowed")
+		return
+	}
+
+	userID, ok := h.authenticator.Authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	clientID, scope := r.PostForm.Get("client_id"), r.PostForm.Get("scope")
+	if !h.validConsentToken(r.PostForm.Get("csrf_token"), userID, clientID, scope) {
+		sendConsentError(w, http.StatusForbidden, "access_denied", "Invalid or expired csrf_token")
+		return
+	}
+
+	err := h.oauthService.GrantConsent(userID, clientID, scope)
+	if errors.Is(err, services.ErrInvalidConsent) {
+		sendConsentError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if err != nil {
+		sendConsentError(w, http.StatusInternalServerError, "server_error", "Failed to record consent")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func sendConsentError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&models.ErrorResponse{
+		Error:            code,
+		ErrorDescription: description,
+	})
+}
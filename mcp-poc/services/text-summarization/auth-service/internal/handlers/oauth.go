@@ -2,24 +2,46 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
 	"net/http"
 	"net/url"
+	"strings"
 
+	"auth-service/internal/middleware"
 	"auth-service/internal/models"
 	"auth-service/internal/services"
 	"auth-service/pkg/metrics"
 )
 
+// maxTokenRequestBytes caps a JSON token request body, matching the limit
+// ParseForm applies to form bodies
+const maxTokenRequestBytes = 10 << 20
+
 type OAuthHandler struct {
-	oauthService *services.OAuthService
-	jwtService   *services.JWTService
+	oauthService    *services.OAuthService
+	jwtService      *services.JWTService
+	authenticator   Authenticator
+	consentPrompter ConsentPrompter
+	consentKey      []byte
 }
 
-func NewOAuthHandler(oauthService *services.OAuthService, jwtService *services.JWTService) *OAuthHandler {
-	return &OAuthHandler{
-		oauthService: oauthService,
-		jwtService:   jwtService,
+func NewOAuthHandler(oauthService *services.OAuthService, jwtService *services.JWTService, opts ...HandlerOption) *OAuthHandler {
+	h := &OAuthHandler{
+		oauthService:  oauthService,
+		jwtService:    jwtService,
+		authenticator: DemoAuthenticator{},
+	}
+	for _, opt := range opts {
+		opt(h)
 	}
+	if h.consentKey == nil {
+		h.consentKey = newConsentKey()
+	}
+	return h
 }
 
 // HandleAuthorize handles the OAuth2.1 authorization endpoint
@@ -39,21 +61,52 @@ func (h *OAuthHandler) HandleAuthorize(w http.ResponseWriter, r *http.Request) {
 		CodeChallenge:       r.URL.Query().Get("code_challenge"),
 		CodeChallengeMethod: r.URL.Query().Get("code_challenge_method"),
 		Nonce:               r.URL.Query().Get("nonce"),
+		Resources:           r.URL.Query()["resource"],
+		Prompt:              r.URL.Query().Get("prompt"),
+		MaxAge:              r.URL.Query().Get("max_age"),
 	}
 
-	// Validate request
-	if req.ResponseType == "" || req.ClientID == "" || req.RedirectURI == "" {
-		errorResp := &models.ErrorResponse{
-			Error:            "invalid_request",
-			ErrorDescription: "Missing required parameters",
-			State:            req.State,
+	// Resolve a pushed authorization request (RFC 9126); it was validated
+	// when it was pushed. Other requests are validated before asking the
+	// user to log in, so errors go back to the client.
+	if requestURI := r.URL.Query().Get("request_uri"); requestURI != "" {
+		pushed, errorResp := h.oauthService.ResolveRequestURI(req.ClientID, requestURI)
+		if errorResp != nil {
+			// The redirect_uri can't be trusted without the pushed request
+			h.sendErrorResponse(w, r, errorResp, "")
+			return
 		}
-		h.sendErrorResponse(w, r, errorResp, req.RedirectURI)
+		req = pushed
+	} else {
+		if req.ResponseType == "" || req.ClientID == "" || req.RedirectURI == "" {
+			errorResp := models.NewInvalidRequest("Missing required parameters").WithState(req.State)
+			h.sendErrorResponse(w, r, errorResp, req.RedirectURI)
+			return
+		}
+		if errorResp := h.oauthService.ValidateAuthorizationRequest(req); errorResp != nil {
+			metrics.RecordAuthorizationRequest(req.ClientID, req.ResponseType, "error")
+			h.sendErrorResponse(w, r, errorResp, req.RedirectURI)
+			return
+		}
+	}
+
+	// The authenticator answers the request itself when it starts a login
+	userID, authTime, ok := h.authenticate(w, r, req)
+	if !ok {
 		return
 	}
+	req.UserID = userID
+	req.AuthTime = authTime
 
-	// Process authorization request
+	// Process authorization request. With prompt=none a missing consent is
+	// an error for the client rather than a prompt.
 	authCode, errorResp := h.oauthService.HandleAuthorizationRequest(req)
+	if errorResp != nil && errorResp.Error == "consent_required" && h.consentPrompter != nil && !req.HasPrompt("none") {
+		metrics.RecordAuthorizationRequest(req.ClientID, req.ResponseType, "consent_required")
+		req.ConsentToken = h.newConsentToken(req)
+		h.consentPrompter.PromptConsent(w, r, req)
+		return
+	}
 	if errorResp != nil {
 		metrics.RecordAuthorizationRequest(req.ClientID, req.ResponseType, "error")
 		h.sendErrorResponse(w, r, errorResp, req.RedirectURI)
@@ -65,11 +118,7 @@ func (h *OAuthHandler) HandleAuthorize(w http.ResponseWriter, r *http.Request) {
 	// Redirect back to client with authorization code
 	redirectURL, err := url.Parse(req.RedirectURI)
 	if err != nil {
-		errorResp := &models.ErrorResponse{
-			Error:            "invalid_request",
-			ErrorDescription: "Invalid redirect_uri",
-			State:            req.State,
-		}
+		errorResp := models.NewInvalidRequest("Invalid redirect_uri").WithState(req.State)
 		h.sendErrorResponse(w, r, errorResp, req.RedirectURI)
 		return
 	}
@@ -91,30 +140,23 @@ func (h *OAuthHandler) HandleToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse form data
-	if err := r.ParseForm(); err != nil {
-		h.sendTokenErrorResponse(w, &models.ErrorResponse{
-			Error:            "invalid_request",
-			ErrorDescription: "Failed to parse request",
-		})
+	req, errorResp := parseTokenRequest(r)
+	if errorResp != nil {
+		h.sendTokenErrorResponse(w, errorResp)
 		return
 	}
 
-	req := &models.TokenRequest{
-		GrantType:    r.FormValue("grant_type"),
-		Code:         r.FormValue("code"),
-		RedirectURI:  r.FormValue("redirect_uri"),
-		ClientID:     r.FormValue("client_id"),
-		CodeVerifier: r.FormValue("code_verifier"),
-		RefreshToken: r.FormValue("refresh_token"),
+	clientID, clientSecret, err := clientCredentials(r, req.ClientID, req.ClientSecret)
+	if err != nil {
+		h.sendTokenErrorResponse(w, models.NewInvalidClient("Malformed client credentials"))
+		return
 	}
+	req.ClientID, req.ClientSecret = clientID, clientSecret
 
-	// Validate required parameters
-	if req.GrantType == "" || req.ClientID == "" {
-		h.sendTokenErrorResponse(w, &models.ErrorResponse{
-			Error:            "invalid_request",
-			ErrorDescription: "Missing required parameters",
-		})
+	// Validate required parameters. A client assertion names the client
+	// itself.
+	if req.GrantType == "" || (req.ClientID == "" && req.ClientAssertion == "") {
+		h.sendTokenErrorResponse(w, models.NewInvalidRequest("Missing required parameters"))
 		return
 	}
 
@@ -138,6 +180,71 @@ func (h *OAuthHandler) HandleToken(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(tokenResp)
 }
 
+// HandlePAR handles the pushed authorization request endpoint (RFC 9126)
+func (h *OAuthHandler) HandlePAR(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse form data
+	if err := r.ParseForm(); err != nil {
+		h.sendTokenErrorResponse(w, models.NewInvalidRequest("Failed to parse request"))
+		return
+	}
+
+	clientID, clientSecret, err := clientCredentials(r, r.FormValue("client_id"), r.FormValue("client_secret"))
+	if err != nil {
+		h.sendTokenErrorResponse(w, models.NewInvalidClient("Malformed client credentials"))
+		return
+	}
+
+	// request_uri must not itself be pushed (RFC 9126 section 2.1)
+	if r.PostFormValue("request_uri") != "" {
+		h.sendTokenErrorResponse(w, models.NewInvalidRequest("request_uri is not allowed in a pushed request"))
+		return
+	}
+
+	req := &models.AuthorizationRequest{
+		ResponseType:        r.PostFormValue("response_type"),
+		ClientID:            clientID,
+		RedirectURI:         r.PostFormValue("redirect_uri"),
+		Scope:               r.PostFormValue("scope"),
+		State:               r.PostFormValue("state"),
+		CodeChallenge:       r.PostFormValue("code_challenge"),
+		CodeChallengeMethod: r.PostFormValue("code_challenge_method"),
+		Nonce:               r.PostFormValue("nonce"),
+		Resources:           r.PostForm["resource"],
+		Prompt:              r.PostFormValue("prompt"),
+		MaxAge:              r.PostFormValue("max_age"),
+	}
+
+	credentials := models.ClientCredentials{
+		ClientSecret:        clientSecret,
+		ClientAssertionType: r.PostFormValue("client_assertion_type"),
+		ClientAssertion:     r.PostFormValue("client_assertion"),
+	}
+
+	// With a client assertion the client_id may be left out
+	if req.ResponseType == "" || (req.ClientID == "" && credentials.ClientAssertion == "") || req.RedirectURI == "" {
+		h.sendTokenErrorResponse(w, models.NewInvalidRequest("Missing required parameters"))
+		return
+	}
+
+	resp, errorResp := h.oauthService.PushAuthorizationRequest(req, credentials)
+	if errorResp != nil {
+		// Errors go back to the client directly, never through the redirect
+		errorResp.State = ""
+		h.sendTokenErrorResponse(w, errorResp)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
 // HandleJWKS handles the JWKS endpoint
 func (h *OAuthHandler) HandleJWKS(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -145,17 +252,49 @@ func (h *OAuthHandler) HandleJWKS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	jwks, err := h.jwtService.GetJWKS()
+	jwks, etag, err := h.jwtService.GetJWKSWithETag()
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Cache-Control", "public, max-age=3600") // Cache for 1 hour
+	w.Header().Set("ETag", etag)
+
+	// Relying parties polling with the ETag they have get no body back
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.Write(jwks)
 }
 
+// etagMatches reports whether an If-None-Match header value names etag,
+// using the weak comparison RFC 9110 section 13.1.2 requires
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleDiscovery handles the OpenID Connect discovery endpoint
+func (h *OAuthHandler) HandleDiscovery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600") // Cache for 1 hour
+	json.NewEncoder(w).Encode(h.oauthService.GetDiscoveryDocument())
+}
+
 // HandleIntrospect handles the token introspection endpoint
 func (h *OAuthHandler) HandleIntrospect(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -166,14 +305,14 @@ func (h *OAuthHandler) HandleIntrospect(w http.ResponseWriter, r *http.Request)
 	// Parse form data
 	if err := r.ParseForm(); err != nil {
 		metrics.RecordIntrospectionRequest("error")
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		h.sendBearerError(w, http.StatusBadRequest, "invalid_request", "Malformed request body", "")
 		return
 	}
 
 	token := r.FormValue("token")
 	if token == "" {
 		metrics.RecordIntrospectionRequest("error")
-		http.Error(w, "Missing token parameter", http.StatusBadRequest)
+		h.sendBearerError(w, http.StatusBadRequest, "invalid_request", "Missing token parameter", "")
 		return
 	}
 
@@ -181,7 +320,7 @@ func (h *OAuthHandler) HandleIntrospect(w http.ResponseWriter, r *http.Request)
 	resp, err := h.oauthService.IntrospectToken(token)
 	if err != nil {
 		metrics.RecordIntrospectionRequest("error")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		h.sendTokenErrorResponse(w, models.NewServerError(""))
 		return
 	}
 
@@ -197,6 +336,146 @@ func (h *OAuthHandler) HandleIntrospect(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(resp)
 }
 
+// HandleBatchIntrospect introspects a JSON array of tokens, responding with
+// an array of introspection responses in the same order
+func (h *OAuthHandler) HandleBatchIntrospect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var tokens []string
+	if err := json.NewDecoder(r.Body).Decode(&tokens); err != nil {
+		metrics.RecordIntrospectionRequest("error")
+		h.sendBearerError(w, http.StatusBadRequest, "invalid_request", "Request body must be a JSON array of tokens", "")
+		return
+	}
+
+	responses, err := h.oauthService.IntrospectTokens(tokens)
+	if errors.Is(err, services.ErrBatchTooLarge) {
+		metrics.RecordIntrospectionRequest("error")
+		description := fmt.Sprintf("At most %d tokens may be introspected at once", h.oauthService.MaxBatchIntrospection())
+		h.sendBearerError(w, http.StatusRequestEntityTooLarge, "invalid_request", description, "")
+		return
+	}
+	if err != nil {
+		metrics.RecordIntrospectionRequest("error")
+		h.sendTokenErrorResponse(w, models.NewServerError(""))
+		return
+	}
+
+	for _, resp := range responses {
+		if resp.Active {
+			metrics.RecordIntrospectionRequest("success")
+			metrics.RecordJWTValidation("valid")
+		} else {
+			metrics.RecordIntrospectionRequest("inactive")
+			metrics.RecordJWTValidation("invalid")
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responses)
+}
+
+// HandleRevoke handles the token revocation endpoint (RFC 7009)
+func (h *OAuthHandler) HandleRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse form data
+	if err := r.ParseForm(); err != nil {
+		metrics.RecordRevocationRequest("error")
+		h.sendTokenErrorResponse(w, models.NewInvalidRequest("Failed to parse request"))
+		return
+	}
+
+	token := r.FormValue("token")
+	if token == "" {
+		metrics.RecordRevocationRequest("error")
+		h.sendTokenErrorResponse(w, models.NewInvalidRequest("Missing token parameter"))
+		return
+	}
+
+	// The client authenticates as at the token endpoint (RFC 7009 section 2.1)
+	clientID, clientSecret, err := clientCredentials(r, r.FormValue("client_id"), r.FormValue("client_secret"))
+	if err != nil {
+		metrics.RecordRevocationRequest("error")
+		h.sendTokenErrorResponse(w, models.NewInvalidClient("Malformed client credentials"))
+		return
+	}
+	credentials := models.ClientCredentials{
+		ClientSecret:        clientSecret,
+		ClientAssertionType: r.PostFormValue("client_assertion_type"),
+		ClientAssertion:     r.PostFormValue("client_assertion"),
+	}
+
+	if errorResp := h.oauthService.RevokeClientToken(token, r.FormValue("token_type_hint"), clientID, credentials); errorResp != nil {
+		metrics.RecordRevocationRequest("error")
+		h.sendTokenErrorResponse(w, errorResp)
+		return
+	}
+
+	metrics.RecordRevocationRequest("success")
+
+	// Per RFC 7009 the response is 200 even for unknown or already revoked tokens
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleUserInfo handles the OpenID Connect UserInfo endpoint
+func (h *OAuthHandler) HandleUserInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, ok := bearerToken(r)
+	if !ok {
+		errorCode := "invalid_request"
+		if r.Header.Get("Authorization") == "" {
+			// RFC 6750 challenges without an error code when no credentials were sent
+			errorCode = ""
+		}
+		h.sendBearerError(w, http.StatusUnauthorized, errorCode, "Missing bearer token", "")
+		return
+	}
+
+	claims, err := h.jwtService.ValidateAccessToken(token)
+	if err != nil {
+		metrics.RecordJWTValidation("invalid")
+		description := "The access token is invalid"
+		if errors.Is(err, services.ErrTokenExpired) {
+			description = "The access token expired"
+		}
+		h.sendBearerError(w, http.StatusUnauthorized, "invalid_token", description, "")
+		return
+	}
+	metrics.RecordJWTValidation("valid")
+
+	if !hasScope(claims.Scope, "openid") {
+		h.sendBearerError(w, http.StatusForbidden, "insufficient_scope", "The access token does not carry the openid scope", "openid")
+		return
+	}
+
+	userInfo, err := h.oauthService.UserInfo(claims)
+	if errors.Is(err, services.ErrUserNotFound) {
+		h.sendBearerError(w, http.StatusUnauthorized, "invalid_token", "The user no longer exists", "")
+		return
+	}
+	if err != nil {
+		h.sendTokenErrorResponse(w, models.NewServerError(""))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(userInfo)
+}
+
 // HandleHealth handles health check endpoint
 func (h *OAuthHandler) HandleHealth(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -213,6 +492,34 @@ func (h *OAuthHandler) HandleHealth(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(health)
 }
 
+// HandleReady reports whether the service can issue tokens, returning 503
+// while Vault is unavailable. HandleHealth remains the liveness check.
+func (h *OAuthHandler) HandleReady(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ready := map[string]string{
+		"status":  "ready",
+		"service": "auth-service",
+		"vault":   "ok",
+	}
+	status := http.StatusOK
+	if err := h.jwtService.HealthCheck(); err != nil {
+		// The reason can name Vault's address or paths, so it is only logged
+		log.Printf("Readiness check failed: %v", err)
+		ready["status"] = "unavailable"
+		ready["vault"] = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ready)
+}
+
 // sendErrorResponse sends an OAuth error response
 func (h *OAuthHandler) sendErrorResponse(w http.ResponseWriter, r *http.Request, errorResp *models.ErrorResponse, redirectURI string) {
 	// If we have a valid redirect URI, redirect with error
@@ -235,16 +542,144 @@ func (h *OAuthHandler) sendErrorResponse(w http.ResponseWriter, r *http.Request,
 	}
 
 	// Otherwise, return JSON error response
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusBadRequest)
-	json.NewEncoder(w).Encode(errorResp)
+	writeErrorResponse(w, errorResp)
 }
 
-// sendTokenErrorResponse sends a token error response
+// sendTokenErrorResponse sends a token error response, which must not be
+// cached
 func (h *OAuthHandler) sendTokenErrorResponse(w http.ResponseWriter, errorResp *models.ErrorResponse) {
-	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Cache-Control", "no-store")
 	w.Header().Set("Pragma", "no-cache")
-	w.WriteHeader(http.StatusBadRequest)
+	writeErrorResponse(w, errorResp)
+}
+
+// writeErrorResponse writes errorResp as JSON with the status it carries.
+// Failed client authentication is answered with 401 and a Basic challenge
+// (RFC 6749 section 5.2).
+func writeErrorResponse(w http.ResponseWriter, errorResp *models.ErrorResponse) {
+	status := errorResp.StatusCode()
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="auth-service"`)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(errorResp)
 }
+
+// sendBearerError sends an RFC 6750 error response with a WWW-Authenticate
+// challenge. An empty errorCode means the request carried no credentials.
+func (h *OAuthHandler) sendBearerError(w http.ResponseWriter, status int, errorCode, description, scope string) {
+	w.Header().Set("WWW-Authenticate", middleware.BearerChallenge(errorCode, description, scope))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	bodyError := errorCode
+	if bodyError == "" {
+		bodyError = "invalid_request"
+	}
+	json.NewEncoder(w).Encode(&models.ErrorResponse{
+		Error:            bodyError,
+		ErrorDescription: description,
+	})
+}
+
+// parseTokenRequest reads the token request parameters from the body. Form
+// encoding is what RFC 6749 specifies, but some clients send JSON, so a JSON
+// Content-Type is honoured as well.
+func parseTokenRequest(r *http.Request) (*models.TokenRequest, *models.ErrorResponse) {
+	mediaType := ""
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return nil, models.NewInvalidRequest("Invalid Content-Type")
+		}
+	}
+
+	switch mediaType {
+	case "application/json":
+		req := &models.TokenRequest{}
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxTokenRequestBytes)).Decode(req); err != nil {
+			return nil, models.NewInvalidRequest("Request body must be a JSON object of token request parameters")
+		}
+		return req, nil
+	case "", "application/x-www-form-urlencoded", "multipart/form-data":
+		if err := r.ParseForm(); err != nil {
+			return nil, models.NewInvalidRequest("Failed to parse request")
+		}
+		return &models.TokenRequest{
+			GrantType:           r.FormValue("grant_type"),
+			Code:                r.FormValue("code"),
+			RedirectURI:         r.FormValue("redirect_uri"),
+			ClientID:            r.FormValue("client_id"),
+			ClientSecret:        r.FormValue("client_secret"),
+			ClientAssertionType: r.FormValue("client_assertion_type"),
+			ClientAssertion:     r.FormValue("client_assertion"),
+			CodeVerifier:        r.FormValue("code_verifier"),
+			RefreshToken:        r.FormValue("refresh_token"),
+			SubjectToken:        r.FormValue("subject_token"),
+			SubjectTokenType:    r.FormValue("subject_token_type"),
+			RequestedTokenType:  r.FormValue("requested_token_type"),
+			Audience:            r.FormValue("audience"),
+			Scope:               r.FormValue("scope"),
+			Resources:           r.Form["resource"],
+		}, nil
+	default:
+		return nil, models.NewInvalidRequest(fmt.Sprintf("Unsupported Content-Type %q", mediaType))
+	}
+}
+
+// clientCredentials returns the client_id and client_secret of the request,
+// preferring HTTP Basic credentials over those sent in the body
+// (client_secret_post)
+func clientCredentials(r *http.Request, clientID, clientSecret string) (string, string, error) {
+	if _, _, ok := r.BasicAuth(); !ok {
+		return clientID, clientSecret, nil
+	}
+
+	basicID, basicSecret, err := basicClientCredentials(r)
+	if err != nil {
+		return "", "", err
+	}
+	if clientID != "" && clientID != basicID {
+		return "", "", errors.New("client_id does not match the Authorization header")
+	}
+	return basicID, basicSecret, nil
+}
+
+// basicClientCredentials extracts client credentials from an "Authorization:
+// Basic" header, undoing the form-encoding required by RFC 6749 section 2.3.1
+func basicClientCredentials(r *http.Request) (string, string, error) {
+	username, password, _ := r.BasicAuth()
+
+	clientID, err := url.QueryUnescape(username)
+	if err != nil {
+		return "", "", err
+	}
+	clientSecret, err := url.QueryUnescape(password)
+	if err != nil {
+		return "", "", err
+	}
+	return clientID, clientSecret, nil
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	authHeader := r.Header.Get("Authorization")
+	if len(authHeader) < 7 || !strings.EqualFold(authHeader[:7], "Bearer ") {
+		return "", false
+	}
+
+	token := strings.TrimSpace(authHeader[7:])
+	return token, token != ""
+}
+
+// hasScope reports whether the space-delimited scope string contains want
+func hasScope(scope, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
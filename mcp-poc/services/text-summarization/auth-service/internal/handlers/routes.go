@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"auth-service/internal/middleware"
+)
+
+// RegisterRoutes registers the OAuth endpoints on the given router
+func (h *OAuthHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/authorize", h.HandleAuthorize)
+	router.HandleFunc("/par", h.HandlePAR)
+	router.HandleFunc("/consent", h.HandleConsent)
+	router.HandleFunc("/token", h.HandleToken)
+	router.HandleFunc("/revoke", h.HandleRevoke)
+	router.HandleFunc("/userinfo", h.HandleUserInfo)
+	router.HandleFunc("/.well-known/jwks.json", h.HandleJWKS)
+	router.HandleFunc("/.well-known/openid-configuration", h.HandleDiscovery)
+	introspectAuth := middleware.IntrospectAuthMiddleware(h.jwtService, h.oauthService.IntrospectionScope())
+	router.Handle("/introspect", introspectAuth(http.HandlerFunc(h.HandleIntrospect)))
+	router.Handle("/introspect/batch", introspectAuth(http.HandlerFunc(h.HandleBatchIntrospect)))
+	router.HandleFunc("/health", h.HandleHealth)
+	router.HandleFunc("/ready", h.HandleReady)
+}
@@ -1,28 +1,46 @@
 package middleware
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"auth-service/internal/config"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
 	"auth-service/pkg/metrics"
 )
 
-// MetricsMiddleware records HTTP request metrics
+// unmatchedEndpoint labels requests that didn't match a registered route
+const unmatchedEndpoint = "unmatched"
+
+// MetricsMiddleware records HTTP request metrics. Requests are labelled with
+// their route's path template, such as /clients/{id}, rather than the raw
+// path so label cardinality stays bounded; install it with router.Use so the
+// matched route is known.
 func MetricsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
+		endpoint := routeTemplate(r)
 
 		// Wrap ResponseWriter to capture status code
 		wrapped := &responseWriter{ResponseWriter: w, statusCode: 200}
 
 		// Record request duration
-		timer := metrics.HttpRequestDuration.WithLabelValues(r.Method, r.URL.Path)
+		timer := metrics.HttpRequestDuration.WithLabelValues(r.Method, endpoint)
 		defer func() {
 			timer.Observe(time.Since(start).Seconds())
 		}()
@@ -30,41 +48,135 @@ func MetricsMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(wrapped, r)
 
 		// Record request count
-		metrics.RecordHTTPRequest(r.Method, r.URL.Path, strconv.Itoa(wrapped.statusCode))
+		metrics.RecordHTTPRequest(r.Method, endpoint, strconv.Itoa(wrapped.statusCode))
 	})
 }
 
-// LoggingMiddleware logs HTTP requests
-func LoggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
+// routeTemplate returns the path template of the mux route matched by r
+func routeTemplate(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return unmatchedEndpoint
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return unmatchedEndpoint
+	}
+	return template
+}
 
-		// Wrap ResponseWriter to capture status code
-		wrapped := &responseWriter{ResponseWriter: w, statusCode: 200}
+// RequestIDHeader carries the request ID in requests and responses
+const RequestIDHeader = "X-Request-ID"
 
-		next.ServeHTTP(wrapped, r)
+// maxRequestIDLength bounds caller-supplied request IDs; longer ones are replaced
+const maxRequestIDLength = 128
 
-		duration := time.Since(start)
-		log.Printf("%s %s %d %v %s", r.Method, r.URL.Path, wrapped.statusCode, duration, r.RemoteAddr)
-	})
+type requestIDContextKey struct{}
+
+// RequestIDFromContext returns the request ID assigned by RequestIDMiddleware,
+// or "" outside of it
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey{}).(string)
+	return requestID
 }
 
-// CORSMiddleware handles CORS headers
-func CORSMiddleware(next http.Handler) http.Handler {
+// RequestIDMiddleware reuses the caller's X-Request-ID, or generates one,
+// stores it in the request context and echoes it in the response so logs can
+// be correlated across services
+func RequestIDMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			requestID = uuid.New().String()
 		}
+		w.Header().Set(RequestIDHeader, requestID)
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, requestID)))
 	})
 }
 
+// LoggingMiddleware logs each request as a structured record, including the
+// request ID when wrapped by RequestIDMiddleware. A nil logger uses
+// slog.Default.
+func LoggingMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			// Wrap ResponseWriter to capture status code
+			wrapped := &responseWriter{ResponseWriter: w, statusCode: 200}
+
+			next.ServeHTTP(wrapped, r)
+
+			logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", wrapped.statusCode),
+				slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+				slog.String("remote_addr", r.RemoteAddr),
+				slog.String("request_id", RequestIDFromContext(r.Context())),
+			)
+		})
+	}
+}
+
+// Methods and headers allowed cross-origin when CORSConfig leaves them empty
+var (
+	defaultCORSMethods = []string{"GET", "POST", "OPTIONS"}
+	defaultCORSHeaders = []string{"Content-Type", "Authorization"}
+)
+
+// CORSMiddleware handles CORS headers. Origins in the allow-list are echoed
+// back; with an empty list any origin is allowed, but never with credentials.
+func CORSMiddleware(cfg config.CORSConfig) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(cfg.AllowedOrigins))
+	for _, origin := range cfg.AllowedOrigins {
+		allowed[origin] = true
+	}
+
+	methods := cfg.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	headers := cfg.AllowedHeaders
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+	allowMethods := strings.Join(methods, ", ")
+	allowHeaders := strings.Join(headers, ", ")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if len(allowed) == 0 {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				// The response depends on the request origin, so caches must key on it
+				w.Header().Add("Vary", "Origin")
+				if origin != "" && allowed[origin] {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+					if cfg.AllowCredentials {
+						w.Header().Set("Access-Control-Allow-Credentials", "true")
+					}
+				}
+			}
+			w.Header().Set("Access-Control-Allow-Methods", allowMethods)
+			w.Header().Set("Access-Control-Allow-Headers", allowHeaders)
+
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // SecurityHeadersMiddleware adds security headers
 func SecurityHeadersMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -78,57 +190,167 @@ func SecurityHeadersMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// MTLSAuthMiddleware validates client certificates for mTLS
-func MTLSAuthMiddleware(caCertPool *x509.CertPool) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
-			http.Error(w, "Client certificate required", http.StatusUnauthorized)
-			return
-		}
+// MTLSAuthMiddleware only lets through requests with a client certificate
+// issued by caCertPool. It enforces mTLS per route when the listener merely
+// verifies certificates that are given, see WithOptionalClientCert.
+func MTLSAuthMiddleware(caCertPool *x509.CertPool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+				http.Error(w, "Client certificate required", http.StatusUnauthorized)
+				return
+			}
 
-		clientCert := r.TLS.PeerCertificates[0]
+			clientCert := r.TLS.PeerCertificates[0]
 
-		// Verify the client certificate against the CA
-		roots := caCertPool
-		opts := x509.VerifyOptions{Roots: roots}
-		
-		if _, err := clientCert.Verify(opts); err != nil {
-			log.Printf("Client certificate verification failed: %v", err)
-			http.Error(w, "Invalid client certificate", http.StatusUnauthorized)
-			return
-		}
+			// Verify the client certificate against the CA
+			intermediates := x509.NewCertPool()
+			for _, cert := range r.TLS.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+			opts := x509.VerifyOptions{
+				Roots:         caCertPool,
+				Intermediates: intermediates,
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			}
 
-		log.Printf("Client authenticated: %s", clientCert.Subject.CommonName)
-	})
+			if _, err := clientCert.Verify(opts); err != nil {
+				log.Printf("Client certificate verification failed: %v", err)
+				http.Error(w, "Invalid client certificate", http.StatusUnauthorized)
+				return
+			}
+
+			log.Printf("Client authenticated: %s", clientCert.Subject.CommonName)
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
-// IntrospectAuthMiddleware validates Bearer tokens for the introspect endpoint
-func IntrospectAuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// For the introspect endpoint, we require either:
-		// 1. mTLS client certificate (already validated by MTLSAuthMiddleware)
-		// 2. Valid Bearer token (for trusted internal services)
-		
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" && (r.TLS == nil || len(r.TLS.PeerCertificates) == 0) {
-			http.Error(w, "Authentication required", http.StatusUnauthorized)
-			return
-		}
+// TokenValidator validates access tokens presented to protected endpoints
+type TokenValidator interface {
+	ValidateAccessToken(token string) (*models.Claims, error)
+}
 
-		// If Bearer token is provided, validate it
-		if authHeader != "" {
-			if len(authHeader) < 7 || authHeader[:7] != "Bearer " {
-				http.Error(w, "Invalid authorization header", http.StatusUnauthorized)
+// IntrospectAuthMiddleware authenticates callers of the introspect endpoint.
+// Callers must present either an mTLS client certificate that was verified
+// during the TLS handshake or a valid Bearer token. When requiredScope is
+// set, Bearer tokens must also carry that scope.
+func IntrospectAuthMiddleware(validator TokenValidator, requiredScope string) func(http.Handler) http.Handler {
+	var scopes []string
+	if requiredScope != "" {
+		scopes = []string{requiredScope}
+	}
+	requireBearer := RequireScope(validator, scopes...)
+
+	return func(next http.Handler) http.Handler {
+		bearerAuth := requireBearer(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// A certificate the handshake merely requested but didn't verify
+			// has no chains and doesn't count
+			if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+				next.ServeHTTP(w, r)
 				return
 			}
-			// In a real implementation, you would validate the Bearer token here
-			// For now, we just check if it's present
-		}
+			bearerAuth.ServeHTTP(w, r)
+		})
+	}
+}
 
-		next.ServeHTTP(w, r)
+// RequireScope only lets through requests with a valid Bearer access token
+// carrying at least one of scopes; with no scopes any valid token will do.
+// The token's claims are stored in the request context, see ClaimsFromContext.
+func RequireScope(validator TokenValidator, scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				// No credentials at all, so the challenge carries no error code
+				sendBearerChallenge(w, http.StatusUnauthorized, "", "Authentication required", "")
+				return
+			}
+			token := ""
+			if len(authHeader) >= 7 && strings.EqualFold(authHeader[:7], "Bearer ") {
+				token = strings.TrimSpace(authHeader[7:])
+			}
+			if token == "" {
+				sendBearerChallenge(w, http.StatusUnauthorized, "invalid_request", "Invalid authorization header", "")
+				return
+			}
+
+			claims, err := validator.ValidateAccessToken(token)
+			if err != nil {
+				log.Printf("Bearer token rejected for %s: %v", r.URL.Path, err)
+				description := "The access token is invalid"
+				if errors.Is(err, services.ErrTokenExpired) {
+					description = "The access token expired"
+				}
+				sendBearerChallenge(w, http.StatusUnauthorized, "invalid_token", description, "")
+				return
+			}
+
+			if len(scopes) > 0 && !containsAnyScope(claims.Scope, scopes) {
+				required := strings.Join(scopes, " ")
+				sendBearerChallenge(w, http.StatusForbidden, "insufficient_scope", "The access token does not carry a required scope", required)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey{}, claims)))
+		})
+	}
+}
+
+type claimsContextKey struct{}
+
+// ClaimsFromContext returns the access token claims stored by RequireScope
+func ClaimsFromContext(ctx context.Context) (*models.Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey{}).(*models.Claims)
+	return claims, ok
+}
+
+// BearerChallenge formats an RFC 6750 WWW-Authenticate value. errorCode is
+// empty when the request carried no credentials, and scope names the scopes
+// an insufficient_scope error is asking for.
+func BearerChallenge(errorCode, description, scope string) string {
+	challenge := `Bearer realm="auth-service"`
+	if errorCode != "" {
+		challenge += `, error="` + errorCode + `", error_description="` + description + `"`
+	}
+	if scope != "" {
+		challenge += `, scope="` + scope + `"`
+	}
+	return challenge
+}
+
+// sendBearerChallenge rejects a request with a WWW-Authenticate challenge and
+// the matching OAuth error JSON body
+func sendBearerChallenge(w http.ResponseWriter, status int, errorCode, description, scope string) {
+	w.Header().Set("WWW-Authenticate", BearerChallenge(errorCode, description, scope))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	bodyError := errorCode
+	if bodyError == "" {
+		bodyError = "invalid_request"
+	}
+	json.NewEncoder(w).Encode(&models.ErrorResponse{
+		Error:            bodyError,
+		ErrorDescription: description,
 	})
 }
 
+// containsAnyScope reports whether the space-delimited scope string contains
+// any of want
+func containsAnyScope(scope string, want []string) bool {
+	for _, s := range strings.Fields(scope) {
+		for _, w := range want {
+			if s == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // responseWriter wraps http.ResponseWriter to capture status code
 type responseWriter struct {
 	http.ResponseWriter
@@ -140,8 +362,112 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// defaultCipherSuites are the TLS 1.2 suites offered unless configured
+// otherwise. TLS 1.3 suites are not configurable in crypto/tls.
+var defaultCipherSuites = []uint16{
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+}
+
+// TLSOption customizes the configuration built by CreateTLSConfig
+type TLSOption func(*tls.Config)
+
+// WithMinTLSVersion sets the lowest protocol version accepted. With TLS 1.3
+// the cipher suite list no longer applies and is dropped.
+func WithMinTLSVersion(version uint16) TLSOption {
+	return func(c *tls.Config) {
+		c.MinVersion = version
+	}
+}
+
+// WithCipherSuites replaces the TLS 1.2 cipher suites
+func WithCipherSuites(suites []uint16) TLSOption {
+	return func(c *tls.Config) {
+		c.CipherSuites = suites
+	}
+}
+
+// WithNextProtos sets the ALPN protocols offered. Note that http.Server adds
+// "h2" on its own unless its TLSNextProto map is set to an empty map.
+func WithNextProtos(protos []string) TLSOption {
+	return func(c *tls.Config) {
+		c.NextProtos = protos
+	}
+}
+
+// WithOptionalClientCert verifies client certificates only when one is
+// given, so public endpoints can share a listener with mTLS-protected ones.
+// Routes that need a certificate must then use MTLSAuthMiddleware.
+func WithOptionalClientCert() TLSOption {
+	return func(c *tls.Config) {
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+}
+
+// TLSOptions turns the server configuration into TLS options, rejecting
+// unknown versions and cipher suites
+func TLSOptions(cfg config.ServerConfig) ([]TLSOption, error) {
+	var opts []TLSOption
+
+	if cfg.TLSMinVersion != "" {
+		version, err := parseTLSVersion(cfg.TLSMinVersion)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithMinTLSVersion(version))
+	}
+
+	if len(cfg.TLSCipherSuites) > 0 {
+		suites, err := parseCipherSuites(cfg.TLSCipherSuites)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithCipherSuites(suites))
+	}
+
+	if len(cfg.TLSNextProtos) > 0 {
+		opts = append(opts, WithNextProtos(cfg.TLSNextProtos))
+	}
+
+	if cfg.TLSOptionalClientCert {
+		opts = append(opts, WithOptionalClientCert())
+	}
+
+	return opts, nil
+}
+
+func parseTLSVersion(name string) (uint16, error) {
+	switch name {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q", name)
+}
+
+// parseCipherSuites accepts only the suites crypto/tls considers secure
+func parseCipherSuites(names []string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	suites := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported cipher suite %q", name)
+		}
+		suites = append(suites, id)
+	}
+	return suites, nil
+}
+
 // CreateTLSConfig creates TLS configuration for mTLS
-func CreateTLSConfig(certFile, keyFile, caCertFile string) (*tls.Config, error) {
+func CreateTLSConfig(certFile, keyFile, caCertFile string, opts ...TLSOption) (*tls.Config, error) {
 	// Load server certificate and key
 	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
 	if err != nil {
@@ -163,18 +489,21 @@ func CreateTLSConfig(certFile, keyFile, caCertFile string) (*tls.Config, error)
 	}
 
 	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		ClientAuth:   tls.RequireAndVerifyClientCert,
-		ClientCAs:    caCertPool,
-		MinVersion:   tls.VersionTLS12,
-		CipherSuites: []uint16{
-			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
-		},
+		Certificates:             []tls.Certificate{cert},
+		ClientAuth:               tls.RequireAndVerifyClientCert,
+		ClientCAs:                caCertPool,
+		MinVersion:               tls.VersionTLS12,
+		CipherSuites:             defaultCipherSuites,
 		PreferServerCipherSuites: true,
 	}
+	for _, opt := range opts {
+		opt(tlsConfig)
+	}
+
+	// TLS 1.3 negotiates its own suites, so a TLS 1.2 list would be misleading
+	if tlsConfig.MinVersion >= tls.VersionTLS13 {
+		tlsConfig.CipherSuites = nil
+	}
 
 	return tlsConfig, nil
 }
@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"auth-service/internal/config"
+	"auth-service/pkg/metrics"
+)
+
+// Idle buckets are dropped after this long so the limiter map stays bounded
+const rateLimitIdleTimeout = 10 * time.Minute
+
+// RateLimitMiddleware throttles requests with a token bucket per caller.
+// Every request takes a token from its remote IP's bucket; with
+// cfg.ByClientID it also needs one from the bucket of the client_id it names
+// at that IP. Throttled requests get 429 Too Many Requests with a Retry-After
+// header. Like MetricsMiddleware, install it with router.Use so throttled
+// requests are counted under their route template.
+func RateLimitMiddleware(cfg config.RateLimitConfig) func(http.Handler) http.Handler {
+	limiter := newRateLimiter(rate.Limit(cfg.RequestsPerSecond), cfg.Burst)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if delay, ok := limiter.allow(rateLimitKeys(r, cfg.ByClientID), time.Now()); !ok {
+				metrics.RecordRateLimitedRequest(routeTemplate(r))
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type rateLimiter struct {
+	limit     rate.Limit
+	burst     int
+	mutex     sync.Mutex
+	buckets   map[string]*rateLimitBucket
+	lastSweep time.Time
+}
+
+type rateLimitBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newRateLimiter(limit rate.Limit, burst int) *rateLimiter {
+	return &rateLimiter{
+		limit:     limit,
+		burst:     burst,
+		buckets:   make(map[string]*rateLimitBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow takes a token from the bucket of each of keys, in order, or from
+// none of them. When one is empty it returns how long until it refills,
+// rounded up to at least a second; the buckets after it aren't touched, so
+// requests turned away by an earlier bucket don't create later ones.
+func (l *rateLimiter) allow(keys []string, now time.Time) (time.Duration, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if now.Sub(l.lastSweep) > rateLimitIdleTimeout {
+		for k, bucket := range l.buckets {
+			if now.Sub(bucket.lastSeen) > rateLimitIdleTimeout {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	var reservations []*rate.Reservation
+	cancel := func() {
+		for _, reservation := range reservations {
+			reservation.CancelAt(now)
+		}
+	}
+
+	for _, key := range keys {
+		bucket, ok := l.buckets[key]
+		if !ok {
+			bucket = &rateLimitBucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+			l.buckets[key] = bucket
+		}
+		bucket.lastSeen = now
+
+		reservation := bucket.limiter.ReserveN(now, 1)
+		if !reservation.OK() {
+			cancel()
+			return time.Second, false
+		}
+		reservations = append(reservations, reservation)
+		if delay := reservation.DelayFrom(now); delay > 0 {
+			cancel()
+			if delay < time.Second {
+				delay = time.Second
+			}
+			return delay, false
+		}
+	}
+	return 0, true
+}
+
+// rateLimitKeys returns the buckets a request draws from: its remote IP's
+// and, with byClientID, that of the client_id from HTTP Basic credentials or
+// the request parameters at the same IP. The client_id isn't authenticated
+// yet, so its bucket is only ever an extra limit: sending a new client_id
+// each time still uses up the IP's bucket, and naming another client from
+// elsewhere doesn't drain theirs.
+func rateLimitKeys(r *http.Request, byClientID bool) []string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	key := "ip:" + host
+	keys := []string{key}
+
+	if byClientID {
+		if clientID, _, ok := r.BasicAuth(); ok && clientID != "" {
+			return append(keys, key+"|client:"+clientID)
+		}
+		if clientID := r.FormValue("client_id"); clientID != "" {
+			return append(keys, key+"|client:"+clientID)
+		}
+	}
+	return keys
+}
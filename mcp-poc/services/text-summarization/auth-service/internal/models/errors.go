@@ -0,0 +1,125 @@
+package models
+
+import "net/http"
+
+// Constructors for the OAuth error codes this service returns. Each carries
+// the HTTP status it is answered with when it isn't delivered by redirect.
+// Errors built as literals fall back to the status for their code.
+
+func newErrorResponse(code string, status int, description string) *ErrorResponse {
+	return &ErrorResponse{
+		Error:            code,
+		ErrorDescription: description,
+		Status:           status,
+	}
+}
+
+// NewInvalidRequest reports a missing, repeated or malformed parameter
+func NewInvalidRequest(description string) *ErrorResponse {
+	return newErrorResponse("invalid_request", http.StatusBadRequest, description)
+}
+
+// NewInvalidClient reports failed client authentication. It is answered
+// with 401 and a WWW-Authenticate challenge (RFC 6749 section 5.2).
+func NewInvalidClient(description string) *ErrorResponse {
+	return newErrorResponse("invalid_client", http.StatusUnauthorized, description)
+}
+
+// NewInvalidGrant reports an invalid, expired or revoked code or refresh
+// token
+func NewInvalidGrant(description string) *ErrorResponse {
+	return newErrorResponse("invalid_grant", http.StatusBadRequest, description)
+}
+
+// NewUnauthorizedClient reports a client not allowed to use a grant type
+func NewUnauthorizedClient(description string) *ErrorResponse {
+	return newErrorResponse("unauthorized_client", http.StatusBadRequest, description)
+}
+
+func NewUnsupportedGrantType(description string) *ErrorResponse {
+	return newErrorResponse("unsupported_grant_type", http.StatusBadRequest, description)
+}
+
+func NewUnsupportedResponseType(description string) *ErrorResponse {
+	return newErrorResponse("unsupported_response_type", http.StatusBadRequest, description)
+}
+
+// NewUnsupportedTokenType reports a token exchange subject token of a type
+// that isn't accepted (RFC 8693 section 2.2.2)
+func NewUnsupportedTokenType(description string) *ErrorResponse {
+	return newErrorResponse("unsupported_token_type", http.StatusBadRequest, description)
+}
+
+func NewInvalidScope(description string) *ErrorResponse {
+	return newErrorResponse("invalid_scope", http.StatusBadRequest, description)
+}
+
+// NewInvalidTarget reports a resource indicator that isn't allowed (RFC 8707
+// section 2)
+func NewInvalidTarget(description string) *ErrorResponse {
+	return newErrorResponse("invalid_target", http.StatusBadRequest, description)
+}
+
+// NewInvalidRequestURI reports an unknown or expired pushed authorization
+// request_uri (RFC 9126 section 4)
+func NewInvalidRequestURI(description string) *ErrorResponse {
+	return newErrorResponse("invalid_request_uri", http.StatusBadRequest, description)
+}
+
+// NewAccessDenied reports that the user or server refused the request
+func NewAccessDenied(description string) *ErrorResponse {
+	return newErrorResponse("access_denied", http.StatusForbidden, description)
+}
+
+// NewLoginRequired and NewConsentRequired report an authorization request
+// that needs user interaction (OpenID Connect Core section 3.1.2.6)
+func NewLoginRequired(description string) *ErrorResponse {
+	return newErrorResponse("login_required", http.StatusBadRequest, description)
+}
+
+func NewConsentRequired(description string) *ErrorResponse {
+	return newErrorResponse("consent_required", http.StatusBadRequest, description)
+}
+
+// NewInteractionRequired reports a prompt=none request that needs some
+// other user interaction
+func NewInteractionRequired(description string) *ErrorResponse {
+	return newErrorResponse("interaction_required", http.StatusBadRequest, description)
+}
+
+// NewServerError reports an internal failure the client can't fix
+func NewServerError(description string) *ErrorResponse {
+	return newErrorResponse("server_error", http.StatusInternalServerError, description)
+}
+
+// NewTemporarilyUnavailable reports an overload or outage the client may
+// retry later
+func NewTemporarilyUnavailable(description string) *ErrorResponse {
+	return newErrorResponse("temporarily_unavailable", http.StatusServiceUnavailable, description)
+}
+
+// WithState sets the state to echo back to the client and returns e
+func (e *ErrorResponse) WithState(state string) *ErrorResponse {
+	e.State = state
+	return e
+}
+
+// StatusCode returns the HTTP status for the error: the one it was built
+// with, or else the status for its code
+func (e *ErrorResponse) StatusCode() int {
+	if e.Status != 0 {
+		return e.Status
+	}
+	switch e.Error {
+	case "invalid_client":
+		return http.StatusUnauthorized
+	case "access_denied":
+		return http.StatusForbidden
+	case "server_error":
+		return http.StatusInternalServerError
+	case "temporarily_unavailable":
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadRequest
+	}
+}
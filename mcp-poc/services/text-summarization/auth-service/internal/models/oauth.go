@@ -1,6 +1,9 @@
 package models
 
 import (
+	"encoding/json"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -14,6 +17,53 @@ type AuthorizationRequest struct {
 	CodeChallenge        string `json:"code_challenge"`
 	CodeChallengeMethod  string `json:"code_challenge_method"`
 	Nonce                string `json:"nonce,omitempty"`
+	// Resources are the resource indicators (RFC 8707) the tokens are for
+	Resources []string `json:"resource,omitempty"`
+	// Prompt and MaxAge are the OpenID Connect "prompt" and "max_age"
+	// parameters, as sent
+	Prompt string `json:"prompt,omitempty"`
+	MaxAge string `json:"max_age,omitempty"`
+
+	// UserID is the authenticated resource owner, set by the authorization
+	// endpoint after login and never taken from the request parameters
+	UserID string `json:"-"`
+	// AuthTime is when the user last authenticated, when the authorization
+	// endpoint knows it
+	AuthTime time.Time `json:"-"`
+
+	// RequestURI is set when the request was pushed (RFC 9126); the
+	// request_uri is used up once a code is issued for it
+	RequestURI string `json:"-"`
+	// PushedAt is when a pushed request was pushed
+	PushedAt time.Time `json:"-"`
+
+	// ConsentToken is set for the consent prompt, which must send it back
+	// with the user's approval
+	ConsentToken string `json:"-"`
+}
+
+// HasPrompt reports whether value is among the space-separated prompt values
+func (r *AuthorizationRequest) HasPrompt(value string) bool {
+	for _, prompt := range strings.Fields(r.Prompt) {
+		if prompt == value {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxAgeDuration returns the allowable time since the user last
+// authenticated, and false when max_age is absent or not a non-negative
+// number of seconds
+func (r *AuthorizationRequest) MaxAgeDuration() (time.Duration, bool) {
+	if r.MaxAge == "" {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(r.MaxAge)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
 }
 
 // AuthorizationCode represents an authorization code with PKCE
@@ -26,18 +76,52 @@ type AuthorizationCode struct {
 	CodeChallenge       string    `json:"code_challenge"`
 	CodeChallengeMethod string    `json:"code_challenge_method"`
 	Nonce               string    `json:"nonce,omitempty"`
+	Resources           []string  `json:"resources,omitempty"`
 	ExpiresAt           time.Time `json:"expires_at"`
 	UserID              string    `json:"user_id"`
+	// AuthTime is when the user authenticated, zero when unknown
+	AuthTime time.Time `json:"auth_time,omitempty"`
 }
 
-// TokenRequest represents an OAuth2.1 token request
+// TokenRequest represents an OAuth2.1 token request. The subject token,
+// audience and scope fields are used by the token exchange grant (RFC 8693).
 type TokenRequest struct {
-	GrantType    string `json:"grant_type"`
-	Code         string `json:"code,omitempty"`
-	RedirectURI  string `json:"redirect_uri,omitempty"`
-	ClientID     string `json:"client_id"`
-	CodeVerifier string `json:"code_verifier,omitempty"`
-	RefreshToken string `json:"refresh_token,omitempty"`
+	GrantType        string `json:"grant_type"`
+	Code             string `json:"code,omitempty"`
+	RedirectURI      string `json:"redirect_uri,omitempty"`
+	ClientID         string `json:"client_id"`
+	ClientSecret     string `json:"client_secret,omitempty"`
+	CodeVerifier     string `json:"code_verifier,omitempty"`
+	RefreshToken     string `json:"refresh_token,omitempty"`
+	SubjectToken     string `json:"subject_token,omitempty"`
+	SubjectTokenType string `json:"subject_token_type,omitempty"`
+	// RequestedTokenType may only ask for an access token
+	RequestedTokenType string `json:"requested_token_type,omitempty"`
+	Audience           string `json:"audience,omitempty"`
+	Scope              string `json:"scope,omitempty"`
+	// Resources are the resource indicators (RFC 8707) the access token is
+	// for; JSON bodies may send a single string or an array
+	Resources Audience `json:"resource,omitempty"`
+	// ClientAssertion authenticates the client with private_key_jwt in place
+	// of ClientSecret (RFC 7523 section 2.2)
+	ClientAssertionType string `json:"client_assertion_type,omitempty"`
+	ClientAssertion     string `json:"client_assertion,omitempty"`
+}
+
+// ClientCredentials authenticate a client at an endpoint other than the
+// token endpoint, with a client secret or a client assertion as in
+// TokenRequest
+type ClientCredentials struct {
+	ClientSecret        string
+	ClientAssertionType string
+	ClientAssertion     string
+}
+
+// PushedAuthorizationResponse represents a pushed authorization request
+// response (RFC 9126)
+type PushedAuthorizationResponse struct {
+	RequestURI string `json:"request_uri"`
+	ExpiresIn  int64  `json:"expires_in"`
 }
 
 // TokenResponse represents an OAuth2.1 token response
@@ -48,6 +132,8 @@ type TokenResponse struct {
 	RefreshToken string `json:"refresh_token,omitempty"`
 	Scope        string `json:"scope,omitempty"`
 	IDToken      string `json:"id_token,omitempty"`
+	// IssuedTokenType is only set by the token exchange grant
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
 }
 
 // ErrorResponse represents an OAuth2.1 error response
@@ -56,6 +142,8 @@ type ErrorResponse struct {
 	ErrorDescription string `json:"error_description,omitempty"`
 	ErrorURI         string `json:"error_uri,omitempty"`
 	State            string `json:"state,omitempty"`
+	// Status is the HTTP status to answer with; see StatusCode
+	Status int `json:"-"`
 }
 
 // IntrospectionRequest represents a token introspection request
@@ -78,6 +166,42 @@ type IntrospectionResponse struct {
 	Aud       string `json:"aud,omitempty"`
 	Iss       string `json:"iss,omitempty"`
 	Jti       string `json:"jti,omitempty"`
+	TenantID  string `json:"tenant_id,omitempty"`
+}
+
+// DiscoveryDocument represents an OpenID Connect discovery document
+type DiscoveryDocument struct {
+	Issuer                           string   `json:"issuer"`
+	AuthorizationEndpoint            string   `json:"authorization_endpoint"`
+	TokenEndpoint                    string   `json:"token_endpoint"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	IntrospectionEndpoint            string   `json:"introspection_endpoint"`
+	RevocationEndpoint               string   `json:"revocation_endpoint"`
+	UserInfoEndpoint                 string   `json:"userinfo_endpoint"`
+	PushedAuthorizationEndpoint      string   `json:"pushed_authorization_request_endpoint"`
+	ResponseTypesSupported           []string `json:"response_types_supported"`
+	GrantTypesSupported              []string `json:"grant_types_supported"`
+	CodeChallengeMethodsSupported    []string `json:"code_challenge_methods_supported"`
+	ScopesSupported                  []string `json:"scopes_supported"`
+	SubjectTypesSupported            []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+
+	TokenEndpointAuthMethodsSupported          []string `json:"token_endpoint_auth_methods_supported"`
+	TokenEndpointAuthSigningAlgValuesSupported []string `json:"token_endpoint_auth_signing_alg_values_supported"`
+}
+
+// UserInfoResponse represents an OpenID Connect UserInfo response. Profile
+// and email claims are left out unless the access token carries the profile
+// or email scope.
+type UserInfoResponse struct {
+	Sub               string `json:"sub"`
+	Name              string `json:"name,omitempty"`
+	GivenName         string `json:"given_name,omitempty"`
+	FamilyName        string `json:"family_name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Picture           string `json:"picture,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
 }
 
 // JWKSResponse represents a JSON Web Key Set response
@@ -96,11 +220,41 @@ type JWK struct {
 	X5c []string `json:"x5c,omitempty"`
 }
 
+// Audience represents the JWT "aud" claim, which may be a single string or
+// an array of strings (RFC 7519 section 4.1.3)
+type Audience []string
+
+// UnmarshalJSON accepts both the string and array forms of the claim
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+// Contains reports whether the audience includes the given value
+func (a Audience) Contains(audience string) bool {
+	for _, aud := range a {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
 // Claims represents JWT claims
 type Claims struct {
 	Issuer    string   `json:"iss"`
 	Subject   string   `json:"sub"`
-	Audience  []string `json:"aud"`
+	Audience  Audience `json:"aud"`
 	ExpiresAt int64    `json:"exp"`
 	NotBefore int64    `json:"nbf"`
 	IssuedAt  int64    `json:"iat"`
@@ -108,6 +262,21 @@ type Claims struct {
 	Scope     string   `json:"scope,omitempty"`
 	ClientID  string   `json:"client_id,omitempty"`
 	TenantID  string   `json:"tenant_id,omitempty"`
+	Act       *Actor   `json:"act,omitempty"`
+	// AuthTime is the ID token "auth_time", when the user authenticated
+	AuthTime int64 `json:"auth_time,omitempty"`
+
+	// Extra holds claims without a field above, such as those added by a
+	// services.ClaimsProvider. It is filled in when a token is validated.
+	Extra map[string]interface{} `json:"-"`
+}
+
+// Actor represents the JWT "act" claim naming the party acting on behalf of
+// the subject of an exchanged token (RFC 8693 section 4.1). Act holds the
+// previous actor when a delegated token is exchanged again.
+type Actor struct {
+	Subject string `json:"sub"`
+	Act     *Actor `json:"act,omitempty"`
 }
 
 // RefreshToken represents a refresh token
@@ -116,5 +285,6 @@ type RefreshToken struct {
 	ClientID  string    `json:"client_id"`
 	UserID    string    `json:"user_id"`
 	Scope     string    `json:"scope"`
+	Resources []string  `json:"resources,omitempty"`
 	ExpiresAt time.Time `json:"expires_at"`
 }
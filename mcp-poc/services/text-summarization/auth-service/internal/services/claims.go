@@ -0,0 +1,80 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"auth-service/internal/models"
+)
+
+// ClaimsProvider supplies extra claims, such as roles or group memberships,
+// to merge into the access tokens issued for a user and client
+type ClaimsProvider interface {
+	Claims(userID, clientID, scope string) (map[string]interface{}, error)
+}
+
+// ClaimsProviderFunc adapts a function to the ClaimsProvider interface
+type ClaimsProviderFunc func(userID, clientID, scope string) (map[string]interface{}, error)
+
+func (f ClaimsProviderFunc) Claims(userID, clientID, scope string) (map[string]interface{}, error) {
+	return f(userID, clientID, scope)
+}
+
+// reservedClaims are set by the service itself and never taken from a
+// ClaimsProvider, even when the service leaves them out of a token
+var reservedClaims = map[string]bool{
+	"iss":       true,
+	"sub":       true,
+	"aud":       true,
+	"exp":       true,
+	"nbf":       true,
+	"iat":       true,
+	"jti":       true,
+	"scope":     true,
+	"client_id": true,
+	"tenant_id": true,
+	"act":       true,
+	"nonce":     true,
+	"auth_time": true,
+}
+
+// mergeClaims returns the claims of base with the non-reserved claims of
+// extra added, so that base stays authoritative
+func mergeClaims(base models.Claims, extra map[string]interface{}) (map[string]interface{}, error) {
+	baseJSON, err := json.Marshal(base)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal claims: %w", err)
+	}
+
+	merged := make(map[string]interface{}, len(extra))
+	for name, value := range extra {
+		if !reservedClaims[name] {
+			merged[name] = value
+		}
+	}
+	if err := json.Unmarshal(baseJSON, &merged); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal claims: %w", err)
+	}
+	return merged, nil
+}
+
+// extraClaims returns the claims in a token payload that models.Claims has
+// no field for, or nil if there are none
+func extraClaims(claimsJSON []byte) (map[string]interface{}, error) {
+	var all map[string]interface{}
+	if err := json.Unmarshal(claimsJSON, &all); err != nil {
+		return nil, err
+	}
+
+	var extra map[string]interface{}
+	for name, value := range all {
+		if reservedClaims[name] {
+			continue
+		}
+		if extra == nil {
+			extra = make(map[string]interface{})
+		}
+		extra[name] = value
+	}
+	return extra, nil
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+
+	"auth-service/internal/config"
+	"auth-service/internal/models"
+)
+
+// ClientAssertionTypeJWTBearer identifies a client_assertion JWT signed with
+// the client's private key (RFC 7523 section 2.2)
+const ClientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// maxAssertionLifetime bounds how far in the future an assertion may expire.
+// Used jtis are remembered this long, so a replay is caught for as long as
+// the assertion would otherwise be accepted.
+const maxAssertionLifetime = 10 * time.Minute
+
+// assertionAlgorithms are the signature algorithms accepted for client
+// assertions, matching those this service signs with
+var assertionAlgorithms = []jose.SignatureAlgorithm{jose.RS256, jose.PS256, jose.ES256}
+
+type assertionClaims struct {
+	Issuer    string          `json:"iss"`
+	Subject   string          `json:"sub"`
+	Audience  models.Audience `json:"aud"`
+	ExpiresAt int64           `json:"exp"`
+	NotBefore int64           `json:"nbf,omitempty"`
+	JWTID     string          `json:"jti"`
+}
+
+// authenticateTokenClient authenticates the client of a token request, by
+// client assertion when one is sent and otherwise by client secret. The
+// client_id may be left out of an assertion request, in which case it is
+// set from the assertion.
+func (o *OAuthService) authenticateTokenClient(req *models.TokenRequest) (*config.ClientConfig, *models.ErrorResponse) {
+	client, errorResp := o.authenticateClientCredentials(req.ClientID, models.ClientCredentials{
+		ClientSecret:        req.ClientSecret,
+		ClientAssertionType: req.ClientAssertionType,
+		ClientAssertion:     req.ClientAssertion,
+	}, o.tokenEndpoint())
+	if errorResp != nil {
+		return nil, errorResp
+	}
+	req.ClientID = client.ClientID
+	return client, nil
+}
+
+// authenticateClientCredentials authenticates clientID, which may be empty
+// when a client assertion names the client, with credentials. An assertion
+// must be for one of audiences.
+func (o *OAuthService) authenticateClientCredentials(clientID string, credentials models.ClientCredentials, audiences ...string) (*config.ClientConfig, *models.ErrorResponse) {
+	if credentials.ClientAssertion == "" && credentials.ClientAssertionType == "" {
+		return o.authenticateClient(clientID, credentials.ClientSecret)
+	}
+
+	if credentials.ClientAssertionType != ClientAssertionTypeJWTBearer {
+		return nil, models.NewInvalidClient("Unsupported client_assertion_type")
+	}
+	if credentials.ClientAssertion == "" {
+		return nil, models.NewInvalidRequest("client_assertion is required")
+	}
+	if credentials.ClientSecret != "" {
+		return nil, models.NewInvalidRequest("Only one client authentication method may be used")
+	}
+
+	assertedClientID, errorResp := o.verifyClientAssertion(credentials.ClientAssertion, clientID, audiences, time.Now())
+	if errorResp != nil {
+		return nil, errorResp
+	}
+	client, _ := o.config.OAuth.GetClient(assertedClientID)
+	return client, nil
+}
+
+// verifyClientAssertion checks a private_key_jwt assertion (RFC 7523
+// section 3) against the client's registered JWK and returns the client ID.
+// The assertion must name the client as iss and sub, be for one of
+// audiences, expire within maxAssertionLifetime and carry a jti that hasn't
+// been used before.
+func (o *OAuthService) verifyClientAssertion(assertion, clientID string, audiences []string, now time.Time) (string, *models.ErrorResponse) {
+	jws, err := jose.ParseSigned(assertion, assertionAlgorithms)
+	if err != nil || len(jws.Signatures) != 1 {
+		return "", models.NewInvalidClient("Malformed client_assertion")
+	}
+
+	// The client is named by the unverified claims, then the signature is
+	// checked with its key before any of them is trusted
+	var claims assertionClaims
+	if err := json.Unmarshal(jws.UnsafePayloadWithoutVerification(), &claims); err != nil {
+		return "", models.NewInvalidClient("Malformed client_assertion")
+	}
+	if claims.Subject == "" || claims.Issuer != claims.Subject || (clientID != "" && clientID != claims.Subject) {
+		return "", models.NewInvalidClient("client_assertion must name the client as iss and sub")
+	}
+
+	client, ok := o.config.OAuth.GetClient(claims.Subject)
+	if !ok || len(client.JWK) == 0 {
+		return "", models.NewInvalidClient("Client is not registered for private_key_jwt")
+	}
+	var jwk jose.JSONWebKey
+	if err := jwk.UnmarshalJSON(client.JWK); err != nil {
+		return "", models.NewServerError("Invalid JWK registered for client")
+	}
+	if _, err := jws.Verify(jwk.Public()); err != nil {
+		return "", models.NewInvalidClient("Invalid client_assertion signature")
+	}
+
+	if !containsAudience(claims.Audience, audiences) {
+		return "", models.NewInvalidClient("client_assertion is for another audience")
+	}
+
+	leeway := o.config.JWT.ClockSkew
+	if claims.ExpiresAt == 0 || !now.Before(time.Unix(claims.ExpiresAt, 0).Add(leeway)) {
+		return "", models.NewInvalidClient("client_assertion has expired")
+	}
+	if time.Unix(claims.ExpiresAt, 0).After(now.Add(maxAssertionLifetime)) {
+		return "", models.NewInvalidClient("client_assertion expires too far in the future")
+	}
+	if claims.NotBefore != 0 && now.Add(leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return "", models.NewInvalidClient("client_assertion is not yet valid")
+	}
+
+	if claims.JWTID == "" {
+		return "", models.NewInvalidClient("client_assertion must carry a jti")
+	}
+	if !o.assertions.checkAndStore(client.ClientID, claims.JWTID, now) {
+		return "", models.NewInvalidClient("client_assertion has already been used")
+	}
+
+	return client.ClientID, nil
+}
+
+// containsAudience reports whether audience names any of audiences
+func containsAudience(audience models.Audience, audiences []string) bool {
+	for _, aud := range audiences {
+		if audience.Contains(aud) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenEndpoint returns the token endpoint URL, the audience of client
+// assertions
+func (o *OAuthService) tokenEndpoint() string {
+	return strings.TrimSuffix(o.config.JWT.EffectiveIssuer(), "/") + "/token"
+}
+
+// revocationEndpoint returns the token revocation endpoint URL
+func (o *OAuthService) revocationEndpoint() string {
+	return strings.TrimSuffix(o.config.JWT.EffectiveIssuer(), "/") + "/revoke"
+}
+
+// pushedAuthorizationEndpoint returns the pushed authorization request
+// endpoint URL
+func (o *OAuthService) pushedAuthorizationEndpoint() string {
+	return strings.TrimSuffix(o.config.JWT.EffectiveIssuer(), "/") + "/par"
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"auth-service/internal/models"
+)
+
+// ErrInvalidConsent is returned by GrantConsent when the approval names an
+// unknown client or scopes the client may not be granted.
+var ErrInvalidConsent = errors.New("invalid consent")
+
+// GrantConsent records that userID approved scope for clientID, adding to
+// anything approved before. Authorization requests for those scopes are then
+// issued a code without asking again.
+func (o *OAuthService) GrantConsent(userID, clientID, scope string) error {
+	if userID == "" {
+		return fmt.Errorf("%w: no user", ErrInvalidConsent)
+	}
+
+	client, ok := o.config.OAuth.GetClient(clientID)
+	if !ok {
+		return fmt.Errorf("%w: unknown client %q", ErrInvalidConsent, clientID)
+	}
+
+	// Only record scopes that could actually be granted to the client
+	granted, ok := o.grantableScope(client, scope)
+	if !ok {
+		return fmt.Errorf("%w: no grantable scope in %q", ErrInvalidConsent, scope)
+	}
+	scopes := strings.Fields(granted)
+	for _, requested := range strings.Fields(scope) {
+		if !containsString(scopes, requested) {
+			return fmt.Errorf("%w: scope %q cannot be granted to %s", ErrInvalidConsent, requested, clientID)
+		}
+	}
+
+	if err := o.store.GrantConsent(userID, clientID, scopes); err != nil {
+		return fmt.Errorf("failed to record consent: %w", err)
+	}
+	return nil
+}
+
+// checkConsent returns a consent_required error when the user hasn't
+// approved every scope of req for its client
+func (o *OAuthService) checkConsent(req *models.AuthorizationRequest) *models.ErrorResponse {
+	approved, err := o.store.GetConsent(req.UserID, req.ClientID)
+	if err != nil {
+		return models.NewServerError("Failed to look up consent").WithState(req.State)
+	}
+
+	for _, scope := range strings.Fields(req.Scope) {
+		if !containsString(approved, scope) {
+			return models.NewConsentRequired("The user has not approved the requested scope").WithState(req.State)
+		}
+	}
+	return nil
+}
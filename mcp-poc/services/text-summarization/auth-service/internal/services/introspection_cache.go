@@ -0,0 +1,115 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"auth-service/internal/models"
+)
+
+// introspectionCacheSize bounds the cached introspection responses
+const introspectionCacheSize = 10000
+
+// introspectionCache remembers the responses for active tokens so repeated
+// introspection of a token doesn't validate it, and possibly call Vault,
+// every time. Entries are keyed by a SHA-256 of the token, so the cache
+// never holds usable tokens, and live until the TTL or the token's "exp",
+// whichever comes first. Revocation removes the token's entry, and other
+// replicas check the denylist before using theirs.
+type introspectionCache struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	entries map[string]introspectionEntry
+}
+
+type introspectionEntry struct {
+	response  *models.IntrospectionResponse
+	expiresAt time.Time
+}
+
+// newIntrospectionCache returns nil, which caches nothing, when ttl is not
+// positive
+func newIntrospectionCache(ttl time.Duration) *introspectionCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &introspectionCache{
+		ttl:     ttl,
+		entries: make(map[string]introspectionEntry),
+	}
+}
+
+func introspectionKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// get returns a copy of the cached response for token, if any
+func (c *introspectionCache) get(token string, now time.Time) (*models.IntrospectionResponse, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := introspectionKey(token)
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !now.Before(entry.expiresAt) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	response := *entry.response
+	return &response, true
+}
+
+// put caches an active response for token. When the cache is full, expired
+// entries are dropped and, if none were, the response isn't cached.
+func (c *introspectionCache) put(token string, response *models.IntrospectionResponse, now time.Time) {
+	if c == nil || !response.Active {
+		return
+	}
+
+	expiresAt := now.Add(c.ttl)
+	if exp := time.Unix(response.Exp, 0); exp.Before(expiresAt) {
+		expiresAt = exp
+	}
+	if !now.Before(expiresAt) {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.entries) >= introspectionCacheSize {
+		for key, entry := range c.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= introspectionCacheSize {
+			return
+		}
+	}
+
+	cached := *response
+	c.entries[introspectionKey(token)] = introspectionEntry{response: &cached, expiresAt: expiresAt}
+}
+
+// remove drops the entry for token, as when it is revoked
+func (c *introspectionCache) remove(token string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.entries, introspectionKey(token))
+}
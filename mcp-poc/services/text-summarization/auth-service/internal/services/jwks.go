@@ -0,0 +1,89 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+)
+
+// defaultJWKSCacheTTL is used when JWT.JWKSCacheTTL isn't positive
+const defaultJWKSCacheTTL = 5 * time.Minute
+
+// jwksCache keeps the last JWKS document that was fetched successfully. Once
+// it is older than ttl it is still served while a single background fetch
+// replaces it, so a Vault outage doesn't take the JWKS endpoint down with it.
+type jwksCache struct {
+	mutex      sync.Mutex
+	ttl        time.Duration
+	fetch      func() ([]byte, error)
+	jwks       []byte
+	etag       string
+	fetchedAt  time.Time
+	refreshing bool
+}
+
+func newJWKSCache(ttl time.Duration, fetch func() ([]byte, error)) *jwksCache {
+	if ttl <= 0 {
+		ttl = defaultJWKSCacheTTL
+	}
+
+	return &jwksCache{
+		ttl:   ttl,
+		fetch: fetch,
+	}
+}
+
+// get returns the cached JWKS and its ETag, fetching it synchronously only
+// if no fetch has succeeded yet
+func (c *jwksCache) get() ([]byte, string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.jwks == nil {
+		jwks, err := c.fetch()
+		if err != nil {
+			return nil, "", err
+		}
+		c.store(jwks)
+		return c.jwks, c.etag, nil
+	}
+
+	if time.Since(c.fetchedAt) >= c.ttl && !c.refreshing {
+		c.refreshing = true
+		go c.refreshInBackground()
+	}
+
+	return c.jwks, c.etag, nil
+}
+
+// refresh fetches the JWKS now, keeping the cached copy if that fails
+func (c *jwksCache) refresh() error {
+	jwks, err := c.fetch()
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.store(jwks)
+	return nil
+}
+
+func (c *jwksCache) refreshInBackground() {
+	if err := c.refresh(); err != nil {
+		log.Printf("Failed to refresh JWKS, serving cached copy: %v", err)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.refreshing = false
+}
+
+func (c *jwksCache) store(jwks []byte) {
+	sum := sha256.Sum256(jwks)
+	c.jwks = jwks
+	c.etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+	c.fetchedAt = time.Now()
+}
@@ -1,42 +1,113 @@
 package services
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 
 	"auth-service/internal/config"
 	"auth-service/internal/models"
+	"auth-service/internal/store"
+	"auth-service/pkg/metrics"
 	"auth-service/pkg/vault"
 )
 
+// ErrTokenExpired is returned when validating an access token past its "exp"
+var ErrTokenExpired = errors.New("token expired")
+
+// ErrTokenRevoked is returned when validating an access token whose jti has
+// been denylisted
+var ErrTokenRevoked = errors.New("token revoked")
+
+// ErrTenantMismatch is returned when validating an access token for a tenant
+// other than the one in its "tenant_id" claim, or for any tenant when it has
+// none
+var ErrTenantMismatch = errors.New("token not issued for tenant")
+
+// ErrNoDenylist is returned when revoking an access token with a JWTService
+// that has no denylist to record it in
+var ErrNoDenylist = errors.New("no denylist for revoked access tokens")
+
+// JWT header types. Access tokens use typeAccessToken (RFC 9068 section
+// 2.1) when JWT.AccessTokenJWTType is set.
+const (
+	typeJWT         = "JWT"
+	typeAccessToken = "at+jwt"
+)
+
 type JWTService struct {
 	vaultClient *vault.Client
 	config      *config.Config
+	denylist    store.JTIDenylist
+	jwks        *jwksCache
+	claims      ClaimsProvider
+	// revocationHooks are told each access token that is revoked, so
+	// caches of its validation can drop it
+	hooksMutex      sync.Mutex
+	revocationHooks map[int]func(token string)
+	nextHookID      int
+}
+
+// JWTOption customizes a JWTService at construction time
+type JWTOption func(*JWTService)
+
+// WithDenylist sets where the jtis of revoked access tokens are recorded.
+// Pass the TokenStore shared by all replicas so a revocation applies to
+// every one of them. Without it, NewOAuthService uses its TokenStore, which
+// also sweeps expired entries.
+func WithDenylist(denylist store.JTIDenylist) JWTOption {
+	return func(j *JWTService) {
+		j.denylist = denylist
+	}
 }
 
-func NewJWTService(vaultClient *vault.Client, cfg *config.Config) *JWTService {
-	return &JWTService{
+// WithClaimsProvider adds the claims returned by provider to every access
+// token. Claims the service sets itself, such as "iss" and "exp", cannot be
+// overridden.
+func WithClaimsProvider(provider ClaimsProvider) JWTOption {
+	return func(j *JWTService) {
+		j.claims = provider
+	}
+}
+
+func NewJWTService(vaultClient *vault.Client, cfg *config.Config, opts ...JWTOption) *JWTService {
+	j := &JWTService{
 		vaultClient: vaultClient,
 		config:      cfg,
 	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	j.jwks = newJWKSCache(cfg.JWT.JWKSCacheTTL, j.fetchJWKS)
+	return j
 }
 
 func (j *JWTService) GenerateAccessToken(userID, clientID, scope string) (string, error) {
 	return j.GenerateAccessTokenWithTenant(userID, clientID, scope, "")
 }
 
-func (j *JWTService) GenerateAccessTokenWithTenant(userID, clientID, scope, tenantID string) (string, error) {
+// GenerateAccessTokenWithTenant issues an access token whose audience is the
+// given resources (RFC 8707), or the configured audience when there are none
+func (j *JWTService) GenerateAccessTokenWithTenant(userID, clientID, scope, tenantID string, resources ...string) (string, error) {
+	audience := models.Audience{j.config.JWT.Audience}
+	if len(resources) > 0 {
+		audience = resources
+	}
+
 	now := time.Now()
 	claims := models.Claims{
-		Issuer:    j.config.JWT.Issuer,
+		Issuer:    j.config.JWT.IssuerForTenant(tenantID),
 		Subject:   userID,
-		Audience:  []string{j.config.JWT.Audience},
-		ExpiresAt: now.Add(j.config.JWT.TokenExpiration).Unix(),
+		Audience:  audience,
+		ExpiresAt: now.Add(j.AccessTokenTTL(scope)).Unix(),
 		NotBefore: now.Unix(),
 		IssuedAt:  now.Unix(),
 		JWTID:     uuid.New().String(),
@@ -45,13 +116,70 @@ func (j *JWTService) GenerateAccessTokenWithTenant(userID, clientID, scope, tena
 		TenantID:  tenantID,
 	}
 
-	return j.signJWT(claims)
+	if j.claims == nil {
+		return j.signJWT(claims, j.accessTokenType())
+	}
+
+	extra, err := j.claims.Claims(userID, clientID, scope)
+	if err != nil {
+		return "", fmt.Errorf("failed to get custom claims: %w", err)
+	}
+	merged, err := mergeClaims(claims, extra)
+	if err != nil {
+		return "", err
+	}
+	return j.signJWT(merged, j.accessTokenType())
+}
+
+// GenerateDelegatedToken issues an access token for the subject of an
+// exchanged token, recording clientID as the actor. Without an audience the
+// configured one is used.
+func (j *JWTService) GenerateDelegatedToken(subject *models.Claims, clientID, scope string, audience ...string) (string, error) {
+	if len(audience) == 0 {
+		audience = []string{j.config.JWT.Audience}
+	}
+
+	now := time.Now()
+	claims := models.Claims{
+		Issuer:    j.config.JWT.IssuerForTenant(subject.TenantID),
+		Subject:   subject.Subject,
+		Audience:  audience,
+		ExpiresAt: now.Add(j.AccessTokenTTL(scope)).Unix(),
+		NotBefore: now.Unix(),
+		IssuedAt:  now.Unix(),
+		JWTID:     uuid.New().String(),
+		Scope:     scope,
+		ClientID:  clientID,
+		TenantID:  subject.TenantID,
+		Act:       &models.Actor{Subject: clientID, Act: subject.Act},
+	}
+
+	return j.signJWT(claims, j.accessTokenType())
+}
+
+// AccessTokenTTL returns the lifetime of an access token carrying scope: the
+// shortest of JWT.TokenExpiration and the JWT.ScopeTokenTTLs of its scopes
+func (j *JWTService) AccessTokenTTL(scope string) time.Duration {
+	ttl := j.config.JWT.TokenExpiration
+	for _, s := range strings.Fields(scope) {
+		if scopeTTL, ok := j.config.JWT.ScopeTokenTTLs[s]; ok && scopeTTL > 0 && scopeTTL < ttl {
+			ttl = scopeTTL
+		}
+	}
+	return ttl
 }
 
 func (j *JWTService) GenerateIDToken(userID, clientID, nonce string) (string, error) {
+	return j.GenerateIDTokenWithClaims(userID, clientID, nonce, time.Time{}, nil)
+}
+
+// GenerateIDTokenWithClaims issues an ID token that also carries userClaims,
+// such as name and email, and the auth_time of authTime unless it is zero.
+// Registered claims, the nonce and auth_time cannot be overridden by them.
+func (j *JWTService) GenerateIDTokenWithClaims(userID, clientID, nonce string, authTime time.Time, userClaims map[string]interface{}) (string, error) {
 	now := time.Now()
 	claims := models.Claims{
-		Issuer:    j.config.JWT.Issuer,
+		Issuer:    j.config.JWT.EffectiveIssuer(),
 		Subject:   userID,
 		Audience:  []string{clientID},
 		ExpiresAt: now.Add(j.config.JWT.TokenExpiration).Unix(),
@@ -59,41 +187,58 @@ func (j *JWTService) GenerateIDToken(userID, clientID, nonce string) (string, er
 		IssuedAt:  now.Unix(),
 		JWTID:     uuid.New().String(),
 	}
+	if !authTime.IsZero() {
+		claims.AuthTime = authTime.Unix()
+	}
+
+	if nonce == "" && len(userClaims) == 0 {
+		return j.signJWT(claims, typeJWT)
+	}
 
+	claimsMap, err := mergeClaims(claims, userClaims)
+	if err != nil {
+		return "", err
+	}
 	// Add nonce if provided (for OIDC)
 	if nonce != "" {
-		claimsMap := map[string]interface{}{
-			"iss":   claims.Issuer,
-			"sub":   claims.Subject,
-			"aud":   claims.Audience,
-			"exp":   claims.ExpiresAt,
-			"nbf":   claims.NotBefore,
-			"iat":   claims.IssuedAt,
-			"jti":   claims.JWTID,
-			"nonce": nonce,
-		}
-		return j.signJWTFromMap(claimsMap)
+		claimsMap["nonce"] = nonce
 	}
+	return j.signJWT(claimsMap, typeJWT)
+}
 
-	return j.signJWT(claims)
+// accessTokenType returns the "typ" header for access tokens
+func (j *JWTService) accessTokenType() string {
+	if j.config.JWT.AccessTokenJWTType {
+		return typeAccessToken
+	}
+	return typeJWT
 }
 
-func (j *JWTService) signJWT(claims models.Claims) (string, error) {
+// signJWT signs claims, a models.Claims or a map of raw claims, with the
+// given "typ" header
+func (j *JWTService) signJWT(claims interface{}, typ string) (string, error) {
 	claimsJSON, err := json.Marshal(claims)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal claims: %w", err)
 	}
+	return j.sign(claimsJSON, typ)
+}
 
-	// Get public key for header
-	_, keyID, err := j.vaultClient.GetPublicKey()
+// sign builds and signs a JWT with the latest cached Vault key for the
+// marshaled claims. Every token goes through here, so they all get the same
+// header apart from its type.
+func (j *JWTService) sign(claimsJSON []byte, typ string) (string, error) {
+	// The key version named in the header is the one Vault signs with, even
+	// if another replica has rotated the key since it was cached
+	keyVersion, keyID, err := j.vaultClient.SigningKey()
 	if err != nil {
 		return "", fmt.Errorf("failed to get public key: %w", err)
 	}
 
 	// Create JWT header
 	header := map[string]interface{}{
-		"alg": "RS256",
-		"typ": "JWT",
+		"alg": j.vaultClient.Algorithm(),
+		"typ": typ,
 		"kid": keyID,
 	}
 
@@ -108,76 +253,134 @@ func (j *JWTService) signJWT(claims models.Claims) (string, error) {
 	payload := headerB64 + "." + claimsB64
 
 	// Sign with Vault
-	signature, err := j.vaultClient.SignJWT([]byte(payload))
+	signature, err := j.vaultClient.SignJWTWithKeyVersion([]byte(payload), keyVersion)
 	if err != nil {
 		return "", fmt.Errorf("failed to sign JWT: %w", err)
 	}
 
-	// Vault returns the signature in the format "vault:v1:signature"
-	// We need to extract just the signature part
-	parts := len("vault:v1:")
-	if len(signature) <= parts {
-		return "", fmt.Errorf("invalid signature format from vault")
+	actualSignature, err := vaultSignatureValue(signature)
+	if err != nil {
+		return "", err
 	}
-	actualSignature := signature[parts:]
 
 	return payload + "." + actualSignature, nil
 }
 
-func (j *JWTService) signJWTFromMap(claims map[string]interface{}) (string, error) {
-	claimsJSON, err := json.Marshal(claims)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal claims: %w", err)
+// vaultSignatureValue extracts the signature from Vault's
+// "vault:v<version>:<signature>" format. The version has as many digits as
+// it needs, so the prefix can't be stripped by length.
+func vaultSignatureValue(signature string) (string, error) {
+	parts := strings.Split(signature, ":")
+	if len(parts) != 3 || parts[0] != "vault" || !isKeyVersion(parts[1]) || parts[2] == "" {
+		return "", fmt.Errorf("invalid signature format from vault")
 	}
+	return parts[2], nil
+}
 
-	// Get public key for header
-	_, keyID, err := j.vaultClient.GetPublicKey()
-	if err != nil {
-		return "", fmt.Errorf("failed to get public key: %w", err)
+// isKeyVersion reports whether s is a Vault key version such as "v12"
+func isKeyVersion(s string) bool {
+	version, ok := strings.CutPrefix(s, "v")
+	if !ok || version == "" {
+		return false
+	}
+	for _, c := range version {
+		if c < '0' || c > '9' {
+			return false
+		}
 	}
+	return true
+}
 
-	// Create JWT header
-	header := map[string]interface{}{
-		"alg": "RS256",
-		"typ": "JWT",
-		"kid": keyID,
+// ValidateAccessToken validates a token against the configured audience, or
+// without an audience check when JWT.ValidateAudience is off
+func (j *JWTService) ValidateAccessToken(token string) (*models.Claims, error) {
+	if !j.config.JWT.ValidateAudience {
+		return j.ValidateAccessTokenForAudience(token, "")
 	}
+	return j.ValidateAccessTokenForAudience(token, j.config.JWT.Audience)
+}
 
-	headerJSON, err := json.Marshal(header)
+// ValidateAccessTokenForTenant validates a token like ValidateAccessToken and
+// also checks that it was issued for tenantID, so that a token for one
+// tenant can't be used on another tenant's data
+func (j *JWTService) ValidateAccessTokenForTenant(token, tenantID string) (*models.Claims, error) {
+	claims, err := j.ValidateAccessToken(token)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal header: %w", err)
+		return nil, err
 	}
 
-	// Create JWT payload (header.claims)
-	headerB64 := base64.RawURLEncoding.EncodeToString(headerJSON)
-	claimsB64 := base64.RawURLEncoding.EncodeToString(claimsJSON)
-	payload := headerB64 + "." + claimsB64
+	if claims.TenantID == "" {
+		return nil, fmt.Errorf("%w %q: token has no tenant", ErrTenantMismatch, tenantID)
+	}
+	if tenantID == "" || claims.TenantID != tenantID {
+		return nil, fmt.Errorf("%w %q: token is for %q", ErrTenantMismatch, tenantID, claims.TenantID)
+	}
+	return claims, nil
+}
 
-	// Sign with Vault
-	signature, err := j.vaultClient.SignJWT([]byte(payload))
+// ValidateAccessTokenForAudience validates a token and checks that its "aud"
+// claim contains the expected audience. An empty audience skips the check.
+func (j *JWTService) ValidateAccessTokenForAudience(token, audience string) (*models.Claims, error) {
+	claims, err := parseClaims(token)
 	if err != nil {
-		return "", fmt.Errorf("failed to sign JWT: %w", err)
+		return nil, err
 	}
 
-	// Vault returns the signature in the format "vault:v1:signature"
-	// We need to extract just the signature part
-	parts := len("vault:v1:")
-	if len(signature) <= parts {
-		return "", fmt.Errorf("invalid signature format from vault")
+	// Verify signature, locally against the cached keys when enabled
+	verify := j.vaultClient.VerifyJWT
+	if j.config.JWT.LocalVerification {
+		verify = j.vaultClient.VerifyJWTLocally
+	}
+	isValid, err := verify(token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify JWT signature: %w", err)
 	}
-	actualSignature := signature[parts:]
 
-	return payload + "." + actualSignature, nil
+	if !isValid {
+		return nil, fmt.Errorf("invalid JWT signature")
+	}
+
+	// Check revocation; without a denylist nothing can have been revoked
+	if j.denylist != nil {
+		revoked, err := j.denylist.IsJTIRevoked(claims.JWTID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check revocation: %w", err)
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+	}
+
+	// Check expiration and not before, allowing for clock skew
+	now := time.Now().Unix()
+	leeway := int64(j.config.JWT.ClockSkew.Seconds())
+	if now > claims.ExpiresAt+leeway {
+		return nil, ErrTokenExpired
+	}
+	if now < claims.NotBefore-leeway {
+		return nil, fmt.Errorf("token not yet valid")
+	}
+
+	// Check issuer, which is the tenant's own when it has one
+	if claims.Issuer != j.config.JWT.IssuerForTenant(claims.TenantID) {
+		return nil, fmt.Errorf("invalid issuer")
+	}
+
+	// Check audience
+	if audience != "" && !claims.Audience.Contains(audience) {
+		return nil, fmt.Errorf("invalid audience: token is not intended for %q", audience)
+	}
+
+	return claims, nil
 }
 
-func (j *JWTService) ValidateAccessToken(token string) (*models.Claims, error) {
-	// Parse the JWT manually to extract claims
+// parseClaims decodes the claims of a JWT without verifying it
+func parseClaims(token string) (*models.Claims, error) {
 	parts := strings.Split(token, ".")
 	if len(parts) != 3 {
 		return nil, fmt.Errorf("invalid JWT format")
 	}
 
-	// Decode claims
 	claimsBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode claims: %w", err)
@@ -187,41 +390,109 @@ func (j *JWTService) ValidateAccessToken(token string) (*models.Claims, error) {
 	if err := json.Unmarshal(claimsBytes, &claims); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal claims: %w", err)
 	}
+	if claims.Extra, err = extraClaims(claimsBytes); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal claims: %w", err)
+	}
+	return &claims, nil
+}
 
-	// Verify signature with Vault
-	isValid, err := j.vaultClient.VerifyJWT(token)
+// accessTokenID returns the jti of an access token this service just
+// issued, for the audit trail
+func accessTokenID(token string) string {
+	claims, err := parseClaims(token)
 	if err != nil {
-		return nil, fmt.Errorf("failed to verify JWT signature: %w", err)
+		return ""
 	}
+	return claims.JWTID
+}
 
-	if !isValid {
-		return nil, fmt.Errorf("invalid JWT signature")
+// RevokeAccessToken denylists the token's jti for the rest of its lifetime
+// so that later validations fail. Tokens that don't validate are ignored
+// since they are already unusable.
+func (j *JWTService) RevokeAccessToken(token string) error {
+	claims, err := j.ValidateAccessToken(token)
+	if err != nil {
+		return nil
 	}
 
-	// Check expiration
-	if time.Now().Unix() > claims.ExpiresAt {
-		return nil, fmt.Errorf("token expired")
-	}
+	return j.revokeToken(token, claims)
+}
 
-	// Check not before
-	if time.Now().Unix() < claims.NotBefore {
-		return nil, fmt.Errorf("token not yet valid")
+// revokeToken denylists the validated access token with claims until it
+// expires, including the clock skew validation allows past "exp", and tells
+// the revocation hooks
+func (j *JWTService) revokeToken(token string, claims *models.Claims) error {
+	if j.denylist == nil {
+		return ErrNoDenylist
+	}
+	if err := j.denylist.RevokeJTI(claims.JWTID, time.Unix(claims.ExpiresAt, 0).Add(j.config.JWT.ClockSkew)); err != nil {
+		return err
+	}
+	j.hooksMutex.Lock()
+	hooks := make([]func(string), 0, len(j.revocationHooks))
+	for _, hook := range j.revocationHooks {
+		hooks = append(hooks, hook)
+	}
+	j.hooksMutex.Unlock()
+	for _, hook := range hooks {
+		hook(token)
 	}
+	return nil
+}
 
-	// Check issuer
-	if claims.Issuer != j.config.JWT.Issuer {
-		return nil, fmt.Errorf("invalid issuer")
+// onRevoke registers hook to be told each access token that is revoked. The
+// returned function unregisters it.
+func (j *JWTService) onRevoke(hook func(token string)) func() {
+	j.hooksMutex.Lock()
+	defer j.hooksMutex.Unlock()
+	if j.revocationHooks == nil {
+		j.revocationHooks = make(map[int]func(string))
+	}
+	id := j.nextHookID
+	j.nextHookID++
+	j.revocationHooks[id] = hook
+	return func() {
+		j.hooksMutex.Lock()
+		defer j.hooksMutex.Unlock()
+		delete(j.revocationHooks, id)
 	}
+}
 
-	return &claims, nil
+// jtiRevoked reports whether jti is denylisted. A failed lookup counts as
+// revoked, since it is used to decide whether a cached result still holds.
+func (j *JWTService) jtiRevoked(jti string) bool {
+	if j.denylist == nil {
+		return false
+	}
+	revoked, err := j.denylist.IsJTIRevoked(jti)
+	return err != nil || revoked
 }
 
+// GetJWKS returns the JSON Web Key Set, served from a cache that is refreshed
+// in the background after JWT.JWKSCacheTTL. It only fails if the key set has
+// never been fetched successfully.
 func (j *JWTService) GetJWKS() ([]byte, error) {
+	jwks, _, err := j.jwks.get()
+	return jwks, err
+}
+
+// GetJWKSWithETag is GetJWKS that also returns a strong ETag for the key set,
+// which changes whenever the published keys do
+func (j *JWTService) GetJWKSWithETag() ([]byte, string, error) {
+	return j.jwks.get()
+}
+
+func (j *JWTService) fetchJWKS() ([]byte, error) {
 	jwks, err := j.vaultClient.GetJWKS()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get JWKS: %w", err)
 	}
 
+	// Keys are ordered newest first, so this keeps the most recent versions
+	if limit := j.keysInJWKS(); limit > 0 && len(jwks.Keys) > limit {
+		jwks.Keys = jwks.Keys[:limit]
+	}
+
 	jwksJSON, err := json.Marshal(jwks)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal JWKS: %w", err)
@@ -230,6 +501,110 @@ func (j *JWTService) GetJWKS() ([]byte, error) {
 	return jwksJSON, nil
 }
 
+// keysInJWKS returns how many key versions the JWKS keeps: JWT.KeysInJWKS,
+// raised when keys rotate often enough that it would drop keys live tokens
+// were signed with. A replica can sign with a version for up to a rotation
+// interval after it is superseded, and the token then lives for
+// TokenExpiration plus the clock skew.
+func (j *JWTService) keysInJWKS() int {
+	limit := j.config.JWT.KeysInJWKS
+	interval := j.config.JWT.KeyRotationInterval
+	if limit <= 0 || interval <= 0 {
+		return limit
+	}
+
+	lifetime := j.config.JWT.TokenExpiration + j.config.JWT.ClockSkew
+	if needed := int((lifetime+interval-1)/interval) + 2; needed > limit {
+		return needed
+	}
+	return limit
+}
+
+// HealthCheck reports whether Vault can currently be used for signing
+func (j *JWTService) HealthCheck() error {
+	return j.vaultClient.HealthCheck()
+}
+
+// RotateKeys rotates the signing key and refreshes the cached JWKS so the
+// new key is published before any token is signed with it
 func (j *JWTService) RotateKeys() error {
-	return j.vaultClient.RotateKey()
+	if err := j.vaultClient.RotateKey(); err != nil {
+		return err
+	}
+	metrics.SetLastKeyRotation(time.Now())
+
+	if err := j.jwks.refresh(); err != nil {
+		log.Printf("Failed to refresh JWKS after key rotation: %v", err)
+	}
+	return nil
+}
+
+// StartKeyRotation rotates the signing key every KeyRotationInterval until
+// ctx is canceled. A zero interval disables scheduled rotation. Replicas
+// sharing the transit key each run a rotator, but one only rotates when the
+// latest version is about an interval old; otherwise it picks up the version
+// another replica rotated to. Replicas whose ticks coincide may still both
+// rotate. The returned channel is closed once the rotator has exited,
+// including any rotation that was in flight when ctx was canceled.
+func (j *JWTService) StartKeyRotation(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+
+	interval := j.config.JWT.KeyRotationInterval
+	if interval <= 0 {
+		close(done)
+		return done
+	}
+
+	go j.rotateKeysPeriodically(ctx, interval, done)
+	return done
+}
+
+func (j *JWTService) rotateKeysPeriodically(ctx context.Context, interval time.Duration, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// select picks randomly when both are ready, so don't rotate
+			// after cancellation just because a tick was also pending
+			if ctx.Err() != nil {
+				return
+			}
+			j.rotateKeysAndRecord(interval)
+		}
+	}
+}
+
+func (j *JWTService) rotateKeysAndRecord(interval time.Duration) {
+	start := time.Now()
+
+	// Ticks come an interval after this replica's own last rotation, which
+	// Vault stamped a little later, so allow some slack
+	rotated, err := j.vaultClient.RotateKeyIfOlderThan(interval - interval/10)
+	if err != nil {
+		metrics.RecordVaultOperation("rotate_key", "error")
+		log.Printf("Failed to rotate signing key: %v", err)
+		return
+	}
+	if !rotated {
+		// Another replica rotated; publish the version it rotated to
+		if err := j.jwks.refresh(); err != nil {
+			log.Printf("Failed to refresh JWKS after key rotation: %v", err)
+		}
+		return
+	}
+	metrics.SetLastKeyRotation(time.Now())
+	if err := j.jwks.refresh(); err != nil {
+		log.Printf("Failed to refresh JWKS after key rotation: %v", err)
+	}
+
+	metrics.ObserveKeyRotationDuration(time.Since(start))
+	metrics.RecordKeyRotation()
+	metrics.RecordVaultOperation("rotate_key", "success")
+	log.Printf("Rotated signing key")
 }
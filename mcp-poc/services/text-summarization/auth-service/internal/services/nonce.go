@@ -0,0 +1,95 @@
+package services
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Defaults used when the nonce replay cache is not configured
+const (
+	defaultNonceTTL       = 10 * time.Minute
+	defaultNonceCacheSize = 10000
+)
+
+// nonceCache remembers recently seen client_id+nonce pairs so an ID token
+// nonce cannot be replayed in another authorization request. It holds at most
+// maxSize entries and evicts the oldest first when full. It also tracks
+// consumed authorization codes to detect their reuse.
+type nonceCache struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type nonceEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+func newNonceCache(ttl time.Duration, maxSize int) *nonceCache {
+	if ttl <= 0 {
+		ttl = defaultNonceTTL
+	}
+	if maxSize <= 0 {
+		maxSize = defaultNonceCacheSize
+	}
+
+	return &nonceCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// checkAndStore records the nonce for the client and reports whether it was
+// fresh. It returns false if the pair was seen within the TTL.
+func (c *nonceCache) checkAndStore(clientID, nonce string, now time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.removeExpired(now)
+
+	key := clientID + "\x00" + nonce
+	if _, seen := c.entries[key]; seen {
+		return false
+	}
+
+	if c.order.Len() >= c.maxSize {
+		c.remove(c.order.Front())
+	}
+
+	c.entries[key] = c.order.PushBack(&nonceEntry{key: key, expiresAt: now.Add(c.ttl)})
+	return true
+}
+
+// contains reports whether the pair was stored within the TTL, without
+// storing it
+func (c *nonceCache) contains(clientID, nonce string, now time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.removeExpired(now)
+
+	_, seen := c.entries[clientID+"\x00"+nonce]
+	return seen
+}
+
+func (c *nonceCache) removeExpired(now time.Time) {
+	// Entries share one TTL, so insertion order is also expiry order
+	for front := c.order.Front(); front != nil; front = c.order.Front() {
+		entry := front.Value.(*nonceEntry)
+		if now.Before(entry.expiresAt) {
+			break
+		}
+		c.remove(front)
+	}
+}
+
+func (c *nonceCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*nonceEntry).key)
+}
@@ -1,8 +1,13 @@
 package services
 
 import (
+	"context"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
 	"strings"
 	"sync"
 	"time"
@@ -11,216 +16,411 @@ import (
 
 	"auth-service/internal/config"
 	"auth-service/internal/models"
+	"auth-service/internal/store"
+	"auth-service/pkg/metrics"
 )
 
+// Code verifier length bounds from RFC 7636 section 4.1
+const (
+	minCodeVerifierLength = 43
+	maxCodeVerifierLength = 128
+)
+
+// Token exchange identifiers from RFC 8693 sections 2.1 and 3
+const (
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	TokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// defaultMaxStateLength caps state when OAuth.MaxStateLength isn't positive
+const defaultMaxStateLength = 1024
+
+// defaultMaxBatchIntrospection caps IntrospectTokens when
+// OAuth.MaxBatchIntrospection isn't positive
+const defaultMaxBatchIntrospection = 100
+
+// batchIntrospectionWorkers bounds how many tokens of one batch are
+// validated concurrently
+const batchIntrospectionWorkers = 8
+
+// defaultCleanupInterval is used when OAuth.CleanupInterval isn't positive.
+// It is kept well below the authorization code lifetime.
+const defaultCleanupInterval = 5 * time.Minute
+
+// ErrUnsupportedTokenType is returned by RevokeToken when the presented
+// token is of a type this server cannot revoke.
+var ErrUnsupportedTokenType = errors.New("unsupported token type")
+
+// ErrTokenNotIssuedToClient is returned when a client revokes a token issued
+// to another client (RFC 7009 section 2.1)
+var ErrTokenNotIssuedToClient = errors.New("token was not issued to this client")
+
+// ErrBatchTooLarge is returned by IntrospectTokens when given more tokens
+// than OAuth.MaxBatchIntrospection allows.
+var ErrBatchTooLarge = errors.New("too many tokens in batch")
+
 type OAuthService struct {
-	config           *config.Config
-	jwtService       *JWTService
-	authCodes        map[string]*models.AuthorizationCode
-	refreshTokens    map[string]*models.RefreshToken
-	mutex            sync.RWMutex
+	config        *config.Config
+	jwtService    *JWTService
+	store         store.TokenStore
+	nonces        *nonceCache
+	usedCodes     *nonceCache // exchanged authorization codes, for reuse detection
+	assertions    *nonceCache // jtis of client assertions, for replay detection
+	pushed        *pushedRequests
+	introspection *introspectionCache
+	unhook        func() // unregisters the introspection cache's revocation hook
+	userInfo      UserInfoProvider
+	audit         AuditSink
+	tenants       TenantResolver
+	ctx           context.Context
+	stop          chan struct{}
+	stopOnce      sync.Once
+	done          chan struct{}
+}
+
+// OAuthOption customizes an OAuthService at construction time
+type OAuthOption func(*OAuthService)
+
+// WithTokenStore sets the store used for authorization codes and refresh
+// tokens. Defaults to an in-memory store.
+func WithTokenStore(tokenStore store.TokenStore) OAuthOption {
+	return func(o *OAuthService) {
+		o.store = tokenStore
+	}
+}
+
+// WithContext ties the background cleanup goroutine to ctx, so it exits when
+// ctx is cancelled as well as on Stop
+func WithContext(ctx context.Context) OAuthOption {
+	return func(o *OAuthService) {
+		o.ctx = ctx
+	}
 }
 
-func NewOAuthService(cfg *config.Config, jwtService *JWTService) *OAuthService {
+func NewOAuthService(cfg *config.Config, jwtService *JWTService, opts ...OAuthOption) *OAuthService {
 	service := &OAuthService{
 		config:        cfg,
 		jwtService:    jwtService,
-		authCodes:     make(map[string]*models.AuthorizationCode),
-		refreshTokens: make(map[string]*models.RefreshToken),
+		store:         store.NewMemoryStore(store.WithMaxAuthCodes(cfg.OAuth.MaxAuthCodes), store.WithMaxRefreshTokens(cfg.OAuth.MaxRefreshTokens)),
+		nonces:        newNonceCache(cfg.OAuth.NonceTTL, cfg.OAuth.NonceCacheSize),
+		usedCodes:     newNonceCache(cfg.OAuth.CodeExpiration, cfg.OAuth.NonceCacheSize),
+		assertions:    newNonceCache(maxAssertionLifetime+cfg.JWT.ClockSkew, cfg.OAuth.NonceCacheSize),
+		pushed:        newPushedRequests(),
+		introspection: newIntrospectionCache(cfg.OAuth.IntrospectionCacheTTL),
+		audit:         discardAuditSink{},
+		ctx:           context.Background(),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
 	}
 
-	// Start cleanup goroutine
+	for _, opt := range opts {
+		opt(service)
+	}
+
+	// Revoked access tokens go in the token store, so they are shared with
+	// other replicas using it and swept with the rest of it
+	if jwtService != nil && jwtService.denylist == nil {
+		jwtService.denylist = service.store
+	}
+	service.unhook = func() {}
+	if jwtService != nil {
+		service.unhook = jwtService.onRevoke(service.introspection.remove)
+	}
+
+	// Clear anything that expired while the service was down rather than
+	// waiting a full interval, then start the cleanup goroutine
+	service.deleteExpiredTokens()
 	go service.cleanupExpiredTokens()
 
 	return service
 }
 
 func (o *OAuthService) HandleAuthorizationRequest(req *models.AuthorizationRequest) (*models.AuthorizationCode, *models.ErrorResponse) {
+	if errorResp := o.validateAuthorizationRequest(req); errorResp != nil {
+		return nil, errorResp
+	}
+
+	if req.UserID == "" {
+		return nil, models.NewLoginRequired("The user must be authenticated").WithState(req.State)
+	}
+
+	if errorResp := o.checkConsent(req); errorResp != nil {
+		return nil, errorResp
+	}
+
+	// Reject replayed nonces; checked after the request is validated so
+	// invalid requests don't use one up, and before a pushed request is used
+	// up so a rejected one doesn't take its request_uri with it
+	if req.Nonce != "" && !o.nonces.checkAndStore(req.ClientID, req.Nonce, time.Now()) {
+		return nil, models.NewInvalidRequest("nonce has already been used").WithState(req.State)
+	}
+
+	// A pushed request yields a single code, even when authorized concurrently
+	if req.RequestURI != "" {
+		if _, ok := o.pushed.take(req.RequestURI); !ok {
+			return nil, models.NewInvalidRequestURI("Unknown or already used request_uri").WithState(req.State)
+		}
+	}
+
+	// Generate authorization code
+	code := uuid.New().String()
+	authCode := &models.AuthorizationCode{
+		Code:                code,
+		ClientID:            req.ClientID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               req.Scope,
+		State:               req.State,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		Nonce:               req.Nonce,
+		Resources:           req.Resources,
+		ExpiresAt:           time.Now().Add(o.config.OAuth.CodeExpiration),
+		UserID:              req.UserID,
+		AuthTime:            req.AuthTime,
+	}
+
+	if err := o.store.SaveAuthCode(authCode); err != nil {
+		if errors.Is(err, store.ErrCapacity) {
+			metrics.RecordStoreCapacityRejection("authorization_code")
+			return nil, models.NewTemporarilyUnavailable("Too many pending authorization requests, try again later").WithState(req.State)
+		}
+		return nil, models.NewServerError("Failed to store authorization code").WithState(req.State)
+	}
+	o.reportActiveCounts()
+	o.recordAudit(AuditEvent{
+		Type:     AuditCodeIssued,
+		ClientID: authCode.ClientID,
+		UserID:   authCode.UserID,
+		Scope:    authCode.Scope,
+	})
+
+	return authCode, nil
+}
+
+// ValidateAuthorizationRequest checks an authorization request without
+// issuing a code, so that invalid requests can be rejected before the user
+// is asked to log in
+func (o *OAuthService) ValidateAuthorizationRequest(req *models.AuthorizationRequest) *models.ErrorResponse {
+	return o.validateAuthorizationRequest(req)
+}
+
+// validateAuthorizationRequest checks an authorization request against the
+// client registration and PKCE policy. It defaults an empty
+// code_challenge_method to plain and drops requested scopes the client may not
+// be granted.
+func (o *OAuthService) validateAuthorizationRequest(req *models.AuthorizationRequest) *models.ErrorResponse {
 	// Validate response_type
 	if req.ResponseType != "code" {
-		return nil, &models.ErrorResponse{
-			Error:            "unsupported_response_type",
-			ErrorDescription: "Only 'code' response type is supported",
-			State:            req.State,
-		}
+		return models.NewUnsupportedResponseType("Only 'code' response type is supported").WithState(req.State)
 	}
 
 	// Validate client_id
-	if req.ClientID != o.config.OAuth.ClientID {
-		return nil, &models.ErrorResponse{
-			Error:            "invalid_client",
-			ErrorDescription: "Invalid client_id",
-			State:            req.State,
-		}
+	client, ok := o.config.OAuth.GetClient(req.ClientID)
+	if !ok {
+		return models.NewInvalidClient("Invalid client_id").WithState(req.State)
 	}
 
 	// Validate redirect_uri
-	if !o.isValidRedirectURI(req.RedirectURI) {
-		return nil, &models.ErrorResponse{
-			Error:            "invalid_request",
-			ErrorDescription: "Invalid redirect_uri",
-			State:            req.State,
-		}
+	if !o.isValidRedirectURI(client, req.RedirectURI) {
+		return models.NewInvalidRequest("Invalid redirect_uri").WithState(req.State)
+	}
+
+	if errorResp := o.validateState(req.State); errorResp != nil {
+		return errorResp
+	}
+
+	// Without a salt anyone could recompute the pairwise subjects from user
+	// IDs, so pairwise clients are refused until one is configured
+	if client.SubjectType == config.SubjectTypePairwise && o.config.OAuth.PairwiseSalt == "" {
+		return models.NewServerError("Pairwise subject identifiers are not configured").WithState(req.State)
+	}
+
+	if errorResp := validatePrompt(req); errorResp != nil {
+		return errorResp.WithState(req.State)
 	}
 
 	// Validate PKCE (required in OAuth 2.1)
 	if o.config.OAuth.PKCERequired {
 		if req.CodeChallenge == "" {
-			return nil, &models.ErrorResponse{
-				Error:            "invalid_request",
-				ErrorDescription: "code_challenge is required",
-				State:            req.State,
-			}
+			return models.NewInvalidRequest("code_challenge is required").WithState(req.State)
 		}
 
 		if req.CodeChallengeMethod == "" {
+			if o.config.OAuth.RequireS256 {
+				return models.NewInvalidRequest("code_challenge_method is required and must be 'S256'").WithState(req.State)
+			}
 			req.CodeChallengeMethod = "plain" // Default per spec
 		}
 
 		if req.CodeChallengeMethod != "S256" && req.CodeChallengeMethod != "plain" {
-			return nil, &models.ErrorResponse{
-				Error:            "invalid_request",
-				ErrorDescription: "Invalid code_challenge_method. Only 'S256' and 'plain' are supported",
-				State:            req.State,
-			}
+			return models.NewInvalidRequest("Invalid code_challenge_method. Only 'S256' and 'plain' are supported").WithState(req.State)
+		}
+
+		// OAuth 2.1 discourages plain, so only legacy clients may opt back in
+		if req.CodeChallengeMethod == "plain" && !o.plainPKCEAllowed() {
+			return models.NewInvalidRequest("code_challenge_method 'plain' is not allowed, use 'S256'").WithState(req.State)
 		}
-	}
 
-	// Validate scope
-	if !o.isValidScope(req.Scope) {
-		return nil, &models.ErrorResponse{
-			Error:            "invalid_scope",
-			ErrorDescription: "Invalid or unsupported scope",
-			State:            req.State,
+		if req.CodeChallengeMethod == "S256" && !isValidS256Challenge(req.CodeChallenge) {
+			return models.NewInvalidRequest("code_challenge must be a base64url-encoded SHA-256 hash").WithState(req.State)
 		}
 	}
 
-	// Generate authorization code
-	code := uuid.New().String()
-	authCode := &models.AuthorizationCode{
-		Code:                code,
-		ClientID:            req.ClientID,
-		RedirectURI:         req.RedirectURI,
-		Scope:               req.Scope,
-		State:               req.State,
-		CodeChallenge:       req.CodeChallenge,
-		CodeChallengeMethod: req.CodeChallengeMethod,
-		Nonce:               req.Nonce,
-		ExpiresAt:           time.Now().Add(o.config.OAuth.CodeExpiration),
-		UserID:              "demo-user", // In a real implementation, this would come from authentication
+	resources, errorResp := o.validateResources(req.Resources)
+	if errorResp != nil {
+		errorResp.State = req.State
+		return errorResp
 	}
+	req.Resources = resources
 
-	o.mutex.Lock()
-	o.authCodes[code] = authCode
-	o.mutex.Unlock()
+	// Narrow the scope to what the client may be granted
+	scope, ok := o.grantableScope(client, req.Scope)
+	if !ok {
+		return models.NewInvalidScope("Invalid or unsupported scope").WithState(req.State)
+	}
+	req.Scope = scope
 
-	return authCode, nil
+	return nil
 }
 
+// HandleTokenRequest runs the grant named by req.GrantType. Refused
+// requests are audited as failures.
 func (o *OAuthService) HandleTokenRequest(req *models.TokenRequest) (*models.TokenResponse, *models.ErrorResponse) {
+	response, errorResp := o.handleGrant(req)
+	if errorResp != nil {
+		o.recordTokenFailure(req, errorResp)
+	}
+	return response, errorResp
+}
+
+func (o *OAuthService) handleGrant(req *models.TokenRequest) (*models.TokenResponse, *models.ErrorResponse) {
 	switch req.GrantType {
 	case "authorization_code":
 		return o.handleAuthorizationCodeGrant(req)
 	case "refresh_token":
 		return o.handleRefreshTokenGrant(req)
+	case GrantTypeTokenExchange:
+		return o.handleTokenExchangeGrant(req)
 	default:
-		return nil, &models.ErrorResponse{
-			Error:            "unsupported_grant_type",
-			ErrorDescription: "Only 'authorization_code' and 'refresh_token' grant types are supported",
-		}
+		return nil, models.NewUnsupportedGrantType("Only 'authorization_code', 'refresh_token' and token exchange grant types are supported")
 	}
 }
 
+// authenticateClient looks up the requesting client and, for confidential
+// clients, checks the presented secret. Public clients have no secret and
+// rely on PKCE instead. Clients with a registered JWK must use a client
+// assertion, see authenticateTokenClient.
+func (o *OAuthService) authenticateClient(clientID, clientSecret string) (*config.ClientConfig, *models.ErrorResponse) {
+	client, ok := o.config.OAuth.GetClient(clientID)
+	if !ok {
+		return nil, models.NewInvalidClient("Invalid client_id")
+	}
+
+	if len(client.JWK) > 0 {
+		return nil, models.NewInvalidClient("Client must authenticate with private_key_jwt")
+	}
+
+	if client.ClientSecret != "" &&
+		subtle.ConstantTimeCompare([]byte(client.ClientSecret), []byte(clientSecret)) != 1 {
+		return nil, models.NewInvalidClient("Client authentication failed")
+	}
+
+	return client, nil
+}
+
 func (o *OAuthService) handleAuthorizationCodeGrant(req *models.TokenRequest) (*models.TokenResponse, *models.ErrorResponse) {
-	// Validate client_id
-	if req.ClientID != o.config.OAuth.ClientID {
-		return nil, &models.ErrorResponse{
-			Error:            "invalid_client",
-			ErrorDescription: "Invalid client_id",
-		}
+	// Authenticate the client
+	client, errorResp := o.authenticateTokenClient(req)
+	if errorResp != nil {
+		return nil, errorResp
 	}
 
 	// Get and validate authorization code
-	o.mutex.RLock()
-	authCode, exists := o.authCodes[req.Code]
-	o.mutex.RUnlock()
-
-	if !exists {
-		return nil, &models.ErrorResponse{
-			Error:            "invalid_grant",
-			ErrorDescription: "Invalid authorization code",
+	authCode, err := o.store.GetAuthCode(req.Code)
+	if errors.Is(err, store.ErrNotFound) {
+		// A consumed code being presented again suggests it was intercepted
+		if o.usedCodes.contains("", req.Code, time.Now()) {
+			metrics.RecordCodeReuse()
+			log.Printf("Security: reuse of consumed authorization code by client %q", req.ClientID)
 		}
+		return nil, models.NewInvalidGrant("Invalid authorization code")
+	}
+	if err != nil {
+		return nil, models.NewServerError("Failed to look up authorization code")
 	}
 
 	// Check if code is expired
 	if time.Now().After(authCode.ExpiresAt) {
 		// Remove expired code
-		o.mutex.Lock()
-		delete(o.authCodes, req.Code)
-		o.mutex.Unlock()
+		o.store.DeleteAuthCode(req.Code)
+		o.reportActiveCounts()
 
-		return nil, &models.ErrorResponse{
-			Error:            "invalid_grant",
-			ErrorDescription: "Authorization code expired",
-		}
+		return nil, models.NewInvalidGrant("Authorization code expired")
 	}
 
 	// Validate client_id matches
 	if authCode.ClientID != req.ClientID {
-		return nil, &models.ErrorResponse{
-			Error:            "invalid_grant",
-			ErrorDescription: "Client ID mismatch",
-		}
+		return nil, models.NewInvalidGrant("Client ID mismatch")
 	}
 
 	// Validate redirect_uri matches
 	if authCode.RedirectURI != req.RedirectURI {
-		return nil, &models.ErrorResponse{
-			Error:            "invalid_grant",
-			ErrorDescription: "Redirect URI mismatch",
-		}
+		return nil, models.NewInvalidGrant("Redirect URI mismatch")
 	}
 
 	// Validate PKCE
 	if o.config.OAuth.PKCERequired && authCode.CodeChallenge != "" {
 		if req.CodeVerifier == "" {
-			return nil, &models.ErrorResponse{
-				Error:            "invalid_request",
-				ErrorDescription: "code_verifier is required",
-			}
+			return nil, models.NewInvalidRequest("code_verifier is required")
+		}
+
+		if !isValidCodeVerifier(req.CodeVerifier) {
+			return nil, models.NewInvalidRequest("code_verifier must be between 43 and 128 characters from A-Z, a-z, 0-9, '-', '.', '_' and '~'")
 		}
 
 		if !o.verifyPKCE(authCode.CodeChallenge, authCode.CodeChallengeMethod, req.CodeVerifier) {
-			return nil, &models.ErrorResponse{
-				Error:            "invalid_grant",
-				ErrorDescription: "Invalid code_verifier",
-			}
+			return nil, models.NewInvalidGrant("Invalid code_verifier")
+		}
+	}
+
+	resources, errorResp := o.tokenResources(req.Resources, authCode.Resources)
+	if errorResp != nil {
+		return nil, errorResp
+	}
+
+	tenantID, errorResp := o.resolveTenant(authCode.UserID)
+	if errorResp != nil {
+		return nil, errorResp
+	}
+
+	// Remove the used authorization code. Only one of several concurrent
+	// exchanges of the code gets to remove it, and the others fail as if the
+	// code had already been used.
+	if err := o.store.ConsumeAuthCode(req.Code); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			metrics.RecordCodeReuse()
+			log.Printf("Security: concurrent reuse of authorization code by client %q", req.ClientID)
+			return nil, models.NewInvalidGrant("Invalid authorization code")
 		}
+		return nil, models.NewServerError("Failed to consume authorization code")
 	}
+	o.usedCodes.checkAndStore("", req.Code, time.Now())
+	o.reportActiveCounts()
 
-	// Remove the used authorization code
-	o.mutex.Lock()
-	delete(o.authCodes, req.Code)
-	o.mutex.Unlock()
+	// The client's allowed scopes may have shrunk since the code was issued
+	scope, ok := o.grantableScope(client, authCode.Scope)
+	if !ok {
+		return nil, models.NewInvalidScope("None of the authorized scopes may be granted to this client")
+	}
 
 	// Generate access token with tenant_id
 	if o.jwtService == nil {
-		return nil, &models.ErrorResponse{
-			Error:            "server_error",
-			ErrorDescription: "JWT service not configured",
-		}
+		return nil, models.NewServerError("JWT service not configured")
 	}
-	
-	// For demo purposes, derive tenant_id from user_id or use a default
-	// In production, this would come from user authentication context
-	tenantID := "tenant-" + authCode.UserID // Simple demo mapping
-	
-	accessToken, err := o.jwtService.GenerateAccessTokenWithTenant(authCode.UserID, authCode.ClientID, authCode.Scope, tenantID)
+
+	accessToken, err := o.jwtService.GenerateAccessTokenWithTenant(authCode.UserID, authCode.ClientID, scope, tenantID, resources...)
 	if err != nil {
-		return nil, &models.ErrorResponse{
-			Error:            "server_error",
-			ErrorDescription: "Failed to generate access token",
-		}
+		return nil, models.NewServerError("Failed to generate access token")
 	}
 
 	// Generate refresh token
@@ -229,101 +429,223 @@ func (o *OAuthService) handleAuthorizationCodeGrant(req *models.TokenRequest) (*
 		Token:     refreshToken,
 		ClientID:  authCode.ClientID,
 		UserID:    authCode.UserID,
-		Scope:     authCode.Scope,
+		Scope:     scope,
+		Resources: authCode.Resources,
 		ExpiresAt: time.Now().Add(o.config.JWT.RefreshTokenTTL),
 	}
 
-	o.mutex.Lock()
-	o.refreshTokens[refreshToken] = refreshTokenData
-	o.mutex.Unlock()
+	if err := o.store.SaveRefreshToken(refreshTokenData); err != nil {
+		if errors.Is(err, store.ErrCapacity) {
+			metrics.RecordStoreCapacityRejection("refresh_token")
+			return nil, models.NewTemporarilyUnavailable("Too many active refresh tokens, try again later")
+		}
+		return nil, models.NewServerError("Failed to store refresh token")
+	}
+	o.reportActiveCounts()
 
 	response := &models.TokenResponse{
 		AccessToken:  accessToken,
 		TokenType:    "Bearer",
-		ExpiresIn:    int64(o.config.JWT.TokenExpiration.Seconds()),
+		ExpiresIn:    int64(o.jwtService.AccessTokenTTL(scope).Seconds()),
 		RefreshToken: refreshToken,
-		Scope:        authCode.Scope,
+		Scope:        scope,
 	}
 
-	// Generate ID token if openid scope is requested
-	if strings.Contains(authCode.Scope, "openid") {
-		idToken, err := o.jwtService.GenerateIDToken(authCode.UserID, authCode.ClientID, authCode.Nonce)
+	// Generate ID token if openid scope is granted
+	if containsString(strings.Fields(scope), "openid") {
+		// Without the user's claims, clients can still fetch them from the
+		// UserInfo endpoint
+		userClaims, _ := o.idTokenUserClaims(authCode.UserID, scope)
+		idToken, err := o.jwtService.GenerateIDTokenWithClaims(o.subjectFor(authCode.UserID, client), authCode.ClientID, authCode.Nonce, authCode.AuthTime, userClaims)
 		if err == nil {
 			response.IDToken = idToken
 		}
 	}
 
+	o.recordAudit(AuditEvent{
+		Type:      AuditTokenIssued,
+		ClientID:  authCode.ClientID,
+		UserID:    authCode.UserID,
+		TenantID:  tenantID,
+		Scope:     scope,
+		GrantType: req.GrantType,
+		TokenID:   accessTokenID(accessToken),
+	})
+
 	return response, nil
 }
 
 func (o *OAuthService) handleRefreshTokenGrant(req *models.TokenRequest) (*models.TokenResponse, *models.ErrorResponse) {
-	// Validate client_id
-	if req.ClientID != o.config.OAuth.ClientID {
-		return nil, &models.ErrorResponse{
-			Error:            "invalid_client",
-			ErrorDescription: "Invalid client_id",
-		}
+	// Authenticate the client
+	client, errorResp := o.authenticateTokenClient(req)
+	if errorResp != nil {
+		return nil, errorResp
 	}
 
 	// Get and validate refresh token
-	o.mutex.RLock()
-	refreshTokenData, exists := o.refreshTokens[req.RefreshToken]
-	o.mutex.RUnlock()
-
-	if !exists {
-		return nil, &models.ErrorResponse{
-			Error:            "invalid_grant",
-			ErrorDescription: "Invalid refresh token",
-		}
+	refreshTokenData, err := o.store.GetRefreshToken(req.RefreshToken)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, models.NewInvalidGrant("Invalid refresh token")
+	}
+	if err != nil {
+		return nil, models.NewServerError("Failed to look up refresh token")
 	}
 
 	// Check if refresh token is expired
 	if time.Now().After(refreshTokenData.ExpiresAt) {
 		// Remove expired refresh token
-		o.mutex.Lock()
-		delete(o.refreshTokens, req.RefreshToken)
-		o.mutex.Unlock()
+		o.store.DeleteRefreshToken(req.RefreshToken)
+		o.reportActiveCounts()
 
-		return nil, &models.ErrorResponse{
-			Error:            "invalid_grant",
-			ErrorDescription: "Refresh token expired",
-		}
+		return nil, models.NewInvalidGrant("Refresh token expired")
 	}
 
 	// Validate client_id matches
 	if refreshTokenData.ClientID != req.ClientID {
-		return nil, &models.ErrorResponse{
-			Error:            "invalid_grant",
-			ErrorDescription: "Client ID mismatch",
+		return nil, models.NewInvalidGrant("Client ID mismatch")
+	}
+
+	// The access token may be issued for a narrower scope than was granted
+	// (RFC 6749 section 6), while the refresh token keeps the original one
+	scope := refreshTokenData.Scope
+	if req.Scope != "" {
+		if !isScopeSubset(req.Scope, refreshTokenData.Scope) {
+			return nil, models.NewInvalidScope("Requested scope exceeds the originally granted scope")
 		}
+		scope = req.Scope
+	}
+
+	scope, ok := o.grantableScope(client, scope)
+	if !ok {
+		return nil, models.NewInvalidScope("None of the requested scopes may be granted to this client")
+	}
+
+	resources, errorResp := o.tokenResources(req.Resources, refreshTokenData.Resources)
+	if errorResp != nil {
+		return nil, errorResp
 	}
 
 	// Generate new access token
 	if o.jwtService == nil {
-		return nil, &models.ErrorResponse{
-			Error:            "server_error",
-			ErrorDescription: "JWT service not configured",
-		}
+		return nil, models.NewServerError("JWT service not configured")
 	}
 	
-	accessToken, err := o.jwtService.GenerateAccessToken(refreshTokenData.UserID, refreshTokenData.ClientID, refreshTokenData.Scope)
+	// Resolved again, so a user who moved tenants doesn't keep the old one
+	tenantID, errorResp := o.resolveTenant(refreshTokenData.UserID)
+	if errorResp != nil {
+		return nil, errorResp
+	}
+
+	accessToken, err := o.jwtService.GenerateAccessTokenWithTenant(refreshTokenData.UserID, refreshTokenData.ClientID, scope, tenantID, resources...)
 	if err != nil {
-		return nil, &models.ErrorResponse{
-			Error:            "server_error",
-			ErrorDescription: "Failed to generate access token",
-		}
+		return nil, models.NewServerError("Failed to generate access token")
 	}
 
 	response := &models.TokenResponse{
 		AccessToken: accessToken,
 		TokenType:   "Bearer",
-		ExpiresIn:   int64(o.config.JWT.TokenExpiration.Seconds()),
-		Scope:       refreshTokenData.Scope,
+		ExpiresIn:   int64(o.jwtService.AccessTokenTTL(scope).Seconds()),
+		Scope:       scope,
 	}
 
+	o.recordAudit(AuditEvent{
+		Type:      AuditTokenRefreshed,
+		ClientID:  refreshTokenData.ClientID,
+		UserID:    refreshTokenData.UserID,
+		TenantID:  tenantID,
+		Scope:     scope,
+		GrantType: req.GrantType,
+		TokenID:   accessTokenID(accessToken),
+	})
+
 	return response, nil
 }
 
+// handleTokenExchangeGrant exchanges a valid access token for one issued to
+// the requesting client, optionally for another audience and a subset of the
+// subject token's scope (RFC 8693). The client is recorded in the "act" claim.
+func (o *OAuthService) handleTokenExchangeGrant(req *models.TokenRequest) (*models.TokenResponse, *models.ErrorResponse) {
+	// Authenticate the client
+	client, errorResp := o.authenticateTokenClient(req)
+	if errorResp != nil {
+		return nil, errorResp
+	}
+
+	if req.SubjectToken == "" || req.SubjectTokenType == "" {
+		return nil, models.NewInvalidRequest("subject_token and subject_token_type are required")
+	}
+
+	if req.SubjectTokenType != TokenTypeAccessToken {
+		return nil, models.NewInvalidRequest("Only access token subject tokens are supported")
+	}
+
+	if req.RequestedTokenType != "" && req.RequestedTokenType != TokenTypeAccessToken {
+		return nil, models.NewInvalidRequest("Only access tokens can be requested")
+	}
+
+	// The new token is for the named audience and resources (RFC 8693
+	// section 2.1), or for this service's audience when there are none
+	resources, errorResp := o.validateResources(req.Resources)
+	if errorResp != nil {
+		return nil, errorResp
+	}
+	var audience []string
+	if req.Audience != "" {
+		if !o.isIssuedAudience(models.Audience{req.Audience}) {
+			return nil, models.NewInvalidTarget(fmt.Sprintf("audience %q is not served by this authorization server", req.Audience))
+		}
+		audience = append(audience, req.Audience)
+	}
+	audience = append(audience, resources...)
+
+	if o.jwtService == nil {
+		return nil, models.NewServerError("JWT service not configured")
+	}
+
+	subject, err := o.jwtService.ValidateAccessToken(req.SubjectToken)
+	if err != nil {
+		return nil, models.NewInvalidGrant("Invalid subject_token")
+	}
+
+	// The exchanged token keeps the subject's scope unless narrowed, less any
+	// scopes the requesting client may not be granted
+	scope := subject.Scope
+	if req.Scope != "" {
+		if !isScopeSubset(req.Scope, subject.Scope) {
+			return nil, models.NewInvalidScope("Requested scope exceeds the subject token's scope")
+		}
+		scope = req.Scope
+	}
+
+	scope, ok := o.grantableScope(client, scope)
+	if !ok {
+		return nil, models.NewInvalidScope("None of the requested scopes may be granted to this client")
+	}
+
+	accessToken, err := o.jwtService.GenerateDelegatedToken(subject, client.ClientID, scope, audience...)
+	if err != nil {
+		return nil, models.NewServerError("Failed to generate access token")
+	}
+
+	o.recordAudit(AuditEvent{
+		Type:      AuditTokenIssued,
+		ClientID:  client.ClientID,
+		UserID:    subject.Subject,
+		TenantID:  subject.TenantID,
+		Scope:     scope,
+		GrantType: req.GrantType,
+		TokenID:   accessTokenID(accessToken),
+	})
+
+	return &models.TokenResponse{
+		AccessToken:     accessToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(o.jwtService.AccessTokenTTL(scope).Seconds()),
+		Scope:           scope,
+		IssuedTokenType: TokenTypeAccessToken,
+	}, nil
+}
+
 func (o *OAuthService) IntrospectToken(token string) (*models.IntrospectionResponse, error) {
 	if o.jwtService == nil {
 		return &models.IntrospectionResponse{
@@ -331,15 +653,50 @@ func (o *OAuthService) IntrospectToken(token string) (*models.IntrospectionRespo
 		}, nil
 	}
 	
-	claims, err := o.jwtService.ValidateAccessToken(token)
-	if err != nil {
+	// A cached response is only used while the token isn't denylisted, so
+	// revocations by other replicas sharing the denylist apply at once
+	cached, ok := o.introspection.get(token, time.Now())
+	if ok && o.jwtService.jtiRevoked(cached.Jti) {
+		o.introspection.remove(token)
+		ok = false
+	}
+	if ok {
+		o.recordAudit(AuditEvent{
+			Type:     AuditTokenIntrospected,
+			ClientID: cached.ClientID,
+			UserID:   cached.Sub,
+			TenantID: cached.TenantID,
+			Scope:    cached.Scope,
+			TokenID:  cached.Jti,
+			Active:   &cached.Active,
+		})
+		return cached, nil
+	}
+
+	// Tokens for any audience this service issues are active; the resource
+	// server checks that it is the one they are meant for
+	claims, err := o.jwtService.ValidateAccessTokenForAudience(token, "")
+	if err != nil || (o.config.JWT.ValidateAudience && !o.isIssuedAudience(claims.Audience)) {
 		// Token is invalid or expired
+		active := false
+		o.recordAudit(AuditEvent{Type: AuditTokenIntrospected, Active: &active})
 		return &models.IntrospectionResponse{
 			Active: false,
 		}, nil
 	}
 
-	return &models.IntrospectionResponse{
+	active := true
+	o.recordAudit(AuditEvent{
+		Type:     AuditTokenIntrospected,
+		ClientID: claims.ClientID,
+		UserID:   claims.Subject,
+		TenantID: claims.TenantID,
+		Scope:    claims.Scope,
+		TokenID:  claims.JWTID,
+		Active:   &active,
+	})
+
+	response := &models.IntrospectionResponse{
 		Active:    true,
 		ClientID:  claims.ClientID,
 		Username:  claims.Subject, // Using subject as username
@@ -352,42 +709,279 @@ func (o *OAuthService) IntrospectToken(token string) (*models.IntrospectionRespo
 		Aud:       strings.Join(claims.Audience, " "),
 		Iss:       claims.Issuer,
 		Jti:       claims.JWTID,
-	}, nil
+		TenantID:  claims.TenantID,
+	}
+	o.introspection.put(token, response, time.Now())
+	return response, nil
+}
+
+// IntrospectTokens introspects each token concurrently, returning the
+// responses in the order of tokens. A token that fails to validate is
+// reported inactive without affecting the others.
+func (o *OAuthService) IntrospectTokens(tokens []string) ([]*models.IntrospectionResponse, error) {
+	if len(tokens) > o.MaxBatchIntrospection() {
+		return nil, ErrBatchTooLarge
+	}
+
+	responses := make([]*models.IntrospectionResponse, len(tokens))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < batchIntrospectionWorkers && w < len(tokens); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				resp, err := o.IntrospectToken(tokens[i])
+				if err != nil {
+					resp = &models.IntrospectionResponse{Active: false}
+				}
+				responses[i] = resp
+			}
+		}()
+	}
+	for i := range tokens {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return responses, nil
+}
+
+// MaxBatchIntrospection returns how many tokens IntrospectTokens accepts
+func (o *OAuthService) MaxBatchIntrospection() int {
+	if o.config.OAuth.MaxBatchIntrospection <= 0 {
+		return defaultMaxBatchIntrospection
+	}
+	return o.config.OAuth.MaxBatchIntrospection
+}
+
+// RevokeToken revokes a token per RFC 7009, whichever client it was issued
+// to. Unknown tokens are not an error, so callers can always report success
+// to the client.
+func (o *OAuthService) RevokeToken(token, tokenTypeHint string) error {
+	return o.revoke(token, tokenTypeHint, "")
+}
+
+// RevokeClientToken revokes a token for the revocation endpoint. The client
+// authenticates as it would at the token endpoint, and may only revoke
+// tokens issued to it.
+func (o *OAuthService) RevokeClientToken(token, tokenTypeHint, clientID string, credentials models.ClientCredentials) *models.ErrorResponse {
+	client, errorResp := o.authenticateClientCredentials(clientID, credentials,
+		o.config.JWT.EffectiveIssuer(), o.tokenEndpoint(), o.revocationEndpoint())
+	if errorResp != nil {
+		return errorResp
+	}
+
+	err := o.revoke(token, tokenTypeHint, client.ClientID)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrUnsupportedTokenType):
+		return models.NewUnsupportedTokenType("Revocation of this token type is not supported")
+	case errors.Is(err, ErrTokenNotIssuedToClient):
+		return models.NewUnauthorizedClient("Token was not issued to this client")
+	}
+	log.Printf("Failed to revoke token: %v", err)
+	return models.NewServerError("Failed to revoke token")
+}
+
+// revoke revokes token, which must have been issued to clientID unless it
+// is empty
+func (o *OAuthService) revoke(token, tokenTypeHint, clientID string) error {
+	refreshToken, err := o.store.GetRefreshToken(token)
+	if err == nil {
+		if clientID != "" && refreshToken.ClientID != clientID {
+			return ErrTokenNotIssuedToClient
+		}
+		if err := o.store.DeleteRefreshToken(token); err != nil {
+			return err
+		}
+		o.reportActiveCounts()
+		o.recordAudit(AuditEvent{
+			Type:      AuditTokenRevoked,
+			ClientID:  refreshToken.ClientID,
+			UserID:    refreshToken.UserID,
+			Scope:     refreshToken.Scope,
+			TokenType: "refresh_token",
+		})
+		return nil
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+
+	// Access tokens are self-contained JWTs, so revocation records their jti
+	// in a denylist consulted on validation. Any audience this server issues
+	// for is accepted, as in IntrospectToken, so resource-bound tokens can be
+	// revoked too.
+	if o.jwtService == nil {
+		if tokenTypeHint == "access_token" {
+			return ErrUnsupportedTokenType
+		}
+		return nil
+	}
+
+	claims, err := o.jwtService.ValidateAccessTokenForAudience(token, "")
+	if err != nil || (o.config.JWT.ValidateAudience && !o.isIssuedAudience(claims.Audience)) {
+		return nil
+	}
+	if clientID != "" && claims.ClientID != clientID {
+		return ErrTokenNotIssuedToClient
+	}
+	if err := o.jwtService.revokeToken(token, claims); err != nil {
+		return err
+	}
+	o.recordAudit(AuditEvent{
+		Type:      AuditTokenRevoked,
+		ClientID:  claims.ClientID,
+		UserID:    claims.Subject,
+		TenantID:  claims.TenantID,
+		Scope:     claims.Scope,
+		TokenType: "access_token",
+		TokenID:   claims.JWTID,
+	})
+	return nil
+}
+
+// GetDiscoveryDocument builds the OpenID Connect discovery document from config
+func (o *OAuthService) GetDiscoveryDocument() *models.DiscoveryDocument {
+	issuer := o.config.JWT.EffectiveIssuer()
+	base := strings.TrimSuffix(issuer, "/")
+
+	return &models.DiscoveryDocument{
+		Issuer:                           issuer,
+		AuthorizationEndpoint:            base + "/authorize",
+		TokenEndpoint:                    o.tokenEndpoint(),
+		JWKSURI:                          base + "/.well-known/jwks.json",
+		IntrospectionEndpoint:            base + "/introspect",
+		RevocationEndpoint:               o.revocationEndpoint(),
+		UserInfoEndpoint:                 base + "/userinfo",
+		PushedAuthorizationEndpoint:      o.pushedAuthorizationEndpoint(),
+		ResponseTypesSupported:           []string{"code"},
+		GrantTypesSupported:              []string{"authorization_code", "refresh_token", GrantTypeTokenExchange},
+		CodeChallengeMethodsSupported:    o.supportedCodeChallengeMethods(),
+		ScopesSupported:                  o.config.OAuth.SupportedScopes,
+		SubjectTypesSupported:            o.subjectTypesSupported(),
+		IDTokenSigningAlgValuesSupported: []string{o.signingAlgorithm()},
+		TokenEndpointAuthMethodsSupported: []string{
+			"client_secret_basic", "client_secret_post", "private_key_jwt", "none",
+		},
+		TokenEndpointAuthSigningAlgValuesSupported: []string{"RS256", "PS256", "ES256"},
+	}
+}
+
+// signingAlgorithm returns the configured JWT algorithm, defaulting to RS256
+func (o *OAuthService) signingAlgorithm() string {
+	if o.config.JWT.Algorithm == "" {
+		return "RS256"
+	}
+	return o.config.JWT.Algorithm
 }
 
-func (o *OAuthService) isValidRedirectURI(uri string) bool {
-	for _, validURI := range o.config.OAuth.RedirectURIs {
-		if uri == validURI {
+// IntrospectionScope returns the scope Bearer tokens need to call the
+// introspect endpoint, or "" when any valid token is accepted
+func (o *OAuthService) IntrospectionScope() string {
+	return o.config.OAuth.IntrospectionScope
+}
+
+func (o *OAuthService) supportedCodeChallengeMethods() []string {
+	if o.plainPKCEAllowed() {
+		return []string{"S256", "plain"}
+	}
+	return []string{"S256"}
+}
+
+func (o *OAuthService) isValidRedirectURI(client *config.ClientConfig, uri string) bool {
+	for _, validURI := range client.RedirectURIs {
+		if redirectURIMatches(validURI, uri, o.config.OAuth.RedirectURIAllowedParams, o.config.OAuth.AllowLoopbackPortFlexibility) {
 			return true
 		}
 	}
 	return false
 }
 
-func (o *OAuthService) isValidScope(scope string) bool {
-	if scope == "" {
-		return true // Empty scope is valid
+// validateState applies the configured state policy. Errors don't echo the
+// offending state back, since it is what was rejected.
+func (o *OAuthService) validateState(state string) *models.ErrorResponse {
+	if state == "" {
+		if o.config.OAuth.RequireState {
+			return models.NewInvalidRequest("state is required")
+		}
+		return nil
 	}
 
-	requestedScopes := strings.Split(scope, " ")
+	if minLength := o.config.OAuth.MinStateLength; len(state) < minLength {
+		return models.NewInvalidRequest(fmt.Sprintf("state must be at least %d characters", minLength))
+	}
+
+	maxLength := o.config.OAuth.MaxStateLength
+	if maxLength <= 0 {
+		maxLength = defaultMaxStateLength
+	}
+	if len(state) > maxLength {
+		return models.NewInvalidRequest(fmt.Sprintf("state must be at most %d characters", maxLength))
+	}
+
+	return nil
+}
+
+// grantableScope returns the requested scopes that are supported and allowed
+// for the client, in request order. It reports false when scopes were
+// requested but none of them can be granted; an empty scope is always fine.
+func (o *OAuthService) grantableScope(client *config.ClientConfig, scope string) (string, bool) {
+	requestedScopes := strings.Fields(scope)
+	if len(requestedScopes) == 0 {
+		return "", true
+	}
+
+	allowedScopes := client.AllowedScopes
+	if len(allowedScopes) == 0 {
+		allowedScopes = o.config.OAuth.SupportedScopes
+	}
+
+	var granted []string
 	for _, requested := range requestedScopes {
-		found := false
-		for _, supported := range o.config.OAuth.SupportedScopes {
-			if requested == supported {
-				found = true
-				break
-			}
+		if containsString(o.config.OAuth.SupportedScopes, requested) &&
+			containsString(allowedScopes, requested) && !containsString(granted, requested) {
+			granted = append(granted, requested)
 		}
-		if !found {
+	}
+	return strings.Join(granted, " "), len(granted) > 0
+}
+
+// isScopeSubset reports whether every scope in requested is also in granted
+func isScopeSubset(requested, granted string) bool {
+	grantedScopes := strings.Fields(granted)
+	for _, scope := range strings.Fields(requested) {
+		if !containsString(grantedScopes, scope) {
 			return false
 		}
 	}
 	return true
 }
 
+func containsString(values []string, want string) bool {
+	for _, value := range values {
+		if value == want {
+			return true
+		}
+	}
+	return false
+}
+
+// plainPKCEAllowed reports whether the plain method may be used. RequireS256
+// overrides AllowPlainPKCE.
+func (o *OAuthService) plainPKCEAllowed() bool {
+	return o.config.OAuth.AllowPlainPKCE && !o.config.OAuth.RequireS256
+}
+
 func (o *OAuthService) verifyPKCE(codeChallenge, method, codeVerifier string) bool {
 	switch method {
 	case "plain":
+		if !o.plainPKCEAllowed() {
+			return false
+		}
 		return codeChallenge == codeVerifier
 	case "S256":
 		hash := sha256.Sum256([]byte(codeVerifier))
@@ -398,27 +992,86 @@ func (o *OAuthService) verifyPKCE(codeChallenge, method, codeVerifier string) bo
 	}
 }
 
+// isValidS256Challenge reports whether the challenge is an unpadded base64url
+// encoding of exactly 32 bytes, as produced by the S256 transformation
+func isValidS256Challenge(challenge string) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(challenge)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// isValidCodeVerifier reports whether the verifier is 43 to 128 characters
+// from the RFC 7636 unreserved set
+func isValidCodeVerifier(verifier string) bool {
+	if len(verifier) < minCodeVerifierLength || len(verifier) > maxCodeVerifierLength {
+		return false
+	}
+	for i := 0; i < len(verifier); i++ {
+		c := verifier[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '-', c == '.', c == '_', c == '~':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Stop terminates the background cleanup goroutine and waits for it to
+// return. It is safe to call more than once.
+func (o *OAuthService) Stop() {
+	o.stopOnce.Do(func() {
+		close(o.stop)
+	})
+	<-o.done
+}
+
+// Done returns a channel that is closed once the background cleanup goroutine
+// has returned, after Stop or cancellation of the WithContext context
+func (o *OAuthService) Done() <-chan struct{} {
+	return o.done
+}
+
 func (o *OAuthService) cleanupExpiredTokens() {
-	ticker := time.NewTicker(time.Hour)
-	defer ticker.Stop()
+	defer close(o.done)
+	defer o.unhook()
 
-	for range ticker.C {
-		now := time.Now()
+	interval := o.config.OAuth.CleanupInterval
+	if interval <= 0 {
+		interval = defaultCleanupInterval
+	}
 
-		o.mutex.Lock()
-		// Clean expired authorization codes
-		for code, authCode := range o.authCodes {
-			if now.After(authCode.ExpiresAt) {
-				delete(o.authCodes, code)
-			}
-		}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-		// Clean expired refresh tokens
-		for token, refreshToken := range o.refreshTokens {
-			if now.After(refreshToken.ExpiresAt) {
-				delete(o.refreshTokens, token)
-			}
+	for {
+		select {
+		case <-o.stop:
+			return
+		case <-o.ctx.Done():
+			return
+		case <-ticker.C:
+			o.deleteExpiredTokens()
 		}
-		o.mutex.Unlock()
 	}
 }
+
+// deleteExpiredTokens runs a single cleanup pass over the token store
+func (o *OAuthService) deleteExpiredTokens() {
+	if err := o.store.DeleteExpired(time.Now()); err != nil {
+		log.Printf("Failed to clean up expired tokens: %v", err)
+	}
+	o.reportActiveCounts()
+}
+
+// reportActiveCounts publishes the current store sizes to the active gauges
+func (o *OAuthService) reportActiveCounts() {
+	authCodes, refreshTokens, err := o.store.Counts()
+	if err != nil {
+		log.Printf("Failed to count active tokens: %v", err)
+		return
+	}
+
+	metrics.SetActiveAuthorizationCodes(authCodes)
+	metrics.SetActiveRefreshTokens(refreshTokens)
+}
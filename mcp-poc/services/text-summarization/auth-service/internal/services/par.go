@@ -0,0 +1,129 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"auth-service/internal/models"
+)
+
+// requestURIPrefix is the URN namespace for request_uri values (RFC 9126 section 2.2)
+const requestURIPrefix = "urn:ietf:params:oauth:request_uri:"
+
+// defaultPARExpiration is used when OAuth.PARExpiration is not configured
+const defaultPARExpiration = 60 * time.Second
+
+// pushedRequests holds pushed authorization requests until they are used or
+// expire. Each request_uri can be used only once.
+type pushedRequests struct {
+	mutex    sync.Mutex
+	requests map[string]*pushedRequest
+}
+
+type pushedRequest struct {
+	request   *models.AuthorizationRequest
+	pushedAt  time.Time
+	expiresAt time.Time
+}
+
+func newPushedRequests() *pushedRequests {
+	return &pushedRequests{
+		requests: make(map[string]*pushedRequest),
+	}
+}
+
+func (p *pushedRequests) save(requestURI string, req *models.AuthorizationRequest, pushedAt, expiresAt time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// Drop requests that expired without being used
+	now := time.Now()
+	for uri, pushed := range p.requests {
+		if now.After(pushed.expiresAt) {
+			delete(p.requests, uri)
+		}
+	}
+
+	p.requests[requestURI] = &pushedRequest{request: req, pushedAt: pushedAt, expiresAt: expiresAt}
+}
+
+// get returns the pushed request for requestURI without using it up
+func (p *pushedRequests) get(requestURI string) (*pushedRequest, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	pushed, ok := p.requests[requestURI]
+	return pushed, ok
+}
+
+// take removes and returns the pushed request for requestURI
+func (p *pushedRequests) take(requestURI string) (*pushedRequest, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	pushed, ok := p.requests[requestURI]
+	if ok {
+		delete(p.requests, requestURI)
+	}
+	return pushed, ok
+}
+
+// PushAuthorizationRequest validates and stores an authorization request
+// pushed by an authenticated client (RFC 9126). Clients registered with a
+// JWK authenticate with a client assertion, whose audience may be the
+// issuer, the token endpoint or the pushed authorization request endpoint
+// (RFC 9126 section 2). When one is sent req.ClientID may be empty, and is
+// then set from the assertion.
+func (o *OAuthService) PushAuthorizationRequest(req *models.AuthorizationRequest, credentials models.ClientCredentials) (*models.PushedAuthorizationResponse, *models.ErrorResponse) {
+	client, errorResp := o.authenticateClientCredentials(req.ClientID, credentials,
+		o.config.JWT.EffectiveIssuer(), o.tokenEndpoint(), o.pushedAuthorizationEndpoint())
+	if errorResp != nil {
+		return nil, errorResp
+	}
+	req.ClientID = client.ClientID
+
+	if errorResp := o.validateAuthorizationRequest(req); errorResp != nil {
+		return nil, errorResp
+	}
+
+	expiration := o.config.OAuth.PARExpiration
+	if expiration <= 0 {
+		expiration = defaultPARExpiration
+	}
+
+	requestURI := requestURIPrefix + uuid.New().String()
+	now := time.Now()
+	o.pushed.save(requestURI, req, now, now.Add(expiration))
+
+	return &models.PushedAuthorizationResponse{
+		RequestURI: requestURI,
+		ExpiresIn:  int64(expiration.Seconds()),
+	}, nil
+}
+
+// ResolveRequestURI returns the pushed authorization request referenced by
+// requestURI. The client_id sent to the authorization endpoint must match
+// the client that pushed it. The request_uri stays usable until
+// HandleAuthorizationRequest issues a code for it, so it survives a login or
+// consent prompt.
+func (o *OAuthService) ResolveRequestURI(clientID, requestURI string) (*models.AuthorizationRequest, *models.ErrorResponse) {
+	pushed, ok := o.pushed.get(requestURI)
+	if !ok {
+		return nil, models.NewInvalidRequestURI("Unknown or already used request_uri")
+	}
+
+	if time.Now().After(pushed.expiresAt) {
+		return nil, models.NewInvalidRequestURI("request_uri has expired")
+	}
+
+	if pushed.request.ClientID != clientID {
+		return nil, models.NewInvalidRequestURI("request_uri was issued to another client")
+	}
+
+	resolved := *pushed.request
+	resolved.RequestURI = requestURI
+	resolved.PushedAt = pushed.pushedAt
+	return &resolved, nil
+}
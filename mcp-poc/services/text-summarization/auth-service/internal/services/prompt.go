@@ -0,0 +1,55 @@
+package services
+
+import (
+	"strings"
+	"time"
+
+	"auth-service/internal/models"
+)
+
+// promptValues are the OpenID Connect prompt values accepted. Only none and
+// login change how a request is handled; consent and select_account are
+// accepted for clients that send them and otherwise ignored.
+var promptValues = map[string]bool{
+	"none":           true,
+	"login":          true,
+	"consent":        true,
+	"select_account": true,
+}
+
+// validatePrompt checks the prompt and max_age parameters (OpenID Connect
+// Core section 3.1.2.1)
+func validatePrompt(req *models.AuthorizationRequest) *models.ErrorResponse {
+	prompts := strings.Fields(req.Prompt)
+	for _, prompt := range prompts {
+		if !promptValues[prompt] {
+			return models.NewInvalidRequest("Unsupported prompt value: " + prompt)
+		}
+	}
+	if req.HasPrompt("none") && len(prompts) > 1 {
+		return models.NewInvalidRequest("prompt=none can't be combined with other values")
+	}
+
+	if _, ok := req.MaxAgeDuration(); req.MaxAge != "" && !ok {
+		return models.NewInvalidRequest("max_age must be a non-negative number of seconds")
+	}
+	return nil
+}
+
+// SessionSatisfies reports whether a session in which the user
+// authenticated at authTime may be used for req without a new login.
+// prompt=login calls for a new login and max_age for one no older than it;
+// for a pushed request, a login after it was pushed satisfies both, so the
+// request_uri can be reused once the user has logged in again.
+func (o *OAuthService) SessionSatisfies(req *models.AuthorizationRequest, authTime, now time.Time) bool {
+	if !req.PushedAt.IsZero() && authTime.After(req.PushedAt) {
+		return true
+	}
+	if req.HasPrompt("login") {
+		return false
+	}
+	if maxAge, ok := req.MaxAgeDuration(); ok {
+		return now.Sub(authTime) <= maxAge
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// redirectURIMatches compares a requested redirect URI against a registered
+// one. Scheme and host are compared case-insensitively and default ports are
+// ignored, but the path must match exactly so that extra segments can't be
+// used as an open redirect. The query must match too, except for parameters
+// in allowedParams, which the client may add or vary. With loopbackAnyPort,
+// a registered loopback IP redirect URI matches on any port, for native apps
+// listening on an ephemeral port (RFC 8252 section 7.3).
+func redirectURIMatches(registered, requested string, allowedParams []string, loopbackAnyPort bool) bool {
+	want, err := url.Parse(registered)
+	if err != nil {
+		return false
+	}
+	got, err := url.Parse(requested)
+	if err != nil {
+		return false
+	}
+
+	// Redirect URIs are absolute and never carry a fragment or credentials
+	// (RFC 6749 section 3.1.2)
+	if !got.IsAbs() || got.Host == "" || got.User != nil || strings.Contains(requested, "#") {
+		return false
+	}
+
+	sameHost := normalizedHost(want) == normalizedHost(got)
+	if loopbackAnyPort && isLoopbackIP(want.Hostname()) {
+		sameHost = want.Hostname() == got.Hostname()
+	}
+
+	if !strings.EqualFold(want.Scheme, got.Scheme) || !sameHost ||
+		want.EscapedPath() != got.EscapedPath() {
+		return false
+	}
+
+	return queriesMatch(want.Query(), got.Query(), allowedParams)
+}
+
+// normalizedHost lowercases the host and drops the scheme's default port
+func normalizedHost(u *url.URL) string {
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	switch {
+	case port == "":
+		return host
+	case port == "80" && strings.EqualFold(u.Scheme, "http"):
+		return host
+	case port == "443" && strings.EqualFold(u.Scheme, "https"):
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// isLoopbackIP reports whether host is a loopback IP literal. Names such as
+// localhost don't count, as RFC 8252 section 8.3 advises against them.
+func isLoopbackIP(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// queriesMatch reports whether the requested query has exactly the
+// registered parameters, ignoring any additional allowed ones
+func queriesMatch(registered, requested url.Values, allowedParams []string) bool {
+	for key := range requested {
+		if _, ok := registered[key]; ok {
+			continue
+		}
+		if !containsString(allowedParams, key) {
+			return false
+		}
+		delete(requested, key)
+	}
+
+	if len(registered) != len(requested) {
+		return false
+	}
+	for key, values := range registered {
+		if !slices.Equal(values, requested[key]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"auth-service/internal/models"
+)
+
+// validateResources checks requested resource indicators (RFC 8707 section 2)
+// against OAuth.AllowedResources and returns them without duplicates
+func (o *OAuthService) validateResources(resources []string) ([]string, *models.ErrorResponse) {
+	var valid []string
+	for _, resource := range resources {
+		parsed, err := url.Parse(resource)
+		if err != nil || !parsed.IsAbs() || strings.Contains(resource, "#") {
+			return nil, models.NewInvalidTarget("resource must be an absolute URI without a fragment")
+		}
+		if !containsString(o.config.OAuth.AllowedResources, resource) {
+			return nil, models.NewInvalidTarget(fmt.Sprintf("resource %q is not allowed", resource))
+		}
+		if !containsString(valid, resource) {
+			valid = append(valid, resource)
+		}
+	}
+	return valid, nil
+}
+
+// tokenResources returns the resources an access token is issued for. A
+// token request may name a subset of the resources bound to the grant, or
+// any allowed resource when the grant isn't bound to any; otherwise the
+// grant's resources are used.
+func (o *OAuthService) tokenResources(requested, granted []string) ([]string, *models.ErrorResponse) {
+	if len(requested) == 0 {
+		return granted, nil
+	}
+
+	resources, errorResp := o.validateResources(requested)
+	if errorResp != nil {
+		return nil, errorResp
+	}
+	if len(granted) == 0 {
+		return resources, nil
+	}
+	for _, resource := range resources {
+		if !containsString(granted, resource) {
+			return nil, models.NewInvalidTarget(fmt.Sprintf("resource %q was not part of the authorization grant", resource))
+		}
+	}
+	return resources, nil
+}
+
+// isIssuedAudience reports whether aud names an audience this service issues
+// access tokens for: the configured audience or an allowed resource
+func (o *OAuthService) isIssuedAudience(aud models.Audience) bool {
+	if aud.Contains(o.config.JWT.Audience) {
+		return true
+	}
+	for _, resource := range o.config.OAuth.AllowedResources {
+		if aud.Contains(resource) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+
+	"auth-service/internal/config"
+)
+
+// subjectFor returns the subject identifier client is told for userID: the
+// user ID itself for public clients, and a pairwise pseudonym for pairwise
+// ones, so that clients in different sectors can't correlate users by sub
+// (OpenID Connect Core section 8.1). It applies to ID tokens and UserInfo,
+// which are meant for the client. Access tokens keep the user ID: they are
+// meant for resource servers, which along with UserInfo, introspection and
+// token exchange identify the user by it, and clients must treat them as
+// opaque. Authorization requests from pairwise clients are refused while
+// PairwiseSalt is empty.
+func (o *OAuthService) subjectFor(userID string, client *config.ClientConfig) string {
+	if client.SubjectType != config.SubjectTypePairwise {
+		return userID
+	}
+
+	mac := hmac.New(sha256.New, []byte(o.config.OAuth.PairwiseSalt))
+	mac.Write([]byte(sectorIdentifier(client)))
+	mac.Write([]byte{0})
+	mac.Write([]byte(userID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sectorIdentifier returns the sector client's pairwise subjects are
+// computed for: its configured SectorIdentifier, or else the host its
+// redirect URIs share. Clients whose redirect URIs span several hosts get a
+// sector of their own.
+func sectorIdentifier(client *config.ClientConfig) string {
+	if client.SectorIdentifier != "" {
+		return client.SectorIdentifier
+	}
+
+	host := ""
+	for _, redirectURI := range client.RedirectURIs {
+		parsed, err := url.Parse(redirectURI)
+		if err != nil || (host != "" && parsed.Hostname() != host) {
+			return client.ClientID
+		}
+		host = parsed.Hostname()
+	}
+	if host == "" {
+		return client.ClientID
+	}
+	return host
+}
+
+// subjectTypesSupported lists public, and pairwise when a client uses it
+func (o *OAuthService) subjectTypesSupported() []string {
+	types := []string{config.SubjectTypePublic}
+	for _, client := range o.config.OAuth.Clients {
+		if client.SubjectType == config.SubjectTypePairwise {
+			return append(types, config.SubjectTypePairwise)
+		}
+	}
+	return types
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"errors"
+
+	"auth-service/internal/models"
+	"auth-service/internal/store"
+)
+
+// TenantResolver maps an authenticated user to the tenant whose data their
+// tokens give access to. It returns store.ErrNotFound for users without
+// one. store.PostgresStore resolves tenants from public.users.
+type TenantResolver interface {
+	ResolveTenant(userID string) (string, error)
+}
+
+// TenantResolverFunc adapts a function to the TenantResolver interface
+type TenantResolverFunc func(userID string) (string, error)
+
+func (f TenantResolverFunc) ResolveTenant(userID string) (string, error) {
+	return f(userID)
+}
+
+// WithTenantResolver stamps access tokens with the tenant_id resolver
+// returns for their user. Without one, tokens carry no tenant_id.
+func WithTenantResolver(resolver TenantResolver) OAuthOption {
+	return func(o *OAuthService) {
+		o.tenants = resolver
+	}
+}
+
+// resolveTenant returns userID's tenant, or "" when no resolver is set. Users
+// without a tenant are refused a token rather than given a made-up one.
+func (o *OAuthService) resolveTenant(userID string) (string, *models.ErrorResponse) {
+	if o.tenants == nil {
+		return "", nil
+	}
+
+	tenantID, err := o.tenants.ResolveTenant(userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return "", models.NewInvalidGrant("The user does not belong to a tenant")
+	}
+	if err != nil || tenantID == "" {
+		return "", models.NewServerError("Failed to resolve the user's tenant")
+	}
+	return tenantID, nil
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"auth-service/internal/models"
+)
+
+// ErrUserNotFound is returned by a UserInfoProvider for users it doesn't know
+var ErrUserNotFound = errors.New("user not found")
+
+// UserInfoProvider looks up the profile of a user for the UserInfo endpoint.
+// The Sub of the returned profile is ignored.
+type UserInfoProvider interface {
+	UserInfo(userID string) (*models.UserInfoResponse, error)
+}
+
+// UserInfoProviderFunc adapts a function to the UserInfoProvider interface
+type UserInfoProviderFunc func(userID string) (*models.UserInfoResponse, error)
+
+func (f UserInfoProviderFunc) UserInfo(userID string) (*models.UserInfoResponse, error) {
+	return f(userID)
+}
+
+// WithUserInfoProvider sets where the UserInfo endpoint and ID tokens get
+// profile and email claims from. Without one, they only carry sub.
+func WithUserInfoProvider(provider UserInfoProvider) OAuthOption {
+	return func(o *OAuthService) {
+		o.userInfo = provider
+	}
+}
+
+// UserInfo returns the claims about the subject of an access token that its
+// scopes release (OpenID Connect Core section 5.4): name, given_name,
+// family_name, preferred_username and picture for profile, and email and
+// email_verified for email
+func (o *OAuthService) UserInfo(claims *models.Claims) (*models.UserInfoResponse, error) {
+	userInfo, err := o.scopedUserInfo(claims.Subject, claims.Scope)
+	if err != nil {
+		return nil, err
+	}
+	if client, ok := o.config.OAuth.GetClient(claims.ClientID); ok {
+		userInfo.Sub = o.subjectFor(claims.Subject, client)
+	}
+	return userInfo, nil
+}
+
+// idTokenUserClaims returns the claims about userID that scope releases, to
+// add to an ID token, or nil when it releases none
+func (o *OAuthService) idTokenUserClaims(userID, scope string) (map[string]interface{}, error) {
+	scopes := strings.Fields(scope)
+	if o.userInfo == nil || (!containsString(scopes, "profile") && !containsString(scopes, "email")) {
+		return nil, nil
+	}
+
+	userInfo, err := o.scopedUserInfo(userID, scope)
+	if err != nil {
+		return nil, err
+	}
+	userInfoJSON, err := json.Marshal(userInfo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal user info: %w", err)
+	}
+	var userClaims map[string]interface{}
+	if err := json.Unmarshal(userInfoJSON, &userClaims); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user info: %w", err)
+	}
+	// The ID token sets sub itself
+	delete(userClaims, "sub")
+	return userClaims, nil
+}
+
+// scopedUserInfo looks up userID's profile and keeps the claims scope
+// releases
+func (o *OAuthService) scopedUserInfo(userID, scope string) (*models.UserInfoResponse, error) {
+	userInfo := &models.UserInfoResponse{Sub: userID}
+	if o.userInfo == nil {
+		return userInfo, nil
+	}
+
+	profile, err := o.userInfo.UserInfo(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user info: %w", err)
+	}
+
+	scopes := strings.Fields(scope)
+	if containsString(scopes, "profile") {
+		userInfo.Name = profile.Name
+		userInfo.GivenName = profile.GivenName
+		userInfo.FamilyName = profile.FamilyName
+		userInfo.PreferredUsername = profile.PreferredUsername
+		userInfo.Picture = profile.Picture
+	}
+	if containsString(scopes, "email") {
+		userInfo.Email = profile.Email
+		userInfo.EmailVerified = profile.EmailVerified
+	}
+	return userInfo, nil
+}
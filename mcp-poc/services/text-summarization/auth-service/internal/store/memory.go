@@ -0,0 +1,228 @@
+package store
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"auth-service/internal/models"
+)
+
+// MemoryStore is a TokenStore backed by in-process maps. State is lost on
+// restart, so it is only suitable for single-replica deployments and tests.
+type MemoryStore struct {
+	authCodes        map[string]*models.AuthorizationCode
+	refreshTokens    map[string]*models.RefreshToken
+	revokedJTIs      map[string]time.Time
+	consents         map[consentKey]map[string]struct{}
+	maxAuthCodes     int
+	maxRefreshTokens int
+	mutex            sync.RWMutex
+}
+
+// MemoryOption customizes a MemoryStore at construction time
+type MemoryOption func(*MemoryStore)
+
+// WithMaxAuthCodes caps the authorization codes held at once, so a flood of
+// authorization requests can't exhaust memory. Zero means no cap.
+func WithMaxAuthCodes(max int) MemoryOption {
+	return func(m *MemoryStore) {
+		m.maxAuthCodes = max
+	}
+}
+
+// WithMaxRefreshTokens caps the refresh tokens held at once. Zero means no
+// cap.
+func WithMaxRefreshTokens(max int) MemoryOption {
+	return func(m *MemoryStore) {
+		m.maxRefreshTokens = max
+	}
+}
+
+type consentKey struct {
+	userID   string
+	clientID string
+}
+
+func NewMemoryStore(opts ...MemoryOption) *MemoryStore {
+	m := &MemoryStore{
+		authCodes:     make(map[string]*models.AuthorizationCode),
+		refreshTokens: make(map[string]*models.RefreshToken),
+		revokedJTIs:   make(map[string]time.Time),
+		consents:      make(map[consentKey]map[string]struct{}),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// SaveAuthCode returns ErrCapacity when the store holds the maximum number
+// of codes even after dropping the expired ones
+func (m *MemoryStore) SaveAuthCode(code *models.AuthorizationCode) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.authCodes[code.Code]; !exists && m.maxAuthCodes > 0 && len(m.authCodes) >= m.maxAuthCodes {
+		m.deleteExpiredAuthCodes(time.Now())
+		if len(m.authCodes) >= m.maxAuthCodes {
+			return ErrCapacity
+		}
+	}
+
+	m.authCodes[code.Code] = code
+	return nil
+}
+
+func (m *MemoryStore) GetAuthCode(code string) (*models.AuthorizationCode, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	authCode, exists := m.authCodes[code]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return authCode, nil
+}
+
+func (m *MemoryStore) DeleteAuthCode(code string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.authCodes, code)
+	return nil
+}
+
+func (m *MemoryStore) ConsumeAuthCode(code string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.authCodes[code]; !exists {
+		return ErrNotFound
+	}
+	delete(m.authCodes, code)
+	return nil
+}
+
+// SaveRefreshToken returns ErrCapacity when the store holds the maximum
+// number of refresh tokens even after dropping the expired ones
+func (m *MemoryStore) SaveRefreshToken(token *models.RefreshToken) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.refreshTokens[token.Token]; !exists && m.maxRefreshTokens > 0 && len(m.refreshTokens) >= m.maxRefreshTokens {
+		m.deleteExpiredRefreshTokens(time.Now())
+		if len(m.refreshTokens) >= m.maxRefreshTokens {
+			return ErrCapacity
+		}
+	}
+
+	m.refreshTokens[token.Token] = token
+	return nil
+}
+
+func (m *MemoryStore) GetRefreshToken(token string) (*models.RefreshToken, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	refreshToken, exists := m.refreshTokens[token]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return refreshToken, nil
+}
+
+func (m *MemoryStore) DeleteRefreshToken(token string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.refreshTokens, token)
+	return nil
+}
+
+func (m *MemoryStore) RevokeJTI(jti string, expiresAt time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.revokedJTIs[jti] = expiresAt
+	return nil
+}
+
+func (m *MemoryStore) IsJTIRevoked(jti string) (bool, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	expiresAt, revoked := m.revokedJTIs[jti]
+	return revoked && time.Now().Before(expiresAt), nil
+}
+
+func (m *MemoryStore) GrantConsent(userID, clientID string, scopes []string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := consentKey{userID: userID, clientID: clientID}
+	granted, exists := m.consents[key]
+	if !exists {
+		granted = make(map[string]struct{})
+		m.consents[key] = granted
+	}
+	for _, scope := range scopes {
+		granted[scope] = struct{}{}
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetConsent(userID, clientID string) ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	granted := m.consents[consentKey{userID: userID, clientID: clientID}]
+	scopes := make([]string, 0, len(granted))
+	for scope := range granted {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	return scopes, nil
+}
+
+func (m *MemoryStore) Counts() (int, int, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return len(m.authCodes), len(m.refreshTokens), nil
+}
+
+func (m *MemoryStore) DeleteExpired(now time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.deleteExpiredAuthCodes(now)
+	m.deleteExpiredRefreshTokens(now)
+
+	// Clean denylist entries for tokens that have expired on their own
+	for jti, expiresAt := range m.revokedJTIs {
+		if now.After(expiresAt) {
+			delete(m.revokedJTIs, jti)
+		}
+	}
+
+	return nil
+}
+
+// deleteExpiredAuthCodes must be called with the write lock held
+func (m *MemoryStore) deleteExpiredAuthCodes(now time.Time) {
+	for code, authCode := range m.authCodes {
+		if now.After(authCode.ExpiresAt) {
+			delete(m.authCodes, code)
+		}
+	}
+}
+
+// deleteExpiredRefreshTokens must be called with the write lock held
+func (m *MemoryStore) deleteExpiredRefreshTokens(now time.Time) {
+	for token, refreshToken := range m.refreshTokens {
+		if now.After(refreshToken.ExpiresAt) {
+			delete(m.refreshTokens, token)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"auth-service/internal/models"
+)
+
+// PostgresStore is a TokenStore backed by the oauth_* tables created by
+// migrations/sql/003_create_oauth_token_tables.sql through
+// 006_add_oauth_resources.sql. Resource indicators are stored space-separated,
+// like scopes. ResolveTenant reads the users and tenants tables of
+// 001_create_base_schema.sql. The caller is responsible for opening db with a
+// registered Postgres driver.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (p *PostgresStore) SaveAuthCode(code *models.AuthorizationCode) error {
+	_, err := p.db.Exec(`
+		INSERT INTO public.oauth_authorization_codes
+			(code, client_id, redirect_uri, scope, state, code_challenge, code_challenge_method, nonce, resources, user_id, expires_at, auth_time)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		code.Code, code.ClientID, code.RedirectURI, code.Scope, code.State,
+		code.CodeChallenge, code.CodeChallengeMethod, code.Nonce, strings.Join(code.Resources, " "), code.UserID, code.ExpiresAt,
+		sql.NullTime{Time: code.AuthTime, Valid: !code.AuthTime.IsZero()},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save authorization code: %w", err)
+	}
+	return nil
+}
+
+func (p *PostgresStore) GetAuthCode(code string) (*models.AuthorizationCode, error) {
+	authCode := &models.AuthorizationCode{}
+	var resources string
+	var authTime sql.NullTime
+	err := p.db.QueryRow(`
+		SELECT code, client_id, redirect_uri, scope, state, code_challenge, code_challenge_method, nonce, resources, user_id, expires_at, auth_time
+		FROM public.oauth_authorization_codes
+		WHERE code = $1`, code,
+	).Scan(
+		&authCode.Code, &authCode.ClientID, &authCode.RedirectURI, &authCode.Scope, &authCode.State,
+		&authCode.CodeChallenge, &authCode.CodeChallengeMethod, &authCode.Nonce, &resources, &authCode.UserID, &authCode.ExpiresAt,
+		&authTime,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get authorization code: %w", err)
+	}
+	authCode.Resources = strings.Fields(resources)
+	authCode.AuthTime = authTime.Time
+	return authCode, nil
+}
+
+func (p *PostgresStore) DeleteAuthCode(code string) error {
+	if _, err := p.db.Exec(`DELETE FROM public.oauth_authorization_codes WHERE code = $1`, code); err != nil {
+		return fmt.Errorf("failed to delete authorization code: %w", err)
+	}
+	return nil
+}
+
+func (p *PostgresStore) ConsumeAuthCode(code string) error {
+	result, err := p.db.Exec(`DELETE FROM public.oauth_authorization_codes WHERE code = $1`, code)
+	if err != nil {
+		return fmt.Errorf("failed to consume authorization code: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to consume authorization code: %w", err)
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *PostgresStore) SaveRefreshToken(token *models.RefreshToken) error {
+	_, err := p.db.Exec(`
+		INSERT INTO public.oauth_refresh_tokens (token, client_id, user_id, scope, resources, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		token.Token, token.ClientID, token.UserID, token.Scope, strings.Join(token.Resources, " "), token.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save refresh token: %w", err)
+	}
+	return nil
+}
+
+func (p *PostgresStore) GetRefreshToken(token string) (*models.RefreshToken, error) {
+	refreshToken := &models.RefreshToken{}
+	var resources string
+	err := p.db.QueryRow(`
+		SELECT token, client_id, user_id, scope, resources, expires_at
+		FROM public.oauth_refresh_tokens
+		WHERE token = $1`, token,
+	).Scan(&refreshToken.Token, &refreshToken.ClientID, &refreshToken.UserID, &refreshToken.Scope, &resources, &refreshToken.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	refreshToken.Resources = strings.Fields(resources)
+	return refreshToken, nil
+}
+
+func (p *PostgresStore) DeleteRefreshToken(token string) error {
+	if _, err := p.db.Exec(`DELETE FROM public.oauth_refresh_tokens WHERE token = $1`, token); err != nil {
+		return fmt.Errorf("failed to delete refresh token: %w", err)
+	}
+	return nil
+}
+
+func (p *PostgresStore) RevokeJTI(jti string, expiresAt time.Time) error {
+	_, err := p.db.Exec(`
+		INSERT INTO public.oauth_revoked_jtis (jti, expires_at)
+		VALUES ($1, $2)
+		ON CONFLICT (jti) DO NOTHING`,
+		jti, expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke jti: %w", err)
+	}
+	return nil
+}
+
+func (p *PostgresStore) IsJTIRevoked(jti string) (bool, error) {
+	var revoked bool
+	err := p.db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM public.oauth_revoked_jtis
+			WHERE jti = $1 AND expires_at > NOW()
+		)`, jti,
+	).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("failed to check revoked jti: %w", err)
+	}
+	return revoked, nil
+}
+
+func (p *PostgresStore) GrantConsent(userID, clientID string, scopes []string) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to grant consent: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, scope := range scopes {
+		_, err := tx.Exec(`
+			INSERT INTO public.oauth_consents (user_id, client_id, scope)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, client_id, scope) DO NOTHING`,
+			userID, clientID, scope,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to grant consent: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to grant consent: %w", err)
+	}
+	return nil
+}
+
+func (p *PostgresStore) GetConsent(userID, clientID string) ([]string, error) {
+	rows, err := p.db.Query(`
+		SELECT scope FROM public.oauth_consents
+		WHERE user_id = $1 AND client_id = $2
+		ORDER BY scope`, userID, clientID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get consent: %w", err)
+	}
+	defer rows.Close()
+
+	var scopes []string
+	for rows.Next() {
+		var scope string
+		if err := rows.Scan(&scope); err != nil {
+			return nil, fmt.Errorf("failed to get consent: %w", err)
+		}
+		scopes = append(scopes, scope)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get consent: %w", err)
+	}
+	return scopes, nil
+}
+
+// ResolveTenant returns the tenant of the active user whose ID or external ID
+// is userID, provided the tenant is active too
+func (p *PostgresStore) ResolveTenant(userID string) (string, error) {
+	var tenantID string
+	err := p.db.QueryRow(`
+		SELECT u.tenant_id
+		FROM public.users u
+		JOIN public.tenants t ON t.id = u.tenant_id
+		WHERE (u.id::text = $1 OR u.external_id = $1) AND u.is_active AND t.is_active
+		ORDER BY u.id::text = $1 DESC
+		LIMIT 1`,
+		userID,
+	).Scan(&tenantID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve tenant: %w", err)
+	}
+	return tenantID, nil
+}
+
+func (p *PostgresStore) Counts() (int, int, error) {
+	var authCodes, refreshTokens int
+	err := p.db.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM public.oauth_authorization_codes),
+			(SELECT COUNT(*) FROM public.oauth_refresh_tokens)`,
+	).Scan(&authCodes, &refreshTokens)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count tokens: %w", err)
+	}
+	return authCodes, refreshTokens, nil
+}
+
+func (p *PostgresStore) DeleteExpired(now time.Time) error {
+	if _, err := p.db.Exec(`DELETE FROM public.oauth_authorization_codes WHERE expires_at < $1`, now); err != nil {
+		return fmt.Errorf("failed to delete expired authorization codes: %w", err)
+	}
+	if _, err := p.db.Exec(`DELETE FROM public.oauth_refresh_tokens WHERE expires_at < $1`, now); err != nil {
+		return fmt.Errorf("failed to delete expired refresh tokens: %w", err)
+	}
+	if _, err := p.db.Exec(`DELETE FROM public.oauth_revoked_jtis WHERE expires_at < $1`, now); err != nil {
+		return fmt.Errorf("failed to delete expired revoked jtis: %w", err)
+	}
+	return nil
+}
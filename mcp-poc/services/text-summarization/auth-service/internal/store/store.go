@@ -0,0 +1,60 @@
+package store
+
+import (
+	"errors"
+	"time"
+
+	"auth-service/internal/models"
+)
+
+// ErrNotFound is returned when a code or token does not exist in the store
+var ErrNotFound = errors.New("not found")
+
+// ErrCapacity is returned when saving a code or token to a store that holds
+// as many unexpired ones as it is allowed to
+var ErrCapacity = errors.New("store is full")
+
+// TokenStore persists authorization codes and refresh tokens
+type TokenStore interface {
+	SaveAuthCode(code *models.AuthorizationCode) error
+	GetAuthCode(code string) (*models.AuthorizationCode, error)
+	DeleteAuthCode(code string) error
+	// ConsumeAuthCode deletes code, or returns ErrNotFound if it is already
+	// gone, so that of concurrent exchanges of one code only one succeeds
+	ConsumeAuthCode(code string) error
+
+	SaveRefreshToken(token *models.RefreshToken) error
+	GetRefreshToken(token string) (*models.RefreshToken, error)
+	DeleteRefreshToken(token string) error
+
+	JTIDenylist
+	ConsentStore
+
+	// DeleteExpired removes all codes, tokens and denylist entries that
+	// expired before the given time
+	DeleteExpired(now time.Time) error
+
+	// Counts returns the number of stored authorization codes and refresh tokens
+	Counts() (authCodes int, refreshTokens int, err error)
+}
+
+// JTIDenylist records the IDs of revoked access tokens. Entries only need to
+// outlive the token they revoke.
+type JTIDenylist interface {
+	// RevokeJTI denylists jti until expiresAt
+	RevokeJTI(jti string, expiresAt time.Time) error
+
+	// IsJTIRevoked reports whether jti is denylisted and not yet expired
+	IsJTIRevoked(jti string) (bool, error)
+}
+
+// ConsentStore records the scopes each user has approved for each client.
+// Consent does not expire; it lasts until removed out of band.
+type ConsentStore interface {
+	// GrantConsent adds scopes to those userID has approved for clientID
+	GrantConsent(userID, clientID string, scopes []string) error
+
+	// GetConsent returns the scopes userID has approved for clientID, or
+	// none if the user never approved any
+	GetConsent(userID, clientID string) ([]string, error)
+}
@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -49,6 +51,21 @@ var (
 		[]string{"status"},
 	)
 
+	RevocationRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_service_revocation_requests_total",
+			Help: "Total number of token revocation requests",
+		},
+		[]string{"status"},
+	)
+
+	CodeReuseTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "auth_service_code_reuse_total",
+			Help: "Total number of already consumed authorization codes presented again",
+		},
+	)
+
 	// JWT metrics
 	JwtTokensGenerated = promauto.NewCounterVec(
 		prometheus.CounterOpts{
@@ -99,6 +116,23 @@ var (
 		},
 	)
 
+	// Rate limiting metrics
+	RateLimitedRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_service_rate_limited_requests_total",
+			Help: "Total number of requests rejected by the rate limiter",
+		},
+		[]string{"endpoint"},
+	)
+
+	StoreCapacityRejectionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_service_store_capacity_rejections_total",
+			Help: "Total number of codes and tokens not issued because the token store was full",
+		},
+		[]string{"kind"},
+	)
+
 	// Active tokens/codes
 	ActiveAuthorizationCodes = promauto.NewGauge(
 		prometheus.GaugeOpts{
@@ -129,8 +163,61 @@ var (
 			Buckets: prometheus.DefBuckets,
 		},
 	)
+
+	LastKeyRotationTimestamp = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "auth_service_last_key_rotation_timestamp_seconds",
+			Help: "Unix time of the last successful key rotation",
+		},
+	)
+
+	CurrentKeyVersion = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "auth_service_current_key_version",
+			Help: "Version of the signing key currently used for new tokens",
+		},
+	)
 )
 
+// Collectors returns every collector defined by this package
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		HttpRequestsTotal,
+		HttpRequestDuration,
+		AuthorizationRequestsTotal,
+		TokenRequestsTotal,
+		IntrospectionRequestsTotal,
+		RevocationRequestsTotal,
+		CodeReuseTotal,
+		JwtTokensGenerated,
+		JwtTokenValidations,
+		VaultOperations,
+		VaultOperationDuration,
+		KeyCacheHits,
+		KeyCacheMisses,
+		RateLimitedRequestsTotal,
+		StoreCapacityRejectionsTotal,
+		ActiveAuthorizationCodes,
+		ActiveRefreshTokens,
+		KeyRotations,
+		KeyRotationDuration,
+		LastKeyRotationTimestamp,
+		CurrentKeyVersion,
+	}
+}
+
+// Register registers every collector with registerer, for serving metrics
+// from a registry other than the Prometheus default one, which the
+// collectors are registered with already
+func Register(registerer prometheus.Registerer) error {
+	for _, collector := range Collectors() {
+		if err := registerer.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Helper functions for common metric operations
 func RecordHTTPRequest(method, endpoint, statusCode string) {
 	HttpRequestsTotal.WithLabelValues(method, endpoint, statusCode).Inc()
@@ -148,6 +235,22 @@ func RecordIntrospectionRequest(status string) {
 	IntrospectionRequestsTotal.WithLabelValues(status).Inc()
 }
 
+func RecordRevocationRequest(status string) {
+	RevocationRequestsTotal.WithLabelValues(status).Inc()
+}
+
+func RecordRateLimitedRequest(endpoint string) {
+	RateLimitedRequestsTotal.WithLabelValues(endpoint).Inc()
+}
+
+func RecordStoreCapacityRejection(kind string) {
+	StoreCapacityRejectionsTotal.WithLabelValues(kind).Inc()
+}
+
+func RecordCodeReuse() {
+	CodeReuseTotal.Inc()
+}
+
 func RecordJWTTokenGenerated(tokenType, clientID string) {
 	JwtTokensGenerated.WithLabelValues(tokenType, clientID).Inc()
 }
@@ -160,6 +263,10 @@ func RecordVaultOperation(operation, status string) {
 	VaultOperations.WithLabelValues(operation, status).Inc()
 }
 
+func ObserveVaultOperationDuration(operation string, duration time.Duration) {
+	VaultOperationDuration.WithLabelValues(operation).Observe(duration.Seconds())
+}
+
 func RecordKeyCacheHit() {
 	KeyCacheHits.Inc()
 }
@@ -168,6 +275,30 @@ func RecordKeyCacheMiss() {
 	KeyCacheMisses.Inc()
 }
 
+// VaultObserver reports vault.Client events to the Prometheus collectors
+type VaultObserver struct{}
+
+func (VaultObserver) KeyCacheHit() {
+	RecordKeyCacheHit()
+}
+
+func (VaultObserver) KeyCacheMiss() {
+	RecordKeyCacheMiss()
+}
+
+func (VaultObserver) VaultOperation(operation string, duration time.Duration, err error) {
+	status := "success"
+	if err != nil {
+		status = "error"
+	}
+	RecordVaultOperation(operation, status)
+	ObserveVaultOperationDuration(operation, duration)
+}
+
+func (VaultObserver) KeyVersion(version int) {
+	SetCurrentKeyVersion(version)
+}
+
 func SetActiveAuthorizationCodes(count int) {
 	ActiveAuthorizationCodes.Set(float64(count))
 }
@@ -179,3 +310,15 @@ func SetActiveRefreshTokens(count int) {
 func RecordKeyRotation() {
 	KeyRotations.Inc()
 }
+
+func ObserveKeyRotationDuration(duration time.Duration) {
+	KeyRotationDuration.Observe(duration.Seconds())
+}
+
+func SetLastKeyRotation(rotatedAt time.Time) {
+	LastKeyRotationTimestamp.Set(float64(rotatedAt.Unix()))
+}
+
+func SetCurrentKeyVersion(version int) {
+	CurrentKeyVersion.Set(float64(version))
+}
@@ -1,12 +1,21 @@
 package vault
 
 import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"math/big"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,19 +23,99 @@ import (
 	"github.com/hashicorp/vault/api"
 )
 
+// Supported JWT signing algorithms
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmPS256 = "PS256"
+	AlgorithmES256 = "ES256"
+)
+
 type Client struct {
 	vault      *api.Client
 	transitKey string
+	algorithm  string
 	keyCache   *keyCache
+	observer   Observer
+	retry      RetryPolicy
+	tokenFile  string
+	minRefresh time.Duration
 	mutex      sync.RWMutex
 }
 
+// Observer receives instrumentation events from the client. It lets callers
+// plug in metrics without this package depending on them.
+type Observer interface {
+	KeyCacheHit()
+	KeyCacheMiss()
+
+	// VaultOperation is called after each request to Vault with the
+	// operation name, how long it took and the error it failed with, if any
+	VaultOperation(operation string, duration time.Duration, err error)
+}
+
+// KeyVersionObserver is an optional extension of Observer notified with the
+// latest key version each time the key versions are read from Vault
+type KeyVersionObserver interface {
+	KeyVersion(version int)
+}
+
+type noopObserver struct{}
+
+func (noopObserver) KeyCacheHit()                                {}
+func (noopObserver) KeyCacheMiss()                               {}
+func (noopObserver) VaultOperation(string, time.Duration, error) {}
+
+// Operation names reported to Observer.VaultOperation
+const (
+	OperationSign         = "sign"
+	OperationGetPublicKey = "get_public_key"
+	OperationVerify       = "verify"
+	OperationRotateKey    = "rotate_key"
+)
+
+// Option customizes a Client at construction time
+type Option func(*Client)
+
+// WithAlgorithm selects the JWT signing algorithm, which also determines the
+// type of the transit key. Defaults to RS256.
+func WithAlgorithm(algorithm string) Option {
+	return func(c *Client) {
+		c.algorithm = algorithm
+	}
+}
+
+// WithObserver sets the observer notified of client events
+func WithObserver(observer Observer) Option {
+	return func(c *Client) {
+		c.observer = observer
+	}
+}
+
+// DefaultMinKeyRefresh is how often, at most, a signature that fails to
+// verify against a cached key makes the client reread the keys from Vault
+const DefaultMinKeyRefresh = 30 * time.Second
+
+// WithMinKeyRefresh overrides DefaultMinKeyRefresh. It bounds the Vault
+// reads that tokens with forged signatures can cause.
+func WithMinKeyRefresh(interval time.Duration) Option {
+	return func(c *Client) {
+		c.minRefresh = interval
+	}
+}
+
 type keyCache struct {
-	publicKey *rsa.PublicKey
-	keyID     string
+	versions  []keyVersion
+	readAt    time.Time
 	expiresAt time.Time
 }
 
+type keyVersion struct {
+	version   int
+	publicKey crypto.PublicKey
+	// createdAt is zero when Vault doesn't report it
+	createdAt time.Time
+}
+
 type VaultSignResponse struct {
 	Data struct {
 		Signature string `json:"signature"`
@@ -39,7 +128,7 @@ type VaultPublicKeyResponse struct {
 	} `json:"data"`
 }
 
-func NewClient(vaultAddr, vaultToken, transitKey string) (*Client, error) {
+func NewClient(vaultAddr, vaultToken, transitKey string, opts ...Option) (*Client, error) {
 	config := api.DefaultConfig()
 	config.Address = vaultAddr
 
@@ -49,10 +138,33 @@ func NewClient(vaultAddr, vaultToken, transitKey string) (*Client, error) {
 	}
 
 	vaultClient.SetToken(vaultToken)
+	// Retries are left to withRetry, which skips errors that can't succeed
+	// on a retry and caps the total time spent
+	vaultClient.SetMaxRetries(0)
 
 	client := &Client{
 		vault:      vaultClient,
 		transitKey: transitKey,
+		algorithm:  AlgorithmRS256,
+		observer:   noopObserver{},
+		retry:      DefaultRetryPolicy,
+		minRefresh: DefaultMinKeyRefresh,
+	}
+
+	for _, opt := range opts {
+		opt(client)
+	}
+
+	if client.tokenFile != "" {
+		token, err := ReadTokenFile(client.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		vaultClient.SetToken(token)
+	}
+
+	if _, err := client.keyType(); err != nil {
+		return nil, err
 	}
 
 	// Initialize the key on startup
@@ -63,41 +175,107 @@ func NewClient(vaultAddr, vaultToken, transitKey string) (*Client, error) {
 	return client, nil
 }
 
+// Algorithm returns the JWT "alg" value for tokens signed by this client
+func (c *Client) Algorithm() string {
+	return c.algorithm
+}
+
+// keyType maps the signing algorithm to a transit key type
+func (c *Client) keyType() (string, error) {
+	switch c.algorithm {
+	case AlgorithmRS256, AlgorithmPS256:
+		return "rsa-2048", nil
+	case AlgorithmES256:
+		return "ecdsa-p256", nil
+	default:
+		return "", fmt.Errorf("unsupported signing algorithm: %s", c.algorithm)
+	}
+}
+
+// signingParams returns the transit sign/verify parameters that produce a
+// JWS signature for the configured algorithm
+func (c *Client) signingParams() map[string]interface{} {
+	params := map[string]interface{}{
+		"marshaling_algorithm": "jws",
+	}
+	switch c.algorithm {
+	case AlgorithmRS256:
+		// RS256 is RSASSA-PKCS1-v1_5; Vault defaults to PSS
+		params["signature_algorithm"] = "pkcs1v15"
+	case AlgorithmPS256:
+		// PS256 requires the salt to be as long as the hash, while Vault
+		// defaults to the longest salt that fits
+		params["signature_algorithm"] = "pss"
+		params["salt_length"] = "hash"
+	}
+	return params
+}
+
 func (c *Client) ensureKey() error {
-	// Check if key exists, create if not
-	_, err := c.vault.Logical().Read(fmt.Sprintf("transit/keys/%s", c.transitKey))
+	keyType, err := c.keyType()
 	if err != nil {
-		// Key doesn't exist, create it
-		data := map[string]interface{}{
-			"type":                "rsa-2048",
-			"exportable":          false,
-			"allow_plaintext_backup": false,
-		}
+		return err
+	}
 
-		_, err = c.vault.Logical().Write(fmt.Sprintf("transit/keys/%s", c.transitKey), data)
-		if err != nil {
-			return fmt.Errorf("failed to create transit key: %w", err)
+	// Check if key exists, create if not
+	var secret *api.Secret
+	err = c.withRetry(func(ctx context.Context) error {
+		var err error
+		secret, err = c.vault.Logical().ReadWithContext(ctx, fmt.Sprintf("transit/keys/%s", c.transitKey))
+		return err
+	})
+	if err == nil && secret != nil {
+		// An existing key of another type would sign with the wrong algorithm
+		if existing, _ := secret.Data["type"].(string); existing != keyType {
+			return fmt.Errorf("transit key %s has type %q, %s requires %q", c.transitKey, existing, c.algorithm, keyType)
 		}
+		return nil
+	}
+
+	// Key doesn't exist, create it
+	data := map[string]interface{}{
+		"type":                   keyType,
+		"exportable":             false,
+		"allow_plaintext_backup": false,
+	}
+
+	_, err = c.vault.Logical().Write(fmt.Sprintf("transit/keys/%s", c.transitKey), data)
+	if err != nil {
+		return fmt.Errorf("failed to create transit key: %w", err)
 	}
 
 	return nil
 }
 
+// SignJWT signs payload with the latest version of the transit key
 func (c *Client) SignJWT(payload []byte) (string, error) {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
-
-	// Base64url encode the payload
-	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+	return c.SignJWTWithKeyVersion(payload, 0)
+}
 
-	data := map[string]interface{}{
-		"input":           encodedPayload,
-		"signature_algorithm": "pss",
-		"marshaling_algorithm": "jws",
+// SignJWTWithKeyVersion signs payload with the given version of the transit
+// key, or the latest one for version 0. Tokens name their key in the "kid"
+// header before they are signed, so they must be signed with that version
+// rather than whatever Vault's latest is by then.
+func (c *Client) SignJWTWithKeyVersion(payload []byte, version int) (string, error) {
+	// The request is built under the lock but sent without it, so a rotation
+	// doesn't wait out this call's retries and stall every signer behind it
+	c.mutex.RLock()
+	data := c.signingParams()
+	data["input"] = base64.StdEncoding.EncodeToString(payload)
+	if version > 0 {
+		data["key_version"] = version
 	}
-
 	path := fmt.Sprintf("transit/sign/%s", c.transitKey)
-	resp, err := c.vault.Logical().Write(path, data)
+	c.mutex.RUnlock()
+
+	start := time.Now()
+	var resp *api.Secret
+	err := c.withRetry(func(ctx context.Context) error {
+		var err error
+		resp, err = c.vault.Logical().WriteWithContext(ctx, path, data)
+		return err
+	})
+	c.observer.VaultOperation(OperationSign, time.Since(start), err)
 	if err != nil {
 		return "", fmt.Errorf("failed to sign JWT: %w", err)
 	}
@@ -110,106 +288,307 @@ func (c *Client) SignJWT(payload []byte) (string, error) {
 	return signature, nil
 }
 
-func (c *Client) GetPublicKey() (*rsa.PublicKey, string, error) {
+// GetPublicKey returns the latest public key, an *rsa.PublicKey or
+// *ecdsa.PublicKey depending on the algorithm, and its key ID
+func (c *Client) GetPublicKey() (crypto.PublicKey, string, error) {
+	cache, err := c.cachedKeys()
+	if err != nil {
+		return nil, "", err
+	}
+
+	latest := cache.versions[len(cache.versions)-1]
+	return latest.publicKey, c.keyID(latest.version), nil
+}
+
+// SigningKey returns the latest cached key version and its key ID, for
+// SignJWTWithKeyVersion
+func (c *Client) SigningKey() (int, string, error) {
+	cache, err := c.cachedKeys()
+	if err != nil {
+		return 0, "", err
+	}
+
+	latest := cache.versions[len(cache.versions)-1]
+	return latest.version, c.keyID(latest.version), nil
+}
+
+// cachedKeys returns the cached key versions, reading them from Vault when
+// the cache is empty or expired
+func (c *Client) cachedKeys() (*keyCache, error) {
 	c.mutex.RLock()
 	if c.keyCache != nil && time.Now().Before(c.keyCache.expiresAt) {
 		defer c.mutex.RUnlock()
-		return c.keyCache.publicKey, c.keyCache.keyID, nil
+		c.observer.KeyCacheHit()
+		return c.keyCache, nil
 	}
 	c.mutex.RUnlock()
 
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	// Double-check after acquiring write lock
+	// Double-check after acquiring write lock; another caller may have
+	// refreshed the cache, in which case this is still a hit
 	if c.keyCache != nil && time.Now().Before(c.keyCache.expiresAt) {
-		return c.keyCache.publicKey, c.keyCache.keyID, nil
+		c.observer.KeyCacheHit()
+		return c.keyCache, nil
+	}
+	c.observer.KeyCacheMiss()
+
+	return c.loadKeys()
+}
+
+// RotateKeyIfOlderThan rotates the key unless its latest version was
+// created less than age ago, as it is when another replica sharing the key
+// has already rotated it. In that case the cached keys are reread, so this
+// replica signs with the new version too. It reports whether it rotated.
+func (c *Client) RotateKeyIfOlderThan(age time.Duration) (bool, error) {
+	c.mutex.Lock()
+	versions, err := c.readKeyVersions()
+	if err != nil {
+		c.mutex.Unlock()
+		return false, err
+	}
+	if created := versions[len(versions)-1].createdAt; !created.IsZero() && time.Since(created) < age {
+		c.storeKeys(versions)
+		c.mutex.Unlock()
+		return false, nil
 	}
+	c.mutex.Unlock()
+
+	return true, c.RotateKey()
+}
+
+// refreshKeys rereads the key versions in place of stale, unless another
+// caller already has or stale was read less than minRefresh ago, in which
+// case it returns nil. The caller must not hold the mutex.
+func (c *Client) refreshKeys(stale *keyCache) (*keyCache, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.keyCache != nil && c.keyCache != stale {
+		return c.keyCache, nil
+	}
+	if time.Since(stale.readAt) < c.minRefresh {
+		return nil, nil
+	}
+	return c.loadKeys()
+}
+
+// loadKeys reads the key versions from Vault into the cache. The caller
+// must hold the write lock.
+func (c *Client) loadKeys() (*keyCache, error) {
+	versions, err := c.readKeyVersions()
+	if err != nil {
+		return nil, err
+	}
+	return c.storeKeys(versions), nil
+}
 
+// storeKeys caches versions. The caller must hold the write lock.
+func (c *Client) storeKeys(versions []keyVersion) *keyCache {
+	// Cache the keys for 23 hours (rotate every 24 hours)
+	now := time.Now()
+	c.keyCache = &keyCache{
+		versions:  versions,
+		readAt:    now,
+		expiresAt: now.Add(23 * time.Hour),
+	}
+	if observer, ok := c.observer.(KeyVersionObserver); ok {
+		observer.KeyVersion(versions[len(versions)-1].version)
+	}
+
+	return c.keyCache
+}
+
+// readKeyVersions reads every non-retired key version from Vault, ordered
+// from oldest to newest
+func (c *Client) readKeyVersions() ([]keyVersion, error) {
 	path := fmt.Sprintf("transit/keys/%s", c.transitKey)
-	resp, err := c.vault.Logical().Read(path)
+	start := time.Now()
+	var resp *api.Secret
+	err := c.withRetry(func(ctx context.Context) error {
+		var err error
+		resp, err = c.vault.Logical().ReadWithContext(ctx, path)
+		return err
+	})
+	c.observer.VaultOperation(OperationGetPublicKey, time.Since(start), err)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to read public key: %w", err)
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+	if resp == nil {
+		return nil, fmt.Errorf("transit key %s not found", c.transitKey)
 	}
 
 	keys, ok := resp.Data["keys"].(map[string]interface{})
 	if !ok {
-		return nil, "", fmt.Errorf("invalid keys response from vault")
+		return nil, fmt.Errorf("invalid keys response from vault")
 	}
 
-	// Get the latest key version
-	var latestVersion int
-	var latestKey map[string]interface{}
+	// Versions below min_decryption_version can no longer verify signatures
+	minVersion, _ := intValue(resp.Data["min_decryption_version"])
+
+	var versions []keyVersion
 	for version, keyData := range keys {
-		if keyMap, ok := keyData.(map[string]interface{}); ok {
-			if v := version; v > fmt.Sprintf("%d", latestVersion) {
-				latestVersion++
-				latestKey = keyMap
-			}
+		// Versions are map keys, so they must be compared numerically:
+		// "9" sorts after "12" as a string
+		v, err := strconv.Atoi(version)
+		if err != nil || v < minVersion {
+			continue
+		}
+
+		keyMap, ok := keyData.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		publicKey, err := parsePublicKey(keyMap)
+		if err != nil {
+			return nil, fmt.Errorf("key version %d: %w", v, err)
 		}
+
+		// Vault reports when each version of an asymmetric key was created
+		createdAt, _ := keyMap["creation_time"].(string)
+		created, _ := time.Parse(time.RFC3339Nano, createdAt)
+
+		versions = append(versions, keyVersion{version: v, publicKey: publicKey, createdAt: created})
 	}
 
-	if latestKey == nil {
-		return nil, "", fmt.Errorf("no valid key found")
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("no valid key found")
 	}
 
-	publicKeyPEM, ok := latestKey["public_key"].(string)
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].version < versions[j].version
+	})
+
+	return versions, nil
+}
+
+func parsePublicKey(keyMap map[string]interface{}) (crypto.PublicKey, error) {
+	publicKeyPEM, ok := keyMap["public_key"].(string)
 	if !ok {
-		return nil, "", fmt.Errorf("invalid public key format")
+		return nil, fmt.Errorf("invalid public key format")
 	}
 
 	// Parse PEM
 	block, _ := pem.Decode([]byte(publicKeyPEM))
 	if block == nil {
-		return nil, "", fmt.Errorf("failed to decode PEM block")
+		return nil, fmt.Errorf("failed to decode PEM block")
 	}
 
 	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to parse public key: %w", err)
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
 	}
 
-	rsaPublicKey, ok := publicKey.(*rsa.PublicKey)
-	if !ok {
-		return nil, "", fmt.Errorf("public key is not RSA")
+	return publicKey, nil
+}
+
+// intValue converts a number decoded from a Vault response to an int
+func intValue(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		n, err := v.Int64()
+		return int(n), err == nil
+	case float64:
+		return int(v), true
+	case int:
+		return v, true
+	default:
+		return 0, false
 	}
+}
 
-	keyID := fmt.Sprintf("%s-v%d", c.transitKey, latestVersion)
+func (c *Client) keyID(version int) string {
+	return fmt.Sprintf("%s-v%d", c.transitKey, version)
+}
 
-	// Cache the key for 23 hours (rotate every 24 hours)
-	c.keyCache = &keyCache{
-		publicKey: rsaPublicKey,
-		keyID:     keyID,
-		expiresAt: time.Now().Add(23 * time.Hour),
+// keyVersion returns the version of the transit key named by keyID
+func (c *Client) keyVersion(keyID string) (int, bool) {
+	suffix, ok := strings.CutPrefix(keyID, c.transitKey+"-v")
+	if !ok {
+		return 0, false
+	}
+	version, err := strconv.Atoi(suffix)
+	if err != nil || version <= 0 {
+		return 0, false
 	}
+	return version, true
+}
 
-	return rsaPublicKey, keyID, nil
+// cachedPublicKey returns the key in cache named by keyID, or nil
+func (c *Client) cachedPublicKey(cache *keyCache, keyID string) crypto.PublicKey {
+	for _, v := range cache.versions {
+		if c.keyID(v.version) == keyID {
+			return v.publicKey
+		}
+	}
+	return nil
 }
 
+// GetJWKS returns every non-retired key version, newest first, so tokens
+// signed before a rotation remain verifiable during the overlap window
 func (c *Client) GetJWKS() (*jose.JSONWebKeySet, error) {
-	publicKey, keyID, err := c.GetPublicKey()
+	cache, err := c.cachedKeys()
 	if err != nil {
 		return nil, err
 	}
 
-	jwk := jose.JSONWebKey{
-		Key:       publicKey,
-		KeyID:     keyID,
-		Algorithm: "RS256",
-		Use:       "sig",
+	jwks := &jose.JSONWebKeySet{}
+	for i := len(cache.versions) - 1; i >= 0; i-- {
+		jwks.Keys = append(jwks.Keys, jose.JSONWebKey{
+			Key:       cache.versions[i].publicKey,
+			KeyID:     c.keyID(cache.versions[i].version),
+			Algorithm: c.algorithm,
+			Use:       "sig",
+		})
 	}
 
-	return &jose.JSONWebKeySet{
-		Keys: []jose.JSONWebKey{jwk},
-	}, nil
+	return jwks, nil
+}
+
+// healthCheckTimeout bounds HealthCheck, which is not retried, so readiness
+// probes get an answer while Vault is unreachable
+const healthCheckTimeout = 2 * time.Second
+
+// HealthCheck reports whether Vault is reachable, initialized and unsealed,
+// and whether the transit key can be read with the client's token, so that
+// signing requests can be expected to succeed
+func (c *Client) HealthCheck() error {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	health, err := c.vault.Sys().HealthWithContext(ctx)
+	if err != nil {
+		return fmt.Errorf("vault health check failed: %w", err)
+	}
+	if !health.Initialized {
+		return fmt.Errorf("vault is not initialized")
+	}
+	if health.Sealed {
+		return fmt.Errorf("vault is sealed")
+	}
+
+	secret, err := c.vault.Logical().ReadWithContext(ctx, fmt.Sprintf("transit/keys/%s", c.transitKey))
+	if err != nil {
+		return fmt.Errorf("failed to read transit key: %w", err)
+	}
+	if secret == nil {
+		return fmt.Errorf("transit key %s not found", c.transitKey)
+	}
+	return nil
 }
 
 func (c *Client) RotateKey() error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
+	// Not retried: a rotation that failed with a 5xx may still have been
+	// applied, and a retry would rotate twice
 	path := fmt.Sprintf("transit/keys/%s/rotate", c.transitKey)
+	start := time.Now()
 	_, err := c.vault.Logical().Write(path, nil)
+	c.observer.VaultOperation(OperationRotateKey, time.Since(start), err)
 	if err != nil {
 		return fmt.Errorf("failed to rotate key: %w", err)
 	}
@@ -220,13 +599,38 @@ func (c *Client) RotateKey() error {
 	return nil
 }
 
+// VerifyJWT has Vault check the token's signature with the key version
+// named by its "kid" header. Tokens that aren't well-formed JWTs for this
+// key's algorithm, or name another key, are invalid without asking Vault.
 func (c *Client) VerifyJWT(token string) (bool, error) {
-	data := map[string]interface{}{
-		"input": token,
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false, nil
+	}
+	kid, ok := c.parseHeader(parts[0])
+	if !ok {
+		return false, nil
 	}
+	version, ok := c.keyVersion(kid)
+	if !ok {
+		return false, nil
+	}
+
+	// transit/verify takes the signed input base64 encoded and the signature
+	// in the "vault:v<version>:" form transit/sign returns it in
+	data := c.signingParams()
+	data["input"] = base64.StdEncoding.EncodeToString([]byte(parts[0] + "." + parts[1]))
+	data["signature"] = fmt.Sprintf("vault:v%d:%s", version, parts[2])
 
 	path := fmt.Sprintf("transit/verify/%s", c.transitKey)
-	resp, err := c.vault.Logical().Write(path, data)
+	start := time.Now()
+	var resp *api.Secret
+	err := c.withRetry(func(ctx context.Context) error {
+		var err error
+		resp, err = c.vault.Logical().WriteWithContext(ctx, path, data)
+		return err
+	})
+	c.observer.VaultOperation(OperationVerify, time.Since(start), err)
 	if err != nil {
 		return false, fmt.Errorf("failed to verify JWT: %w", err)
 	}
@@ -239,14 +643,136 @@ func (c *Client) VerifyJWT(token string) (bool, error) {
 	return valid, nil
 }
 
+// VerifyJWTLocally checks the token's signature against the cached public key
+// named by its "kid" header, avoiding a round-trip to Vault. A token whose
+// key isn't cached, such as one signed after another replica rotated the key,
+// or whose signature fails against a cached key, as it would if the transit
+// key were recreated, is checked once more after rereading the keys. The
+// keys are reread at most every minRefresh, so tokens naming made-up keys
+// can't make a Vault call each.
+func (c *Client) VerifyJWTLocally(token string) (bool, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false, nil
+	}
+	kid, ok := c.parseHeader(parts[0])
+	if !ok {
+		return false, nil
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false, nil
+	}
+
+	cache, err := c.cachedKeys()
+	if err != nil {
+		return false, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if publicKey := c.cachedPublicKey(cache, kid); publicKey != nil {
+		valid, err := c.verifySignature(publicKey, digest[:], signature)
+		if err != nil || valid {
+			return valid, err
+		}
+	}
+
+	cache, err = c.refreshKeys(cache)
+	if err != nil || cache == nil {
+		return false, err
+	}
+	if refreshed := c.cachedPublicKey(cache, kid); refreshed != nil {
+		return c.verifySignature(refreshed, digest[:], signature)
+	}
+	return false, nil
+}
+
+// parseHeader returns the "kid" of an encoded JWT header, if the header is
+// for this client's algorithm
+func (c *Client) parseHeader(encoded string) (string, bool) {
+	headerJSON, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil || header.Alg != c.algorithm {
+		return "", false
+	}
+	return header.Kid, true
+}
+
+// verifySignature checks a JWS signature over digest with publicKey
+func (c *Client) verifySignature(publicKey crypto.PublicKey, digest, signature []byte) (bool, error) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		if c.algorithm == AlgorithmPS256 {
+			opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+			return rsa.VerifyPSS(key, crypto.SHA256, digest, signature, opts) == nil, nil
+		}
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, signature) == nil, nil
+	case *ecdsa.PublicKey:
+		// JWS encodes ES256 signatures as the fixed-width concatenation r || s
+		if len(signature) != 64 {
+			return false, nil
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, digest, r, s), nil
+	default:
+		return false, fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+}
+
 // Helper function to convert RSA public key to JWK format for JWKS endpoint
 func RSAPublicKeyToJWK(publicKey *rsa.PublicKey, keyID string) map[string]interface{} {
 	return map[string]interface{}{
 		"kty": "RSA",
 		"use": "sig",
-		"alg": "RS256",
+		"alg": AlgorithmRS256,
 		"kid": keyID,
 		"n":   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
 		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
 	}
 }
+
+// ECPublicKeyToJWK converts a P-256 public key to JWK format for the JWKS endpoint
+func ECPublicKeyToJWK(publicKey *ecdsa.PublicKey, keyID string) (map[string]interface{}, error) {
+	if publicKey.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("unsupported curve %s", publicKey.Curve.Params().Name)
+	}
+
+	ecdhKey, err := publicKey.ECDH()
+	if err != nil {
+		return nil, fmt.Errorf("invalid EC public key: %w", err)
+	}
+
+	// Uncompressed point: 0x04 || X || Y, each coordinate zero-padded
+	point := ecdhKey.Bytes()
+	size := (len(point) - 1) / 2
+
+	return map[string]interface{}{
+		"kty": "EC",
+		"use": "sig",
+		"alg": AlgorithmES256,
+		"kid": keyID,
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(point[1 : 1+size]),
+		"y":   base64.RawURLEncoding.EncodeToString(point[1+size:]),
+	}, nil
+}
+
+// PublicKeyToJWK converts an RSA or P-256 public key to JWK format
+func PublicKeyToJWK(publicKey crypto.PublicKey, keyID string) (map[string]interface{}, error) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return RSAPublicKeyToJWK(key, keyID), nil
+	case *ecdsa.PublicKey:
+		return ECPublicKeyToJWK(key, keyID)
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+}
@@ -0,0 +1,89 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// RetryPolicy controls how requests to Vault that fail transiently, with a
+// connection error or a 5xx response, are retried. Other failures, such as a
+// 403 or a missing key, are returned immediately.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt
+	MaxRetries int
+	// InitialBackoff is the wait before the first retry, doubling for each
+	// later retry up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Timeout caps the total time spent on one operation, retries included,
+	// so callers stay within their request deadline. Zero means no cap.
+	Timeout time.Duration
+}
+
+// DefaultRetryPolicy is used unless WithRetryPolicy is given
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries:     3,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     time.Second,
+	Timeout:        5 * time.Second,
+}
+
+// WithRetryPolicy sets how transient Vault failures are retried
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = policy
+	}
+}
+
+// withRetry calls fn until it succeeds, fails with an error that isn't
+// transient, or the policy's retries or timeout run out
+func (c *Client) withRetry(fn func(ctx context.Context) error) error {
+	ctx := context.Background()
+	if c.retry.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.retry.Timeout)
+		defer cancel()
+	}
+
+	backoff := c.retry.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || attempt >= c.retry.MaxRetries || !isTransient(err) {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if c.retry.MaxBackoff > 0 && backoff > c.retry.MaxBackoff {
+			backoff = c.retry.MaxBackoff
+		}
+	}
+}
+
+// isTransient reports whether a failed Vault request may succeed if retried
+func isTransient(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var respErr *api.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode >= http.StatusInternalServerError &&
+			respErr.StatusCode != http.StatusNotImplemented
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,86 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// WithTokenFile reads the Vault token from path, such as the sink file
+// Vault Agent keeps up to date, in place of the token passed to NewClient.
+// Call WatchTokenFile to pick up the token when the file is rewritten.
+func WithTokenFile(path string) Option {
+	return func(c *Client) {
+		c.tokenFile = path
+	}
+}
+
+// SetToken replaces the token used for later requests to Vault
+func (c *Client) SetToken(token string) {
+	c.vault.SetToken(token)
+}
+
+// ReadTokenFile returns the token in path, without surrounding whitespace
+func ReadTokenFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read vault token file: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("vault token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// WatchTokenFile rereads the token file every interval until ctx is
+// canceled, switching to the new token when it changes. The file is polled
+// rather than watched for events, so tokens written by rename, as Vault Agent
+// does, are seen too. A file that can't be read, or is briefly empty while
+// being rewritten, keeps the current token. Without a token file or a
+// positive interval nothing is watched. The returned channel is closed once
+// the watcher has exited.
+func (c *Client) WatchTokenFile(ctx context.Context, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	if c.tokenFile == "" || interval <= 0 {
+		close(done)
+		return done
+	}
+
+	go c.reloadTokenPeriodically(ctx, interval, done)
+	return done
+}
+
+func (c *Client) reloadTokenPeriodically(ctx context.Context, interval time.Duration, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if ctx.Err() != nil {
+				return
+			}
+			c.reloadToken()
+		}
+	}
+}
+
+func (c *Client) reloadToken() {
+	token, err := ReadTokenFile(c.tokenFile)
+	if err != nil {
+		log.Printf("Keeping the current vault token: %v", err)
+		return
+	}
+	if token != c.vault.Token() {
+		c.SetToken(token)
+		log.Printf("Reloaded vault token from %s", c.tokenFile)
+	}
+}
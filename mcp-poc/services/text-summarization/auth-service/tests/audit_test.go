@@ -0,0 +1,310 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+)
+
+// recordingAuditSink keeps audit events for assertions
+type recordingAuditSink struct {
+	mutex  sync.Mutex
+	events []services.AuditEvent
+}
+
+func (r *recordingAuditSink) Audit(event services.AuditEvent) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, event)
+}
+
+// take returns the events recorded since the last call
+func (r *recordingAuditSink) take() []services.AuditEvent {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
+
+func TestAuditTrail(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	sink := &recordingAuditSink{}
+	oauthService := services.NewOAuthService(cfg, jwtService, services.WithAuditSink(sink))
+	defer oauthService.Stop()
+	grantConsent(t, oauthService, "test-client", "openid profile")
+
+	start := time.Now().Add(-time.Second)
+	assertEvent := func(t *testing.T, event services.AuditEvent, eventType, scope string) {
+		t.Helper()
+		assert.Equal(t, eventType, event.Type)
+		assert.Equal(t, services.AuditOutcomeSuccess, event.Outcome)
+		assert.Equal(t, "test-client", event.ClientID)
+		assert.Equal(t, "demo-user", event.UserID)
+		assert.Equal(t, scope, event.Scope)
+		assert.True(t, event.Timestamp.After(start), "timestamp %v", event.Timestamp)
+	}
+
+	// Authorization code
+	authCode, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+		UserID:              "demo-user",
+		ResponseType:        "code",
+		ClientID:            "test-client",
+		RedirectURI:         "http://localhost:3000/callback",
+		Scope:               "openid profile",
+		CodeChallenge:       testCodeChallenge,
+		CodeChallengeMethod: "S256",
+	})
+	require.Nil(t, errorResp)
+	events := sink.take()
+	require.Len(t, events, 1)
+	assertEvent(t, events[0], services.AuditCodeIssued, "openid profile")
+
+	// Token issuance
+	tokenResp, errorResp := oauthService.HandleTokenRequest(&models.TokenRequest{
+		GrantType:    "authorization_code",
+		Code:         authCode.Code,
+		RedirectURI:  authCode.RedirectURI,
+		ClientID:     "test-client",
+		CodeVerifier: testCodeVerifier,
+	})
+	require.Nil(t, errorResp)
+	claims, err := jwtService.ValidateAccessToken(tokenResp.AccessToken)
+	require.NoError(t, err)
+	events = sink.take()
+	require.Len(t, events, 1)
+	assertEvent(t, events[0], services.AuditTokenIssued, "openid profile")
+	assert.Equal(t, "authorization_code", events[0].GrantType)
+	assert.Equal(t, claims.JWTID, events[0].TokenID)
+
+	// Refresh, narrowing the scope
+	refreshed, errorResp := oauthService.HandleTokenRequest(&models.TokenRequest{
+		GrantType:    "refresh_token",
+		RefreshToken: tokenResp.RefreshToken,
+		ClientID:     "test-client",
+		Scope:        "openid",
+	})
+	require.Nil(t, errorResp)
+	events = sink.take()
+	require.Len(t, events, 1)
+	assertEvent(t, events[0], services.AuditTokenRefreshed, "openid")
+	assert.Equal(t, "refresh_token", events[0].GrantType)
+	assert.NotEqual(t, claims.JWTID, events[0].TokenID)
+
+	// Introspection
+	introspection, err := oauthService.IntrospectToken(tokenResp.AccessToken)
+	require.NoError(t, err)
+	require.True(t, introspection.Active)
+	events = sink.take()
+	require.Len(t, events, 1)
+	assertEvent(t, events[0], services.AuditTokenIntrospected, "openid profile")
+	require.NotNil(t, events[0].Active)
+	assert.True(t, *events[0].Active)
+
+	// Revocation of the access token
+	require.NoError(t, oauthService.RevokeToken(tokenResp.AccessToken, "access_token"))
+	events = sink.take()
+	require.Len(t, events, 1)
+	assertEvent(t, events[0], services.AuditTokenRevoked, "openid profile")
+	assert.Equal(t, "access_token", events[0].TokenType)
+	assert.Equal(t, claims.JWTID, events[0].TokenID)
+
+	// The revoked token now introspects as inactive, without naming anyone
+	_, err = oauthService.IntrospectToken(tokenResp.AccessToken)
+	require.NoError(t, err)
+	events = sink.take()
+	require.Len(t, events, 1)
+	assert.Equal(t, services.AuditTokenIntrospected, events[0].Type)
+	require.NotNil(t, events[0].Active)
+	assert.False(t, *events[0].Active)
+	assert.Empty(t, events[0].UserID)
+
+	// Revocation of the refresh token
+	require.NoError(t, oauthService.RevokeToken(tokenResp.RefreshToken, ""))
+	events = sink.take()
+	require.Len(t, events, 1)
+	assertEvent(t, events[0], services.AuditTokenRevoked, "openid profile")
+	assert.Equal(t, "refresh_token", events[0].TokenType)
+
+	// Unknown tokens revoke nothing, so nothing is recorded
+	require.NoError(t, oauthService.RevokeToken(refreshed.AccessToken+"x", ""))
+	require.NoError(t, oauthService.RevokeToken("unknown", ""))
+	assert.Empty(t, sink.take())
+}
+
+func TestAuditTenantsAndFailures(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	sink := &recordingAuditSink{}
+	tenants := services.TenantResolverFunc(func(userID string) (string, error) {
+		return "tenant-acme", nil
+	})
+	oauthService := services.NewOAuthService(cfg, jwtService, services.WithAuditSink(sink), services.WithTenantResolver(tenants))
+	defer oauthService.Stop()
+	grantConsent(t, oauthService, "test-client", "openid")
+
+	tokens := issueTokens(t, oauthService, "openid")
+	claims, err := jwtService.ValidateAccessToken(tokens.AccessToken)
+	require.NoError(t, err)
+
+	events := sink.take()
+	require.Len(t, events, 2)
+	issued := events[1]
+	assert.Equal(t, services.AuditTokenIssued, issued.Type)
+	assert.Equal(t, services.AuditOutcomeSuccess, issued.Outcome)
+	assert.Equal(t, "test-client", issued.ClientID)
+	assert.Equal(t, claims.JWTID, issued.TokenID)
+	assert.Equal(t, "tenant-acme", issued.TenantID)
+
+	require.NoError(t, oauthService.RevokeToken(tokens.AccessToken, "access_token"))
+	events = sink.take()
+	require.Len(t, events, 1)
+	assert.Equal(t, "tenant-acme", events[0].TenantID)
+
+	t.Run("Refused grants", func(t *testing.T) {
+		tests := []struct {
+			req       *models.TokenRequest
+			eventType string
+			errorCode string
+		}{
+			{
+				req:       &models.TokenRequest{GrantType: "authorization_code", ClientID: "test-client", Code: "unknown"},
+				eventType: services.AuditTokenIssued,
+				errorCode: "invalid_grant",
+			},
+			{
+				req:       &models.TokenRequest{GrantType: "refresh_token", ClientID: "test-client", RefreshToken: "unknown"},
+				eventType: services.AuditTokenRefreshed,
+				errorCode: "invalid_grant",
+			},
+			{
+				req:       &models.TokenRequest{GrantType: "password", ClientID: "test-client"},
+				eventType: services.AuditTokenIssued,
+				errorCode: "unsupported_grant_type",
+			},
+		}
+
+		for _, tt := range tests {
+			_, errorResp := oauthService.HandleTokenRequest(tt.req)
+			require.NotNil(t, errorResp)
+
+			events := sink.take()
+			require.Len(t, events, 1)
+			assert.Equal(t, tt.eventType, events[0].Type)
+			assert.Equal(t, services.AuditOutcomeFailure, events[0].Outcome)
+			assert.Equal(t, tt.errorCode, events[0].Error)
+			assert.Equal(t, "test-client", events[0].ClientID)
+			assert.Equal(t, tt.req.GrantType, events[0].GrantType)
+			assert.Empty(t, events[0].TokenID)
+		}
+	})
+}
+
+func TestSlogAuditSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := services.NewSlogAuditSink(slog.New(slog.NewJSONHandler(&buf, nil)))
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService, services.WithAuditSink(sink))
+	defer oauthService.Stop()
+	grantConsent(t, oauthService, "test-client", "openid")
+
+	tokens := issueTokens(t, oauthService, "openid")
+	claims, err := jwtService.ValidateAccessToken(tokens.AccessToken)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &record))
+	assert.Equal(t, "audit", record["msg"])
+	assert.Equal(t, "INFO", record["level"])
+	assert.Equal(t, "token.issued", record["event"])
+	assert.Equal(t, "success", record["outcome"])
+	assert.Equal(t, "test-client", record["client_id"])
+	assert.Equal(t, "demo-user", record["user_id"])
+	assert.Equal(t, claims.JWTID, record["token_id"])
+	assert.Equal(t, "authorization_code", record["grant_type"])
+	assert.NotContains(t, record, "tenant_id")
+	assert.NotContains(t, record, "error")
+}
+
+func TestJSONAuditSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := services.NewJSONAuditSink(&buf)
+	oauthService := services.NewOAuthService(newTestConfig(), nil, services.WithAuditSink(sink))
+	defer oauthService.Stop()
+	grantConsent(t, oauthService, "test-client", "openid")
+
+	for i := 0; i < 2; i++ {
+		_, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+			UserID:              "demo-user",
+			ResponseType:        "code",
+			ClientID:            "test-client",
+			RedirectURI:         "http://localhost:3000/callback",
+			Scope:               "openid",
+			CodeChallenge:       testCodeChallenge,
+			CodeChallengeMethod: "S256",
+		})
+		require.Nil(t, errorResp)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	for _, line := range lines {
+		var event map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+		assert.Equal(t, "authorization_code.issued", event["event"])
+		assert.Equal(t, "test-client", event["client_id"])
+		assert.Equal(t, "demo-user", event["user_id"])
+		assert.Equal(t, "openid", event["scope"])
+		timestamp, err := time.Parse(time.RFC3339Nano, event["timestamp"].(string))
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), timestamp, time.Minute)
+		assert.NotContains(t, event, "token_id")
+	}
+}
+
+func TestAuditDiscardedByDefault(t *testing.T) {
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	oauthService := services.NewOAuthService(newTestConfig(), nil)
+	defer oauthService.Stop()
+	grantConsent(t, oauthService, "test-client", "openid")
+	_, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+		UserID:              "demo-user",
+		ResponseType:        "code",
+		ClientID:            "test-client",
+		RedirectURI:         "http://localhost:3000/callback",
+		Scope:               "openid",
+		CodeChallenge:       testCodeChallenge,
+		CodeChallengeMethod: "S256",
+	})
+	require.Nil(t, errorResp)
+
+	os.Stdout = stdout
+	require.NoError(t, w.Close())
+	written, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Empty(t, written)
+}
@@ -0,0 +1,151 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/handlers"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+	"auth-service/internal/store"
+)
+
+// loginRedirect sends unauthenticated users to a login page that returns to
+// the authorization request
+var loginRedirect = handlers.AuthenticatorFunc(func(w http.ResponseWriter, r *http.Request) (string, bool) {
+	http.Redirect(w, r, "/login?return_to="+url.QueryEscape(r.URL.String()), http.StatusFound)
+	return "", false
+})
+
+func authorizeQuery() url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"test-client"},
+		"redirect_uri":          {"http://localhost:3000/callback"},
+		"scope":                 {"openid"},
+		"state":                 {"xyz"},
+		"code_challenge":        {testCodeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+func authorize(handler *handlers.OAuthHandler, query url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/authorize?"+query.Encode(), nil)
+	rec := httptest.NewRecorder()
+	handler.HandleAuthorize(rec, req)
+	return rec
+}
+
+func TestAuthorizeAuthenticatesUser(t *testing.T) {
+	t.Run("Code is issued to the authenticated user", func(t *testing.T) {
+		fake := newFakeVault(t)
+		cfg := newTestConfig()
+		jwtService := services.NewJWTService(fake.newClient(), cfg)
+		oauthService := services.NewOAuthService(cfg, jwtService)
+		require.NoError(t, oauthService.GrantConsent("alice", "test-client", "openid"))
+		handler := handlers.NewOAuthHandler(oauthService, jwtService,
+			handlers.WithAuthenticator(handlers.AuthenticatorFunc(func(w http.ResponseWriter, r *http.Request) (string, bool) {
+				return "alice", true
+			})))
+
+		rec := authorize(handler, authorizeQuery())
+		require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+		location, err := url.Parse(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		code := location.Query().Get("code")
+		require.NotEmpty(t, code)
+
+		tokenResp, errorResp := oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:    "authorization_code",
+			Code:         code,
+			RedirectURI:  "http://localhost:3000/callback",
+			ClientID:     "test-client",
+			CodeVerifier: testCodeVerifier,
+		})
+		require.Nil(t, errorResp)
+
+		claims, err := jwtService.ValidateAccessToken(tokenResp.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, "alice", claims.Subject)
+	})
+
+	t.Run("Unauthenticated user is sent to login", func(t *testing.T) {
+		tokenStore := store.NewMemoryStore()
+		oauthService := services.NewOAuthService(newTestConfig(), nil, services.WithTokenStore(tokenStore))
+		handler := handlers.NewOAuthHandler(oauthService, nil, handlers.WithAuthenticator(loginRedirect))
+
+		rec := authorize(handler, authorizeQuery())
+		require.Equal(t, http.StatusFound, rec.Code)
+		location, err := url.Parse(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, "/login", location.Path)
+		assert.Contains(t, location.Query().Get("return_to"), "/authorize?")
+		assert.Empty(t, location.Query().Get("code"))
+
+		authCodes, _, err := tokenStore.Counts()
+		require.NoError(t, err)
+		assert.Equal(t, 0, authCodes, "no code should be stored before login")
+	})
+
+	t.Run("Invalid request is rejected before login", func(t *testing.T) {
+		handler := handlers.NewOAuthHandler(services.NewOAuthService(newTestConfig(), nil), nil, handlers.WithAuthenticator(loginRedirect))
+
+		query := authorizeQuery()
+		query.Set("scope", "admin")
+		rec := authorize(handler, query)
+		require.Equal(t, http.StatusFound, rec.Code)
+		location, err := url.Parse(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, "localhost:3000", location.Host)
+		assert.Equal(t, "invalid_scope", location.Query().Get("error"))
+	})
+
+	t.Run("Pushed request survives the login", func(t *testing.T) {
+		loggedIn := false
+		oauthService := services.NewOAuthService(newTestConfig(), nil)
+		require.NoError(t, oauthService.GrantConsent("alice", "test-client", "openid profile"))
+		handler := handlers.NewOAuthHandler(oauthService, nil,
+			handlers.WithAuthenticator(handlers.AuthenticatorFunc(func(w http.ResponseWriter, r *http.Request) (string, bool) {
+				if !loggedIn {
+					return loginRedirect(w, r)
+				}
+				return "alice", true
+			})))
+
+		rec := pushRequest(handler, parForm())
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		var pushed models.PushedAuthorizationResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pushed))
+
+		rec = authorizeWithRequestURI(handler, "test-client", pushed.RequestURI)
+		require.Equal(t, http.StatusFound, rec.Code)
+		assert.Contains(t, rec.Header().Get("Location"), "/login")
+
+		loggedIn = true
+		rec = authorizeWithRequestURI(handler, "test-client", pushed.RequestURI)
+		require.Equal(t, http.StatusFound, rec.Code)
+		location, err := url.Parse(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.NotEmpty(t, location.Query().Get("code"))
+	})
+
+	t.Run("Service requires a user", func(t *testing.T) {
+		oauthService := services.NewOAuthService(newTestConfig(), nil)
+		_, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+			ResponseType:        "code",
+			ClientID:            "test-client",
+			RedirectURI:         "http://localhost:3000/callback",
+			Scope:               "openid",
+			CodeChallenge:       testCodeChallenge,
+			CodeChallengeMethod: "S256",
+		})
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "login_required", errorResp.Error)
+	})
+}
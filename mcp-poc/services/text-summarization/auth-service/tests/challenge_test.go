@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/handlers"
+	"auth-service/internal/middleware"
+	"auth-service/internal/services"
+)
+
+var challengeParam = regexp.MustCompile(`^([a-z_]+)="([^"]*)"$`)
+
+// parseBearerChallenge parses a WWW-Authenticate header holding a single
+// Bearer challenge, failing the test if it is malformed
+func parseBearerChallenge(t *testing.T, header string) map[string]string {
+	t.Helper()
+
+	require.True(t, strings.HasPrefix(header, "Bearer "), "challenge %q does not use the Bearer scheme", header)
+
+	params := make(map[string]string)
+	for _, param := range strings.Split(strings.TrimPrefix(header, "Bearer "), ", ") {
+		match := challengeParam.FindStringSubmatch(param)
+		require.NotNil(t, match, "malformed auth-param %q in %q", param, header)
+		params[match[1]] = match[2]
+	}
+	return params
+}
+
+func TestBearerChallenges(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+	oauthHandler := handlers.NewOAuthHandler(oauthService, jwtService)
+
+	expiredCfg := newTestConfig()
+	expiredCfg.JWT.TokenExpiration = -time.Minute
+	expiredToken, err := services.NewJWTService(fake.newClient(), expiredCfg).GenerateAccessToken("demo-user", "test-client", "openid")
+	require.NoError(t, err)
+
+	profileToken, err := jwtService.GenerateAccessToken("demo-user", "test-client", "profile")
+	require.NoError(t, err)
+
+	endpoints := map[string]http.Handler{
+		"RequireScope": middleware.RequireScope(jwtService, "openid")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})),
+		"IntrospectAuthMiddleware": middleware.IntrospectAuthMiddleware(jwtService, "openid")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})),
+		"UserInfo": http.HandlerFunc(oauthHandler.HandleUserInfo),
+	}
+
+	tests := []struct {
+		name        string
+		authHeader  string
+		status      int
+		errorCode   string
+		description string
+		scope       string
+	}{
+		{name: "Missing", status: http.StatusUnauthorized},
+		{name: "Malformed", authHeader: "Token abc", status: http.StatusUnauthorized, errorCode: "invalid_request"},
+		{name: "Invalid", authHeader: "Bearer not.a.token", status: http.StatusUnauthorized, errorCode: "invalid_token", description: "invalid"},
+		{name: "Expired", authHeader: "Bearer " + expiredToken, status: http.StatusUnauthorized, errorCode: "invalid_token", description: "expired"},
+		{name: "Insufficient scope", authHeader: "Bearer " + profileToken, status: http.StatusForbidden, errorCode: "insufficient_scope", scope: "openid"},
+	}
+
+	for endpoint, handler := range endpoints {
+		for _, tt := range tests {
+			t.Run(endpoint+"/"+tt.name, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+				if tt.authHeader != "" {
+					req.Header.Set("Authorization", tt.authHeader)
+				}
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+
+				require.Equal(t, tt.status, rec.Code)
+				params := parseBearerChallenge(t, rec.Header().Get("WWW-Authenticate"))
+				assert.Equal(t, "auth-service", params["realm"])
+
+				if tt.errorCode == "" {
+					// RFC 6750 section 3.1: no error code without credentials
+					assert.NotContains(t, params, "error")
+				} else {
+					assert.Equal(t, tt.errorCode, params["error"])
+				}
+				if tt.description != "" {
+					assert.Contains(t, params["error_description"], tt.description)
+				}
+				assert.Equal(t, tt.scope, params["scope"])
+			})
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/services"
+)
+
+func TestClaimsProvider(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+
+	var calledWith []string
+	provider := services.ClaimsProviderFunc(func(userID, clientID, scope string) (map[string]interface{}, error) {
+		calledWith = []string{userID, clientID, scope}
+		return map[string]interface{}{
+			"roles": []string{"admin", "editor"},
+			"iss":   "https://attacker.example.com",
+			"exp":   time.Now().Add(24 * time.Hour).Unix(),
+			"sub":   "someone-else",
+		}, nil
+	})
+	jwtService := services.NewJWTService(fake.newClient(), cfg, services.WithClaimsProvider(provider))
+
+	t.Run("Custom claims round-trip", func(t *testing.T) {
+		token, err := jwtService.GenerateAccessTokenWithTenant("demo-user", "test-client", "openid profile", "tenant-a")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"demo-user", "test-client", "openid profile"}, calledWith)
+
+		claims, err := jwtService.ValidateAccessToken(token)
+		require.NoError(t, err)
+		assert.Equal(t, []interface{}{"admin", "editor"}, claims.Extra["roles"])
+		assert.Equal(t, "tenant-a", claims.TenantID)
+	})
+
+	t.Run("Registered claims stay authoritative", func(t *testing.T) {
+		token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+		require.NoError(t, err)
+
+		claims, err := jwtService.ValidateAccessToken(token)
+		require.NoError(t, err)
+		assert.Equal(t, cfg.JWT.Issuer, claims.Issuer)
+		assert.Equal(t, "demo-user", claims.Subject)
+		assert.LessOrEqual(t, claims.ExpiresAt, time.Now().Add(cfg.JWT.TokenExpiration).Unix())
+		assert.NotContains(t, claims.Extra, "iss")
+		assert.NotContains(t, claims.Extra, "exp")
+	})
+
+	t.Run("Issued through the token endpoint", func(t *testing.T) {
+		oauthService := services.NewOAuthService(cfg, jwtService)
+		defer oauthService.Stop()
+
+		tokens := issueTokens(t, oauthService, "openid")
+		claims, err := jwtService.ValidateAccessToken(tokens.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, []interface{}{"admin", "editor"}, claims.Extra["roles"])
+	})
+
+	t.Run("Provider failure", func(t *testing.T) {
+		failing := services.ClaimsProviderFunc(func(userID, clientID, scope string) (map[string]interface{}, error) {
+			return nil, errors.New("directory unavailable")
+		})
+		jwtService := services.NewJWTService(fake.newClient(), cfg, services.WithClaimsProvider(failing))
+
+		_, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+		assert.ErrorContains(t, err, "directory unavailable")
+	})
+}
+
+func TestNoExtraClaimsWithoutProvider(t *testing.T) {
+	fake := newFakeVault(t)
+	jwtService := services.NewJWTService(fake.newClient(), newTestConfig())
+
+	token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+	require.NoError(t, err)
+
+	claims, err := jwtService.ValidateAccessToken(token)
+	require.NoError(t, err)
+	assert.Nil(t, claims.Extra)
+}
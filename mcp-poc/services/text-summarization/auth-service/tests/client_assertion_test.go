@@ -0,0 +1,260 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/config"
+	"auth-service/internal/handlers"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+)
+
+// signAssertion signs claims as a client assertion with key
+func signAssertion(t *testing.T, key *ecdsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, nil)
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	jws, err := signer.Sign(payload)
+	require.NoError(t, err)
+	assertion, err := jws.CompactSerialize()
+	require.NoError(t, err)
+	return assertion
+}
+
+func TestPrivateKeyJWTClientAuthentication(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	jwk, err := (&jose.JSONWebKey{Key: &key.PublicKey, KeyID: "jwt-client-1"}).MarshalJSON()
+	require.NoError(t, err)
+
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	cfg.JWT.Issuer = "https://auth.example.com"
+	cfg.OAuth.Clients = []config.ClientConfig{
+		{ClientID: "jwt-client", RedirectURIs: []string{"https://app.example.com/callback"}, JWK: jwk},
+		{ClientID: "test-client", RedirectURIs: []string{"http://localhost:3000/callback"}},
+	}
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+	defer oauthService.Stop()
+	grantConsent(t, oauthService, "jwt-client", "openid")
+	handler := handlers.NewOAuthHandler(oauthService, jwtService)
+
+	claims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss": "jwt-client",
+			"sub": "jwt-client",
+			"aud": "https://auth.example.com/token",
+			"exp": time.Now().Add(time.Minute).Unix(),
+			"jti": uuid.New().String(),
+		}
+	}
+
+	codeRequest := func(assertion string) *models.TokenRequest {
+		authCode, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+			UserID:              "demo-user",
+			ResponseType:        "code",
+			ClientID:            "jwt-client",
+			RedirectURI:         "https://app.example.com/callback",
+			Scope:               "openid",
+			CodeChallenge:       testCodeChallenge,
+			CodeChallengeMethod: "S256",
+		})
+		require.Nil(t, errorResp)
+		return &models.TokenRequest{
+			GrantType:           "authorization_code",
+			Code:                authCode.Code,
+			RedirectURI:         authCode.RedirectURI,
+			CodeVerifier:        testCodeVerifier,
+			ClientAssertionType: services.ClientAssertionTypeJWTBearer,
+			ClientAssertion:     assertion,
+		}
+	}
+
+	t.Run("Valid assertion", func(t *testing.T) {
+		req := codeRequest(signAssertion(t, key, claims()))
+		tokenResp, errorResp := oauthService.HandleTokenRequest(req)
+		require.Nil(t, errorResp)
+		assert.Equal(t, "jwt-client", req.ClientID)
+
+		accessClaims, err := jwtService.ValidateAccessToken(tokenResp.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, "jwt-client", accessClaims.ClientID)
+	})
+
+	t.Run("Valid assertion at the token endpoint", func(t *testing.T) {
+		req := codeRequest(signAssertion(t, key, claims()))
+		form := url.Values{
+			"grant_type":            {req.GrantType},
+			"code":                  {req.Code},
+			"redirect_uri":          {req.RedirectURI},
+			"code_verifier":         {req.CodeVerifier},
+			"client_assertion_type": {req.ClientAssertionType},
+			"client_assertion":      {req.ClientAssertion},
+		}
+		httpReq := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+		httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.HandleToken(rec, httpReq)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	})
+
+	t.Run("Expired assertion", func(t *testing.T) {
+		expired := claims()
+		expired["exp"] = time.Now().Add(-2 * time.Minute).Unix()
+
+		_, errorResp := oauthService.HandleTokenRequest(codeRequest(signAssertion(t, key, expired)))
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_client", errorResp.Error)
+		assert.Contains(t, errorResp.ErrorDescription, "expired")
+	})
+
+	t.Run("Replayed jti", func(t *testing.T) {
+		assertion := signAssertion(t, key, claims())
+		_, errorResp := oauthService.HandleTokenRequest(codeRequest(assertion))
+		require.Nil(t, errorResp)
+
+		_, errorResp = oauthService.HandleTokenRequest(codeRequest(assertion))
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_client", errorResp.Error)
+		assert.Contains(t, errorResp.ErrorDescription, "already been used")
+	})
+
+	t.Run("Rejected assertions", func(t *testing.T) {
+		otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+
+		tests := map[string]string{
+			"Signed with another key": signAssertion(t, otherKey, claims()),
+			"Wrong audience": signAssertion(t, key, func() map[string]interface{} {
+				c := claims()
+				c["aud"] = "https://auth.example.com"
+				return c
+			}()),
+			"Issuer is not the client": signAssertion(t, key, func() map[string]interface{} {
+				c := claims()
+				c["iss"] = "someone-else"
+				return c
+			}()),
+			"Long-lived": signAssertion(t, key, func() map[string]interface{} {
+				c := claims()
+				c["exp"] = time.Now().Add(time.Hour).Unix()
+				return c
+			}()),
+			"Without jti": signAssertion(t, key, func() map[string]interface{} {
+				c := claims()
+				delete(c, "jti")
+				return c
+			}()),
+			"Not a JWT": "not-a-jwt",
+		}
+		for name, assertion := range tests {
+			t.Run(name, func(t *testing.T) {
+				_, errorResp := oauthService.HandleTokenRequest(codeRequest(assertion))
+				require.NotNil(t, errorResp)
+				assert.Equal(t, "invalid_client", errorResp.Error)
+			})
+		}
+	})
+
+	t.Run("client_id must match the assertion", func(t *testing.T) {
+		req := codeRequest(signAssertion(t, key, claims()))
+		req.ClientID = "test-client"
+		_, errorResp := oauthService.HandleTokenRequest(req)
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_client", errorResp.Error)
+	})
+
+	t.Run("Client with a JWK can't skip the assertion", func(t *testing.T) {
+		req := codeRequest("")
+		req.ClientAssertionType = ""
+		req.ClientID = "jwt-client"
+		_, errorResp := oauthService.HandleTokenRequest(req)
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_client", errorResp.Error)
+	})
+
+	t.Run("Client without a JWK", func(t *testing.T) {
+		testClaims := claims()
+		testClaims["iss"], testClaims["sub"] = "test-client", "test-client"
+		_, errorResp := oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:           "refresh_token",
+			RefreshToken:        "unknown",
+			ClientAssertionType: services.ClientAssertionTypeJWTBearer,
+			ClientAssertion:     signAssertion(t, key, testClaims),
+		})
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_client", errorResp.Error)
+	})
+
+	t.Run("Pushed authorization request", func(t *testing.T) {
+		parForm := func(assertion string) url.Values {
+			form := url.Values{
+				"response_type":         {"code"},
+				"redirect_uri":          {"https://app.example.com/callback"},
+				"scope":                 {"openid"},
+				"state":                 {"xyz"},
+				"code_challenge":        {testCodeChallenge},
+				"code_challenge_method": {"S256"},
+			}
+			if assertion != "" {
+				form.Set("client_assertion_type", services.ClientAssertionTypeJWTBearer)
+				form.Set("client_assertion", assertion)
+			}
+			return form
+		}
+
+		// The issuer, token endpoint and PAR endpoint are all accepted as
+		// the audience
+		for _, audience := range []string{"https://auth.example.com/par", "https://auth.example.com/token", "https://auth.example.com"} {
+			assertionClaims := claims()
+			assertionClaims["aud"] = audience
+			rec := pushRequest(handler, parForm(signAssertion(t, key, assertionClaims)))
+			require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+			var pushed models.PushedAuthorizationResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pushed))
+
+			rec = authorizeWithRequestURI(handler, "jwt-client", pushed.RequestURI)
+			require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+			location, err := url.Parse(rec.Header().Get("Location"))
+			require.NoError(t, err)
+			assert.NotEmpty(t, location.Query().Get("code"), audience)
+		}
+
+		// A JWK client can't push with just its client_id
+		form := parForm("")
+		form.Set("client_id", "jwt-client")
+		rec := pushRequest(handler, form)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, rec.Body.String())
+
+		// Nor with an assertion for another server
+		assertionClaims := claims()
+		assertionClaims["aud"] = "https://other.example.com/par"
+		rec = pushRequest(handler, parForm(signAssertion(t, key, assertionClaims)))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, rec.Body.String())
+	})
+
+	t.Run("Advertised in discovery", func(t *testing.T) {
+		doc := oauthService.GetDiscoveryDocument()
+		assert.Equal(t, "https://auth.example.com/token", doc.TokenEndpoint)
+		assert.Contains(t, doc.TokenEndpointAuthMethodsSupported, "private_key_jwt")
+		assert.Contains(t, doc.TokenEndpointAuthSigningAlgValuesSupported, "ES256")
+	})
+}
@@ -0,0 +1,253 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/config"
+	"auth-service/internal/handlers"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+)
+
+func TestConfidentialClientAuthentication(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	cfg.OAuth.Clients = []config.ClientConfig{
+		{
+			ClientID:     "backend",
+			ClientSecret: "s3cret",
+			RedirectURIs: []string{"https://backend.example.com/callback"},
+		},
+		{
+			ClientID:     "test-client",
+			RedirectURIs: []string{"http://localhost:3000/callback"},
+		},
+	}
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+	grantConsent(t, oauthService, "backend", "openid")
+	grantConsent(t, oauthService, "test-client", "openid")
+	handler := handlers.NewOAuthHandler(oauthService, jwtService)
+
+	authorize := func(clientID, redirectURI string) *models.AuthorizationCode {
+		authCode, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+			UserID:              "demo-user",
+			ResponseType:        "code",
+			ClientID:            clientID,
+			RedirectURI:         redirectURI,
+			Scope:               "openid",
+			CodeChallenge:       testCodeChallenge,
+			CodeChallengeMethod: "S256",
+		})
+		require.Nil(t, errorResp)
+		return authCode
+	}
+
+	token := func(form url.Values, username, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		rec := httptest.NewRecorder()
+		handler.HandleToken(rec, req)
+		return rec
+	}
+
+	codeForm := func(authCode *models.AuthorizationCode) url.Values {
+		return url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {authCode.Code},
+			"redirect_uri":  {authCode.RedirectURI},
+			"code_verifier": {testCodeVerifier},
+		}
+	}
+
+	t.Run("Basic auth success", func(t *testing.T) {
+		authCode := authorize("backend", "https://backend.example.com/callback")
+
+		rec := token(codeForm(authCode), "backend", "s3cret")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var tokenResp models.TokenResponse
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&tokenResp))
+		assert.NotEmpty(t, tokenResp.AccessToken)
+	})
+
+	t.Run("client_secret_post success", func(t *testing.T) {
+		authCode := authorize("backend", "https://backend.example.com/callback")
+
+		form := codeForm(authCode)
+		form.Set("client_id", "backend")
+		form.Set("client_secret", "s3cret")
+		rec := token(form, "", "")
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	})
+
+	t.Run("Wrong secret", func(t *testing.T) {
+		authCode := authorize("backend", "https://backend.example.com/callback")
+
+		rec := token(codeForm(authCode), "backend", "wrong")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, `Basic realm="auth-service"`, rec.Header().Get("WWW-Authenticate"))
+		assert.Contains(t, rec.Body.String(), "invalid_client")
+	})
+
+	t.Run("invalid_client versus invalid_grant", func(t *testing.T) {
+		authCode := authorize("backend", "https://backend.example.com/callback")
+		form := codeForm(authCode)
+		form.Set("code", "unknown-code")
+
+		// Both requests authenticate with the Authorization header, but only
+		// failed authentication is a 401 with a challenge for its scheme
+		rec := token(form, "backend", "wrong")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, `Basic realm="auth-service"`, rec.Header().Get("WWW-Authenticate"))
+		var errorResp models.ErrorResponse
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&errorResp))
+		assert.Equal(t, "invalid_client", errorResp.Error)
+
+		rec = token(form, "backend", "s3cret")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Empty(t, rec.Header().Get("WWW-Authenticate"))
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&errorResp))
+		assert.Equal(t, "invalid_grant", errorResp.Error)
+	})
+
+	t.Run("Missing secret", func(t *testing.T) {
+		authCode := authorize("backend", "https://backend.example.com/callback")
+
+		form := codeForm(authCode)
+		form.Set("client_id", "backend")
+		rec := token(form, "", "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, `Basic realm="auth-service"`, rec.Header().Get("WWW-Authenticate"))
+		assert.Contains(t, rec.Body.String(), "invalid_client")
+	})
+
+	t.Run("Conflicting client_id", func(t *testing.T) {
+		authCode := authorize("backend", "https://backend.example.com/callback")
+
+		form := codeForm(authCode)
+		form.Set("client_id", "test-client")
+		rec := token(form, "backend", "s3cret")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("Public client without secret", func(t *testing.T) {
+		authCode := authorize("test-client", "http://localhost:3000/callback")
+
+		form := codeForm(authCode)
+		form.Set("client_id", "test-client")
+		rec := token(form, "", "")
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	})
+}
+
+func TestTokenErrorStatusCodes(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.OAuth.Clients = []config.ClientConfig{
+		{
+			ClientID:     "backend",
+			ClientSecret: "s3cret",
+			RedirectURIs: []string{"https://backend.example.com/callback"},
+		},
+		{
+			ClientID:     "test-client",
+			RedirectURIs: []string{"http://localhost:3000/callback"},
+		},
+	}
+	// Without a JWT service, otherwise valid code exchanges fail server-side
+	oauthService := services.NewOAuthService(cfg, nil)
+	defer oauthService.Stop()
+	grantConsent(t, oauthService, "test-client", "openid")
+	handler := handlers.NewOAuthHandler(oauthService, nil)
+
+	validCode := func() string {
+		authCode, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+			UserID:              "demo-user",
+			ResponseType:        "code",
+			ClientID:            "test-client",
+			RedirectURI:         "http://localhost:3000/callback",
+			Scope:               "openid",
+			CodeChallenge:       testCodeChallenge,
+			CodeChallengeMethod: "S256",
+		})
+		require.Nil(t, errorResp)
+		return authCode.Code
+	}
+
+	tests := []struct {
+		name      string
+		form      url.Values
+		errorCode string
+		status    int
+	}{
+		{
+			name:      "invalid_request",
+			form:      url.Values{"client_id": {"test-client"}},
+			errorCode: "invalid_request",
+			status:    http.StatusBadRequest,
+		},
+		{
+			name:      "invalid_client",
+			form:      url.Values{"grant_type": {"refresh_token"}, "client_id": {"backend"}, "client_secret": {"wrong"}},
+			errorCode: "invalid_client",
+			status:    http.StatusUnauthorized,
+		},
+		{
+			name:      "invalid_grant",
+			form:      url.Values{"grant_type": {"refresh_token"}, "client_id": {"test-client"}, "refresh_token": {"unknown"}},
+			errorCode: "invalid_grant",
+			status:    http.StatusBadRequest,
+		},
+		{
+			name:      "unsupported_grant_type",
+			form:      url.Values{"grant_type": {"password"}, "client_id": {"test-client"}},
+			errorCode: "unsupported_grant_type",
+			status:    http.StatusBadRequest,
+		},
+		{
+			name: "server_error",
+			form: url.Values{
+				"grant_type":    {"authorization_code"},
+				"client_id":     {"test-client"},
+				"code":          {validCode()},
+				"redirect_uri":  {"http://localhost:3000/callback"},
+				"code_verifier": {testCodeVerifier},
+			},
+			errorCode: "server_error",
+			status:    http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			handler.HandleToken(rec, req)
+
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+			assert.Equal(t, "no-cache", rec.Header().Get("Pragma"))
+			if tt.status == http.StatusUnauthorized {
+				assert.Equal(t, `Basic realm="auth-service"`, rec.Header().Get("WWW-Authenticate"))
+			} else {
+				assert.Empty(t, rec.Header().Get("WWW-Authenticate"))
+			}
+
+			var errorResp models.ErrorResponse
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&errorResp))
+			assert.Equal(t, tt.errorCode, errorResp.Error)
+		})
+	}
+}
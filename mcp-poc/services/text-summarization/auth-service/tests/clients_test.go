@@ -0,0 +1,186 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/config"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+)
+
+func newMultiClientConfig() *config.Config {
+	cfg := newTestConfig()
+	cfg.OAuth.Clients = []config.ClientConfig{
+		{
+			ClientID:      "web-app",
+			RedirectURIs:  []string{"https://app.example.com/callback"},
+			AllowedScopes: []string{"openid", "profile"},
+		},
+		{
+			ClientID:      "cli-tool",
+			RedirectURIs:  []string{"http://127.0.0.1:8080/callback"},
+			AllowedScopes: []string{"openid", "email"},
+		},
+	}
+	return cfg
+}
+
+func TestMultipleClients(t *testing.T) {
+	oauthService := services.NewOAuthService(newMultiClientConfig(), nil)
+	grantConsent(t, oauthService, "web-app", "openid profile")
+	grantConsent(t, oauthService, "cli-tool", "openid email")
+
+	authorize := func(clientID, redirectURI, scope string) (*models.AuthorizationCode, *models.ErrorResponse) {
+		return oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+			UserID:              "demo-user",
+			ResponseType:        "code",
+			ClientID:            clientID,
+			RedirectURI:         redirectURI,
+			Scope:               scope,
+			CodeChallenge:       testCodeChallenge,
+			CodeChallengeMethod: "S256",
+		})
+	}
+
+	t.Run("Each client uses its own redirect URI and scopes", func(t *testing.T) {
+		authCode, errorResp := authorize("web-app", "https://app.example.com/callback", "openid profile")
+		require.Nil(t, errorResp)
+		assert.Equal(t, "web-app", authCode.ClientID)
+
+		authCode, errorResp = authorize("cli-tool", "http://127.0.0.1:8080/callback", "openid email")
+		require.Nil(t, errorResp)
+		assert.Equal(t, "cli-tool", authCode.ClientID)
+	})
+
+	t.Run("Redirect URI of another client is rejected", func(t *testing.T) {
+		_, errorResp := authorize("web-app", "http://127.0.0.1:8080/callback", "openid")
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_request", errorResp.Error)
+	})
+
+	t.Run("Scope allowed only for another client is dropped", func(t *testing.T) {
+		authCode, errorResp := authorize("web-app", "https://app.example.com/callback", "openid email")
+		require.Nil(t, errorResp)
+		assert.Equal(t, "openid", authCode.Scope)
+	})
+
+	t.Run("Fully disallowed scope is rejected", func(t *testing.T) {
+		_, errorResp := authorize("web-app", "https://app.example.com/callback", "email")
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_scope", errorResp.Error)
+
+		_, errorResp = authorize("web-app", "https://app.example.com/callback", "email unknown")
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_scope", errorResp.Error)
+	})
+
+	t.Run("Legacy client ID is not registered", func(t *testing.T) {
+		_, errorResp := authorize("test-client", "http://localhost:3000/callback", "openid")
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_client", errorResp.Error)
+	})
+
+	t.Run("Token request from unknown client", func(t *testing.T) {
+		_, errorResp := oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:   "authorization_code",
+			Code:        "some-code",
+			RedirectURI: "https://app.example.com/callback",
+			ClientID:    "unknown-client",
+		})
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_client", errorResp.Error)
+	})
+}
+
+func TestClientScopeAtIssuance(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newMultiClientConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+	defer oauthService.Stop()
+	grantConsent(t, oauthService, "web-app", "openid profile")
+
+	authCode, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+		UserID:              "demo-user",
+		ResponseType:        "code",
+		ClientID:            "web-app",
+		RedirectURI:         "https://app.example.com/callback",
+		Scope:               "openid profile email",
+		CodeChallenge:       testCodeChallenge,
+		CodeChallengeMethod: "S256",
+	})
+	require.Nil(t, errorResp)
+	require.Equal(t, "openid profile", authCode.Scope)
+
+	// The client loses "openid" between authorization and the token request
+	cfg.OAuth.Clients[0].AllowedScopes = []string{"profile"}
+
+	tokenResp, errorResp := oauthService.HandleTokenRequest(&models.TokenRequest{
+		GrantType:    "authorization_code",
+		Code:         authCode.Code,
+		RedirectURI:  authCode.RedirectURI,
+		ClientID:     "web-app",
+		CodeVerifier: testCodeVerifier,
+	})
+	require.Nil(t, errorResp)
+	assert.Equal(t, "profile", tokenResp.Scope)
+	assert.Empty(t, tokenResp.IDToken)
+
+	claims, err := jwtService.ValidateAccessToken(tokenResp.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "profile", claims.Scope)
+
+	t.Run("Refresh is narrowed to the allowed scopes", func(t *testing.T) {
+		cfg.OAuth.Clients[0].AllowedScopes = []string{"openid"}
+
+		_, errorResp := oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:    "refresh_token",
+			ClientID:     "web-app",
+			RefreshToken: tokenResp.RefreshToken,
+		})
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_scope", errorResp.Error)
+
+		cfg.OAuth.Clients[0].AllowedScopes = []string{"openid", "profile"}
+		refreshed, errorResp := oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:    "refresh_token",
+			ClientID:     "web-app",
+			RefreshToken: tokenResp.RefreshToken,
+		})
+		require.Nil(t, errorResp)
+		assert.Equal(t, "profile", refreshed.Scope)
+	})
+}
+
+func TestLoadClients(t *testing.T) {
+	t.Run("Client list from environment", func(t *testing.T) {
+		t.Setenv("OAUTH_CLIENTS", `[
+			{"client_id": "web-app", "redirect_uris": ["https://app.example.com/callback"], "allowed_scopes": ["openid"]},
+			{"client_id": "backend", "client_secret": "s3cret", "redirect_uris": ["https://backend.example.com/cb"]}
+		]`)
+
+		cfg := config.Load()
+		require.Len(t, cfg.OAuth.Clients, 2)
+
+		client, ok := cfg.OAuth.GetClient("backend")
+		require.True(t, ok)
+		assert.Equal(t, "s3cret", client.ClientSecret)
+		assert.Equal(t, []string{"https://backend.example.com/cb"}, client.RedirectURIs)
+	})
+
+	t.Run("Legacy variables synthesize a single client", func(t *testing.T) {
+		t.Setenv("OAUTH_CLIENT_ID", "legacy-client")
+		t.Setenv("OAUTH_REDIRECT_URI", "https://legacy.example.com/callback")
+
+		cfg := config.Load()
+		require.Len(t, cfg.OAuth.Clients, 1)
+
+		client, ok := cfg.OAuth.GetClient("legacy-client")
+		require.True(t, ok)
+		assert.Equal(t, []string{"https://legacy.example.com/callback"}, client.RedirectURIs)
+		assert.Equal(t, cfg.OAuth.SupportedScopes, client.AllowedScopes)
+	})
+}
@@ -0,0 +1,238 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/config"
+	"auth-service/internal/handlers"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+)
+
+func TestConsent(t *testing.T) {
+	authorize := func(oauthService *services.OAuthService, userID, scope string) (*models.AuthorizationCode, *models.ErrorResponse) {
+		return oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+			UserID:              userID,
+			ResponseType:        "code",
+			ClientID:            "test-client",
+			RedirectURI:         "http://localhost:3000/callback",
+			Scope:               scope,
+			State:               "xyz",
+			CodeChallenge:       testCodeChallenge,
+			CodeChallengeMethod: "S256",
+		})
+	}
+
+	t.Run("Pre-consented request succeeds", func(t *testing.T) {
+		oauthService := services.NewOAuthService(newTestConfig(), nil)
+		defer oauthService.Stop()
+		grantConsent(t, oauthService, "test-client", "openid profile")
+
+		authCode, errorResp := authorize(oauthService, "demo-user", "openid profile")
+		require.Nil(t, errorResp)
+		assert.Equal(t, "openid profile", authCode.Scope)
+
+		// A subset of what was approved needs no new consent
+		_, errorResp = authorize(oauthService, "demo-user", "profile")
+		assert.Nil(t, errorResp)
+	})
+
+	t.Run("New scope requires consent", func(t *testing.T) {
+		oauthService := services.NewOAuthService(newTestConfig(), nil)
+		defer oauthService.Stop()
+		grantConsent(t, oauthService, "test-client", "openid")
+
+		_, errorResp := authorize(oauthService, "demo-user", "openid email")
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "consent_required", errorResp.Error)
+		assert.Equal(t, "xyz", errorResp.State)
+
+		grantConsent(t, oauthService, "test-client", "email")
+		_, errorResp = authorize(oauthService, "demo-user", "openid email")
+		assert.Nil(t, errorResp)
+	})
+
+	t.Run("Consent is per user", func(t *testing.T) {
+		oauthService := services.NewOAuthService(newTestConfig(), nil)
+		defer oauthService.Stop()
+		grantConsent(t, oauthService, "test-client", "openid")
+
+		_, errorResp := authorize(oauthService, "other-user", "openid")
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "consent_required", errorResp.Error)
+	})
+
+	t.Run("Only grantable scopes can be approved", func(t *testing.T) {
+		cfg := newTestConfig()
+		cfg.OAuth.Clients = []config.ClientConfig{
+			{ClientID: "test-client", RedirectURIs: []string{"http://localhost:3000/callback"}, AllowedScopes: []string{"openid"}},
+		}
+		oauthService := services.NewOAuthService(cfg, nil)
+		defer oauthService.Stop()
+
+		assert.ErrorIs(t, oauthService.GrantConsent("demo-user", "test-client", "openid email"), services.ErrInvalidConsent)
+		assert.ErrorIs(t, oauthService.GrantConsent("demo-user", "test-client", "unknown"), services.ErrInvalidConsent)
+		assert.ErrorIs(t, oauthService.GrantConsent("demo-user", "unknown-client", "openid"), services.ErrInvalidConsent)
+		assert.ErrorIs(t, oauthService.GrantConsent("", "test-client", "openid"), services.ErrInvalidConsent)
+
+		// Nothing was recorded by the rejected approvals
+		_, errorResp := authorize(oauthService, "demo-user", "openid")
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "consent_required", errorResp.Error)
+	})
+}
+
+func TestConsentEndpoints(t *testing.T) {
+	postConsent := func(handler *handlers.OAuthHandler, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/consent", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.HandleConsent(rec, req)
+		return rec
+	}
+
+	t.Run("Missing consent is reported to the client", func(t *testing.T) {
+		handler := handlers.NewOAuthHandler(services.NewOAuthService(newTestConfig(), nil), nil)
+
+		rec := authorize(handler, authorizeQuery())
+		require.Equal(t, http.StatusFound, rec.Code)
+		location, err := url.Parse(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, "localhost:3000", location.Host)
+		assert.Equal(t, "consent_required", location.Query().Get("error"))
+		assert.Equal(t, "xyz", location.Query().Get("state"))
+	})
+
+	t.Run("Prompt then approve", func(t *testing.T) {
+		var prompted *models.AuthorizationRequest
+		handler := handlers.NewOAuthHandler(services.NewOAuthService(newTestConfig(), nil), nil,
+			handlers.WithConsentPrompter(handlers.ConsentPrompterFunc(func(w http.ResponseWriter, r *http.Request, req *models.AuthorizationRequest) {
+				prompted = req
+				w.WriteHeader(http.StatusOK)
+			})))
+
+		rec := authorize(handler, authorizeQuery())
+		assert.Equal(t, http.StatusOK, rec.Code)
+		require.NotNil(t, prompted)
+		assert.Equal(t, "test-client", prompted.ClientID)
+		assert.Equal(t, "openid", prompted.Scope)
+		assert.Equal(t, "demo-user", prompted.UserID)
+
+		require.NotEmpty(t, prompted.ConsentToken)
+		rec = postConsent(handler, url.Values{"client_id": {"test-client"}, "scope": {prompted.Scope}, "csrf_token": {prompted.ConsentToken}})
+		require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+
+		rec = authorize(handler, authorizeQuery())
+		require.Equal(t, http.StatusFound, rec.Code)
+		location, err := url.Parse(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.NotEmpty(t, location.Query().Get("code"))
+	})
+
+	t.Run("Approval without a csrf_token", func(t *testing.T) {
+		handler := handlers.NewOAuthHandler(services.NewOAuthService(newTestConfig(), nil), nil)
+
+		rec := postConsent(handler, url.Values{"client_id": {"test-client"}, "scope": {"openid"}})
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		var errorResp models.ErrorResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &errorResp))
+		assert.Equal(t, "access_denied", errorResp.Error)
+
+		rec = authorize(handler, authorizeQuery())
+		require.Equal(t, http.StatusFound, rec.Code)
+		assert.Contains(t, rec.Header().Get("Location"), "error=consent_required")
+
+		req := httptest.NewRequest(http.MethodGet, "/consent", nil)
+		rec = httptest.NewRecorder()
+		handler.HandleConsent(rec, req)
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	})
+
+	t.Run("Forged approvals", func(t *testing.T) {
+		var token string
+		userID := "demo-user"
+		handler := handlers.NewOAuthHandler(services.NewOAuthService(newTestConfig(), nil), nil,
+			handlers.WithAuthenticator(handlers.AuthenticatorFunc(func(w http.ResponseWriter, r *http.Request) (string, bool) {
+				return userID, true
+			})),
+			handlers.WithConsentPrompter(handlers.ConsentPrompterFunc(func(w http.ResponseWriter, r *http.Request, req *models.AuthorizationRequest) {
+				token = req.ConsentToken
+				w.WriteHeader(http.StatusOK)
+			})))
+		require.Equal(t, http.StatusOK, authorize(handler, authorizeQuery()).Code)
+		require.NotEmpty(t, token)
+
+		form := func(scope string) url.Values {
+			return url.Values{"client_id": {"test-client"}, "scope": {scope}, "csrf_token": {token}}
+		}
+
+		// The token only approves the scope that was shown
+		assert.Equal(t, http.StatusForbidden, postConsent(handler, form("openid profile")).Code)
+
+		// Nor for another user
+		userID = "other-user"
+		assert.Equal(t, http.StatusForbidden, postConsent(handler, form("openid")).Code)
+		userID = "demo-user"
+
+		// A token signed with another key is refused
+		other := handlers.NewOAuthHandler(services.NewOAuthService(newTestConfig(), nil), nil,
+			handlers.WithConsentKey([]byte("another-key")))
+		assert.Equal(t, http.StatusForbidden, postConsent(other, form("openid")).Code)
+
+		// Cross-origin posts are refused even with the right token
+		req := httptest.NewRequest(http.MethodPost, "/consent", strings.NewReader(form("openid").Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", "https://attacker.example.com")
+		rec := httptest.NewRecorder()
+		handler.HandleConsent(rec, req)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		assert.Equal(t, http.StatusNoContent, postConsent(handler, form("openid")).Code)
+	})
+
+	t.Run("Unauthenticated approval starts a login", func(t *testing.T) {
+		handler := handlers.NewOAuthHandler(services.NewOAuthService(newTestConfig(), nil), nil, handlers.WithAuthenticator(loginRedirect))
+
+		rec := postConsent(handler, url.Values{"client_id": {"test-client"}, "scope": {"openid"}})
+		assert.Equal(t, http.StatusFound, rec.Code)
+		assert.Contains(t, rec.Header().Get("Location"), "/login")
+	})
+
+	t.Run("Pushed request survives the consent prompt", func(t *testing.T) {
+		var token string
+		handler := handlers.NewOAuthHandler(services.NewOAuthService(newTestConfig(), nil), nil,
+			handlers.WithConsentPrompter(handlers.ConsentPrompterFunc(func(w http.ResponseWriter, r *http.Request, req *models.AuthorizationRequest) {
+				token = req.ConsentToken
+				w.WriteHeader(http.StatusOK)
+			})))
+
+		rec := pushRequest(handler, parForm())
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		var pushed models.PushedAuthorizationResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pushed))
+
+		rec = authorizeWithRequestURI(handler, "test-client", pushed.RequestURI)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		rec = postConsent(handler, url.Values{"client_id": {"test-client"}, "scope": {"openid profile"}, "csrf_token": {token}})
+		require.Equal(t, http.StatusNoContent, rec.Code)
+
+		rec = authorizeWithRequestURI(handler, "test-client", pushed.RequestURI)
+		require.Equal(t, http.StatusFound, rec.Code)
+		location, err := url.Parse(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.NotEmpty(t, location.Query().Get("code"))
+
+		// The request_uri is used up once a code is issued
+		rec = authorizeWithRequestURI(handler, "test-client", pushed.RequestURI)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"auth-service/internal/config"
+	"auth-service/internal/middleware"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	okHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	request := func(handler http.Handler, method, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/token", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Allowed origin is echoed back", func(t *testing.T) {
+		handler := middleware.CORSMiddleware(config.CORSConfig{
+			AllowedOrigins:   []string{"https://app.example.com", "https://admin.example.com"},
+			AllowCredentials: true,
+		})(okHandler)
+
+		rec := request(handler, http.MethodGet, "https://app.example.com")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Equal(t, "Origin", rec.Header().Get("Vary"))
+	})
+
+	t.Run("Credentials are omitted unless configured", func(t *testing.T) {
+		handler := middleware.CORSMiddleware(config.CORSConfig{
+			AllowedOrigins: []string{"https://app.example.com"},
+		})(okHandler)
+
+		rec := request(handler, http.MethodGet, "https://app.example.com")
+		assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, rec.Header().Get("Access-Control-Allow-Credentials"))
+	})
+
+	t.Run("Disallowed origin gets no ACAO header", func(t *testing.T) {
+		handler := middleware.CORSMiddleware(config.CORSConfig{
+			AllowedOrigins:   []string{"https://app.example.com"},
+			AllowCredentials: true,
+		})(okHandler)
+
+		rec := request(handler, http.MethodGet, "https://evil.example.com")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, rec.Header().Get("Access-Control-Allow-Credentials"))
+	})
+
+	t.Run("Empty list falls back to wildcard", func(t *testing.T) {
+		handler := middleware.CORSMiddleware(config.CORSConfig{AllowCredentials: true})(okHandler)
+
+		rec := request(handler, http.MethodGet, "https://anywhere.example.com")
+		assert.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+		// Browsers reject credentials with a wildcard origin
+		assert.Empty(t, rec.Header().Get("Access-Control-Allow-Credentials"))
+	})
+
+	t.Run("Preflight short-circuits", func(t *testing.T) {
+		called := false
+		handler := middleware.CORSMiddleware(config.CORSConfig{
+			AllowedOrigins: []string{"https://app.example.com"},
+		})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		}))
+
+		rec := request(handler, http.MethodOptions, "https://app.example.com")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.False(t, called)
+		assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "GET, POST, OPTIONS", rec.Header().Get("Access-Control-Allow-Methods"))
+	})
+
+	t.Run("Configured methods and headers", func(t *testing.T) {
+		handler := middleware.CORSMiddleware(config.CORSConfig{
+			AllowedOrigins: []string{"https://app.example.com"},
+			AllowedMethods: []string{"POST", "OPTIONS"},
+			AllowedHeaders: []string{"Content-Type", "Authorization", "X-Request-ID"},
+		})(okHandler)
+
+		rec := request(handler, http.MethodOptions, "https://app.example.com")
+		assert.Equal(t, "POST, OPTIONS", rec.Header().Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "Content-Type, Authorization, X-Request-ID", rec.Header().Get("Access-Control-Allow-Headers"))
+	})
+}
+
+func TestLoadCORSConfig(t *testing.T) {
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example.com, https://admin.example.com")
+	t.Setenv("CORS_ALLOWED_METHODS", "GET,POST")
+	t.Setenv("CORS_ALLOWED_HEADERS", "Authorization")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+
+	cfg := config.Load()
+	assert.Equal(t, config.CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com", "https://admin.example.com"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Authorization"},
+		AllowCredentials: true,
+	}, cfg.CORS)
+}
@@ -0,0 +1,150 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/config"
+	"auth-service/internal/handlers"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+)
+
+func TestHandleDiscovery(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.JWT.Issuer = "https://auth.example.com"
+	oauthService := services.NewOAuthService(cfg, nil)
+	handler := handlers.NewOAuthHandler(oauthService, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil)
+	rec := httptest.NewRecorder()
+	handler.HandleDiscovery(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Header().Get("Cache-Control"), "max-age")
+
+	var doc models.DiscoveryDocument
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+
+	assert.Equal(t, "https://auth.example.com", doc.Issuer)
+	assert.Equal(t, cfg.OAuth.SupportedScopes, doc.ScopesSupported)
+	assert.Contains(t, doc.CodeChallengeMethodsSupported, "S256")
+
+	jwksURI, err := url.Parse(doc.JWKSURI)
+	require.NoError(t, err)
+	assert.True(t, jwksURI.IsAbs())
+	assert.Equal(t, "auth.example.com", jwksURI.Host)
+	assert.Equal(t, "/.well-known/jwks.json", jwksURI.Path)
+}
+
+func TestDiscoveryEndpointsAreRegistered(t *testing.T) {
+	cfg := newTestConfig()
+	oauthService := services.NewOAuthService(cfg, nil)
+	handler := handlers.NewOAuthHandler(oauthService, nil)
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	doc := oauthService.GetDiscoveryDocument()
+	endpoints := map[string]string{
+		"authorization_endpoint":                doc.AuthorizationEndpoint,
+		"token_endpoint":                        doc.TokenEndpoint,
+		"jwks_uri":                              doc.JWKSURI,
+		"introspection_endpoint":                doc.IntrospectionEndpoint,
+		"revocation_endpoint":                   doc.RevocationEndpoint,
+		"userinfo_endpoint":                     doc.UserInfoEndpoint,
+		"pushed_authorization_request_endpoint": doc.PushedAuthorizationEndpoint,
+	}
+
+	for name, endpoint := range endpoints {
+		t.Run(name, func(t *testing.T) {
+			require.NotEmpty(t, endpoint)
+			assert.True(t, strings.HasPrefix(endpoint, cfg.JWT.Issuer+"/"), "endpoint %s is not under the issuer", endpoint)
+
+			req := httptest.NewRequest(http.MethodGet, endpoint, nil)
+			var match mux.RouteMatch
+			assert.True(t, router.Match(req, &match), "endpoint %s is not registered", endpoint)
+		})
+	}
+
+	t.Run("Discovery document itself", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, cfg.JWT.Issuer+"/.well-known/openid-configuration", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		var served models.DiscoveryDocument
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &served))
+		assert.Equal(t, doc.RevocationEndpoint, served.RevocationEndpoint)
+	})
+}
+
+func TestIssuerPathPrefix(t *testing.T) {
+	t.Run("Derived issuer", func(t *testing.T) {
+		tests := []struct {
+			name string
+			jwt  config.JWTConfig
+			want string
+		}{
+			{"Issuer only", config.JWTConfig{Issuer: "https://auth.example.com/"}, "https://auth.example.com/"},
+			{"Public base URL", config.JWTConfig{Issuer: "https://auth-service", PublicBaseURL: "https://gateway.example.com"}, "https://gateway.example.com"},
+			{"Base URL and path", config.JWTConfig{PublicBaseURL: "https://gateway.example.com/", IssuerPath: "/auth/"}, "https://gateway.example.com/auth"},
+			{"Path under the issuer", config.JWTConfig{Issuer: "https://auth.example.com", IssuerPath: "tenant-a"}, "https://auth.example.com/tenant-a"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				assert.Equal(t, tt.want, tt.jwt.EffectiveIssuer())
+			})
+		}
+	})
+
+	t.Run("Discovery and tokens agree", func(t *testing.T) {
+		fake := newFakeVault(t)
+		cfg := newTestConfig()
+		cfg.JWT.PublicBaseURL = "https://gateway.example.com/"
+		cfg.JWT.IssuerPath = "/instances/auth-1"
+		jwtService := services.NewJWTService(fake.newClient(), cfg)
+		oauthService := services.NewOAuthService(cfg, jwtService)
+		defer oauthService.Stop()
+
+		const issuer = "https://gateway.example.com/instances/auth-1"
+		doc := oauthService.GetDiscoveryDocument()
+		assert.Equal(t, issuer, doc.Issuer)
+		assert.Equal(t, issuer+"/.well-known/jwks.json", doc.JWKSURI)
+		assert.Equal(t, issuer+"/token", doc.TokenEndpoint)
+		assert.Equal(t, issuer+"/authorize", doc.AuthorizationEndpoint)
+
+		tokenResp := issueTokens(t, oauthService, "openid")
+		assert.Equal(t, doc.Issuer, tokenClaims(t, tokenResp.AccessToken)["iss"])
+		assert.Equal(t, doc.Issuer, tokenClaims(t, tokenResp.IDToken)["iss"])
+
+		// Tokens with the derived issuer validate, and those naming the
+		// configured issuer don't
+		_, err := jwtService.ValidateAccessToken(tokenResp.AccessToken)
+		assert.NoError(t, err)
+
+		plain := newTestConfig()
+		plainService := services.NewJWTService(fake.newClient(), plain)
+		otherToken, err := plainService.GenerateAccessToken("demo-user", "test-client", "openid")
+		require.NoError(t, err)
+		_, err = jwtService.ValidateAccessToken(otherToken)
+		assert.ErrorContains(t, err, "invalid issuer")
+	})
+
+	t.Run("Loaded from the environment", func(t *testing.T) {
+		t.Setenv("JWT_PUBLIC_BASE_URL", "https://gateway.example.com")
+		t.Setenv("JWT_ISSUER_PATH", "auth")
+
+		cfg := config.Load()
+		assert.Equal(t, "https://gateway.example.com/auth", cfg.JWT.EffectiveIssuer())
+	})
+}
@@ -0,0 +1,60 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/models"
+)
+
+func TestErrorResponseConstructors(t *testing.T) {
+	tests := []struct {
+		errorResp *models.ErrorResponse
+		code      string
+		status    int
+	}{
+		{models.NewInvalidRequest("d"), "invalid_request", http.StatusBadRequest},
+		{models.NewInvalidClient("d"), "invalid_client", http.StatusUnauthorized},
+		{models.NewInvalidGrant("d"), "invalid_grant", http.StatusBadRequest},
+		{models.NewUnauthorizedClient("d"), "unauthorized_client", http.StatusBadRequest},
+		{models.NewUnsupportedGrantType("d"), "unsupported_grant_type", http.StatusBadRequest},
+		{models.NewUnsupportedResponseType("d"), "unsupported_response_type", http.StatusBadRequest},
+		{models.NewUnsupportedTokenType("d"), "unsupported_token_type", http.StatusBadRequest},
+		{models.NewInvalidScope("d"), "invalid_scope", http.StatusBadRequest},
+		{models.NewInvalidTarget("d"), "invalid_target", http.StatusBadRequest},
+		{models.NewInvalidRequestURI("d"), "invalid_request_uri", http.StatusBadRequest},
+		{models.NewAccessDenied("d"), "access_denied", http.StatusForbidden},
+		{models.NewLoginRequired("d"), "login_required", http.StatusBadRequest},
+		{models.NewConsentRequired("d"), "consent_required", http.StatusBadRequest},
+		{models.NewServerError("d"), "server_error", http.StatusInternalServerError},
+		{models.NewTemporarilyUnavailable("d"), "temporarily_unavailable", http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			assert.Equal(t, tt.code, tt.errorResp.Error)
+			assert.Equal(t, "d", tt.errorResp.ErrorDescription)
+			assert.Equal(t, tt.status, tt.errorResp.StatusCode())
+
+			// A literal with the same code gets the same status
+			assert.Equal(t, tt.status, (&models.ErrorResponse{Error: tt.code}).StatusCode())
+		})
+	}
+
+	t.Run("State and status in the response body", func(t *testing.T) {
+		body, err := json.Marshal(models.NewInvalidClient("Invalid client_id").WithState("xyz"))
+		require.NoError(t, err)
+
+		var fields map[string]interface{}
+		require.NoError(t, json.Unmarshal(body, &fields))
+		assert.Equal(t, map[string]interface{}{
+			"error":             "invalid_client",
+			"error_description": "Invalid client_id",
+			"state":             "xyz",
+		}, fields)
+	})
+}
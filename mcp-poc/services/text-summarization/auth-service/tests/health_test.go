@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/handlers"
+	"auth-service/internal/services"
+)
+
+func TestReadinessCheck(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+	defer oauthService.Stop()
+
+	router := mux.NewRouter()
+	handlers.NewOAuthHandler(oauthService, jwtService).RegisterRoutes(router)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	get := func(path string) (int, map[string]string) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+
+		var body map[string]string
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+		return rr.Code, body
+	}
+
+	t.Run("Vault available", func(t *testing.T) {
+		status, body := get("/ready")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "ready", body["status"])
+	})
+
+	t.Run("Vault sealed", func(t *testing.T) {
+		fake.setSealed(true)
+		defer fake.setSealed(false)
+
+		status, body := get("/ready")
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, "unavailable", body["status"])
+		assert.Equal(t, "unavailable", body["vault"])
+		assert.Contains(t, logs.String(), "sealed")
+	})
+
+	t.Run("Transit key deleted", func(t *testing.T) {
+		fake.mutex.Lock()
+		latest := fake.latest
+		fake.latest = 0
+		fake.mutex.Unlock()
+		defer func() {
+			fake.mutex.Lock()
+			fake.latest = latest
+			fake.mutex.Unlock()
+		}()
+
+		status, body := get("/ready")
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, "unavailable", body["vault"])
+		assert.Contains(t, logs.String(), "not found")
+	})
+
+	t.Run("Vault unreachable", func(t *testing.T) {
+		fake.setUnavailable(true)
+		defer fake.setUnavailable(false)
+
+		status, body := get("/ready")
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, "unavailable", body["status"])
+		assert.Equal(t, "unavailable", body["vault"])
+
+		// Liveness does not depend on Vault
+		status, body = get("/health")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "healthy", body["status"])
+	})
+}
+
+func TestReadinessCheckPermissionDenied(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	handler := handlers.NewOAuthHandler(nil, jwtService)
+
+	// Vault is up, but the token can no longer read the signing key
+	fake.deny("transit/keys/" + testTransitKey)
+
+	rr := httptest.NewRecorder()
+	handler.HandleReady(rr, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	var body map[string]string
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	assert.Equal(t, "unavailable", body["status"])
+	assert.Equal(t, "unavailable", body["vault"])
+}
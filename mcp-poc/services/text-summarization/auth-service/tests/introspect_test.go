@@ -0,0 +1,559 @@
+package tests
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/config"
+	"auth-service/internal/handlers"
+	"auth-service/internal/middleware"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+	"auth-service/internal/store"
+)
+
+func TestIntrospectAuthMiddleware(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+
+	handler := middleware.IntrospectAuthMiddleware(jwtService, "introspect")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	introspect := func(authHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/introspect", nil)
+		if authHeader != "" {
+			req.Header.Set("Authorization", authHeader)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Valid token with scope", func(t *testing.T) {
+		token, err := jwtService.GenerateAccessToken("summarizer", "internal-service", "introspect")
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, introspect("Bearer "+token).Code)
+	})
+
+	t.Run("Expired token", func(t *testing.T) {
+		expiredCfg := newTestConfig()
+		expiredCfg.JWT.TokenExpiration = -time.Minute
+		token, err := services.NewJWTService(fake.newClient(), expiredCfg).GenerateAccessToken("summarizer", "internal-service", "introspect")
+		require.NoError(t, err)
+
+		rec := introspect("Bearer " + token)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+	})
+
+	t.Run("Forged token", func(t *testing.T) {
+		rec := introspect("Bearer not.a.token")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+	})
+
+	t.Run("Token without the required scope", func(t *testing.T) {
+		token, err := jwtService.GenerateAccessToken("summarizer", "internal-service", "openid profile")
+		require.NoError(t, err)
+
+		rec := introspect("Bearer " + token)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Contains(t, rec.Header().Get("WWW-Authenticate"), `error="insufficient_scope"`)
+	})
+
+	t.Run("Missing or malformed header", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, introspect("").Code)
+		assert.Equal(t, http.StatusUnauthorized, introspect("Basic dXNlcjpwYXNz").Code)
+	})
+
+	t.Run("Verified client certificate bypasses bearer auth", func(t *testing.T) {
+		cert := &x509.Certificate{}
+		req := httptest.NewRequest(http.MethodPost, "/introspect", nil)
+		req.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{cert},
+			VerifiedChains:   [][]*x509.Certificate{{cert}},
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Unverified client certificate is not enough", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/introspect", nil)
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{}}}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("No scope required", func(t *testing.T) {
+		anyScope := middleware.IntrospectAuthMiddleware(jwtService, "")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		token, err := jwtService.GenerateAccessToken("summarizer", "internal-service", "openid")
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/introspect", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		anyScope.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+}
+
+func TestIntrospectRouteRequiresAuthentication(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	cfg.OAuth.IntrospectionScope = "introspect"
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+
+	router := mux.NewRouter()
+	handlers.NewOAuthHandler(oauthService, jwtService).RegisterRoutes(router)
+
+	subject, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+	require.NoError(t, err)
+
+	introspect := func(authHeader string) *httptest.ResponseRecorder {
+		form := url.Values{"token": {subject}}
+		req := httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if authHeader != "" {
+			req.Header.Set("Authorization", authHeader)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Valid bearer", func(t *testing.T) {
+		caller, err := jwtService.GenerateAccessToken("summarizer", "internal-service", "introspect")
+		require.NoError(t, err)
+
+		rec := introspect("Bearer " + caller)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var resp models.IntrospectionResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.True(t, resp.Active)
+		assert.Equal(t, "demo-user", resp.Sub)
+	})
+
+	t.Run("Invalid bearer", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, introspect("Bearer garbage").Code)
+	})
+
+	t.Run("Bearer without introspect scope", func(t *testing.T) {
+		// Any access token can't be used to probe other tokens
+		assert.Equal(t, http.StatusForbidden, introspect("Bearer "+subject).Code)
+	})
+
+	t.Run("No credentials", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, introspect("").Code)
+	})
+}
+
+func TestIntrospectionScopeDefault(t *testing.T) {
+	t.Setenv("OAUTH_INTROSPECTION_SCOPE", "")
+	assert.Equal(t, "", config.Load().OAuth.IntrospectionScope, "an empty value opts out")
+
+	os.Unsetenv("OAUTH_INTROSPECTION_SCOPE")
+	assert.Equal(t, "introspect", config.Load().OAuth.IntrospectionScope)
+}
+
+func TestIntrospectionScopeIsGrantable(t *testing.T) {
+	for _, key := range []string{"OAUTH_INTROSPECTION_SCOPE", "OAUTH_SUPPORTED_SCOPES", "OAUTH_CLIENTS"} {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+	t.Setenv("OAUTH_CLIENT_ID", "test-client")
+	t.Setenv("OAUTH_REDIRECT_URI", "http://localhost:3000/callback")
+
+	fake := newFakeVault(t)
+	cfg := config.Load()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+	defer oauthService.Stop()
+
+	router := mux.NewRouter()
+	handlers.NewOAuthHandler(oauthService, jwtService).RegisterRoutes(router)
+
+	// The default introspection scope is granted by a real authorization
+	// code flow and then accepted by the introspect endpoint
+	tokens := issueTokens(t, oauthService, "openid introspect")
+	require.Equal(t, "openid introspect", tokens.Scope)
+
+	subject, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+	require.NoError(t, err)
+
+	form := url.Values{"token": {subject}}
+	req := httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var resp models.IntrospectionResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.True(t, resp.Active)
+}
+
+func TestIntrospectTokenTenantID(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+
+	t.Run("Tenant token", func(t *testing.T) {
+		token, err := jwtService.GenerateAccessTokenWithTenant("demo-user", "test-client", "openid", "tenant-acme")
+		require.NoError(t, err)
+
+		resp, err := oauthService.IntrospectToken(token)
+		require.NoError(t, err)
+		assert.True(t, resp.Active)
+		assert.Equal(t, "tenant-acme", resp.TenantID)
+
+		body, err := json.Marshal(resp)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), `"tenant_id":"tenant-acme"`)
+	})
+
+	t.Run("Token without tenant", func(t *testing.T) {
+		token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+		require.NoError(t, err)
+
+		resp, err := oauthService.IntrospectToken(token)
+		require.NoError(t, err)
+
+		body, err := json.Marshal(resp)
+		require.NoError(t, err)
+		assert.NotContains(t, string(body), "tenant_id")
+	})
+}
+
+func TestIntrospectionCache(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	cfg.OAuth.IntrospectionCacheTTL = time.Minute
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+
+	t.Run("Repeated introspection skips validation", func(t *testing.T) {
+		token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+		require.NoError(t, err)
+
+		first, err := oauthService.IntrospectToken(token)
+		require.NoError(t, err)
+		require.True(t, first.Active)
+		verifications := fake.verifyRequests()
+
+		second, err := oauthService.IntrospectToken(token)
+		require.NoError(t, err)
+		assert.Equal(t, first, second)
+		assert.Equal(t, verifications, fake.verifyRequests())
+
+		// Callers get their own copy
+		second.Active = false
+		third, err := oauthService.IntrospectToken(token)
+		require.NoError(t, err)
+		assert.True(t, third.Active)
+	})
+
+	t.Run("Inactive tokens are not cached", func(t *testing.T) {
+		token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+		require.NoError(t, err)
+		// Another token's signature doesn't verify
+		other, err := jwtService.GenerateAccessToken("other-user", "test-client", "openid")
+		require.NoError(t, err)
+		forged := token[:strings.LastIndex(token, ".")] + other[strings.LastIndex(other, "."):]
+
+		verifications := fake.verifyRequests()
+		for i := 0; i < 2; i++ {
+			resp, err := oauthService.IntrospectToken(forged)
+			require.NoError(t, err)
+			assert.False(t, resp.Active)
+		}
+		assert.Equal(t, verifications+2, fake.verifyRequests())
+	})
+
+	t.Run("Revocation invalidates the entry", func(t *testing.T) {
+		token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+		require.NoError(t, err)
+		resp, err := oauthService.IntrospectToken(token)
+		require.NoError(t, err)
+		require.True(t, resp.Active)
+
+		require.NoError(t, oauthService.RevokeToken(token, "access_token"))
+
+		resp, err = oauthService.IntrospectToken(token)
+		require.NoError(t, err)
+		assert.False(t, resp.Active)
+	})
+
+	t.Run("Revocation by the JWT service invalidates the entry", func(t *testing.T) {
+		token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+		require.NoError(t, err)
+		resp, err := oauthService.IntrospectToken(token)
+		require.NoError(t, err)
+		require.True(t, resp.Active)
+
+		require.NoError(t, jwtService.RevokeAccessToken(token))
+
+		resp, err = oauthService.IntrospectToken(token)
+		require.NoError(t, err)
+		assert.False(t, resp.Active)
+	})
+
+	t.Run("Stopping another service on the same JWT service keeps the hook", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			services.NewOAuthService(cfg, jwtService).Stop()
+		}
+
+		token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+		require.NoError(t, err)
+		resp, err := oauthService.IntrospectToken(token)
+		require.NoError(t, err)
+		require.True(t, resp.Active)
+
+		require.NoError(t, jwtService.RevokeAccessToken(token))
+
+		resp, err = oauthService.IntrospectToken(token)
+		require.NoError(t, err)
+		assert.False(t, resp.Active)
+	})
+
+	t.Run("Revocation by another replica", func(t *testing.T) {
+		denylist := store.NewMemoryStore()
+		replicaJWT := services.NewJWTService(fake.newClient(), cfg, services.WithDenylist(denylist))
+		replica := services.NewOAuthService(cfg, replicaJWT)
+		defer replica.Stop()
+		otherJWT := services.NewJWTService(fake.newClient(), cfg, services.WithDenylist(denylist))
+
+		token, err := replicaJWT.GenerateAccessToken("demo-user", "test-client", "openid")
+		require.NoError(t, err)
+		resp, err := replica.IntrospectToken(token)
+		require.NoError(t, err)
+		require.True(t, resp.Active)
+
+		require.NoError(t, otherJWT.RevokeAccessToken(token))
+
+		resp, err = replica.IntrospectToken(token)
+		require.NoError(t, err)
+		assert.False(t, resp.Active)
+	})
+
+	t.Run("Entries expire", func(t *testing.T) {
+		shortCfg := newTestConfig()
+		shortCfg.OAuth.IntrospectionCacheTTL = 50 * time.Millisecond
+		shortService := services.NewOAuthService(shortCfg, jwtService)
+		defer shortService.Stop()
+
+		token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+		require.NoError(t, err)
+		_, err = shortService.IntrospectToken(token)
+		require.NoError(t, err)
+
+		time.Sleep(100 * time.Millisecond)
+		verifications := fake.verifyRequests()
+		resp, err := shortService.IntrospectToken(token)
+		require.NoError(t, err)
+		assert.True(t, resp.Active)
+		assert.Equal(t, verifications+1, fake.verifyRequests())
+	})
+
+	t.Run("Entries live no longer than the token", func(t *testing.T) {
+		// The token expires within a second, well before the cache TTL
+		expiring := newTestConfig()
+		expiring.JWT.TokenExpiration = time.Second
+		expiring.JWT.ClockSkew = 0
+		token, err := services.NewJWTService(fake.newClient(), expiring).GenerateAccessToken("demo-user", "test-client", "openid")
+		require.NoError(t, err)
+
+		resp, err := oauthService.IntrospectToken(token)
+		require.NoError(t, err)
+		require.True(t, resp.Active)
+
+		time.Sleep(time.Until(time.Unix(resp.Exp, 0)) + 100*time.Millisecond)
+		verifications := fake.verifyRequests()
+		_, err = oauthService.IntrospectToken(token)
+		require.NoError(t, err)
+		assert.Equal(t, verifications+1, fake.verifyRequests())
+	})
+}
+
+func TestBatchIntrospection(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	cfg.OAuth.IntrospectionScope = "introspect"
+	cfg.OAuth.MaxBatchIntrospection = 20
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+	defer oauthService.Stop()
+
+	router := mux.NewRouter()
+	handlers.NewOAuthHandler(oauthService, jwtService).RegisterRoutes(router)
+
+	caller, err := jwtService.GenerateAccessToken("summarizer", "internal-service", "introspect")
+	require.NoError(t, err)
+
+	introspectBatch := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/introspect/batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+caller)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Mixed valid and invalid tokens", func(t *testing.T) {
+		expiredCfg := newTestConfig()
+		expiredCfg.JWT.TokenExpiration = -time.Minute
+		expired, err := services.NewJWTService(fake.newClient(), expiredCfg).GenerateAccessToken("expired-user", "test-client", "openid")
+		require.NoError(t, err)
+
+		revoked, err := jwtService.GenerateAccessToken("revoked-user", "test-client", "openid")
+		require.NoError(t, err)
+		require.NoError(t, oauthService.RevokeToken(revoked, "access_token"))
+
+		var tokens []string
+		want := make(map[int]string)
+		for i := 0; i < 12; i++ {
+			switch i % 4 {
+			case 0:
+				tokens = append(tokens, "not.a.token")
+			case 1:
+				tokens = append(tokens, expired)
+			case 2:
+				tokens = append(tokens, revoked)
+			default:
+				subject := "user-" + string(rune('a'+i))
+				token, err := jwtService.GenerateAccessToken(subject, "test-client", "openid")
+				require.NoError(t, err)
+				tokens = append(tokens, token)
+				want[i] = subject
+			}
+		}
+		body, err := json.Marshal(tokens)
+		require.NoError(t, err)
+
+		rec := introspectBatch(string(body))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var responses []models.IntrospectionResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &responses))
+		require.Len(t, responses, len(tokens))
+		for i, resp := range responses {
+			subject, active := want[i]
+			assert.Equal(t, active, resp.Active, "token %d", i)
+			assert.Equal(t, subject, resp.Sub, "token %d", i)
+		}
+	})
+
+	t.Run("Empty batch", func(t *testing.T) {
+		rec := introspectBatch("[]")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, "[]", rec.Body.String())
+	})
+
+	t.Run("Batch exceeding the cap", func(t *testing.T) {
+		tokens := make([]string, cfg.OAuth.MaxBatchIntrospection+1)
+		for i := range tokens {
+			tokens[i] = caller
+		}
+		body, err := json.Marshal(tokens)
+		require.NoError(t, err)
+
+		rec := introspectBatch(string(body))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	})
+
+	t.Run("Body that isn't an array of tokens", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, introspectBatch(`{"token":"abc"}`).Code)
+	})
+
+	t.Run("Requires authentication", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/introspect/batch", strings.NewReader("[]"))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
+
+func TestIntrospectErrorResponses(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	cfg.OAuth.IntrospectionScope = "introspect"
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+	defer oauthService.Stop()
+
+	router := mux.NewRouter()
+	handlers.NewOAuthHandler(oauthService, jwtService).RegisterRoutes(router)
+
+	caller, err := jwtService.GenerateAccessToken("summarizer", "internal-service", "introspect")
+	require.NoError(t, err)
+
+	post := func(path, contentType, body, authHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		if authHeader != "" {
+			req.Header.Set("Authorization", authHeader)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assertError := func(t *testing.T, rec *httptest.ResponseRecorder, status int, errorCode string) {
+		t.Helper()
+		assert.Equal(t, status, rec.Code)
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		assert.Contains(t, rec.Header().Get("WWW-Authenticate"), `error="`+errorCode+`"`)
+
+		var errorResp models.ErrorResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &errorResp))
+		assert.Equal(t, errorCode, errorResp.Error)
+		assert.NotEmpty(t, errorResp.ErrorDescription)
+	}
+
+	const form = "application/x-www-form-urlencoded"
+
+	t.Run("Missing token parameter", func(t *testing.T) {
+		rec := post("/introspect", form, "token_type_hint=access_token", "Bearer "+caller)
+		assertError(t, rec, http.StatusBadRequest, "invalid_request")
+	})
+
+	t.Run("Malformed form body", func(t *testing.T) {
+		rec := post("/introspect", form, "token=%zz", "Bearer "+caller)
+		assertError(t, rec, http.StatusBadRequest, "invalid_request")
+	})
+
+	t.Run("Malformed batch body", func(t *testing.T) {
+		rec := post("/introspect/batch", "application/json", `["unterminated`, "Bearer "+caller)
+		assertError(t, rec, http.StatusBadRequest, "invalid_request")
+	})
+
+	t.Run("Invalid caller token", func(t *testing.T) {
+		rec := post("/introspect", form, "token=abc", "Bearer not.a.token")
+		assertError(t, rec, http.StatusUnauthorized, "invalid_token")
+	})
+}
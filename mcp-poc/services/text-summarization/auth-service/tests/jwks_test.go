@@ -0,0 +1,206 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/handlers"
+	"auth-service/internal/services"
+	"auth-service/pkg/vault"
+)
+
+func TestJWKSCache(t *testing.T) {
+	fake := newFakeVault(t)
+	client := fake.newClient()
+	cfg := newTestConfig()
+	cfg.JWT.JWKSCacheTTL = 20 * time.Millisecond
+	jwtService := services.NewJWTService(client, cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+	defer oauthService.Stop()
+	handler := handlers.NewOAuthHandler(oauthService, jwtService)
+
+	getJWKS := func() (int, jose.JSONWebKeySet) {
+		rr := httptest.NewRecorder()
+		handler.HandleJWKS(rr, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+
+		var jwks jose.JSONWebKeySet
+		if rr.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &jwks))
+		}
+		return rr.Code, jwks
+	}
+
+	status, jwks := getJWKS()
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, jwks.Keys, 1)
+
+	// Rotating through the client directly leaves the cached JWKS stale, and
+	// clears the client's key cache so the next fetch has to reach Vault
+	require.NoError(t, client.RotateKey())
+
+	t.Run("Serves cached JWKS while Vault is down", func(t *testing.T) {
+		fake.setUnavailable(true)
+		defer fake.setUnavailable(false)
+
+		time.Sleep(30 * time.Millisecond)
+		for i := 0; i < 5; i++ {
+			status, jwks := getJWKS()
+			assert.Equal(t, http.StatusOK, status)
+			assert.Len(t, jwks.Keys, 1)
+			time.Sleep(5 * time.Millisecond)
+		}
+	})
+
+	t.Run("Refreshes after TTL", func(t *testing.T) {
+		// Allow for the Vault client retrying the refresh that failed above
+		assert.Eventually(t, func() bool {
+			status, jwks := getJWKS()
+			return status == http.StatusOK && len(jwks.Keys) == 2
+		}, 10*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Rotation refreshes immediately", func(t *testing.T) {
+		cfg.JWT.JWKSCacheTTL = time.Hour
+		jwtService := services.NewJWTService(fake.newClient(), cfg)
+		_, err := jwtService.GetJWKS()
+		require.NoError(t, err)
+
+		require.NoError(t, jwtService.RotateKeys())
+
+		jwksJSON, err := jwtService.GetJWKS()
+		require.NoError(t, err)
+		var jwks jose.JSONWebKeySet
+		require.NoError(t, json.Unmarshal(jwksJSON, &jwks))
+		assert.Len(t, jwks.Keys, 3)
+	})
+}
+
+func TestJWKSNeverFetched(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	handler := handlers.NewOAuthHandler(nil, jwtService)
+
+	fake.setUnavailable(true)
+
+	rr := httptest.NewRecorder()
+	handler.HandleJWKS(rr, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+}
+
+func TestJWKSKeyRollover(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	cfg.JWT.KeysInJWKS = 2
+	cfg.JWT.LocalVerification = true
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+
+	publishedKeyIDs := func() []string {
+		jwksJSON, err := jwtService.GetJWKS()
+		require.NoError(t, err)
+
+		var jwks jose.JSONWebKeySet
+		require.NoError(t, json.Unmarshal(jwksJSON, &jwks))
+
+		var keyIDs []string
+		for _, key := range jwks.Keys {
+			keyIDs = append(keyIDs, key.KeyID)
+		}
+		return keyIDs
+	}
+
+	assert.Equal(t, []string{testTransitKey + "-v1"}, publishedKeyIDs())
+
+	oldToken, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+	require.NoError(t, err)
+
+	require.NoError(t, jwtService.RotateKeys())
+	assert.Equal(t, []string{testTransitKey + "-v2", testTransitKey + "-v1"}, publishedKeyIDs())
+
+	// Tokens signed before and after the rotation both verify
+	newToken, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+	require.NoError(t, err)
+	for _, token := range []string{oldToken, newToken} {
+		_, err := jwtService.ValidateAccessToken(token)
+		assert.NoError(t, err)
+	}
+
+	// Only the configured number of versions stays published
+	require.NoError(t, jwtService.RotateKeys())
+	assert.Equal(t, []string{testTransitKey + "-v3", testTransitKey + "-v2"}, publishedKeyIDs())
+}
+
+func TestJWKSKeepsKeysForLiveTokens(t *testing.T) {
+	fake := newEmptyFakeVault(t)
+	cfg := newTestConfig()
+	cfg.JWT.KeysInJWKS = 2
+	cfg.JWT.TokenExpiration = time.Hour
+	cfg.JWT.KeyRotationInterval = 30 * time.Minute
+	jwtService := services.NewJWTService(fake.newClient(vault.WithAlgorithm(vault.AlgorithmES256)), cfg)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, jwtService.RotateKeys())
+	}
+
+	// Tokens live for two intervals, and a replica may sign with a key for
+	// an interval after it is superseded
+	jwksJSON, err := jwtService.GetJWKS()
+	require.NoError(t, err)
+	var jwks jose.JSONWebKeySet
+	require.NoError(t, json.Unmarshal(jwksJSON, &jwks))
+	assert.Len(t, jwks.Keys, 4)
+}
+
+func TestJWKSETag(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	handler := handlers.NewOAuthHandler(nil, jwtService)
+
+	getJWKS := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/.well-known/jwks.json", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rr := httptest.NewRecorder()
+		handler.HandleJWKS(rr, req)
+		return rr
+	}
+
+	first := getJWKS("")
+	require.Equal(t, http.StatusOK, first.Code)
+	etag := first.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	assert.Equal(t, "application/json", first.Header().Get("Content-Type"))
+	assert.NotEmpty(t, first.Body.Bytes())
+
+	t.Run("Unchanged key set", func(t *testing.T) {
+		for _, ifNoneMatch := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+			rr := getJWKS(ifNoneMatch)
+			assert.Equal(t, http.StatusNotModified, rr.Code, ifNoneMatch)
+			assert.Empty(t, rr.Body.Bytes())
+			assert.Equal(t, etag, rr.Header().Get("ETag"))
+		}
+	})
+
+	t.Run("Stale ETag", func(t *testing.T) {
+		rr := getJWKS(`"stale"`)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, first.Body.String(), rr.Body.String())
+	})
+
+	t.Run("Rotation changes the ETag", func(t *testing.T) {
+		require.NoError(t, jwtService.RotateKeys())
+
+		rr := getJWKS(etag)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NotEqual(t, etag, rr.Header().Get("ETag"))
+	})
+}
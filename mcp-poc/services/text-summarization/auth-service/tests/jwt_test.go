@@ -0,0 +1,240 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/config"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+)
+
+func TestValidateAccessTokenAudience(t *testing.T) {
+	fake := newFakeVault(t)
+	client := fake.newClient()
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(client, cfg)
+
+	t.Run("Matching audience", func(t *testing.T) {
+		token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+		require.NoError(t, err)
+
+		claims, err := jwtService.ValidateAccessToken(token)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"api"}, []string(claims.Audience))
+	})
+
+	t.Run("Single string audience", func(t *testing.T) {
+		claims := standardTestClaims()
+		claims["aud"] = "api"
+
+		validated, err := jwtService.ValidateAccessToken(signTestToken(t, client, claims))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"api"}, []string(validated.Audience))
+	})
+
+	t.Run("Missing audience", func(t *testing.T) {
+		claims := standardTestClaims()
+		delete(claims, "aud")
+
+		_, err := jwtService.ValidateAccessToken(signTestToken(t, client, claims))
+		assert.ErrorContains(t, err, "invalid audience")
+	})
+
+	t.Run("Audience for another resource server", func(t *testing.T) {
+		token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+		require.NoError(t, err)
+
+		_, err = jwtService.ValidateAccessTokenForAudience(token, "billing-api")
+		assert.ErrorContains(t, err, "invalid audience")
+	})
+
+	t.Run("Multiple audiences with one match", func(t *testing.T) {
+		claims := standardTestClaims()
+		claims["aud"] = []string{"billing-api", "api"}
+		token := signTestToken(t, client, claims)
+
+		_, err := jwtService.ValidateAccessToken(token)
+		assert.NoError(t, err)
+
+		_, err = jwtService.ValidateAccessTokenForAudience(token, "billing-api")
+		assert.NoError(t, err)
+	})
+}
+
+func TestValidateAudienceDisabled(t *testing.T) {
+	fake := newFakeVault(t)
+	client := fake.newClient()
+	cfg := newTestConfig()
+	cfg.JWT.ValidateAudience = false
+	jwtService := services.NewJWTService(client, cfg)
+
+	claims := standardTestClaims()
+	claims["aud"] = []string{"billing-api"}
+	_, err := jwtService.ValidateAccessToken(signTestToken(t, client, claims))
+	assert.NoError(t, err, "mismatched audience is accepted during rollout")
+
+	// An explicit audience is still enforced
+	_, err = jwtService.ValidateAccessTokenForAudience(signTestToken(t, client, claims), "api")
+	assert.ErrorContains(t, err, "invalid audience")
+}
+
+func TestScopeTokenTTLs(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	cfg.JWT.ScopeTokenTTLs = map[string]time.Duration{
+		"email":   30 * time.Minute,
+		"admin":   5 * time.Minute,
+		"profile": 2 * time.Hour, // longer than TokenExpiration, so never used
+	}
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+
+	tests := []struct {
+		name  string
+		scope string
+		ttl   time.Duration
+	}{
+		{"No matching scope", "openid", time.Hour},
+		{"Empty scope", "", time.Hour},
+		{"One matching scope", "openid email", 30 * time.Minute},
+		{"Shortest of several", "email admin openid", 5 * time.Minute},
+		{"Capped by TokenExpiration", "profile", time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.ttl, jwtService.AccessTokenTTL(tt.scope))
+
+			token, err := jwtService.GenerateAccessToken("demo-user", "test-client", tt.scope)
+			require.NoError(t, err)
+
+			claims, err := jwtService.ValidateAccessToken(token)
+			require.NoError(t, err)
+			assert.Equal(t, int64(tt.ttl.Seconds()), claims.ExpiresAt-claims.IssuedAt)
+		})
+	}
+
+	t.Run("Token response expires_in", func(t *testing.T) {
+		oauthService := services.NewOAuthService(cfg, jwtService)
+		defer oauthService.Stop()
+
+		tokens := issueTokens(t, oauthService, "openid email")
+		assert.Equal(t, int64((30 * time.Minute).Seconds()), tokens.ExpiresIn)
+	})
+}
+
+func TestLoadScopeTokenTTLs(t *testing.T) {
+	t.Setenv("JWT_SCOPE_TOKEN_TTLS", "admin=5m, email = 1h,broken,bad=soon")
+
+	cfg := config.Load()
+	assert.Equal(t, map[string]time.Duration{
+		"admin": 5 * time.Minute,
+		"email": time.Hour,
+	}, cfg.JWT.ScopeTokenTTLs)
+}
+
+func TestValidateAccessTokenClockSkew(t *testing.T) {
+	fake := newFakeVault(t)
+	client := fake.newClient()
+	cfg := newTestConfig()
+	cfg.JWT.ClockSkew = time.Minute
+	jwtService := services.NewJWTService(client, cfg)
+
+	tokenWith := func(claim string, offset time.Duration) string {
+		claims := standardTestClaims()
+		claims[claim] = time.Now().Add(offset).Unix()
+		return signTestToken(t, client, claims)
+	}
+
+	t.Run("Expired within leeway", func(t *testing.T) {
+		_, err := jwtService.ValidateAccessToken(tokenWith("exp", -30*time.Second))
+		assert.NoError(t, err)
+	})
+
+	t.Run("Expired beyond leeway", func(t *testing.T) {
+		_, err := jwtService.ValidateAccessToken(tokenWith("exp", -120*time.Second))
+		assert.ErrorIs(t, err, services.ErrTokenExpired)
+	})
+
+	t.Run("Not yet valid within leeway", func(t *testing.T) {
+		_, err := jwtService.ValidateAccessToken(tokenWith("nbf", 30*time.Second))
+		assert.NoError(t, err)
+	})
+
+	t.Run("Not yet valid beyond leeway", func(t *testing.T) {
+		_, err := jwtService.ValidateAccessToken(tokenWith("nbf", 120*time.Second))
+		assert.ErrorContains(t, err, "not yet valid")
+	})
+
+	t.Run("No leeway", func(t *testing.T) {
+		strict := services.NewJWTService(client, newTestConfig())
+		_, err := strict.ValidateAccessToken(tokenWith("exp", -30*time.Second))
+		assert.ErrorIs(t, err, services.ErrTokenExpired)
+	})
+}
+
+func TestValidateAccessTokenForTenant(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+
+	tenantToken, err := jwtService.GenerateAccessTokenWithTenant("demo-user", "test-client", "openid", "tenant-a")
+	require.NoError(t, err)
+
+	t.Run("Matching tenant", func(t *testing.T) {
+		claims, err := jwtService.ValidateAccessTokenForTenant(tenantToken, "tenant-a")
+		require.NoError(t, err)
+		assert.Equal(t, "tenant-a", claims.TenantID)
+	})
+
+	t.Run("Mismatched tenant", func(t *testing.T) {
+		_, err := jwtService.ValidateAccessTokenForTenant(tenantToken, "tenant-b")
+		assert.ErrorIs(t, err, services.ErrTenantMismatch)
+	})
+
+	t.Run("No expected tenant", func(t *testing.T) {
+		_, err := jwtService.ValidateAccessTokenForTenant(tenantToken, "")
+		assert.ErrorIs(t, err, services.ErrTenantMismatch)
+	})
+
+	t.Run("Missing tenant claim", func(t *testing.T) {
+		token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+		require.NoError(t, err)
+
+		_, err = jwtService.ValidateAccessTokenForTenant(token, "tenant-a")
+		assert.ErrorIs(t, err, services.ErrTenantMismatch)
+		_, err = jwtService.ValidateAccessTokenForTenant(token, "")
+		assert.ErrorIs(t, err, services.ErrTenantMismatch)
+	})
+
+	t.Run("Invalid token is not a tenant mismatch", func(t *testing.T) {
+		_, err := jwtService.ValidateAccessTokenForTenant("not-a-jwt", "tenant-a")
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, services.ErrTenantMismatch)
+	})
+
+	t.Run("Refreshed tokens keep the tenant", func(t *testing.T) {
+		oauthService := services.NewOAuthService(cfg, jwtService,
+			services.WithTenantResolver(services.TenantResolverFunc(func(userID string) (string, error) {
+				return "tenant-a", nil
+			})))
+		defer oauthService.Stop()
+		tokens := issueTokens(t, oauthService, "openid")
+
+		claims, err := jwtService.ValidateAccessToken(tokens.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, "tenant-a", claims.TenantID)
+
+		refreshed, errorResp := oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:    "refresh_token",
+			RefreshToken: tokens.RefreshToken,
+			ClientID:     "test-client",
+		})
+		require.Nil(t, errorResp)
+		_, err = jwtService.ValidateAccessTokenForTenant(refreshed.AccessToken, claims.TenantID)
+		assert.NoError(t, err)
+	})
+}
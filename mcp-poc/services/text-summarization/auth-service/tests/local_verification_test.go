@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/services"
+	"auth-service/pkg/vault"
+)
+
+func TestLocalVerification(t *testing.T) {
+	for _, algorithm := range []string{vault.AlgorithmRS256, vault.AlgorithmPS256, vault.AlgorithmES256} {
+		t.Run(algorithm, func(t *testing.T) {
+			fake := newEmptyFakeVault(t)
+			cfg := newTestConfig()
+			cfg.JWT.Algorithm = algorithm
+			cfg.JWT.LocalVerification = true
+			jwtService := services.NewJWTService(fake.newClient(vault.WithAlgorithm(algorithm)), cfg)
+
+			token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+			require.NoError(t, err)
+
+			claims, err := jwtService.ValidateAccessToken(token)
+			require.NoError(t, err)
+			assert.Equal(t, "demo-user", claims.Subject)
+			assert.Equal(t, 0, fake.verifyRequests(), "valid token should not hit Vault")
+
+			// Flip a character in the signature
+			parts := strings.Split(token, ".")
+			sig := []byte(parts[2])
+			if sig[0] == 'A' {
+				sig[0] = 'B'
+			} else {
+				sig[0] = 'A'
+			}
+			_, err = jwtService.ValidateAccessToken(parts[0] + "." + parts[1] + "." + string(sig))
+			assert.Error(t, err)
+
+			// Tampered claims no longer match the signature
+			_, err = jwtService.ValidateAccessToken(parts[0] + "." + parts[0] + "." + parts[2])
+			assert.Error(t, err)
+			assert.Equal(t, 0, fake.verifyRequests())
+		})
+	}
+}
+
+func TestLocalVerificationRereadsKeysForUnknownKid(t *testing.T) {
+	fake := newEmptyFakeVault(t)
+	cfg := newTestConfig()
+	cfg.JWT.Algorithm = vault.AlgorithmES256
+	cfg.JWT.LocalVerification = true
+
+	newVerifier := func(opts ...vault.Option) *services.JWTService {
+		opts = append(opts, vault.WithAlgorithm(vault.AlgorithmES256))
+		verifier := services.NewJWTService(fake.newClient(opts...), cfg)
+		_, err := verifier.GetJWKS() // warm the verifier's key cache
+		require.NoError(t, err)
+		return verifier
+	}
+	verifier := newVerifier(vault.WithMinKeyRefresh(0))
+	throttled := newVerifier()
+
+	// Another replica rotates and signs with a key the verifiers haven't cached
+	signerClient := fake.newClient(vault.WithAlgorithm(vault.AlgorithmES256))
+	require.NoError(t, signerClient.RotateKey())
+	token, err := services.NewJWTService(signerClient, cfg).GenerateAccessToken("demo-user", "test-client", "openid")
+	require.NoError(t, err)
+
+	_, err = verifier.ValidateAccessToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, 0, fake.verifyRequests(), "verified against the reread keys")
+
+	// Tokens naming keys that don't exist cost at most one read per
+	// minRefresh, and never a transit/verify call
+	parts := strings.Split(token, ".")
+	requests := fake.requestCount()
+	for i := 0; i < 10; i++ {
+		header := fmt.Sprintf(`{"alg":"ES256","typ":"JWT","kid":"%s-v%d"}`, testTransitKey, 100+i)
+		forged := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + parts[1] + "." + parts[2]
+		_, err := throttled.ValidateAccessToken(forged)
+		assert.Error(t, err)
+	}
+	assert.LessOrEqual(t, fake.requestCount(), requests+1)
+	assert.Equal(t, 0, fake.verifyRequests())
+}
+
+func TestTokensAreSignedWithTheirKid(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	cfg.JWT.LocalVerification = true
+
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	_, err := jwtService.GetJWKS() // cache version 1
+	require.NoError(t, err)
+
+	// Another replica rotates; this one keeps signing with the version it
+	// has cached, and names it in the header
+	require.NoError(t, fake.newClient().RotateKey())
+	token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+	require.NoError(t, err)
+	assert.Equal(t, testTransitKey+"-v1", tokenKeyID(t, token))
+
+	_, err = jwtService.ValidateAccessToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, 0, fake.verifyRequests())
+}
+
+func TestLocalVerificationRefreshesStaleKeys(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	cfg.JWT.LocalVerification = true
+
+	newVerifier := func(opts ...vault.Option) *services.JWTService {
+		verifier := services.NewJWTService(fake.newClient(opts...), cfg)
+		_, err := verifier.GetJWKS() // cache version 1
+		require.NoError(t, err)
+		return verifier
+	}
+	verifier := newVerifier(vault.WithMinKeyRefresh(0))
+	throttled := newVerifier()
+
+	// The key is recreated, so the cached version 1 no longer matches
+	fake.recreateKey()
+	token, err := services.NewJWTService(fake.newClient(), cfg).GenerateAccessToken("demo-user", "test-client", "openid")
+	require.NoError(t, err)
+
+	_, err = verifier.ValidateAccessToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, 0, fake.verifyRequests())
+
+	// Keys read moments ago aren't reread for every bad signature
+	_, err = throttled.ValidateAccessToken(token)
+	assert.Error(t, err)
+}
+
+func BenchmarkValidateAccessToken(b *testing.B) {
+	for _, local := range []bool{false, true} {
+		name := "Vault"
+		if local {
+			name = "Local"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			fake := newFakeVault(b)
+			cfg := newTestConfig()
+			cfg.JWT.LocalVerification = local
+			jwtService := services.NewJWTService(fake.newClient(), cfg)
+
+			token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+			require.NoError(b, err)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := jwtService.ValidateAccessToken(token); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
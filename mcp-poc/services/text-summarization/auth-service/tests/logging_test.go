@@ -0,0 +1,64 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/middleware"
+)
+
+func TestLoggingMiddleware(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&out, nil))
+
+	var handlerRequestID string
+	handler := middleware.RequestIDMiddleware(middleware.LoggingMiddleware(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerRequestID = middleware.RequestIDFromContext(r.Context())
+		w.WriteHeader(http.StatusTeapot)
+	})))
+
+	serve := func(requestID string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		out.Reset()
+		req := httptest.NewRequest(http.MethodPost, "/token?client_id=x", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		if requestID != "" {
+			req.Header.Set(middleware.RequestIDHeader, requestID)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal(out.Bytes(), &record), "log output is not JSON: %s", out.String())
+		return rec, record
+	}
+
+	t.Run("Structured fields", func(t *testing.T) {
+		rec, record := serve("")
+
+		for _, key := range []string{"time", "level", "msg", "method", "path", "status", "duration_ms", "remote_addr", "request_id"} {
+			assert.Contains(t, record, key)
+		}
+		assert.Equal(t, "POST", record["method"])
+		assert.Equal(t, "/token", record["path"])
+		assert.Equal(t, float64(http.StatusTeapot), record["status"])
+		assert.Equal(t, "10.0.0.1:1234", record["remote_addr"])
+
+		// A generated ID is shared by the log, the response and the handler
+		requestID := rec.Header().Get(middleware.RequestIDHeader)
+		assert.NotEmpty(t, requestID)
+		assert.Equal(t, requestID, record["request_id"])
+		assert.Equal(t, requestID, handlerRequestID)
+	})
+
+	t.Run("Caller request ID is logged", func(t *testing.T) {
+		_, record := serve("upstream-123")
+		assert.Equal(t, "upstream-123", record["request_id"])
+	})
+}
@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/middleware"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+	"auth-service/internal/store"
+	"auth-service/pkg/metrics"
+)
+
+func TestActiveTokenGauges(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+
+	// Exchanging a code consumes it and leaves one refresh token behind
+	tokens := issueTokens(t, oauthService, "openid")
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.ActiveAuthorizationCodes))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.ActiveRefreshTokens))
+
+	for i := 0; i < 2; i++ {
+		_, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+			UserID:              "demo-user",
+			ResponseType:        "code",
+			ClientID:            "test-client",
+			RedirectURI:         "http://localhost:3000/callback",
+			Scope:               "openid",
+			CodeChallenge:       testCodeChallenge,
+			CodeChallengeMethod: "S256",
+		})
+		require.Nil(t, errorResp)
+	}
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.ActiveAuthorizationCodes))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.ActiveRefreshTokens))
+
+	require.NoError(t, oauthService.RevokeToken(tokens.RefreshToken, "refresh_token"))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.ActiveRefreshTokens))
+}
+
+func TestActiveTokenGaugesOnExpiry(t *testing.T) {
+	tokenStore := store.NewMemoryStore()
+	oauthService := services.NewOAuthService(newTestConfig(), nil, services.WithTokenStore(tokenStore))
+	grantConsent(t, oauthService, "test-client", "openid")
+
+	require.NoError(t, tokenStore.SaveAuthCode(&models.AuthorizationCode{
+		Code:        "expired-code",
+		ClientID:    "test-client",
+		RedirectURI: "http://localhost:3000/callback",
+		ExpiresAt:   time.Now().Add(-time.Minute),
+	}))
+
+	_, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+		UserID:              "demo-user",
+		ResponseType:        "code",
+		ClientID:            "test-client",
+		RedirectURI:         "http://localhost:3000/callback",
+		Scope:               "openid",
+		CodeChallenge:       testCodeChallenge,
+		CodeChallengeMethod: "S256",
+	})
+	require.Nil(t, errorResp)
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.ActiveAuthorizationCodes))
+
+	// Presenting the expired code removes it, and the gauge follows
+	_, errorResp = oauthService.HandleTokenRequest(&models.TokenRequest{
+		GrantType:    "authorization_code",
+		Code:         "expired-code",
+		RedirectURI:  "http://localhost:3000/callback",
+		ClientID:     "test-client",
+		CodeVerifier: testCodeVerifier,
+	})
+	require.NotNil(t, errorResp)
+	assert.Equal(t, "invalid_grant", errorResp.Error)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.ActiveAuthorizationCodes))
+}
+
+func TestMetricsMiddlewareLabelsRouteTemplate(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(middleware.MetricsMiddleware)
+	router.HandleFunc("/metrics-test/clients/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}).Methods("GET")
+
+	series := testutil.CollectAndCount(metrics.HttpRequestsTotal)
+	for _, id := range []string{"1", "2", "abc"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics-test/clients/"+id, nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	// Every ID shares one series instead of adding one each
+	assert.Equal(t, series+1, testutil.CollectAndCount(metrics.HttpRequestsTotal))
+	assert.Equal(t, float64(3), testutil.ToFloat64(
+		metrics.HttpRequestsTotal.WithLabelValues("GET", "/metrics-test/clients/{id}", "200")))
+}
+
+func TestRegisterCollectors(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	require.NoError(t, metrics.Register(registry))
+
+	metrics.RecordKeyCacheHit()
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	names := make(map[string]bool)
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	assert.True(t, names["auth_service_key_cache_hits_total"])
+	assert.True(t, names["auth_service_active_refresh_tokens"])
+
+	// Registering twice with the same registry is reported
+	assert.Error(t, metrics.Register(registry))
+}
@@ -0,0 +1,94 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/config"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+)
+
+func TestNonceReplay(t *testing.T) {
+	authorize := func(oauthService *services.OAuthService, clientID, nonce string) *models.ErrorResponse {
+		_, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+			UserID:              "demo-user",
+			ResponseType:        "code",
+			ClientID:            clientID,
+			RedirectURI:         "http://localhost:3000/callback",
+			Scope:               "openid",
+			State:               "xyz",
+			CodeChallenge:       testCodeChallenge,
+			CodeChallengeMethod: "S256",
+			Nonce:               nonce,
+		})
+		return errorResp
+	}
+
+	t.Run("Fresh nonce accepted and duplicate rejected", func(t *testing.T) {
+		oauthService := services.NewOAuthService(newTestConfig(), nil)
+		grantConsent(t, oauthService, "test-client", "openid")
+
+		require.Nil(t, authorize(oauthService, "test-client", "nonce-1"))
+
+		errorResp := authorize(oauthService, "test-client", "nonce-1")
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_request", errorResp.Error)
+		assert.Equal(t, "xyz", errorResp.State)
+
+		assert.Nil(t, authorize(oauthService, "test-client", "nonce-2"))
+	})
+
+	t.Run("Requests without nonce are unaffected", func(t *testing.T) {
+		oauthService := services.NewOAuthService(newTestConfig(), nil)
+		grantConsent(t, oauthService, "test-client", "openid")
+
+		require.Nil(t, authorize(oauthService, "test-client", ""))
+		assert.Nil(t, authorize(oauthService, "test-client", ""))
+	})
+
+	t.Run("Nonces are scoped per client", func(t *testing.T) {
+		cfg := newTestConfig()
+		cfg.OAuth.Clients = []config.ClientConfig{
+			{ClientID: "client-a", RedirectURIs: []string{"http://localhost:3000/callback"}},
+			{ClientID: "client-b", RedirectURIs: []string{"http://localhost:3000/callback"}},
+		}
+		oauthService := services.NewOAuthService(cfg, nil)
+		grantConsent(t, oauthService, "client-a", "openid")
+		grantConsent(t, oauthService, "client-b", "openid")
+
+		require.Nil(t, authorize(oauthService, "client-a", "shared-nonce"))
+		assert.Nil(t, authorize(oauthService, "client-b", "shared-nonce"))
+	})
+
+	t.Run("Nonce accepted again after TTL", func(t *testing.T) {
+		cfg := newTestConfig()
+		cfg.OAuth.NonceTTL = 50 * time.Millisecond
+		oauthService := services.NewOAuthService(cfg, nil)
+		grantConsent(t, oauthService, "test-client", "openid")
+
+		require.Nil(t, authorize(oauthService, "test-client", "nonce-1"))
+		require.NotNil(t, authorize(oauthService, "test-client", "nonce-1"))
+
+		time.Sleep(60 * time.Millisecond)
+		assert.Nil(t, authorize(oauthService, "test-client", "nonce-1"))
+	})
+
+	t.Run("Cache size is bounded", func(t *testing.T) {
+		cfg := newTestConfig()
+		cfg.OAuth.NonceCacheSize = 2
+		oauthService := services.NewOAuthService(cfg, nil)
+		grantConsent(t, oauthService, "test-client", "openid")
+
+		require.Nil(t, authorize(oauthService, "test-client", "nonce-1"))
+		require.Nil(t, authorize(oauthService, "test-client", "nonce-2"))
+		require.Nil(t, authorize(oauthService, "test-client", "nonce-3"))
+
+		// nonce-1 was evicted to make room, the newer ones are still remembered
+		assert.Nil(t, authorize(oauthService, "test-client", "nonce-1"))
+		assert.NotNil(t, authorize(oauthService, "test-client", "nonce-3"))
+	})
+}
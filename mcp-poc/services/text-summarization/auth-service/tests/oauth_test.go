@@ -3,15 +3,20 @@ package tests
 import (
 	"crypto/sha256"
 	"encoding/base64"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"auth-service/internal/config"
 	"auth-service/internal/models"
 	"auth-service/internal/services"
+	"auth-service/internal/store"
+	"auth-service/pkg/metrics"
 )
 
 func TestOAuthService_HandleAuthorizationRequest(t *testing.T) {
@@ -26,9 +31,11 @@ func TestOAuthService_HandleAuthorizationRequest(t *testing.T) {
 	}
 
 	oauthService := services.NewOAuthService(cfg, nil)
+	grantConsent(t, oauthService, "test-client", "openid profile")
 
 	t.Run("Valid authorization request with PKCE", func(t *testing.T) {
 		req := &models.AuthorizationRequest{
+			UserID:              "demo-user",
 			ResponseType:        "code",
 			ClientID:            "test-client",
 			RedirectURI:         "http://localhost:3000/callback",
@@ -54,6 +61,7 @@ func TestOAuthService_HandleAuthorizationRequest(t *testing.T) {
 
 	t.Run("Invalid response type", func(t *testing.T) {
 		req := &models.AuthorizationRequest{
+			UserID:       "demo-user",
 			ResponseType: "token",
 			ClientID:     "test-client",
 			RedirectURI:  "http://localhost:3000/callback",
@@ -68,6 +76,7 @@ func TestOAuthService_HandleAuthorizationRequest(t *testing.T) {
 
 	t.Run("Invalid client ID", func(t *testing.T) {
 		req := &models.AuthorizationRequest{
+			UserID:       "demo-user",
 			ResponseType: "code",
 			ClientID:     "invalid-client",
 			RedirectURI:  "http://localhost:3000/callback",
@@ -82,6 +91,7 @@ func TestOAuthService_HandleAuthorizationRequest(t *testing.T) {
 
 	t.Run("Invalid redirect URI", func(t *testing.T) {
 		req := &models.AuthorizationRequest{
+			UserID:       "demo-user",
 			ResponseType: "code",
 			ClientID:     "test-client",
 			RedirectURI:  "http://evil.com/callback",
@@ -96,6 +106,7 @@ func TestOAuthService_HandleAuthorizationRequest(t *testing.T) {
 
 	t.Run("Missing PKCE when required", func(t *testing.T) {
 		req := &models.AuthorizationRequest{
+			UserID:       "demo-user",
 			ResponseType: "code",
 			ClientID:     "test-client",
 			RedirectURI:  "http://localhost:3000/callback",
@@ -111,11 +122,12 @@ func TestOAuthService_HandleAuthorizationRequest(t *testing.T) {
 
 	t.Run("Invalid scope", func(t *testing.T) {
 		req := &models.AuthorizationRequest{
+			UserID:              "demo-user",
 			ResponseType:        "code",
 			ClientID:            "test-client",
 			RedirectURI:         "http://localhost:3000/callback",
 			Scope:               "invalid-scope",
-			CodeChallenge:       "test-challenge",
+			CodeChallenge:       "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
 			CodeChallengeMethod: "S256",
 		}
 
@@ -135,10 +147,12 @@ func TestPKCEVerification(t *testing.T) {
 			SupportedScopes: []string{"openid"},
 			CodeExpiration:  10 * time.Minute,
 			PKCERequired:    true,
+			AllowPlainPKCE:  true,
 		},
 	}
 
 	oauthService := services.NewOAuthService(cfg, nil)
+	grantConsent(t, oauthService, "test-client", "openid")
 
 	t.Run("Valid S256 PKCE", func(t *testing.T) {
 		codeVerifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
@@ -147,6 +161,7 @@ func TestPKCEVerification(t *testing.T) {
 
 		// Create authorization request
 		authReq := &models.AuthorizationRequest{
+			UserID:              "demo-user",
 			ResponseType:        "code",
 			ClientID:            "test-client",
 			RedirectURI:         "http://localhost:3000/callback",
@@ -183,6 +198,7 @@ func TestPKCEVerification(t *testing.T) {
 
 		// Create authorization request
 		authReq := &models.AuthorizationRequest{
+			UserID:              "demo-user",
 			ResponseType:        "code",
 			ClientID:            "test-client",
 			RedirectURI:         "http://localhost:3000/callback",
@@ -195,13 +211,13 @@ func TestPKCEVerification(t *testing.T) {
 		require.Nil(t, errorResp)
 		require.NotNil(t, authCode)
 
-		// Create token request with wrong verifier
+		// Create token request with a well-formed but wrong verifier
 		tokenReq := &models.TokenRequest{
 			GrantType:    "authorization_code",
 			Code:         authCode.Code,
 			RedirectURI:  authCode.RedirectURI,
 			ClientID:     authCode.ClientID,
-			CodeVerifier: "wrong-verifier",
+			CodeVerifier: "wrong-verifier-wrong-verifier-wrong-verifier",
 		}
 
 		_, errorResp = oauthService.HandleTokenRequest(tokenReq)
@@ -210,10 +226,11 @@ func TestPKCEVerification(t *testing.T) {
 	})
 
 	t.Run("Valid plain PKCE", func(t *testing.T) {
-		codeVerifier := "test-verifier"
+		codeVerifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
 
 		// Create authorization request
 		authReq := &models.AuthorizationRequest{
+			UserID:              "demo-user",
 			ResponseType:        "code",
 			ClientID:            "test-client",
 			RedirectURI:         "http://localhost:3000/callback",
@@ -251,10 +268,12 @@ func TestTokenRequestValidation(t *testing.T) {
 			SupportedScopes: []string{"openid"},
 			CodeExpiration:  10 * time.Minute,
 			PKCERequired:    true,
+			AllowPlainPKCE:  true,
 		},
 	}
 
 	oauthService := services.NewOAuthService(cfg, nil)
+	grantConsent(t, oauthService, "test-client", "openid")
 
 	t.Run("Invalid grant type", func(t *testing.T) {
 		tokenReq := &models.TokenRequest{
@@ -281,6 +300,7 @@ func TestTokenRequestValidation(t *testing.T) {
 	t.Run("Client ID mismatch", func(t *testing.T) {
 		// First create a valid authorization code
 		authReq := &models.AuthorizationRequest{
+			UserID:              "demo-user",
 			ResponseType:        "code",
 			ClientID:            "test-client",
 			RedirectURI:         "http://localhost:3000/callback",
@@ -310,6 +330,7 @@ func TestTokenRequestValidation(t *testing.T) {
 	t.Run("Redirect URI mismatch", func(t *testing.T) {
 		// First create a valid authorization code
 		authReq := &models.AuthorizationRequest{
+			UserID:              "demo-user",
 			ResponseType:        "code",
 			ClientID:            "test-client",
 			RedirectURI:         "http://localhost:3000/callback",
@@ -336,3 +357,238 @@ func TestTokenRequestValidation(t *testing.T) {
 		assert.Contains(t, errorResp.ErrorDescription, "Redirect URI mismatch")
 	})
 }
+
+func TestAuthorizationCodeReuse(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	oauthService := services.NewOAuthService(cfg, services.NewJWTService(fake.newClient(), cfg))
+	defer oauthService.Stop()
+	grantConsent(t, oauthService, "test-client", "openid")
+
+	authCode, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+		UserID:              "demo-user",
+		ResponseType:        "code",
+		ClientID:            "test-client",
+		RedirectURI:         "http://localhost:3000/callback",
+		Scope:               "openid",
+		CodeChallenge:       testCodeChallenge,
+		CodeChallengeMethod: "S256",
+	})
+	require.Nil(t, errorResp)
+
+	tokenReq := &models.TokenRequest{
+		GrantType:    "authorization_code",
+		Code:         authCode.Code,
+		RedirectURI:  authCode.RedirectURI,
+		ClientID:     "test-client",
+		CodeVerifier: testCodeVerifier,
+	}
+
+	reuses := testutil.ToFloat64(metrics.CodeReuseTotal)
+
+	_, errorResp = oauthService.HandleTokenRequest(tokenReq)
+	require.Nil(t, errorResp)
+	assert.Equal(t, reuses, testutil.ToFloat64(metrics.CodeReuseTotal))
+
+	// Replaying the consumed code is refused and reported
+	_, errorResp = oauthService.HandleTokenRequest(tokenReq)
+	require.NotNil(t, errorResp)
+	assert.Equal(t, "invalid_grant", errorResp.Error)
+	assert.Equal(t, reuses+1, testutil.ToFloat64(metrics.CodeReuseTotal))
+
+	// Codes that were never issued aren't counted as reuse
+	tokenReq.Code = "never-issued"
+	_, errorResp = oauthService.HandleTokenRequest(tokenReq)
+	require.NotNil(t, errorResp)
+	assert.Equal(t, "invalid_grant", errorResp.Error)
+	assert.Equal(t, reuses+1, testutil.ToFloat64(metrics.CodeReuseTotal))
+}
+
+// barrierStore holds up GetAuthCode until every expected reader has looked
+// the code up, so concurrent exchanges all pass validation before any of
+// them consumes the code
+type barrierStore struct {
+	*store.MemoryStore
+	readers sync.WaitGroup
+}
+
+func (b *barrierStore) GetAuthCode(code string) (*models.AuthorizationCode, error) {
+	authCode, err := b.MemoryStore.GetAuthCode(code)
+	b.readers.Done()
+	b.readers.Wait()
+	return authCode, err
+}
+
+func TestConcurrentAuthorizationCodeExchange(t *testing.T) {
+	const exchanges = 2
+
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	tokenStore := &barrierStore{MemoryStore: store.NewMemoryStore()}
+	oauthService := services.NewOAuthService(cfg, services.NewJWTService(fake.newClient(), cfg), services.WithTokenStore(tokenStore))
+	defer oauthService.Stop()
+	grantConsent(t, oauthService, "test-client", "openid")
+
+	authCode, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+		UserID:              "demo-user",
+		ResponseType:        "code",
+		ClientID:            "test-client",
+		RedirectURI:         "http://localhost:3000/callback",
+		Scope:               "openid",
+		CodeChallenge:       testCodeChallenge,
+		CodeChallengeMethod: "S256",
+	})
+	require.Nil(t, errorResp)
+
+	reuses := testutil.ToFloat64(metrics.CodeReuseTotal)
+
+	tokenStore.readers.Add(exchanges)
+	errorResps := make([]*models.ErrorResponse, exchanges)
+	var wg sync.WaitGroup
+	for i := 0; i < exchanges; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errorResps[i] = oauthService.HandleTokenRequest(&models.TokenRequest{
+				GrantType:    "authorization_code",
+				Code:         authCode.Code,
+				RedirectURI:  authCode.RedirectURI,
+				ClientID:     "test-client",
+				CodeVerifier: testCodeVerifier,
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, errorResp := range errorResps {
+		if errorResp == nil {
+			succeeded++
+			continue
+		}
+		assert.Equal(t, "invalid_grant", errorResp.Error)
+	}
+	assert.Equal(t, 1, succeeded, "exactly one exchange should get tokens")
+	assert.Equal(t, reuses+exchanges-1, testutil.ToFloat64(metrics.CodeReuseTotal))
+
+	_, refreshTokens, err := tokenStore.Counts()
+	require.NoError(t, err)
+	assert.Equal(t, 1, refreshTokens)
+}
+
+func TestRefreshTokenDownscoping(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+	defer oauthService.Stop()
+
+	tokens := issueTokens(t, oauthService, "openid profile email")
+
+	refresh := func(scope string) (*models.TokenResponse, *models.ErrorResponse) {
+		return oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:    "refresh_token",
+			ClientID:     "test-client",
+			RefreshToken: tokens.RefreshToken,
+			Scope:        scope,
+		})
+	}
+
+	t.Run("Narrower scope", func(t *testing.T) {
+		tokenResp, errorResp := refresh("profile")
+		require.Nil(t, errorResp)
+		assert.Equal(t, "profile", tokenResp.Scope)
+
+		claims, err := jwtService.ValidateAccessToken(tokenResp.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, "profile", claims.Scope)
+	})
+
+	t.Run("Escalation rejected", func(t *testing.T) {
+		narrowed, errorResp := refresh("openid")
+		require.Nil(t, errorResp)
+		assert.Equal(t, "openid", narrowed.Scope)
+
+		_, errorResp = refresh("openid admin")
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_scope", errorResp.Error)
+	})
+
+	t.Run("Omitted scope keeps the original", func(t *testing.T) {
+		// Earlier narrowed refreshes don't shrink the refresh token's scope
+		tokenResp, errorResp := refresh("")
+		require.Nil(t, errorResp)
+		assert.Equal(t, "openid profile email", tokenResp.Scope)
+
+		claims, err := jwtService.ValidateAccessToken(tokenResp.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, "openid profile email", claims.Scope)
+	})
+}
+
+func TestStateValidation(t *testing.T) {
+	authorize := func(cfg *config.Config, state string) *models.ErrorResponse {
+		oauthService := services.NewOAuthService(cfg, nil)
+		defer oauthService.Stop()
+		grantConsent(t, oauthService, "test-client", "openid")
+
+		_, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+			UserID:              "demo-user",
+			ResponseType:        "code",
+			ClientID:            "test-client",
+			RedirectURI:         "http://localhost:3000/callback",
+			Scope:               "openid",
+			State:               state,
+			CodeChallenge:       testCodeChallenge,
+			CodeChallengeMethod: "S256",
+		})
+		return errorResp
+	}
+
+	cfg := newTestConfig()
+	cfg.OAuth.MinStateLength = 16
+	cfg.OAuth.MaxStateLength = 64
+
+	t.Run("Acceptable state", func(t *testing.T) {
+		assert.Nil(t, authorize(cfg, strings.Repeat("s", 16)))
+		assert.Nil(t, authorize(cfg, strings.Repeat("s", 64)))
+	})
+
+	t.Run("Empty state allowed by default", func(t *testing.T) {
+		assert.Nil(t, authorize(cfg, ""))
+	})
+
+	t.Run("Too short", func(t *testing.T) {
+		errorResp := authorize(cfg, "xyz")
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_request", errorResp.Error)
+		assert.Contains(t, errorResp.ErrorDescription, "at least 16")
+		assert.Empty(t, errorResp.State)
+	})
+
+	t.Run("Too long", func(t *testing.T) {
+		errorResp := authorize(cfg, strings.Repeat("s", 65))
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_request", errorResp.Error)
+		assert.Contains(t, errorResp.ErrorDescription, "at most 64")
+		assert.Empty(t, errorResp.State)
+	})
+
+	t.Run("Default cap", func(t *testing.T) {
+		errorResp := authorize(newTestConfig(), strings.Repeat("s", 1025))
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_request", errorResp.Error)
+		assert.Nil(t, authorize(newTestConfig(), strings.Repeat("s", 1024)))
+	})
+
+	t.Run("Missing when required", func(t *testing.T) {
+		required := newTestConfig()
+		required.OAuth.RequireState = true
+
+		errorResp := authorize(required, "")
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_request", errorResp.Error)
+		assert.Equal(t, "state is required", errorResp.ErrorDescription)
+		assert.Nil(t, authorize(required, "xyz"))
+	})
+}
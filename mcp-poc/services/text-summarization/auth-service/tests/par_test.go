@@ -0,0 +1,185 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/config"
+	"auth-service/internal/handlers"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+)
+
+func newPARHandler(t *testing.T, cfg *config.Config) *handlers.OAuthHandler {
+	oauthService := services.NewOAuthService(cfg, nil)
+	grantConsent(t, oauthService, "test-client", "openid profile")
+	return handlers.NewOAuthHandler(oauthService, nil)
+}
+
+func pushRequest(handler *handlers.OAuthHandler, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/par", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler.HandlePAR(rec, req)
+	return rec
+}
+
+func authorizeWithRequestURI(handler *handlers.OAuthHandler, clientID, requestURI string) *httptest.ResponseRecorder {
+	query := url.Values{"client_id": {clientID}, "request_uri": {requestURI}}
+	req := httptest.NewRequest(http.MethodGet, "/authorize?"+query.Encode(), nil)
+	rec := httptest.NewRecorder()
+	handler.HandleAuthorize(rec, req)
+	return rec
+}
+
+func parForm() url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"test-client"},
+		"redirect_uri":          {"http://localhost:3000/callback"},
+		"scope":                 {"openid profile"},
+		"state":                 {"xyz"},
+		"code_challenge":        {testCodeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+func TestPushedAuthorizationRequest(t *testing.T) {
+	t.Run("Push then authorize", func(t *testing.T) {
+		handler := newPARHandler(t, newTestConfig())
+
+		rec := pushRequest(handler, parForm())
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+
+		var pushed models.PushedAuthorizationResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pushed))
+		assert.True(t, strings.HasPrefix(pushed.RequestURI, "urn:ietf:params:oauth:request_uri:"))
+		assert.Equal(t, int64(60), pushed.ExpiresIn)
+
+		rec = authorizeWithRequestURI(handler, "test-client", pushed.RequestURI)
+		require.Equal(t, http.StatusFound, rec.Code)
+
+		location, err := url.Parse(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, "localhost:3000", location.Host)
+		assert.NotEmpty(t, location.Query().Get("code"))
+		assert.Equal(t, "xyz", location.Query().Get("state"))
+
+		// A request_uri is single use
+		rec = authorizeWithRequestURI(handler, "test-client", pushed.RequestURI)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid_request_uri")
+	})
+
+	t.Run("Replayed nonce doesn't use up the request_uri", func(t *testing.T) {
+		handler := newPARHandler(t, newTestConfig())
+
+		form := parForm()
+		form.Set("nonce", "n-0S6_WzA2Mj")
+		var requestURIs []string
+		for i := 0; i < 2; i++ {
+			rec := pushRequest(handler, form)
+			require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+			var pushed models.PushedAuthorizationResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pushed))
+			requestURIs = append(requestURIs, pushed.RequestURI)
+		}
+
+		rec := authorizeWithRequestURI(handler, "test-client", requestURIs[0])
+		require.Equal(t, http.StatusFound, rec.Code)
+		assert.NotEmpty(t, redirectParams(t, rec).Get("code"))
+
+		// The second request is refused for its nonce each time, rather than
+		// having been used up by the first refusal
+		for i := 0; i < 2; i++ {
+			rec = authorizeWithRequestURI(handler, "test-client", requestURIs[1])
+			params := redirectParams(t, rec)
+			assert.Equal(t, "invalid_request", params.Get("error"))
+			assert.Contains(t, params.Get("error_description"), "nonce")
+		}
+	})
+
+	t.Run("Expired request_uri", func(t *testing.T) {
+		cfg := newTestConfig()
+		cfg.OAuth.PARExpiration = 10 * time.Millisecond
+		handler := newPARHandler(t, cfg)
+
+		rec := pushRequest(handler, parForm())
+		require.Equal(t, http.StatusCreated, rec.Code)
+
+		var pushed models.PushedAuthorizationResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pushed))
+
+		time.Sleep(20 * time.Millisecond)
+
+		rec = authorizeWithRequestURI(handler, "test-client", pushed.RequestURI)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Empty(t, rec.Header().Get("Location"))
+		assert.Contains(t, rec.Body.String(), "expired")
+	})
+
+	t.Run("request_uri used by another client", func(t *testing.T) {
+		cfg := newTestConfig()
+		cfg.OAuth.Clients = []config.ClientConfig{
+			{ClientID: "test-client", RedirectURIs: []string{"http://localhost:3000/callback"}},
+			{ClientID: "other-client", RedirectURIs: []string{"http://localhost:4000/callback"}},
+		}
+		handler := newPARHandler(t, cfg)
+
+		rec := pushRequest(handler, parForm())
+		require.Equal(t, http.StatusCreated, rec.Code)
+
+		var pushed models.PushedAuthorizationResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pushed))
+
+		rec = authorizeWithRequestURI(handler, "other-client", pushed.RequestURI)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid_request_uri")
+	})
+
+	t.Run("Invalid pushed request is rejected", func(t *testing.T) {
+		handler := newPARHandler(t, newTestConfig())
+
+		form := parForm()
+		form.Set("redirect_uri", "https://evil.example.com/callback")
+		rec := pushRequest(handler, form)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid_request")
+		assert.Empty(t, rec.Header().Get("Location"))
+	})
+
+	t.Run("Confidential client must authenticate", func(t *testing.T) {
+		cfg := newTestConfig()
+		cfg.OAuth.Clients = []config.ClientConfig{
+			{ClientID: "test-client", ClientSecret: "s3cret", RedirectURIs: []string{"http://localhost:3000/callback"}},
+		}
+		handler := newPARHandler(t, cfg)
+
+		rec := pushRequest(handler, parForm())
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, `Basic realm="auth-service"`, rec.Header().Get("WWW-Authenticate"))
+
+		form := parForm()
+		form.Set("client_secret", "s3cret")
+		rec = pushRequest(handler, form)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	})
+
+	t.Run("Method not allowed", func(t *testing.T) {
+		handler := newPARHandler(t, newTestConfig())
+
+		req := httptest.NewRequest(http.MethodGet, "/par", nil)
+		rec := httptest.NewRecorder()
+		handler.HandlePAR(rec, req)
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	})
+}
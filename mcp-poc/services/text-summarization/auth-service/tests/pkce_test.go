@@ -0,0 +1,295 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+	"auth-service/internal/store"
+)
+
+func TestPlainPKCEToggle(t *testing.T) {
+	plainRequest := func() *models.AuthorizationRequest {
+		return &models.AuthorizationRequest{
+			UserID:              "demo-user",
+			ResponseType:        "code",
+			ClientID:            "test-client",
+			RedirectURI:         "http://localhost:3000/callback",
+			Scope:               "openid",
+			CodeChallenge:       testCodeVerifier,
+			CodeChallengeMethod: "plain",
+		}
+	}
+
+	t.Run("Plain rejected by default", func(t *testing.T) {
+		oauthService := services.NewOAuthService(newTestConfig(), nil)
+
+		authCode, errorResp := oauthService.HandleAuthorizationRequest(plainRequest())
+		assert.Nil(t, authCode)
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_request", errorResp.Error)
+		assert.Contains(t, errorResp.ErrorDescription, "plain")
+	})
+
+	t.Run("Missing method defaults to plain and is rejected", func(t *testing.T) {
+		oauthService := services.NewOAuthService(newTestConfig(), nil)
+
+		req := plainRequest()
+		req.CodeChallengeMethod = ""
+		_, errorResp := oauthService.HandleAuthorizationRequest(req)
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_request", errorResp.Error)
+	})
+
+	t.Run("Plain accepted for legacy clients", func(t *testing.T) {
+		cfg := newTestConfig()
+		cfg.OAuth.AllowPlainPKCE = true
+		oauthService := services.NewOAuthService(cfg, nil)
+		grantConsent(t, oauthService, "test-client", "openid")
+
+		authCode, errorResp := oauthService.HandleAuthorizationRequest(plainRequest())
+		require.Nil(t, errorResp)
+		assert.Equal(t, "plain", authCode.CodeChallengeMethod)
+
+		_, errorResp = oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:    "authorization_code",
+			Code:         authCode.Code,
+			RedirectURI:  authCode.RedirectURI,
+			ClientID:     authCode.ClientID,
+			CodeVerifier: testCodeVerifier,
+		})
+		// PKCE passes; the nil JWT service causes a server_error afterwards
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "server_error", errorResp.Error)
+	})
+
+	t.Run("Stored plain code refused when plain is disabled", func(t *testing.T) {
+		tokenStore := store.NewMemoryStore()
+		oauthService := services.NewOAuthService(newTestConfig(), nil, services.WithTokenStore(tokenStore))
+
+		require.NoError(t, tokenStore.SaveAuthCode(&models.AuthorizationCode{
+			Code:                "legacy-code",
+			ClientID:            "test-client",
+			RedirectURI:         "http://localhost:3000/callback",
+			CodeChallenge:       testCodeVerifier,
+			CodeChallengeMethod: "plain",
+			ExpiresAt:           time.Now().Add(time.Minute),
+		}))
+
+		_, errorResp := oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:    "authorization_code",
+			Code:         "legacy-code",
+			RedirectURI:  "http://localhost:3000/callback",
+			ClientID:     "test-client",
+			CodeVerifier: testCodeVerifier,
+		})
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_grant", errorResp.Error)
+	})
+
+	for _, allowPlain := range []bool{false, true} {
+		t.Run(fmt.Sprintf("Downgrade from S256 to plain-style verifier (allow plain=%v)", allowPlain), func(t *testing.T) {
+			cfg := newTestConfig()
+			cfg.OAuth.AllowPlainPKCE = allowPlain
+			oauthService := services.NewOAuthService(cfg, nil)
+			grantConsent(t, oauthService, "test-client", "openid")
+
+			authCode, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+				UserID:              "demo-user",
+				ResponseType:        "code",
+				ClientID:            "test-client",
+				RedirectURI:         "http://localhost:3000/callback",
+				Scope:               "openid",
+				CodeChallenge:       testCodeChallenge,
+				CodeChallengeMethod: "S256",
+			})
+			require.Nil(t, errorResp)
+
+			// Presenting the challenge itself as the verifier would pass a plain comparison
+			_, errorResp = oauthService.HandleTokenRequest(&models.TokenRequest{
+				GrantType:    "authorization_code",
+				Code:         authCode.Code,
+				RedirectURI:  authCode.RedirectURI,
+				ClientID:     authCode.ClientID,
+				CodeVerifier: testCodeChallenge,
+			})
+			require.NotNil(t, errorResp)
+			assert.Equal(t, "invalid_grant", errorResp.Error)
+		})
+	}
+
+	t.Run("Discovery advertises only allowed methods", func(t *testing.T) {
+		assert.Equal(t, []string{"S256"}, services.NewOAuthService(newTestConfig(), nil).GetDiscoveryDocument().CodeChallengeMethodsSupported)
+
+		cfg := newTestConfig()
+		cfg.OAuth.AllowPlainPKCE = true
+		assert.Equal(t, []string{"S256", "plain"}, services.NewOAuthService(cfg, nil).GetDiscoveryDocument().CodeChallengeMethodsSupported)
+	})
+}
+
+func TestRequireS256(t *testing.T) {
+	request := func(method string) *models.AuthorizationRequest {
+		return &models.AuthorizationRequest{
+			UserID:              "demo-user",
+			ResponseType:        "code",
+			ClientID:            "test-client",
+			RedirectURI:         "http://localhost:3000/callback",
+			Scope:               "openid",
+			CodeChallenge:       testCodeVerifier,
+			CodeChallengeMethod: method,
+		}
+	}
+
+	// AllowPlainPKCE is on in both cases so only RequireS256 differs
+	newService := func(requireS256 bool) *services.OAuthService {
+		cfg := newTestConfig()
+		cfg.OAuth.AllowPlainPKCE = true
+		cfg.OAuth.RequireS256 = requireS256
+		oauthService := services.NewOAuthService(cfg, nil)
+		grantConsent(t, oauthService, "test-client", "openid")
+		return oauthService
+	}
+
+	t.Run("Plain rejected when S256 is required", func(t *testing.T) {
+		_, errorResp := newService(true).HandleAuthorizationRequest(request("plain"))
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_request", errorResp.Error)
+	})
+
+	t.Run("Missing method rejected when S256 is required", func(t *testing.T) {
+		_, errorResp := newService(true).HandleAuthorizationRequest(request(""))
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_request", errorResp.Error)
+		assert.Contains(t, errorResp.ErrorDescription, "S256")
+	})
+
+	t.Run("Plain accepted when S256 is not required", func(t *testing.T) {
+		authCode, errorResp := newService(false).HandleAuthorizationRequest(request("plain"))
+		require.Nil(t, errorResp)
+		assert.Equal(t, "plain", authCode.CodeChallengeMethod)
+	})
+
+	t.Run("Stored plain code refused when S256 is required", func(t *testing.T) {
+		tokenStore := store.NewMemoryStore()
+		cfg := newTestConfig()
+		cfg.OAuth.AllowPlainPKCE = true
+		cfg.OAuth.RequireS256 = true
+		oauthService := services.NewOAuthService(cfg, nil, services.WithTokenStore(tokenStore))
+
+		require.NoError(t, tokenStore.SaveAuthCode(&models.AuthorizationCode{
+			Code:                "legacy-code",
+			ClientID:            "test-client",
+			RedirectURI:         "http://localhost:3000/callback",
+			CodeChallenge:       testCodeVerifier,
+			CodeChallengeMethod: "plain",
+			ExpiresAt:           time.Now().Add(time.Minute),
+		}))
+
+		_, errorResp := oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:    "authorization_code",
+			Code:         "legacy-code",
+			RedirectURI:  "http://localhost:3000/callback",
+			ClientID:     "test-client",
+			CodeVerifier: testCodeVerifier,
+		})
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_grant", errorResp.Error)
+	})
+
+	t.Run("Discovery advertises only S256", func(t *testing.T) {
+		doc := newService(true).GetDiscoveryDocument()
+		assert.Equal(t, []string{"S256"}, doc.CodeChallengeMethodsSupported)
+	})
+}
+
+func TestPKCEFormatValidation(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	oauthService := services.NewOAuthService(cfg, services.NewJWTService(fake.newClient(), cfg))
+	grantConsent(t, oauthService, "test-client", "openid")
+
+	authorize := func(challenge string) *models.ErrorResponse {
+		_, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+			UserID:              "demo-user",
+			ResponseType:        "code",
+			ClientID:            "test-client",
+			RedirectURI:         "http://localhost:3000/callback",
+			Scope:               "openid",
+			CodeChallenge:       challenge,
+			CodeChallengeMethod: "S256",
+		})
+		return errorResp
+	}
+
+	t.Run("Too short S256 challenge", func(t *testing.T) {
+		errorResp := authorize("E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw")
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_request", errorResp.Error)
+		assert.Contains(t, errorResp.ErrorDescription, "code_challenge")
+	})
+
+	t.Run("Non-base64url S256 challenge", func(t *testing.T) {
+		errorResp := authorize("E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw+c/")
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_request", errorResp.Error)
+	})
+
+	t.Run("Well-formed S256 challenge", func(t *testing.T) {
+		assert.Nil(t, authorize(testCodeChallenge))
+	})
+
+	exchange := func(t *testing.T, verifier string) *models.ErrorResponse {
+		hash := sha256.Sum256([]byte(verifier))
+
+		authCode, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+			UserID:              "demo-user",
+			ResponseType:        "code",
+			ClientID:            "test-client",
+			RedirectURI:         "http://localhost:3000/callback",
+			Scope:               "openid",
+			CodeChallenge:       base64.RawURLEncoding.EncodeToString(hash[:]),
+			CodeChallengeMethod: "S256",
+		})
+		require.Nil(t, errorResp)
+
+		_, errorResp = oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:    "authorization_code",
+			Code:         authCode.Code,
+			RedirectURI:  authCode.RedirectURI,
+			ClientID:     authCode.ClientID,
+			CodeVerifier: verifier,
+		})
+		return errorResp
+	}
+
+	t.Run("Too long code verifier", func(t *testing.T) {
+		errorResp := exchange(t, strings.Repeat("a", 129))
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_request", errorResp.Error)
+		assert.Contains(t, errorResp.ErrorDescription, "between 43 and 128")
+	})
+
+	t.Run("Too short code verifier", func(t *testing.T) {
+		errorResp := exchange(t, strings.Repeat("a", 42))
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_request", errorResp.Error)
+	})
+
+	t.Run("Code verifier with invalid character", func(t *testing.T) {
+		errorResp := exchange(t, strings.Repeat("a", 42)+"+")
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_request", errorResp.Error)
+	})
+
+	t.Run("Valid 43-character code verifier", func(t *testing.T) {
+		assert.Nil(t, exchange(t, "AZaz09-._~"+strings.Repeat("x", 33)))
+	})
+}
@@ -0,0 +1,226 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/handlers"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+)
+
+// sessionAuthenticator reports a fixed session and records the logins it
+// is asked to start
+type sessionAuthenticator struct {
+	userID   string
+	authTime time.Time
+	returnTo []string
+}
+
+func (s *sessionAuthenticator) Authenticate(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if s.userID == "" {
+		s.Login(w, r, r.URL.RequestURI())
+		return "", false
+	}
+	return s.userID, true
+}
+
+func (s *sessionAuthenticator) Session(r *http.Request) (string, time.Time, bool) {
+	return s.userID, s.authTime, s.userID != ""
+}
+
+func (s *sessionAuthenticator) Login(w http.ResponseWriter, r *http.Request, returnTo string) {
+	s.returnTo = append(s.returnTo, returnTo)
+	http.Redirect(w, r, "/login?return_to="+url.QueryEscape(returnTo), http.StatusFound)
+}
+
+// redirectParams returns the query of the redirect to the client
+func redirectParams(t *testing.T, rec *httptest.ResponseRecorder) url.Values {
+	t.Helper()
+
+	require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+	location, err := url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, "localhost:3000", location.Host, "not redirected to the client")
+	return location.Query()
+}
+
+func TestPromptAndMaxAge(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+	defer oauthService.Stop()
+	grantConsent(t, oauthService, "test-client", "openid")
+
+	newHandler := func(sessions *sessionAuthenticator) *handlers.OAuthHandler {
+		return handlers.NewOAuthHandler(oauthService, jwtService, handlers.WithAuthenticator(sessions))
+	}
+	query := func(params map[string]string) url.Values {
+		query := authorizeQuery()
+		for name, value := range params {
+			query.Set(name, value)
+		}
+		return query
+	}
+
+	t.Run("prompt=none without a session", func(t *testing.T) {
+		sessions := &sessionAuthenticator{}
+		params := redirectParams(t, authorize(newHandler(sessions), query(map[string]string{"prompt": "none"})))
+
+		assert.Equal(t, "login_required", params.Get("error"))
+		assert.Equal(t, "xyz", params.Get("state"))
+		assert.Empty(t, params.Get("code"))
+		assert.Empty(t, sessions.returnTo, "prompt=none must not start a login")
+	})
+
+	t.Run("prompt=none with a session", func(t *testing.T) {
+		sessions := &sessionAuthenticator{userID: "demo-user", authTime: time.Now().Add(-time.Hour)}
+		params := redirectParams(t, authorize(newHandler(sessions), query(map[string]string{"prompt": "none"})))
+		assert.NotEmpty(t, params.Get("code"))
+	})
+
+	t.Run("max_age exceeded", func(t *testing.T) {
+		sessions := &sessionAuthenticator{userID: "demo-user", authTime: time.Now().Add(-10 * time.Minute)}
+		handler := newHandler(sessions)
+
+		rec := authorize(handler, query(map[string]string{"max_age": "60"}))
+		require.Equal(t, http.StatusFound, rec.Code)
+		assert.Contains(t, rec.Header().Get("Location"), "/login")
+		require.Len(t, sessions.returnTo, 1)
+
+		// The login satisfies max_age, so the user comes back without it
+		returnTo, err := url.Parse(sessions.returnTo[0])
+		require.NoError(t, err)
+		assert.Equal(t, "/authorize", returnTo.Path)
+		assert.Empty(t, returnTo.Query().Get("max_age"))
+		assert.Equal(t, "xyz", returnTo.Query().Get("state"))
+
+		// Silently, the stale session is an error for the client
+		params := redirectParams(t, authorize(handler, query(map[string]string{"max_age": "60", "prompt": "none"})))
+		assert.Equal(t, "login_required", params.Get("error"))
+	})
+
+	t.Run("Fresh session within max_age sets auth_time", func(t *testing.T) {
+		authTime := time.Now().Add(-30 * time.Second)
+		sessions := &sessionAuthenticator{userID: "demo-user", authTime: authTime}
+		params := redirectParams(t, authorize(newHandler(sessions), query(map[string]string{"max_age": "60"})))
+		require.NotEmpty(t, params.Get("code"))
+		assert.Empty(t, sessions.returnTo)
+
+		tokenResp, errorResp := oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:    "authorization_code",
+			Code:         params.Get("code"),
+			RedirectURI:  "http://localhost:3000/callback",
+			ClientID:     "test-client",
+			CodeVerifier: testCodeVerifier,
+		})
+		require.Nil(t, errorResp)
+		assert.Equal(t, float64(authTime.Unix()), tokenClaims(t, tokenResp.IDToken)["auth_time"])
+	})
+
+	t.Run("prompt=login forces a login", func(t *testing.T) {
+		sessions := &sessionAuthenticator{userID: "demo-user", authTime: time.Now()}
+		handler := newHandler(sessions)
+
+		rec := authorize(handler, query(map[string]string{"prompt": "login"}))
+		require.Equal(t, http.StatusFound, rec.Code)
+		require.Len(t, sessions.returnTo, 1)
+
+		// Once logged in, the request comes back without prompt=login
+		req := httptest.NewRequest(http.MethodGet, sessions.returnTo[0], nil)
+		rec = httptest.NewRecorder()
+		handler.HandleAuthorize(rec, req)
+		assert.NotEmpty(t, redirectParams(t, rec).Get("code"))
+		assert.Len(t, sessions.returnTo, 1)
+	})
+
+	t.Run("prompt=login with a pushed request", func(t *testing.T) {
+		sessions := &sessionAuthenticator{userID: "demo-user", authTime: time.Now().Add(-time.Minute)}
+		handler := newHandler(sessions)
+
+		form := parForm()
+		form.Set("scope", "openid")
+		form.Set("prompt", "login")
+		rec := pushRequest(handler, form)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		var pushed models.PushedAuthorizationResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pushed))
+
+		rec = authorizeWithRequestURI(handler, "test-client", pushed.RequestURI)
+		require.Equal(t, http.StatusFound, rec.Code)
+		require.Len(t, sessions.returnTo, 1)
+
+		// A login after the request was pushed satisfies it
+		sessions.authTime = time.Now().Add(time.Second)
+		rec = authorizeWithRequestURI(handler, "test-client", pushed.RequestURI)
+		assert.NotEmpty(t, redirectParams(t, rec).Get("code"))
+	})
+
+	t.Run("prompt and max_age need a session authenticator", func(t *testing.T) {
+		signedIn := handlers.AuthenticatorFunc(func(w http.ResponseWriter, r *http.Request) (string, bool) {
+			return "demo-user", true
+		})
+		tests := map[string]struct {
+			params map[string]string
+			error  string
+		}{
+			"prompt=none":  {map[string]string{"prompt": "none"}, "interaction_required"},
+			"prompt=login": {map[string]string{"prompt": "login"}, "login_required"},
+			"max_age":      {map[string]string{"max_age": "60"}, "interaction_required"},
+		}
+		for name, test := range tests {
+			t.Run(name, func(t *testing.T) {
+				for _, authenticator := range []handlers.Authenticator{loginRedirect, signedIn} {
+					handler := handlers.NewOAuthHandler(oauthService, jwtService, handlers.WithAuthenticator(authenticator))
+					params := redirectParams(t, authorize(handler, query(test.params)))
+					assert.Equal(t, test.error, params.Get("error"))
+					assert.Equal(t, "xyz", params.Get("state"))
+					assert.Empty(t, params.Get("code"))
+				}
+			})
+		}
+
+		// Without either, the request goes ahead
+		handler := handlers.NewOAuthHandler(oauthService, jwtService, handlers.WithAuthenticator(signedIn))
+		assert.NotEmpty(t, redirectParams(t, authorize(handler, authorizeQuery())).Get("code"))
+	})
+
+	t.Run("prompt=none doesn't ask for consent", func(t *testing.T) {
+		prompted := false
+		sessions := &sessionAuthenticator{userID: "new-user", authTime: time.Now()}
+		handler := handlers.NewOAuthHandler(oauthService, jwtService,
+			handlers.WithAuthenticator(sessions),
+			handlers.WithConsentPrompter(handlers.ConsentPrompterFunc(func(w http.ResponseWriter, r *http.Request, req *models.AuthorizationRequest) {
+				prompted = true
+			})))
+
+		params := redirectParams(t, authorize(handler, query(map[string]string{"prompt": "none"})))
+		assert.Equal(t, "consent_required", params.Get("error"))
+		assert.False(t, prompted)
+	})
+
+	t.Run("Invalid parameters", func(t *testing.T) {
+		tests := map[string]map[string]string{
+			"Unknown prompt":      {"prompt": "sometimes"},
+			"none with login":     {"prompt": "none login"},
+			"Negative max_age":    {"max_age": "-1"},
+			"Non-numeric max_age": {"max_age": "an hour"},
+			"Fractional max_age":  {"max_age": "1.5"},
+		}
+		for name, params := range tests {
+			t.Run(name, func(t *testing.T) {
+				sessions := &sessionAuthenticator{userID: "demo-user", authTime: time.Now()}
+				redirect := redirectParams(t, authorize(newHandler(sessions), query(params)))
+				assert.Equal(t, "invalid_request", redirect.Get("error"))
+			})
+		}
+	})
+}
@@ -0,0 +1,146 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/config"
+	"auth-service/internal/middleware"
+	"auth-service/pkg/metrics"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	okHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tokenRequest := func(handler http.Handler, clientID, remoteAddr string) *httptest.ResponseRecorder {
+		form := url.Values{"grant_type": {"authorization_code"}}
+		if clientID != "" {
+			form.Set("client_id", clientID)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Requests past the burst are throttled", func(t *testing.T) {
+		router := mux.NewRouter()
+		router.Handle("/token", okHandler)
+		router.Use(middleware.RateLimitMiddleware(config.RateLimitConfig{RequestsPerSecond: 0.1, Burst: 3, ByClientID: true}))
+		throttled := testutil.ToFloat64(metrics.RateLimitedRequestsTotal.WithLabelValues("/token"))
+
+		for i := 0; i < 3; i++ {
+			rec := tokenRequest(router, "test-client", "10.0.0.1:1234")
+			require.Equal(t, http.StatusOK, rec.Code, "request %d", i+1)
+		}
+
+		rec := tokenRequest(router, "test-client", "10.0.0.1:1234")
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+		retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, retryAfter, 1)
+		assert.LessOrEqual(t, retryAfter, 10)
+
+		assert.Equal(t, throttled+1, testutil.ToFloat64(metrics.RateLimitedRequestsTotal.WithLabelValues("/token")))
+	})
+
+	t.Run("Throttled requests are counted by route template", func(t *testing.T) {
+		router := mux.NewRouter()
+		router.Handle("/clients/{id}", okHandler)
+		router.Use(middleware.RateLimitMiddleware(config.RateLimitConfig{RequestsPerSecond: 0.1, Burst: 1}))
+		throttled := testutil.ToFloat64(metrics.RateLimitedRequestsTotal.WithLabelValues("/clients/{id}"))
+
+		for i, id := range []string{"client-a", "client-b", "client-c"} {
+			req := httptest.NewRequest(http.MethodGet, "/clients/"+id, nil)
+			req.RemoteAddr = "10.0.0.9:1234"
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if i > 0 {
+				assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+			}
+		}
+
+		assert.Equal(t, throttled+2, testutil.ToFloat64(metrics.RateLimitedRequestsTotal.WithLabelValues("/clients/{id}")))
+		assert.Zero(t, testutil.ToFloat64(metrics.RateLimitedRequestsTotal.WithLabelValues("/clients/client-b")))
+	})
+
+	t.Run("Rotating client IDs still uses up the IP's bucket", func(t *testing.T) {
+		const burst = 3
+		handler := middleware.RateLimitMiddleware(config.RateLimitConfig{RequestsPerSecond: 0.1, Burst: burst, ByClientID: true})(okHandler)
+
+		for i := 0; i < burst; i++ {
+			rec := tokenRequest(handler, fmt.Sprintf("client-%d", i), "10.0.0.1:1234")
+			require.Equal(t, http.StatusOK, rec.Code, "request %d", i+1)
+		}
+		for i := 0; i < 5; i++ {
+			rec := tokenRequest(handler, fmt.Sprintf("fresh-client-%d", i), "10.0.0.1:1234")
+			assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		}
+		assert.Equal(t, http.StatusOK, tokenRequest(handler, "client-0", "10.0.0.2:1234").Code)
+	})
+
+	t.Run("Client IDs don't span IPs", func(t *testing.T) {
+		handler := middleware.RateLimitMiddleware(config.RateLimitConfig{RequestsPerSecond: 0.1, Burst: 1, ByClientID: true})(okHandler)
+
+		// Another caller naming the client can't use up its requests
+		assert.Equal(t, http.StatusOK, tokenRequest(handler, "victim-client", "10.0.0.9:1234").Code)
+		assert.Equal(t, http.StatusTooManyRequests, tokenRequest(handler, "victim-client", "10.0.0.9:1234").Code)
+		assert.Equal(t, http.StatusOK, tokenRequest(handler, "victim-client", "10.0.0.1:1234").Code)
+	})
+
+	t.Run("Requests without client_id are keyed by IP", func(t *testing.T) {
+		handler := middleware.RateLimitMiddleware(config.RateLimitConfig{RequestsPerSecond: 0.1, Burst: 1, ByClientID: true})(okHandler)
+
+		assert.Equal(t, http.StatusOK, tokenRequest(handler, "", "10.0.0.1:1234").Code)
+		// A different source port is still the same caller
+		assert.Equal(t, http.StatusTooManyRequests, tokenRequest(handler, "", "10.0.0.1:5678").Code)
+		assert.Equal(t, http.StatusOK, tokenRequest(handler, "", "10.0.0.2:1234").Code)
+	})
+
+	t.Run("Basic auth client_id doesn't escape the IP's bucket", func(t *testing.T) {
+		handler := middleware.RateLimitMiddleware(config.RateLimitConfig{RequestsPerSecond: 0.1, Burst: 1, ByClientID: true})(okHandler)
+
+		send := func(clientID string) int {
+			req := httptest.NewRequest(http.MethodPost, "/token", nil)
+			req.SetBasicAuth(clientID, "secret")
+			req.RemoteAddr = "10.0.0.1:1234"
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		assert.Equal(t, http.StatusOK, send("client-a"))
+		assert.Equal(t, http.StatusTooManyRequests, send("client-a"))
+		assert.Equal(t, http.StatusTooManyRequests, send("client-b"))
+	})
+
+	t.Run("Burst of N+1 from one IP when keyed by IP only", func(t *testing.T) {
+		const burst = 5
+		handler := middleware.RateLimitMiddleware(config.RateLimitConfig{RequestsPerSecond: 0.1, Burst: burst})(okHandler)
+
+		// Rotating client_id doesn't buy a fresh bucket
+		for i := 0; i < burst; i++ {
+			rec := tokenRequest(handler, fmt.Sprintf("client-%d", i), "10.0.0.1:1234")
+			require.Equal(t, http.StatusOK, rec.Code, "request %d", i+1)
+		}
+
+		rec := tokenRequest(handler, "client-new", "10.0.0.1:1234")
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+	})
+}
@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"auth-service/internal/config"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+)
+
+func TestRedirectURIMatching(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.OAuth.Clients = []config.ClientConfig{{
+		ClientID:     "test-client",
+		RedirectURIs: []string{"https://app.example.com/callback", "http://localhost:3000/callback?tenant=acme"},
+	}}
+	cfg.OAuth.RedirectURIAllowedParams = []string{"locale"}
+
+	oauthService := services.NewOAuthService(cfg, nil)
+	defer oauthService.Stop()
+	grantConsent(t, oauthService, "test-client", "openid")
+
+	tests := []struct {
+		name        string
+		redirectURI string
+		valid       bool
+	}{
+		{"Exact match", "https://app.example.com/callback", true},
+		{"Case-insensitive scheme and host", "HTTPS://App.Example.COM/callback", true},
+		{"Default port", "https://app.example.com:443/callback", true},
+		{"Allowed extra query parameter", "https://app.example.com/callback?locale=de", true},
+		{"Registered query parameter", "http://localhost:3000/callback?tenant=acme&locale=fr", true},
+		{"Trailing slash", "https://app.example.com/callback/", false},
+		{"Path case differs", "https://app.example.com/Callback", false},
+		{"Extra path segment", "https://app.example.com/callback/../../evil", false},
+		{"Attacker path suffix", "https://app.example.com/callback/redirect?to=https://evil.com", false},
+		{"Unlisted query parameter", "https://app.example.com/callback?next=https://evil.com", false},
+		{"Registered query parameter changed", "http://localhost:3000/callback?tenant=other", false},
+		{"Registered query parameter missing", "http://localhost:3000/callback", false},
+		{"Other port", "https://app.example.com:8443/callback", false},
+		{"Other scheme", "http://app.example.com/callback", false},
+		{"Userinfo", "https://evil.com@app.example.com/callback", false},
+		{"Fragment", "https://app.example.com/callback#frag", false},
+		{"Relative", "/callback", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+				UserID:              "demo-user",
+				ResponseType:        "code",
+				ClientID:            "test-client",
+				RedirectURI:         tt.redirectURI,
+				Scope:               "openid",
+				CodeChallenge:       testCodeChallenge,
+				CodeChallengeMethod: "S256",
+			})
+
+			if tt.valid {
+				assert.Nil(t, errorResp)
+				return
+			}
+			if assert.NotNil(t, errorResp) {
+				assert.Equal(t, "invalid_request", errorResp.Error)
+				assert.Equal(t, "Invalid redirect_uri", errorResp.ErrorDescription)
+			}
+		})
+	}
+}
+
+func TestLoopbackRedirectURIPorts(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.OAuth.Clients = []config.ClientConfig{{
+		ClientID: "test-client",
+		RedirectURIs: []string{
+			"http://127.0.0.1/callback",
+			"http://[::1]:8080/callback",
+			"http://localhost:3000/callback",
+			"https://app.example.com:8443/callback",
+		},
+	}}
+
+	authorize := func(oauthService *services.OAuthService, redirectURI string) *models.ErrorResponse {
+		_, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+			UserID:              "demo-user",
+			ResponseType:        "code",
+			ClientID:            "test-client",
+			RedirectURI:         redirectURI,
+			Scope:               "openid",
+			CodeChallenge:       testCodeChallenge,
+			CodeChallengeMethod: "S256",
+		})
+		return errorResp
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		oauthService := services.NewOAuthService(cfg, nil)
+		defer oauthService.Stop()
+		grantConsent(t, oauthService, "test-client", "openid")
+
+		assert.Nil(t, authorize(oauthService, "http://127.0.0.1/callback"))
+		assert.NotNil(t, authorize(oauthService, "http://127.0.0.1:51004/callback"))
+	})
+
+	loopbackCfg := *cfg
+	loopbackCfg.OAuth.AllowLoopbackPortFlexibility = true
+	oauthService := services.NewOAuthService(&loopbackCfg, nil)
+	defer oauthService.Stop()
+	grantConsent(t, oauthService, "test-client", "openid")
+
+	tests := []struct {
+		name        string
+		redirectURI string
+		valid       bool
+	}{
+		{"IPv4 loopback on another port", "http://127.0.0.1:51004/callback", true},
+		{"IPv6 loopback on another port", "http://[::1]:49152/callback", true},
+		{"Loopback with a different path", "http://127.0.0.1:51004/other", false},
+		{"Loopback with a different scheme", "https://127.0.0.1:51004/callback", false},
+		{"Other loopback address", "http://127.0.0.2:51004/callback", false},
+		{"localhost is not a loopback IP", "http://localhost:4000/callback", false},
+		{"Non-loopback on another port", "https://app.example.com:9443/callback", false},
+		{"Non-loopback on the registered port", "https://app.example.com:8443/callback", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errorResp := authorize(oauthService, tt.redirectURI)
+			if tt.valid {
+				assert.Nil(t, errorResp)
+			} else {
+				assert.NotNil(t, errorResp)
+			}
+		})
+	}
+}
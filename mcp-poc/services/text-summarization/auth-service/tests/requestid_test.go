@@ -0,0 +1,57 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"auth-service/internal/middleware"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var seen string
+	handler := middleware.RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = middleware.RequestIDFromContext(r.Context())
+	}))
+
+	serve := func(requestID string) *httptest.ResponseRecorder {
+		seen = ""
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		if requestID != "" {
+			req.Header.Set(middleware.RequestIDHeader, requestID)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Incoming ID is preserved", func(t *testing.T) {
+		rec := serve("upstream-123")
+		assert.Equal(t, "upstream-123", rec.Header().Get(middleware.RequestIDHeader))
+		assert.Equal(t, "upstream-123", seen)
+	})
+
+	t.Run("Missing ID is generated", func(t *testing.T) {
+		rec := serve("")
+		requestID := rec.Header().Get(middleware.RequestIDHeader)
+		_, err := uuid.Parse(requestID)
+		assert.NoError(t, err)
+		assert.Equal(t, requestID, seen)
+
+		assert.NotEqual(t, requestID, serve("").Header().Get(middleware.RequestIDHeader), "IDs must be unique per request")
+	})
+
+	t.Run("Oversized ID is replaced", func(t *testing.T) {
+		rec := serve(strings.Repeat("a", 1000))
+		_, err := uuid.Parse(rec.Header().Get(middleware.RequestIDHeader))
+		assert.NoError(t, err)
+	})
+
+	t.Run("Outside the middleware", func(t *testing.T) {
+		assert.Empty(t, middleware.RequestIDFromContext(httptest.NewRequest(http.MethodGet, "/", nil).Context()))
+	})
+}
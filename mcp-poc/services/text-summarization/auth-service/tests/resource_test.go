@@ -0,0 +1,245 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/handlers"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+)
+
+const (
+	summariesResource = "https://summaries.example.com"
+	billingResource   = "https://billing.example.com"
+)
+
+func TestResourceIndicators(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	cfg.OAuth.AllowedResources = []string{summariesResource, billingResource}
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+	defer oauthService.Stop()
+	grantConsent(t, oauthService, "test-client", "openid")
+
+	authorize := func(resources ...string) (*models.AuthorizationCode, *models.ErrorResponse) {
+		return oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+			UserID:              "demo-user",
+			ResponseType:        "code",
+			ClientID:            "test-client",
+			RedirectURI:         "http://localhost:3000/callback",
+			Scope:               "openid",
+			State:               "xyz",
+			CodeChallenge:       testCodeChallenge,
+			CodeChallengeMethod: "S256",
+			Resources:           resources,
+		})
+	}
+
+	exchangeCode := func(code string, resources ...string) (*models.TokenResponse, *models.ErrorResponse) {
+		return oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:    "authorization_code",
+			Code:         code,
+			RedirectURI:  "http://localhost:3000/callback",
+			ClientID:     "test-client",
+			CodeVerifier: testCodeVerifier,
+			Resources:    resources,
+		})
+	}
+
+	audience := func(t *testing.T, accessToken string) []string {
+		claims, err := jwtService.ValidateAccessTokenForAudience(accessToken, "")
+		require.NoError(t, err)
+		return claims.Audience
+	}
+
+	t.Run("Single resource", func(t *testing.T) {
+		authCode, errorResp := authorize(summariesResource)
+		require.Nil(t, errorResp)
+
+		tokenResp, errorResp := exchangeCode(authCode.Code)
+		require.Nil(t, errorResp)
+		assert.Equal(t, []string{summariesResource}, audience(t, tokenResp.AccessToken))
+
+		_, err := jwtService.ValidateAccessTokenForAudience(tokenResp.AccessToken, summariesResource)
+		assert.NoError(t, err)
+		_, err = jwtService.ValidateAccessToken(tokenResp.AccessToken)
+		assert.ErrorContains(t, err, "invalid audience", "not usable against the default audience")
+
+		introspection, err := oauthService.IntrospectToken(tokenResp.AccessToken)
+		require.NoError(t, err)
+		assert.True(t, introspection.Active)
+		assert.Equal(t, summariesResource, introspection.Aud)
+	})
+
+	t.Run("Multiple resources", func(t *testing.T) {
+		authCode, errorResp := authorize(summariesResource, billingResource, summariesResource)
+		require.Nil(t, errorResp)
+		assert.Equal(t, []string{summariesResource, billingResource}, authCode.Resources)
+
+		tokenResp, errorResp := exchangeCode(authCode.Code)
+		require.Nil(t, errorResp)
+		assert.Equal(t, []string{summariesResource, billingResource}, audience(t, tokenResp.AccessToken))
+
+		// A refresh may narrow the audience to one of the granted resources
+		refreshed, errorResp := oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:    "refresh_token",
+			RefreshToken: tokenResp.RefreshToken,
+			ClientID:     "test-client",
+			Resources:    []string{billingResource},
+		})
+		require.Nil(t, errorResp)
+		assert.Equal(t, []string{billingResource}, audience(t, refreshed.AccessToken))
+
+		// and otherwise keeps all of them
+		refreshed, errorResp = oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:    "refresh_token",
+			RefreshToken: tokenResp.RefreshToken,
+			ClientID:     "test-client",
+		})
+		require.Nil(t, errorResp)
+		assert.Equal(t, []string{summariesResource, billingResource}, audience(t, refreshed.AccessToken))
+	})
+
+	t.Run("Resource requested at the token endpoint", func(t *testing.T) {
+		authCode, errorResp := authorize()
+		require.Nil(t, errorResp)
+
+		tokenResp, errorResp := exchangeCode(authCode.Code, billingResource)
+		require.Nil(t, errorResp)
+		assert.Equal(t, []string{billingResource}, audience(t, tokenResp.AccessToken))
+	})
+
+	t.Run("No resource keeps the default audience", func(t *testing.T) {
+		authCode, errorResp := authorize()
+		require.Nil(t, errorResp)
+
+		tokenResp, errorResp := exchangeCode(authCode.Code)
+		require.Nil(t, errorResp)
+		assert.Equal(t, []string{"api"}, audience(t, tokenResp.AccessToken))
+	})
+
+	t.Run("Disallowed resource", func(t *testing.T) {
+		for _, resource := range []string{
+			"https://admin.example.com",
+			"/relative",
+			"https://summaries.example.com#section",
+		} {
+			_, errorResp := authorize(summariesResource, resource)
+			require.NotNil(t, errorResp, resource)
+			assert.Equal(t, "invalid_target", errorResp.Error, resource)
+			assert.Equal(t, "xyz", errorResp.State, resource)
+		}
+
+		authCode, errorResp := authorize()
+		require.Nil(t, errorResp)
+		_, errorResp = exchangeCode(authCode.Code, "https://admin.example.com")
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_target", errorResp.Error)
+	})
+
+	t.Run("Resource outside the grant", func(t *testing.T) {
+		authCode, errorResp := authorize(summariesResource)
+		require.Nil(t, errorResp)
+
+		_, errorResp = exchangeCode(authCode.Code, billingResource)
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_target", errorResp.Error)
+
+		// The code is still usable with a valid request
+		_, errorResp = exchangeCode(authCode.Code, summariesResource)
+		assert.Nil(t, errorResp)
+	})
+}
+
+func TestResourceParameters(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	cfg.OAuth.AllowedResources = []string{summariesResource, billingResource}
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+	defer oauthService.Stop()
+	grantConsent(t, oauthService, "test-client", "openid")
+	handler := handlers.NewOAuthHandler(oauthService, jwtService)
+
+	authorizeCode := func(t *testing.T, query url.Values) string {
+		rec := authorize(handler, query)
+		require.Equal(t, http.StatusFound, rec.Code)
+		location, err := url.Parse(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		require.Empty(t, location.Query().Get("error"), location.Query().Get("error_description"))
+		return location.Query().Get("code")
+	}
+
+	token := func(t *testing.T, contentType, body string) *models.TokenResponse {
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		handler.HandleToken(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var tokenResp models.TokenResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &tokenResp))
+		return &tokenResp
+	}
+
+	t.Run("Repeated query and form parameters", func(t *testing.T) {
+		query := authorizeQuery()
+		query["resource"] = []string{summariesResource, billingResource}
+		code := authorizeCode(t, query)
+
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {"http://localhost:3000/callback"},
+			"client_id":     {"test-client"},
+			"code_verifier": {testCodeVerifier},
+			"resource":      {summariesResource, billingResource},
+		}
+		tokenResp := token(t, "application/x-www-form-urlencoded", form.Encode())
+
+		claims, err := jwtService.ValidateAccessTokenForAudience(tokenResp.AccessToken, billingResource)
+		require.NoError(t, err)
+		assert.Equal(t, []string{summariesResource, billingResource}, []string(claims.Audience))
+	})
+
+	t.Run("Single JSON resource", func(t *testing.T) {
+		query := authorizeQuery()
+		query.Set("resource", summariesResource)
+		code := authorizeCode(t, query)
+
+		body, err := json.Marshal(map[string]string{
+			"grant_type":    "authorization_code",
+			"code":          code,
+			"redirect_uri":  "http://localhost:3000/callback",
+			"client_id":     "test-client",
+			"code_verifier": testCodeVerifier,
+			"resource":      summariesResource,
+		})
+		require.NoError(t, err)
+		tokenResp := token(t, "application/json", string(body))
+
+		claims, err := jwtService.ValidateAccessTokenForAudience(tokenResp.AccessToken, summariesResource)
+		require.NoError(t, err)
+		assert.Equal(t, []string{summariesResource}, []string(claims.Audience))
+	})
+
+	t.Run("Disallowed resource is sent back to the client", func(t *testing.T) {
+		query := authorizeQuery()
+		query.Set("resource", "https://admin.example.com")
+		rec := authorize(handler, query)
+		require.Equal(t, http.StatusFound, rec.Code)
+		location, err := url.Parse(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, "localhost:3000", location.Host)
+		assert.Equal(t, "invalid_target", location.Query().Get("error"))
+	})
+}
@@ -0,0 +1,317 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/config"
+	"auth-service/internal/handlers"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+	"auth-service/internal/store"
+)
+
+func TestRevokeToken(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+	handler := handlers.NewOAuthHandler(oauthService, jwtService)
+
+	revoke := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/revoke", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.HandleRevoke(rec, req)
+		return rec
+	}
+
+	t.Run("Revoke valid refresh token", func(t *testing.T) {
+		tokens := issueTokens(t, oauthService, "openid")
+		require.NotEmpty(t, tokens.RefreshToken)
+
+		form := url.Values{
+			"token":           {tokens.RefreshToken},
+			"token_type_hint": {"refresh_token"},
+			"client_id":       {"test-client"},
+		}
+		rec := revoke(form.Encode())
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Body.String())
+
+		// The revoked refresh token can no longer be used
+		_, errorResp := oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:    "refresh_token",
+			ClientID:     "test-client",
+			RefreshToken: tokens.RefreshToken,
+		})
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_grant", errorResp.Error)
+	})
+
+	t.Run("Revoke unknown token", func(t *testing.T) {
+		rec := revoke(url.Values{"token": {"unknown-token"}, "client_id": {"test-client"}}.Encode())
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Client must authenticate", func(t *testing.T) {
+		tokens := issueTokens(t, oauthService, "openid")
+
+		for _, clientID := range []string{"", "unknown-client"} {
+			rec := revoke(url.Values{"token": {tokens.AccessToken}, "client_id": {clientID}}.Encode())
+			assert.Equal(t, http.StatusUnauthorized, rec.Code)
+			assert.Contains(t, rec.Body.String(), "invalid_client")
+		}
+
+		_, err := jwtService.ValidateAccessToken(tokens.AccessToken)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Missing token parameter", func(t *testing.T) {
+		rec := revoke(url.Values{"token_type_hint": {"refresh_token"}}.Encode())
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid_request")
+	})
+
+	t.Run("Malformed request body", func(t *testing.T) {
+		rec := revoke("token=%zz")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid_request")
+	})
+
+	t.Run("Method not allowed", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/revoke", nil)
+		rec := httptest.NewRecorder()
+		handler.HandleRevoke(rec, req)
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	})
+}
+
+func TestRevokeTokenOfAnotherClient(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	cfg.OAuth.Clients = []config.ClientConfig{
+		{ClientID: "test-client", RedirectURIs: []string{"http://localhost:3000/callback"}},
+		{ClientID: "other-client", RedirectURIs: []string{"http://localhost:4000/callback"}},
+	}
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+	defer oauthService.Stop()
+	handler := handlers.NewOAuthHandler(oauthService, jwtService)
+
+	tokens := issueTokens(t, oauthService, "openid")
+	for _, token := range []string{tokens.AccessToken, tokens.RefreshToken} {
+		form := url.Values{"token": {token}, "client_id": {"other-client"}}
+		req := httptest.NewRequest(http.MethodPost, "/revoke", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.HandleRevoke(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "unauthorized_client")
+	}
+
+	// Both tokens are still usable by the client they were issued to
+	_, err := jwtService.ValidateAccessToken(tokens.AccessToken)
+	assert.NoError(t, err)
+	_, errorResp := oauthService.HandleTokenRequest(&models.TokenRequest{
+		GrantType:    "refresh_token",
+		ClientID:     "test-client",
+		RefreshToken: tokens.RefreshToken,
+	})
+	assert.Nil(t, errorResp)
+}
+
+func TestRevokeResourceBoundAccessToken(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	cfg.OAuth.AllowedResources = []string{summariesResource}
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+	defer oauthService.Stop()
+	handler := handlers.NewOAuthHandler(oauthService, jwtService)
+
+	grantConsent(t, oauthService, "test-client", "openid")
+	authCode, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+		UserID:              "demo-user",
+		ResponseType:        "code",
+		ClientID:            "test-client",
+		RedirectURI:         "http://localhost:3000/callback",
+		Scope:               "openid",
+		CodeChallenge:       testCodeChallenge,
+		CodeChallengeMethod: "S256",
+		Resources:           []string{summariesResource},
+	})
+	require.Nil(t, errorResp)
+	tokens, errorResp := oauthService.HandleTokenRequest(&models.TokenRequest{
+		GrantType:    "authorization_code",
+		Code:         authCode.Code,
+		RedirectURI:  authCode.RedirectURI,
+		ClientID:     "test-client",
+		CodeVerifier: testCodeVerifier,
+	})
+	require.Nil(t, errorResp)
+	_, err := jwtService.ValidateAccessTokenForAudience(tokens.AccessToken, summariesResource)
+	require.NoError(t, err)
+
+	form := url.Values{"token": {tokens.AccessToken}, "token_type_hint": {"access_token"}, "client_id": {"test-client"}}
+	req := httptest.NewRequest(http.MethodPost, "/revoke", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler.HandleRevoke(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// The resource server now rejects it
+	_, err = jwtService.ValidateAccessTokenForAudience(tokens.AccessToken, summariesResource)
+	assert.ErrorIs(t, err, services.ErrTokenRevoked)
+	introspection, err := oauthService.IntrospectToken(tokens.AccessToken)
+	require.NoError(t, err)
+	assert.False(t, introspection.Active)
+}
+
+func TestRevokeAccessToken(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+
+	t.Run("Revoked access token fails validation", func(t *testing.T) {
+		tokens := issueTokens(t, oauthService, "openid")
+
+		_, err := jwtService.ValidateAccessToken(tokens.AccessToken)
+		require.NoError(t, err)
+
+		require.NoError(t, oauthService.RevokeToken(tokens.AccessToken, "access_token"))
+
+		_, err = jwtService.ValidateAccessToken(tokens.AccessToken)
+		assert.Error(t, err)
+
+		resp, err := oauthService.IntrospectToken(tokens.AccessToken)
+		require.NoError(t, err)
+		assert.False(t, resp.Active)
+	})
+
+	t.Run("Revoking refresh token leaves other tokens usable", func(t *testing.T) {
+		tokens := issueTokens(t, oauthService, "openid")
+
+		require.NoError(t, oauthService.RevokeToken(tokens.RefreshToken, ""))
+
+		_, errorResp := oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:    "refresh_token",
+			ClientID:     "test-client",
+			RefreshToken: tokens.RefreshToken,
+		})
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_grant", errorResp.Error)
+
+		_, err := jwtService.ValidateAccessToken(tokens.AccessToken)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Revoked subject token cannot be exchanged", func(t *testing.T) {
+		tokens := issueTokens(t, oauthService, "openid")
+		require.NoError(t, oauthService.RevokeToken(tokens.AccessToken, "access_token"))
+
+		_, errorResp := oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:        services.GrantTypeTokenExchange,
+			ClientID:         "test-client",
+			SubjectToken:     tokens.AccessToken,
+			SubjectTokenType: services.TokenTypeAccessToken,
+		})
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_grant", errorResp.Error)
+	})
+
+	t.Run("Revocation outlasts the clock skew", func(t *testing.T) {
+		skewCfg := newTestConfig()
+		skewCfg.JWT.ClockSkew = time.Minute
+		client := fake.newClient()
+		skewService := services.NewJWTService(client, skewCfg, services.WithDenylist(store.NewMemoryStore()))
+
+		// Expired, but still accepted within the leeway
+		claims := standardTestClaims()
+		claims["jti"] = "expired-within-skew"
+		claims["exp"] = time.Now().Add(-30 * time.Second).Unix()
+		token := signTestToken(t, client, claims)
+		_, err := skewService.ValidateAccessToken(token)
+		require.NoError(t, err)
+
+		require.NoError(t, skewService.RevokeAccessToken(token))
+		_, err = skewService.ValidateAccessToken(token)
+		assert.ErrorIs(t, err, services.ErrTokenRevoked)
+	})
+
+	t.Run("Access token hint without JWT service", func(t *testing.T) {
+		service := services.NewOAuthService(cfg, nil)
+		err := service.RevokeToken("some-token", "access_token")
+		assert.ErrorIs(t, err, services.ErrUnsupportedTokenType)
+	})
+}
+
+func TestAccessTokenDenylistSharedAcrossReplicas(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	tokenStore := store.NewMemoryStore()
+
+	// Two replicas sharing one token store
+	first := services.NewJWTService(fake.newClient(), cfg, services.WithDenylist(tokenStore))
+	second := services.NewJWTService(fake.newClient(), cfg, services.WithDenylist(tokenStore))
+	oauthService := services.NewOAuthService(cfg, first, services.WithTokenStore(tokenStore))
+	defer oauthService.Stop()
+
+	token, err := first.GenerateAccessToken("demo-user", "test-client", "openid")
+	require.NoError(t, err)
+	claims, err := second.ValidateAccessToken(token)
+	require.NoError(t, err)
+
+	require.NoError(t, oauthService.RevokeToken(token, "access_token"))
+
+	_, err = second.ValidateAccessToken(token)
+	assert.ErrorIs(t, err, services.ErrTokenRevoked)
+
+	// The entry lives exactly as long as the token would have
+	revoked, err := tokenStore.IsJTIRevoked(claims.JWTID)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+	require.NoError(t, tokenStore.DeleteExpired(time.Unix(claims.ExpiresAt, 0).Add(time.Second)))
+	revoked, err = tokenStore.IsJTIRevoked(claims.JWTID)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func TestAccessTokenDenylistDefaultsToTokenStore(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	tokenStore := store.NewMemoryStore()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+
+	token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+	require.NoError(t, err)
+
+	// Alone, the service has nowhere to record a revocation
+	assert.ErrorIs(t, jwtService.RevokeAccessToken(token), services.ErrNoDenylist)
+
+	oauthService := services.NewOAuthService(cfg, jwtService, services.WithTokenStore(tokenStore))
+	defer oauthService.Stop()
+
+	require.NoError(t, jwtService.RevokeAccessToken(token))
+	claims := tokenClaims(t, token)
+	revoked, err := tokenStore.IsJTIRevoked(claims["jti"].(string))
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	_, err = jwtService.ValidateAccessToken(token)
+	assert.ErrorIs(t, err, services.ErrTokenRevoked)
+
+	// Swept with the rest of the store once the token has expired
+	require.NoError(t, tokenStore.DeleteExpired(time.Unix(int64(claims["exp"].(float64)), 0).Add(time.Second)))
+	revoked, err = tokenStore.IsJTIRevoked(claims["jti"].(string))
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
@@ -0,0 +1,166 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/services"
+	"auth-service/pkg/metrics"
+	"auth-service/pkg/vault"
+)
+
+func TestScheduledKeyRotation(t *testing.T) {
+	// ECDSA keys are quick to generate, which keeps rotations short
+	fake := newEmptyFakeVault(t)
+	cfg := newTestConfig()
+	cfg.JWT.KeyRotationInterval = 20 * time.Millisecond
+	jwtService := services.NewJWTService(fake.newClient(vault.WithAlgorithm(vault.AlgorithmES256)), cfg)
+
+	rotationsBefore := testutil.ToFloat64(metrics.KeyRotations)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := jwtService.StartKeyRotation(ctx)
+
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.KeyRotations)-rotationsBefore >= 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.GreaterOrEqual(t, fake.latestVersion(), 3)
+
+	// No further rotations once the rotator has exited
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("rotator did not exit after cancellation")
+	}
+	stopped := fake.latestVersion()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, stopped, fake.latestVersion())
+
+	// The cached keys were refreshed, so new tokens use and publish the
+	// latest key
+	token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%s-v%d", testTransitKey, stopped), tokenKeyID(t, token))
+
+	jwksJSON, err := jwtService.GetJWKS()
+	require.NoError(t, err)
+	assert.Contains(t, string(jwksJSON), fmt.Sprintf(`"kid":"%s-v%d"`, testTransitKey, stopped))
+}
+
+func TestScheduledKeyRotationFailure(t *testing.T) {
+	fake := newEmptyFakeVault(t)
+	cfg := newTestConfig()
+	cfg.JWT.KeyRotationInterval = 20 * time.Millisecond
+	jwtService := services.NewJWTService(fake.newClient(vault.WithAlgorithm(vault.AlgorithmES256)), cfg)
+
+	failures := testutil.ToFloat64(metrics.VaultOperations.WithLabelValues("rotate_key", "error"))
+	fake.setUnavailable(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := jwtService.StartKeyRotation(ctx)
+
+	// The Vault client retries before giving up, so a failure takes a while
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.VaultOperations.WithLabelValues("rotate_key", "error")) > failures
+	}, 10*time.Second, 10*time.Millisecond)
+
+	// The rotator keeps running and succeeds once Vault is back
+	fake.setUnavailable(false)
+	versions := fake.latestVersion()
+	assert.Eventually(t, func() bool {
+		return fake.latestVersion() > versions
+	}, 10*time.Second, 10*time.Millisecond)
+
+	select {
+	case <-done:
+		t.Fatal("rotator exited after a failed rotation")
+	default:
+	}
+}
+
+func TestRotateKeyIfOlderThan(t *testing.T) {
+	fake := newFakeVault(t)
+	client := fake.newClient()
+	_, _, err := client.GetPublicKey() // cache version 1
+	require.NoError(t, err)
+
+	// Another replica has just rotated, so this one picks up its key
+	require.NoError(t, fake.newClient().RotateKey())
+	rotated, err := client.RotateKeyIfOlderThan(time.Hour)
+	require.NoError(t, err)
+	assert.False(t, rotated)
+	assert.Equal(t, 2, fake.latestVersion())
+	_, keyID, err := client.GetPublicKey()
+	require.NoError(t, err)
+	assert.Equal(t, testTransitKey+"-v2", keyID)
+
+	rotated, err = client.RotateKeyIfOlderThan(0)
+	require.NoError(t, err)
+	assert.True(t, rotated)
+	assert.Equal(t, 3, fake.latestVersion())
+}
+
+func TestScheduledKeyRotationAcrossReplicas(t *testing.T) {
+	const interval = 100 * time.Millisecond
+	fake := newEmptyFakeVault(t)
+	cfg := newTestConfig()
+	cfg.JWT.KeyRotationInterval = interval
+
+	// Replicas start at different times, so their ticks don't coincide
+	start := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	var done []<-chan struct{}
+	for i := 0; i < 3; i++ {
+		jwtService := services.NewJWTService(fake.newClient(vault.WithAlgorithm(vault.AlgorithmES256)), cfg)
+		done = append(done, jwtService.StartKeyRotation(ctx))
+		time.Sleep(interval / 3)
+	}
+
+	time.Sleep(10 * interval)
+	cancel()
+	for _, d := range done {
+		<-d
+	}
+
+	// Three replicas rotating independently would make about 30 versions;
+	// together they rotate about once an interval
+	intervals := int(time.Since(start) / interval)
+	assert.Greater(t, fake.latestVersion(), 1)
+	assert.LessOrEqual(t, fake.latestVersion(), 1+intervals*10/9+1)
+}
+
+func TestScheduledKeyRotationDisabled(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	<-jwtService.StartKeyRotation(ctx)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, fake.latestVersion())
+}
+
+func TestKeyRotationGauges(t *testing.T) {
+	fake := newFakeVault(t)
+	jwtService := services.NewJWTService(fake.newClient(vault.WithObserver(metrics.VaultObserver{})), newTestConfig())
+
+	_, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.CurrentKeyVersion))
+
+	before := time.Now().Unix()
+	require.NoError(t, jwtService.RotateKeys())
+
+	assert.GreaterOrEqual(t, testutil.ToFloat64(metrics.LastKeyRotationTimestamp), float64(before))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.CurrentKeyVersion))
+}
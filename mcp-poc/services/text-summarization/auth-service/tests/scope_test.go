@@ -0,0 +1,81 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/middleware"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+)
+
+func TestRequireScope(t *testing.T) {
+	fake := newFakeVault(t)
+	jwtService := services.NewJWTService(fake.newClient(), newTestConfig())
+
+	var seen *models.Claims
+	handler := middleware.RequireScope(jwtService, "summaries:read", "summaries:write")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := middleware.ClaimsFromContext(r.Context())
+		require.True(t, ok)
+		seen = claims
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	request := func(token string) *httptest.ResponseRecorder {
+		seen = nil
+		req := httptest.NewRequest(http.MethodGet, "/summaries", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Sufficient scope", func(t *testing.T) {
+		token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid summaries:write")
+		require.NoError(t, err)
+
+		rec := request(token)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		require.NotNil(t, seen)
+		assert.Equal(t, "demo-user", seen.Subject)
+		assert.Equal(t, "test-client", seen.ClientID)
+	})
+
+	t.Run("Insufficient scope", func(t *testing.T) {
+		token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid profile")
+		require.NoError(t, err)
+
+		rec := request(token)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Nil(t, seen)
+		challenge := rec.Header().Get("WWW-Authenticate")
+		assert.Contains(t, challenge, `error="insufficient_scope"`)
+		assert.Contains(t, challenge, `scope="summaries:read summaries:write"`)
+	})
+
+	t.Run("Scope prefix is not a match", func(t *testing.T) {
+		token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "summaries")
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusForbidden, request(token).Code)
+	})
+
+	t.Run("Invalid token", func(t *testing.T) {
+		rec := request("not.a.token")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+	})
+
+	t.Run("Missing token", func(t *testing.T) {
+		rec := request("")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Header().Get("WWW-Authenticate"), "Bearer")
+		assert.Nil(t, seen)
+	})
+}
@@ -0,0 +1,298 @@
+package tests
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/services"
+	"auth-service/pkg/vault"
+)
+
+func TestSigningAlgorithms(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		keyType   string
+	}{
+		{vault.AlgorithmRS256, "rsa-2048"},
+		{vault.AlgorithmPS256, "rsa-2048"},
+		{vault.AlgorithmES256, "ecdsa-p256"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			fake := newEmptyFakeVault(t)
+			client := fake.newClient(vault.WithAlgorithm(tt.algorithm))
+			assert.Equal(t, tt.keyType, fake.keyType)
+
+			cfg := newTestConfig()
+			cfg.JWT.Algorithm = tt.algorithm
+			jwtService := services.NewJWTService(client, cfg)
+
+			token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+			require.NoError(t, err)
+
+			parts := strings.Split(token, ".")
+			require.Len(t, parts, 3)
+
+			headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+			require.NoError(t, err)
+			var header map[string]interface{}
+			require.NoError(t, json.Unmarshal(headerJSON, &header))
+			assert.Equal(t, tt.algorithm, header["alg"])
+
+			claims, err := jwtService.ValidateAccessToken(token)
+			require.NoError(t, err)
+			assert.Equal(t, "demo-user", claims.Subject)
+
+			// The signature must verify with the advertised key as a standard
+			// JWS, independently of Vault
+			jwksJSON, err := jwtService.GetJWKS()
+			require.NoError(t, err)
+			var jwks jose.JSONWebKeySet
+			require.NoError(t, json.Unmarshal(jwksJSON, &jwks))
+			require.Len(t, jwks.Keys, 1)
+			assert.Equal(t, tt.algorithm, jwks.Keys[0].Algorithm)
+			assert.Equal(t, header["kid"], jwks.Keys[0].KeyID)
+
+			signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+			require.NoError(t, err)
+			assert.True(t, verifyJWS(tt.algorithm, jwks.Keys[0].Key, parts[0]+"."+parts[1], signature))
+		})
+	}
+
+	t.Run("Existing key of another type", func(t *testing.T) {
+		fake := newFakeVault(t)
+		_, err := vault.NewClient(fake.server.URL, "test-token", testTransitKey, vault.WithAlgorithm(vault.AlgorithmES256))
+		assert.ErrorContains(t, err, "ecdsa-p256")
+	})
+
+	t.Run("Unsupported algorithm", func(t *testing.T) {
+		fake := newFakeVault(t)
+		_, err := vault.NewClient(fake.server.URL, "test-token", testTransitKey, vault.WithAlgorithm("HS256"))
+		assert.ErrorContains(t, err, "unsupported signing algorithm")
+	})
+}
+
+// verifyJWS checks an RS256, PS256 or ES256 signature as a relying party would
+func verifyJWS(algorithm string, publicKey interface{}, signingInput string, signature []byte) bool {
+	digest := sha256.Sum256([]byte(signingInput))
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		if algorithm == vault.AlgorithmPS256 {
+			opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+			return rsa.VerifyPSS(key, crypto.SHA256, digest[:], signature, opts) == nil
+		}
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		if len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, digest[:], r, s)
+	}
+	return false
+}
+
+func TestES256JWKS(t *testing.T) {
+	fake := newEmptyFakeVault(t)
+	client := fake.newClient(vault.WithAlgorithm(vault.AlgorithmES256))
+	cfg := newTestConfig()
+	cfg.JWT.Algorithm = vault.AlgorithmES256
+	jwtService := services.NewJWTService(client, cfg)
+
+	token, err := jwtService.GenerateIDToken("demo-user", "test-client", "n-0S6_WzA2Mj")
+	require.NoError(t, err)
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[0])
+	require.NoError(t, err)
+	var header map[string]interface{}
+	require.NoError(t, json.Unmarshal(headerJSON, &header))
+	assert.Equal(t, "ES256", header["alg"])
+
+	jwksJSON, err := jwtService.GetJWKS()
+	require.NoError(t, err)
+	var jwks struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal(jwksJSON, &jwks))
+	require.Len(t, jwks.Keys, 1)
+
+	key := jwks.Keys[0]
+	assert.Equal(t, "EC", key["kty"])
+	assert.Equal(t, "P-256", key["crv"])
+	assert.Equal(t, "ES256", key["alg"])
+	assert.Equal(t, header["kid"], key["kid"])
+
+	// The hand-built JWK agrees with the one in the key set
+	publicKey, keyID, err := client.GetPublicKey()
+	require.NoError(t, err)
+	jwk, err := vault.PublicKeyToJWK(publicKey, keyID)
+	require.NoError(t, err)
+	for _, param := range []string{"kty", "crv", "x", "y", "alg", "kid", "use"} {
+		assert.Equal(t, key[param], jwk[param], param)
+	}
+
+	x, err := base64.RawURLEncoding.DecodeString(jwk["x"].(string))
+	require.NoError(t, err)
+	assert.Len(t, x, 32)
+}
+
+func TestSignatureAfterManyRotations(t *testing.T) {
+	fake := newEmptyFakeVault(t)
+	client := fake.newClient(vault.WithAlgorithm(vault.AlgorithmES256))
+	cfg := newTestConfig()
+	cfg.JWT.Algorithm = vault.AlgorithmES256
+	cfg.JWT.LocalVerification = true
+	jwtService := services.NewJWTService(client, cfg)
+
+	// Vault now returns signatures prefixed "vault:v13:"
+	for fake.latestVersion() < 13 {
+		require.NoError(t, client.RotateKey())
+	}
+
+	token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+	require.NoError(t, err)
+
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+	assert.Len(t, signature, 64)
+
+	_, err = jwtService.ValidateAccessToken(token)
+	assert.NoError(t, err)
+}
+
+func TestStructAndMapClaimsShareHeader(t *testing.T) {
+	fake := newFakeVault(t)
+	jwtService := services.NewJWTService(fake.newClient(), newTestConfig())
+
+	// Without a nonce the ID token is signed from a claims struct, and with
+	// one from a raw claims map
+	fromStruct, err := jwtService.GenerateIDToken("demo-user", "test-client", "")
+	require.NoError(t, err)
+	fromMap, err := jwtService.GenerateIDToken("demo-user", "test-client", "n-0S6_WzA2Mj")
+	require.NoError(t, err)
+
+	header := func(token string) map[string]interface{} {
+		segments := strings.Split(token, ".")
+		require.Len(t, segments, 3)
+		for _, segment := range segments {
+			assert.NotEmpty(t, segment)
+		}
+
+		headerJSON, err := base64.RawURLEncoding.DecodeString(segments[0])
+		require.NoError(t, err)
+		var header map[string]interface{}
+		require.NoError(t, json.Unmarshal(headerJSON, &header))
+		return header
+	}
+
+	structHeader := header(fromStruct)
+	assert.Equal(t, map[string]interface{}{
+		"alg": "RS256",
+		"kid": testTransitKey + "-v1",
+		"typ": "JWT",
+	}, structHeader)
+	assert.Equal(t, structHeader, header(fromMap))
+
+	for _, token := range []string{fromStruct, fromMap} {
+		claims, err := jwtService.ValidateAccessTokenForAudience(token, "test-client")
+		require.NoError(t, err)
+		assert.Equal(t, "demo-user", claims.Subject)
+	}
+}
+
+func TestVaultSignatureFormat(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	cfg.JWT.LocalVerification = true
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+
+	for _, prefix := range []string{"vault:v1:", "vault:v2:", "vault:v100:"} {
+		t.Run(prefix, func(t *testing.T) {
+			fake.setSignaturePrefix(prefix)
+			defer fake.setSignaturePrefix("")
+
+			token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+			require.NoError(t, err)
+			assert.Len(t, strings.Split(token, "."), 3)
+			assert.NotContains(t, token, "vault")
+
+			_, err = jwtService.ValidateAccessToken(token)
+			assert.NoError(t, err)
+		})
+	}
+
+	for _, prefix := range []string{"vault:", "vault:v1:extra:", "vault:1:", "vault:v:", "vault:vx:", "transit:v1:", ":v1:"} {
+		t.Run("Malformed "+prefix, func(t *testing.T) {
+			fake.setSignaturePrefix(prefix)
+			defer fake.setSignaturePrefix("")
+
+			_, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+			assert.ErrorContains(t, err, "invalid signature format")
+		})
+	}
+}
+
+func TestAccessTokenJWTType(t *testing.T) {
+	fake := newFakeVault(t)
+	client := fake.newClient()
+
+	typ := func(t *testing.T, token string) string {
+		headerJSON, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[0])
+		require.NoError(t, err)
+		var header map[string]interface{}
+		require.NoError(t, json.Unmarshal(headerJSON, &header))
+		return header["typ"].(string)
+	}
+
+	t.Run("Enabled", func(t *testing.T) {
+		cfg := newTestConfig()
+		cfg.JWT.AccessTokenJWTType = true
+		jwtService := services.NewJWTService(client, cfg)
+
+		accessToken, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+		require.NoError(t, err)
+		assert.Equal(t, "at+jwt", typ(t, accessToken))
+
+		// RFC 9068 section 2.2 requires these claims
+		claims := tokenClaims(t, accessToken)
+		for _, claim := range []string{"iss", "exp", "aud", "sub", "client_id", "iat", "jti"} {
+			assert.NotEmpty(t, claims[claim], claim)
+		}
+
+		validated, err := jwtService.ValidateAccessToken(accessToken)
+		require.NoError(t, err)
+		delegated, err := jwtService.GenerateDelegatedToken(validated, "other-client", "openid")
+		require.NoError(t, err)
+		assert.Equal(t, "at+jwt", typ(t, delegated))
+
+		for _, nonce := range []string{"", "n-0S6_WzA2Mj"} {
+			idToken, err := jwtService.GenerateIDToken("demo-user", "test-client", nonce)
+			require.NoError(t, err)
+			assert.Equal(t, "JWT", typ(t, idToken))
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		jwtService := services.NewJWTService(client, newTestConfig())
+
+		accessToken, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+		require.NoError(t, err)
+		assert.Equal(t, "JWT", typ(t, accessToken))
+	})
+}
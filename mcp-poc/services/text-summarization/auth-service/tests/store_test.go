@@ -0,0 +1,341 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+	"auth-service/internal/store"
+	"auth-service/pkg/metrics"
+)
+
+func TestMemoryStore(t *testing.T) {
+	tokenStore := store.NewMemoryStore()
+
+	t.Run("Authorization code lifecycle", func(t *testing.T) {
+		code := &models.AuthorizationCode{Code: "code-1", ClientID: "test-client", ExpiresAt: time.Now().Add(time.Minute)}
+		require.NoError(t, tokenStore.SaveAuthCode(code))
+
+		got, err := tokenStore.GetAuthCode("code-1")
+		require.NoError(t, err)
+		assert.Equal(t, "test-client", got.ClientID)
+
+		require.NoError(t, tokenStore.DeleteAuthCode("code-1"))
+		_, err = tokenStore.GetAuthCode("code-1")
+		assert.ErrorIs(t, err, store.ErrNotFound)
+	})
+
+	t.Run("Authorization code is consumed once", func(t *testing.T) {
+		code := &models.AuthorizationCode{Code: "code-2", ClientID: "test-client", ExpiresAt: time.Now().Add(time.Minute)}
+		require.NoError(t, tokenStore.SaveAuthCode(code))
+
+		require.NoError(t, tokenStore.ConsumeAuthCode("code-2"))
+		assert.ErrorIs(t, tokenStore.ConsumeAuthCode("code-2"), store.ErrNotFound)
+		_, err := tokenStore.GetAuthCode("code-2")
+		assert.ErrorIs(t, err, store.ErrNotFound)
+	})
+
+	t.Run("Refresh token lifecycle", func(t *testing.T) {
+		token := &models.RefreshToken{Token: "token-1", UserID: "user-1", ExpiresAt: time.Now().Add(time.Minute)}
+		require.NoError(t, tokenStore.SaveRefreshToken(token))
+
+		got, err := tokenStore.GetRefreshToken("token-1")
+		require.NoError(t, err)
+		assert.Equal(t, "user-1", got.UserID)
+
+		require.NoError(t, tokenStore.DeleteRefreshToken("token-1"))
+		_, err = tokenStore.GetRefreshToken("token-1")
+		assert.ErrorIs(t, err, store.ErrNotFound)
+	})
+
+	t.Run("Delete expired entries", func(t *testing.T) {
+		now := time.Now()
+		require.NoError(t, tokenStore.SaveAuthCode(&models.AuthorizationCode{Code: "expired", ExpiresAt: now.Add(-time.Minute)}))
+		require.NoError(t, tokenStore.SaveAuthCode(&models.AuthorizationCode{Code: "live", ExpiresAt: now.Add(time.Minute)}))
+		require.NoError(t, tokenStore.SaveRefreshToken(&models.RefreshToken{Token: "expired", ExpiresAt: now.Add(-time.Minute)}))
+
+		require.NoError(t, tokenStore.DeleteExpired(now))
+
+		_, err := tokenStore.GetAuthCode("expired")
+		assert.ErrorIs(t, err, store.ErrNotFound)
+		_, err = tokenStore.GetRefreshToken("expired")
+		assert.ErrorIs(t, err, store.ErrNotFound)
+		_, err = tokenStore.GetAuthCode("live")
+		assert.NoError(t, err)
+	})
+
+	t.Run("Denylisted jtis expire with their token", func(t *testing.T) {
+		now := time.Now()
+		require.NoError(t, tokenStore.RevokeJTI("jti-live", now.Add(time.Minute)))
+		require.NoError(t, tokenStore.RevokeJTI("jti-expiring", now.Add(20*time.Millisecond)))
+
+		for _, jti := range []string{"jti-live", "jti-expiring"} {
+			revoked, err := tokenStore.IsJTIRevoked(jti)
+			require.NoError(t, err)
+			assert.True(t, revoked, jti)
+		}
+		revoked, err := tokenStore.IsJTIRevoked("jti-unknown")
+		require.NoError(t, err)
+		assert.False(t, revoked)
+
+		// An expired entry no longer matches, even before cleanup runs
+		time.Sleep(30 * time.Millisecond)
+		revoked, err = tokenStore.IsJTIRevoked("jti-expiring")
+		require.NoError(t, err)
+		assert.False(t, revoked)
+
+		require.NoError(t, tokenStore.DeleteExpired(time.Now()))
+		revoked, err = tokenStore.IsJTIRevoked("jti-live")
+		require.NoError(t, err)
+		assert.True(t, revoked)
+	})
+	t.Run("Consent accumulates per user and client", func(t *testing.T) {
+		scopes, err := tokenStore.GetConsent("user-1", "test-client")
+		require.NoError(t, err)
+		assert.Empty(t, scopes)
+
+		require.NoError(t, tokenStore.GrantConsent("user-1", "test-client", []string{"openid"}))
+		require.NoError(t, tokenStore.GrantConsent("user-1", "test-client", []string{"profile", "openid"}))
+
+		scopes, err = tokenStore.GetConsent("user-1", "test-client")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"openid", "profile"}, scopes)
+
+		// Consent doesn't expire with the codes and tokens
+		require.NoError(t, tokenStore.DeleteExpired(time.Now().Add(time.Hour)))
+		scopes, err = tokenStore.GetConsent("user-1", "test-client")
+		require.NoError(t, err)
+		assert.Len(t, scopes, 2)
+
+		scopes, err = tokenStore.GetConsent("user-2", "test-client")
+		require.NoError(t, err)
+		assert.Empty(t, scopes)
+		scopes, err = tokenStore.GetConsent("user-1", "other-client")
+		require.NoError(t, err)
+		assert.Empty(t, scopes)
+	})
+}
+
+func TestMemoryStoreCapacity(t *testing.T) {
+	now := time.Now()
+
+	t.Run("Authorization codes are capped", func(t *testing.T) {
+		tokenStore := store.NewMemoryStore(store.WithMaxAuthCodes(2))
+		require.NoError(t, tokenStore.SaveAuthCode(&models.AuthorizationCode{Code: "code-1", ExpiresAt: now.Add(time.Minute)}))
+		require.NoError(t, tokenStore.SaveAuthCode(&models.AuthorizationCode{Code: "code-2", ExpiresAt: now.Add(time.Minute)}))
+
+		err := tokenStore.SaveAuthCode(&models.AuthorizationCode{Code: "code-3", ExpiresAt: now.Add(time.Minute)})
+		assert.ErrorIs(t, err, store.ErrCapacity)
+		_, err = tokenStore.GetAuthCode("code-3")
+		assert.ErrorIs(t, err, store.ErrNotFound)
+
+		// Consuming a code makes room for the next one
+		require.NoError(t, tokenStore.ConsumeAuthCode("code-1"))
+		assert.NoError(t, tokenStore.SaveAuthCode(&models.AuthorizationCode{Code: "code-3", ExpiresAt: now.Add(time.Minute)}))
+	})
+
+	t.Run("Refresh tokens are capped", func(t *testing.T) {
+		tokenStore := store.NewMemoryStore(store.WithMaxRefreshTokens(1))
+		require.NoError(t, tokenStore.SaveRefreshToken(&models.RefreshToken{Token: "token-1", ExpiresAt: now.Add(time.Minute)}))
+
+		err := tokenStore.SaveRefreshToken(&models.RefreshToken{Token: "token-2", ExpiresAt: now.Add(time.Minute)})
+		assert.ErrorIs(t, err, store.ErrCapacity)
+	})
+
+	t.Run("Expired entries are evicted to make room", func(t *testing.T) {
+		tokenStore := store.NewMemoryStore(store.WithMaxAuthCodes(1), store.WithMaxRefreshTokens(1))
+		require.NoError(t, tokenStore.SaveAuthCode(&models.AuthorizationCode{Code: "expired", ExpiresAt: now.Add(-time.Minute)}))
+		require.NoError(t, tokenStore.SaveRefreshToken(&models.RefreshToken{Token: "expired", ExpiresAt: now.Add(-time.Minute)}))
+
+		require.NoError(t, tokenStore.SaveAuthCode(&models.AuthorizationCode{Code: "live", ExpiresAt: now.Add(time.Minute)}))
+		require.NoError(t, tokenStore.SaveRefreshToken(&models.RefreshToken{Token: "live", ExpiresAt: now.Add(time.Minute)}))
+
+		_, err := tokenStore.GetAuthCode("expired")
+		assert.ErrorIs(t, err, store.ErrNotFound)
+		_, err = tokenStore.GetRefreshToken("expired")
+		assert.ErrorIs(t, err, store.ErrNotFound)
+	})
+
+	t.Run("Cleanup frees capacity", func(t *testing.T) {
+		tokenStore := store.NewMemoryStore(store.WithMaxRefreshTokens(1))
+		require.NoError(t, tokenStore.SaveRefreshToken(&models.RefreshToken{Token: "token-1", ExpiresAt: now.Add(time.Minute)}))
+		assert.ErrorIs(t, tokenStore.SaveRefreshToken(&models.RefreshToken{Token: "token-2", ExpiresAt: now.Add(2 * time.Minute)}), store.ErrCapacity)
+
+		require.NoError(t, tokenStore.DeleteExpired(now.Add(90*time.Second)))
+		assert.NoError(t, tokenStore.SaveRefreshToken(&models.RefreshToken{Token: "token-2", ExpiresAt: now.Add(2 * time.Minute)}))
+	})
+
+	t.Run("Zero means no cap", func(t *testing.T) {
+		tokenStore := store.NewMemoryStore(store.WithMaxAuthCodes(0))
+		for i := 0; i < 10; i++ {
+			require.NoError(t, tokenStore.SaveAuthCode(&models.AuthorizationCode{Code: fmt.Sprintf("code-%d", i), ExpiresAt: now.Add(time.Minute)}))
+		}
+	})
+}
+
+func TestOAuthServiceStoreCapacity(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	cfg.OAuth.MaxAuthCodes = 1
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+	grantConsent(t, oauthService, "test-client", "openid")
+
+	request := func() (*models.AuthorizationCode, *models.ErrorResponse) {
+		return oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+			UserID:              "demo-user",
+			ResponseType:        "code",
+			ClientID:            "test-client",
+			RedirectURI:         "http://localhost:3000/callback",
+			Scope:               "openid",
+			State:               "xyz",
+			CodeChallenge:       testCodeChallenge,
+			CodeChallengeMethod: "S256",
+		})
+	}
+
+	_, errorResp := request()
+	require.Nil(t, errorResp)
+
+	rejections := testutil.ToFloat64(metrics.StoreCapacityRejectionsTotal.WithLabelValues("authorization_code"))
+	_, errorResp = request()
+	require.NotNil(t, errorResp)
+	assert.Equal(t, "temporarily_unavailable", errorResp.Error)
+	assert.Equal(t, "xyz", errorResp.State)
+	assert.Equal(t, rejections+1, testutil.ToFloat64(metrics.StoreCapacityRejectionsTotal.WithLabelValues("authorization_code")))
+}
+
+func TestOAuthServiceWithTokenStore(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	tokenStore := store.NewMemoryStore()
+
+	oauthService := services.NewOAuthService(cfg, jwtService, services.WithTokenStore(tokenStore))
+	grantConsent(t, oauthService, "test-client", "openid")
+
+	t.Run("Authorization codes are saved to the store", func(t *testing.T) {
+		authCode, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+			UserID:              "demo-user",
+			ResponseType:        "code",
+			ClientID:            "test-client",
+			RedirectURI:         "http://localhost:3000/callback",
+			Scope:               "openid",
+			CodeChallenge:       testCodeChallenge,
+			CodeChallengeMethod: "S256",
+		})
+		require.Nil(t, errorResp)
+
+		stored, err := tokenStore.GetAuthCode(authCode.Code)
+		require.NoError(t, err)
+		assert.Equal(t, authCode.ClientID, stored.ClientID)
+	})
+
+	t.Run("Refresh tokens survive a service restart", func(t *testing.T) {
+		tokens := issueTokens(t, oauthService, "openid")
+
+		restarted := services.NewOAuthService(cfg, jwtService, services.WithTokenStore(tokenStore))
+		tokenResp, errorResp := restarted.HandleTokenRequest(&models.TokenRequest{
+			GrantType:    "refresh_token",
+			ClientID:     "test-client",
+			RefreshToken: tokens.RefreshToken,
+		})
+		require.Nil(t, errorResp)
+		assert.NotEmpty(t, tokenResp.AccessToken)
+	})
+}
+
+func TestExpiredTokenCleanup(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.OAuth.CleanupInterval = 10 * time.Millisecond
+	tokenStore := store.NewMemoryStore()
+
+	oauthService := services.NewOAuthService(cfg, nil, services.WithTokenStore(tokenStore))
+	defer oauthService.Stop()
+
+	require.NoError(t, tokenStore.SaveAuthCode(&models.AuthorizationCode{
+		Code:      "expired-code",
+		ClientID:  "test-client",
+		ExpiresAt: time.Now().Add(-time.Minute),
+	}))
+	require.NoError(t, tokenStore.SaveAuthCode(&models.AuthorizationCode{
+		Code:      "live-code",
+		ClientID:  "test-client",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}))
+
+	assert.Eventually(t, func() bool {
+		_, err := tokenStore.GetAuthCode("expired-code")
+		return errors.Is(err, store.ErrNotFound)
+	}, time.Second, 5*time.Millisecond)
+
+	_, err := tokenStore.GetAuthCode("live-code")
+	assert.NoError(t, err)
+
+	t.Run("No cleanup after Stop", func(t *testing.T) {
+		oauthService.Stop()
+		oauthService.Stop()
+		time.Sleep(20 * time.Millisecond)
+
+		require.NoError(t, tokenStore.SaveAuthCode(&models.AuthorizationCode{
+			Code:      "expired-after-stop",
+			ClientID:  "test-client",
+			ExpiresAt: time.Now().Add(-time.Minute),
+		}))
+		time.Sleep(50 * time.Millisecond)
+
+		_, err := tokenStore.GetAuthCode("expired-after-stop")
+		assert.NoError(t, err)
+	})
+}
+
+func TestCleanupRunsOnStartup(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.OAuth.CleanupInterval = time.Hour
+	tokenStore := store.NewMemoryStore()
+
+	require.NoError(t, tokenStore.SaveAuthCode(&models.AuthorizationCode{
+		Code:      "expired-before-start",
+		ClientID:  "test-client",
+		ExpiresAt: time.Now().Add(-time.Minute),
+	}))
+
+	// The first pass doesn't wait for the hour-long interval
+	oauthService := services.NewOAuthService(cfg, nil, services.WithTokenStore(tokenStore))
+	defer oauthService.Stop()
+
+	_, err := tokenStore.GetAuthCode("expired-before-start")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func TestCleanupStopsOnContextCancel(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.OAuth.CleanupInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	oauthService := services.NewOAuthService(cfg, nil, services.WithContext(ctx))
+
+	select {
+	case <-oauthService.Done():
+		t.Fatal("cleanup goroutine returned before cancellation")
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case <-oauthService.Done():
+	case <-time.After(time.Second):
+		t.Fatal("cleanup goroutine did not return after cancellation")
+	}
+
+	// Stop after cancellation doesn't block
+	oauthService.Stop()
+}
@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/config"
+	"auth-service/internal/handlers"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+)
+
+// idTokenSubject returns the "sub" claim of an ID token
+func idTokenSubject(t *testing.T, idToken string) string {
+	t.Helper()
+	return tokenClaims(t, idToken)["sub"].(string)
+}
+
+func TestPairwiseSubjects(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	cfg.OAuth.PairwiseSalt = "test-salt"
+	cfg.OAuth.Clients = []config.ClientConfig{
+		{
+			ClientID:     "public-app",
+			RedirectURIs: []string{"https://public.example.com/callback"},
+		},
+		{
+			ClientID:     "pairwise-app",
+			RedirectURIs: []string{"https://app.example.com/callback"},
+			SubjectType:  config.SubjectTypePairwise,
+		},
+		{
+			ClientID:     "pairwise-partner",
+			RedirectURIs: []string{"https://partner.example.com/callback"},
+			SubjectType:  config.SubjectTypePairwise,
+		},
+		{
+			// Shares the first pairwise client's sector
+			ClientID:         "pairwise-app-mobile",
+			RedirectURIs:     []string{"https://mobile.example.com/callback"},
+			SubjectType:      config.SubjectTypePairwise,
+			SectorIdentifier: "app.example.com",
+		},
+	}
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+	defer oauthService.Stop()
+	handler := handlers.NewOAuthHandler(oauthService, jwtService)
+
+	issue := func(t *testing.T, clientID, userID string) *models.TokenResponse {
+		client, ok := cfg.OAuth.GetClient(clientID)
+		require.True(t, ok)
+		require.NoError(t, oauthService.GrantConsent(userID, clientID, "openid"))
+
+		authCode, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+			UserID:              userID,
+			ResponseType:        "code",
+			ClientID:            clientID,
+			RedirectURI:         client.RedirectURIs[0],
+			Scope:               "openid",
+			CodeChallenge:       testCodeChallenge,
+			CodeChallengeMethod: "S256",
+		})
+		require.Nil(t, errorResp)
+
+		tokenResp, errorResp := oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:    "authorization_code",
+			Code:         authCode.Code,
+			RedirectURI:  authCode.RedirectURI,
+			ClientID:     clientID,
+			CodeVerifier: testCodeVerifier,
+		})
+		require.Nil(t, errorResp)
+		require.NotEmpty(t, tokenResp.IDToken)
+		return tokenResp
+	}
+
+	userInfoSubject := func(t *testing.T, accessToken string) string {
+		req := httptest.NewRequest(http.MethodGet, "/userinfo", nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		rec := httptest.NewRecorder()
+		handler.HandleUserInfo(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var userInfo models.UserInfoResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &userInfo))
+		return userInfo.Sub
+	}
+
+	t.Run("Public clients see the user ID", func(t *testing.T) {
+		tokens := issue(t, "public-app", "alice")
+		assert.Equal(t, "alice", idTokenSubject(t, tokens.IDToken))
+		assert.Equal(t, "alice", userInfoSubject(t, tokens.AccessToken))
+	})
+
+	t.Run("Pairwise clients see distinct stable subjects", func(t *testing.T) {
+		app := idTokenSubject(t, issue(t, "pairwise-app", "alice").IDToken)
+		partner := idTokenSubject(t, issue(t, "pairwise-partner", "alice").IDToken)
+
+		assert.NotEqual(t, "alice", app)
+		assert.NotEqual(t, app, partner)
+
+		// The same client is always told the same subject
+		tokens := issue(t, "pairwise-app", "alice")
+		assert.Equal(t, app, idTokenSubject(t, tokens.IDToken))
+		assert.Equal(t, app, userInfoSubject(t, tokens.AccessToken))
+
+		// Other users get other subjects
+		assert.NotEqual(t, app, idTokenSubject(t, issue(t, "pairwise-app", "bob").IDToken))
+	})
+
+	t.Run("Clients in the same sector share subjects", func(t *testing.T) {
+		app := idTokenSubject(t, issue(t, "pairwise-app", "alice").IDToken)
+		mobile := idTokenSubject(t, issue(t, "pairwise-app-mobile", "alice").IDToken)
+		assert.Equal(t, app, mobile)
+	})
+
+	t.Run("Access tokens keep the user ID for resource servers", func(t *testing.T) {
+		tokens := issue(t, "pairwise-app", "alice")
+		claims, err := jwtService.ValidateAccessToken(tokens.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, "alice", claims.Subject)
+	})
+
+	t.Run("Discovery advertises pairwise", func(t *testing.T) {
+		assert.Equal(t, []string{"public", "pairwise"}, oauthService.GetDiscoveryDocument().SubjectTypesSupported)
+	})
+
+	t.Run("Pairwise clients are refused without a salt", func(t *testing.T) {
+		unsalted := newTestConfig()
+		unsalted.OAuth.Clients = cfg.OAuth.Clients
+		unsaltedService := services.NewOAuthService(unsalted, jwtService)
+		defer unsaltedService.Stop()
+
+		request := func(clientID, redirectURI string) *models.ErrorResponse {
+			require.NoError(t, unsaltedService.GrantConsent("alice", clientID, "openid"))
+			_, errorResp := unsaltedService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+				UserID:              "alice",
+				ResponseType:        "code",
+				ClientID:            clientID,
+				RedirectURI:         redirectURI,
+				Scope:               "openid",
+				State:               "xyz",
+				CodeChallenge:       testCodeChallenge,
+				CodeChallengeMethod: "S256",
+			})
+			return errorResp
+		}
+
+		errorResp := request("pairwise-app", "https://app.example.com/callback")
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "server_error", errorResp.Error)
+		assert.Equal(t, "xyz", errorResp.State)
+
+		assert.Nil(t, request("public-app", "https://public.example.com/callback"))
+	})
+}
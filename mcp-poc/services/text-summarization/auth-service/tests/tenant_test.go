@@ -0,0 +1,192 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/config"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+	"auth-service/internal/store"
+)
+
+func TestTenantResolver(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+
+	tenants := map[string]string{"demo-user": "3f0c9a52-acme"}
+	resolver := services.TenantResolverFunc(func(userID string) (string, error) {
+		if userID == "broken-user" {
+			return "", errors.New("connection refused")
+		}
+		tenantID, ok := tenants[userID]
+		if !ok {
+			return "", store.ErrNotFound
+		}
+		return tenantID, nil
+	})
+
+	tokenStore := store.NewMemoryStore()
+	oauthService := services.NewOAuthService(cfg, jwtService,
+		services.WithTenantResolver(resolver), services.WithTokenStore(tokenStore))
+	defer oauthService.Stop()
+
+	authorize := func(t *testing.T, userID string) *models.AuthorizationCode {
+		require.NoError(t, oauthService.GrantConsent(userID, "test-client", "openid"))
+		authCode, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+			UserID:              userID,
+			ResponseType:        "code",
+			ClientID:            "test-client",
+			RedirectURI:         "http://localhost:3000/callback",
+			Scope:               "openid",
+			CodeChallenge:       testCodeChallenge,
+			CodeChallengeMethod: "S256",
+		})
+		require.Nil(t, errorResp)
+		return authCode
+	}
+
+	exchange := func(authCode *models.AuthorizationCode) (*models.TokenResponse, *models.ErrorResponse) {
+		return oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:    "authorization_code",
+			Code:         authCode.Code,
+			RedirectURI:  authCode.RedirectURI,
+			ClientID:     "test-client",
+			CodeVerifier: testCodeVerifier,
+		})
+	}
+
+	t.Run("Resolved tenant", func(t *testing.T) {
+		tokenResp, errorResp := exchange(authorize(t, "demo-user"))
+		require.Nil(t, errorResp)
+
+		claims, err := jwtService.ValidateAccessTokenForTenant(tokenResp.AccessToken, "3f0c9a52-acme")
+		require.NoError(t, err)
+		assert.Equal(t, "3f0c9a52-acme", claims.TenantID)
+	})
+
+	t.Run("User without a tenant", func(t *testing.T) {
+		authCode := authorize(t, "orphan-user")
+		_, errorResp := exchange(authCode)
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_grant", errorResp.Error)
+
+		// The code isn't used up, so it can still be exchanged once the
+		// user is assigned a tenant
+		tenants["orphan-user"] = "7b1d44e0-globex"
+		defer delete(tenants, "orphan-user")
+		tokenResp, errorResp := exchange(authCode)
+		require.Nil(t, errorResp)
+		claims, err := jwtService.ValidateAccessToken(tokenResp.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, "7b1d44e0-globex", claims.TenantID)
+	})
+
+	t.Run("Resolver failure", func(t *testing.T) {
+		_, errorResp := exchange(authorize(t, "broken-user"))
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "server_error", errorResp.Error)
+	})
+
+	t.Run("Refresh resolves the tenant again", func(t *testing.T) {
+		tenants["moving-user"] = "3f0c9a52-acme"
+		defer delete(tenants, "moving-user")
+		tokenResp, errorResp := exchange(authorize(t, "moving-user"))
+		require.Nil(t, errorResp)
+
+		tenants["moving-user"] = "7b1d44e0-globex"
+		refreshed, errorResp := oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:    "refresh_token",
+			RefreshToken: tokenResp.RefreshToken,
+			ClientID:     "test-client",
+		})
+		require.Nil(t, errorResp)
+		_, err := jwtService.ValidateAccessTokenForTenant(refreshed.AccessToken, "7b1d44e0-globex")
+		assert.NoError(t, err)
+
+		delete(tenants, "moving-user")
+		_, errorResp = oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:    "refresh_token",
+			RefreshToken: tokenResp.RefreshToken,
+			ClientID:     "test-client",
+		})
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_grant", errorResp.Error)
+	})
+
+	t.Run("No resolver", func(t *testing.T) {
+		tokens := issueTokens(t, services.NewOAuthService(cfg, jwtService), "openid")
+		claims, err := jwtService.ValidateAccessToken(tokens.AccessToken)
+		require.NoError(t, err)
+		assert.Empty(t, claims.TenantID)
+	})
+}
+
+func TestTenantIssuers(t *testing.T) {
+	const (
+		issuerA = "https://auth.example.com/tenants/a"
+		issuerB = "https://auth.example.com/tenants/b"
+	)
+
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	cfg.JWT.TenantIssuers = map[string]string{"tenant-a": issuerA, "tenant-b": issuerB}
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+
+	tokenA, err := jwtService.GenerateAccessTokenWithTenant("demo-user", "test-client", "openid", "tenant-a")
+	require.NoError(t, err)
+
+	t.Run("Tokens carry the tenant's issuer", func(t *testing.T) {
+		assert.Equal(t, issuerA, tokenClaims(t, tokenA)["iss"])
+
+		claims, err := jwtService.ValidateAccessTokenForTenant(tokenA, "tenant-a")
+		require.NoError(t, err)
+		assert.Equal(t, issuerA, claims.Issuer)
+	})
+
+	t.Run("Tenant A token is refused for tenant B", func(t *testing.T) {
+		_, err := jwtService.ValidateAccessTokenForTenant(tokenA, "tenant-b")
+		assert.ErrorIs(t, err, services.ErrTenantMismatch)
+	})
+
+	t.Run("Tenant A token is refused where tenant B's issuer is expected", func(t *testing.T) {
+		misrouted := newTestConfig()
+		misrouted.JWT.TenantIssuers = map[string]string{"tenant-a": issuerB}
+		_, err := services.NewJWTService(fake.newClient(), misrouted).ValidateAccessToken(tokenA)
+		assert.ErrorContains(t, err, "invalid issuer")
+
+		// Nor is it accepted under the shared issuer
+		_, err = services.NewJWTService(fake.newClient(), newTestConfig()).ValidateAccessToken(tokenA)
+		assert.ErrorContains(t, err, "invalid issuer")
+	})
+
+	t.Run("Unmapped tenants use the shared issuer", func(t *testing.T) {
+		token, err := jwtService.GenerateAccessTokenWithTenant("demo-user", "test-client", "openid", "tenant-c")
+		require.NoError(t, err)
+		assert.Equal(t, cfg.JWT.Issuer, tokenClaims(t, token)["iss"])
+
+		_, err = jwtService.ValidateAccessTokenForTenant(token, "tenant-c")
+		assert.NoError(t, err)
+	})
+
+	t.Run("Delegated tokens keep the tenant's issuer", func(t *testing.T) {
+		claims, err := jwtService.ValidateAccessToken(tokenA)
+		require.NoError(t, err)
+		delegated, err := jwtService.GenerateDelegatedToken(claims, "backend", "openid")
+		require.NoError(t, err)
+		assert.Equal(t, issuerA, tokenClaims(t, delegated)["iss"])
+	})
+
+	t.Run("Loaded from the environment", func(t *testing.T) {
+		t.Setenv("JWT_TENANT_ISSUERS", "tenant-a="+issuerA+", tenant-b="+issuerB+",broken")
+
+		loaded := config.Load()
+		assert.Equal(t, map[string]string{"tenant-a": issuerA, "tenant-b": issuerB}, loaded.JWT.TenantIssuers)
+		assert.Equal(t, issuerB, loaded.JWT.IssuerForTenant("tenant-b"))
+		assert.Equal(t, loaded.JWT.EffectiveIssuer(), loaded.JWT.IssuerForTenant(""))
+	})
+}
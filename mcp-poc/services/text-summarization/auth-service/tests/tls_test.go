@@ -0,0 +1,245 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/config"
+	"auth-service/internal/middleware"
+)
+
+// writeTestCertificate writes a self-signed server certificate and key
+func writeTestCertificate(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "auth-service"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "server.crt")
+	keyFile = filepath.Join(dir, "server.key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+// testCA issues certificates for mTLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a certificate for commonName usable for usage
+func (ca *testCA) issue(t *testing.T, commonName string, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// writeFiles writes cert and its key as PEM files, and the CA certificate
+func (ca *testCA) writeFiles(t *testing.T, cert tls.Certificate) (certFile, keyFile, caCertFile string) {
+	t.Helper()
+
+	keyDER, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "server.crt")
+	keyFile = filepath.Join(dir, "server.key")
+	caCertFile = filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	require.NoError(t, os.WriteFile(caCertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600))
+	return certFile, keyFile, caCertFile
+}
+
+func TestOptionalClientCertificates(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile, caCertFile := ca.writeFiles(t, ca.issue(t, "auth-service", x509.ExtKeyUsageServerAuth))
+	clientCert := ca.issue(t, "resource-server", x509.ExtKeyUsageClientAuth)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	router := mux.NewRouter()
+	router.Handle("/public", ok)
+	router.Handle("/mtls", middleware.MTLSAuthMiddleware(ca.pool)(ok))
+
+	serve := func(t *testing.T, opts ...middleware.TLSOption) *httptest.Server {
+		tlsConfig, err := middleware.CreateTLSConfig(certFile, keyFile, caCertFile, opts...)
+		require.NoError(t, err)
+
+		server := httptest.NewUnstartedServer(router)
+		server.TLS = tlsConfig
+		// Refused handshakes are expected
+		server.Config.ErrorLog = log.New(io.Discard, "", 0)
+		server.StartTLS()
+		t.Cleanup(server.Close)
+		return server
+	}
+
+	client := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      ca.pool,
+			Certificates: certs,
+		}}}
+	}
+
+	get := func(t *testing.T, client *http.Client, url string) int {
+		resp, err := client.Get(url)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	t.Run("Optional", func(t *testing.T) {
+		opts, err := middleware.TLSOptions(config.ServerConfig{TLSOptionalClientCert: true})
+		require.NoError(t, err)
+		server := serve(t, opts...)
+
+		assert.Equal(t, http.StatusOK, get(t, client(), server.URL+"/public"))
+		assert.Equal(t, http.StatusUnauthorized, get(t, client(), server.URL+"/mtls"))
+		assert.Equal(t, http.StatusOK, get(t, client(clientCert), server.URL+"/mtls"))
+
+		// A certificate for servers can't authenticate a client
+		_, err = client(ca.issue(t, "impostor", x509.ExtKeyUsageServerAuth)).Get(server.URL + "/mtls")
+		assert.Error(t, err)
+	})
+
+	t.Run("Required", func(t *testing.T) {
+		server := serve(t)
+
+		_, err := client().Get(server.URL + "/public")
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusOK, get(t, client(clientCert), server.URL+"/public"))
+	})
+}
+
+func TestCreateTLSConfig(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+
+	t.Run("Defaults", func(t *testing.T) {
+		tlsConfig, err := middleware.CreateTLSConfig(certFile, keyFile, "")
+		require.NoError(t, err)
+		assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+		assert.Len(t, tlsConfig.CipherSuites, 4)
+		assert.Nil(t, tlsConfig.NextProtos)
+		assert.Equal(t, tls.RequireAndVerifyClientCert, tlsConfig.ClientAuth)
+	})
+
+	t.Run("TLS 1.3 only", func(t *testing.T) {
+		opts, err := middleware.TLSOptions(config.ServerConfig{
+			TLSMinVersion:   "1.3",
+			TLSCipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+			TLSNextProtos:   []string{"http/1.1"},
+		})
+		require.NoError(t, err)
+
+		tlsConfig, err := middleware.CreateTLSConfig(certFile, keyFile, "", opts...)
+		require.NoError(t, err)
+		assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion)
+		assert.Empty(t, tlsConfig.CipherSuites)
+		assert.Equal(t, []string{"http/1.1"}, tlsConfig.NextProtos)
+	})
+
+	t.Run("Configured cipher suites", func(t *testing.T) {
+		opts, err := middleware.TLSOptions(config.ServerConfig{
+			TLSMinVersion:   "1.2",
+			TLSCipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+			TLSNextProtos:   []string{"h2", "http/1.1"},
+		})
+		require.NoError(t, err)
+
+		tlsConfig, err := middleware.CreateTLSConfig(certFile, keyFile, "", opts...)
+		require.NoError(t, err)
+		assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+		assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, tlsConfig.CipherSuites)
+		assert.Equal(t, []string{"h2", "http/1.1"}, tlsConfig.NextProtos)
+	})
+
+	t.Run("Invalid settings are rejected", func(t *testing.T) {
+		_, err := middleware.TLSOptions(config.ServerConfig{TLSMinVersion: "1.0"})
+		assert.ErrorContains(t, err, "unsupported TLS version")
+
+		// Suites crypto/tls considers insecure are not accepted
+		_, err = middleware.TLSOptions(config.ServerConfig{TLSCipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}})
+		assert.ErrorContains(t, err, "unsupported cipher suite")
+	})
+
+	t.Run("Loaded from the environment", func(t *testing.T) {
+		t.Setenv("TLS_MIN_VERSION", "1.3")
+		t.Setenv("TLS_NEXT_PROTOS", "http/1.1")
+
+		cfg := config.Load()
+		assert.Equal(t, "1.3", cfg.Server.TLSMinVersion)
+		assert.Empty(t, cfg.Server.TLSCipherSuites)
+		assert.Equal(t, []string{"http/1.1"}, cfg.Server.TLSNextProtos)
+	})
+}
@@ -0,0 +1,188 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+)
+
+func TestTokenExchange(t *testing.T) {
+	const summarizer = "https://summarizer.example.com"
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	cfg.OAuth.AllowedResources = []string{summarizer}
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+	defer oauthService.Stop()
+
+	userTokens := issueTokens(t, oauthService, "openid profile email")
+
+	exchange := func(subjectToken, scope, audience string) (*models.TokenResponse, *models.ErrorResponse) {
+		return oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:        services.GrantTypeTokenExchange,
+			ClientID:         "test-client",
+			SubjectToken:     subjectToken,
+			SubjectTokenType: services.TokenTypeAccessToken,
+			Scope:            scope,
+			Audience:         audience,
+		})
+	}
+
+	t.Run("Downscoped for another audience", func(t *testing.T) {
+		tokenResp, errorResp := exchange(userTokens.AccessToken, "profile", summarizer)
+		require.Nil(t, errorResp)
+		assert.Equal(t, "profile", tokenResp.Scope)
+		assert.Equal(t, services.TokenTypeAccessToken, tokenResp.IssuedTokenType)
+		assert.Empty(t, tokenResp.RefreshToken)
+
+		claims, err := jwtService.ValidateAccessTokenForAudience(tokenResp.AccessToken, summarizer)
+		require.NoError(t, err)
+		assert.Equal(t, "profile", claims.Scope)
+		require.NotNil(t, claims.Act)
+		assert.Equal(t, "test-client", claims.Act.Subject)
+		assert.Nil(t, claims.Act.Act)
+
+		// Not usable against this service's own audience
+		_, err = jwtService.ValidateAccessToken(tokenResp.AccessToken)
+		assert.Error(t, err)
+	})
+
+	t.Run("Keeps scope and audience by default", func(t *testing.T) {
+		tokenResp, errorResp := exchange(userTokens.AccessToken, "", "")
+		require.Nil(t, errorResp)
+		assert.Equal(t, "openid profile email", tokenResp.Scope)
+
+		claims, err := jwtService.ValidateAccessToken(tokenResp.AccessToken)
+		require.NoError(t, err)
+
+		// Exchanging again nests the previous actor
+		again, errorResp := exchange(tokenResp.AccessToken, "email", "")
+		require.Nil(t, errorResp)
+		nested, err := jwtService.ValidateAccessToken(again.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, claims.Subject, nested.Subject)
+		require.NotNil(t, nested.Act)
+		require.NotNil(t, nested.Act.Act)
+		assert.Equal(t, "test-client", nested.Act.Act.Subject)
+	})
+
+	t.Run("Unknown audience", func(t *testing.T) {
+		for _, audience := range []string{"https://evil.example.com", "summarizer"} {
+			_, errorResp := exchange(userTokens.AccessToken, "profile", audience)
+			require.NotNil(t, errorResp, audience)
+			assert.Equal(t, "invalid_target", errorResp.Error)
+		}
+	})
+
+	t.Run("Scope cannot be widened", func(t *testing.T) {
+		narrow, errorResp := exchange(userTokens.AccessToken, "profile", "")
+		require.Nil(t, errorResp)
+
+		_, errorResp = exchange(narrow.AccessToken, "profile email", "")
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_scope", errorResp.Error)
+	})
+
+	t.Run("Invalid subject token", func(t *testing.T) {
+		_, errorResp := exchange("not-a-jwt", "", "")
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_grant", errorResp.Error)
+
+		tampered := userTokens.AccessToken[:len(userTokens.AccessToken)-4] + "AAAA"
+		_, errorResp = exchange(tampered, "", "")
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_grant", errorResp.Error)
+	})
+
+	t.Run("Requested token type", func(t *testing.T) {
+		req := &models.TokenRequest{
+			GrantType:          services.GrantTypeTokenExchange,
+			ClientID:           "test-client",
+			SubjectToken:       userTokens.AccessToken,
+			SubjectTokenType:   services.TokenTypeAccessToken,
+			RequestedTokenType: services.TokenTypeAccessToken,
+		}
+		_, errorResp := oauthService.HandleTokenRequest(req)
+		require.Nil(t, errorResp)
+
+		req.RequestedTokenType = "urn:ietf:params:oauth:token-type:refresh_token"
+		_, errorResp = oauthService.HandleTokenRequest(req)
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_request", errorResp.Error)
+	})
+
+	t.Run("Unsupported subject token type", func(t *testing.T) {
+		_, errorResp := oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:        services.GrantTypeTokenExchange,
+			ClientID:         "test-client",
+			SubjectToken:     userTokens.RefreshToken,
+			SubjectTokenType: "urn:ietf:params:oauth:token-type:refresh_token",
+		})
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_request", errorResp.Error)
+	})
+}
+
+func TestTokenExchangeResources(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	cfg.OAuth.AllowedResources = []string{"https://summarizer.example.com", "https://search.example.com"}
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+	defer oauthService.Stop()
+
+	userTokens := issueTokens(t, oauthService, "openid profile")
+
+	exchange := func(audience string, resources ...string) (*models.TokenResponse, *models.ErrorResponse) {
+		return oauthService.HandleTokenRequest(&models.TokenRequest{
+			GrantType:        services.GrantTypeTokenExchange,
+			ClientID:         "test-client",
+			SubjectToken:     userTokens.AccessToken,
+			SubjectTokenType: services.TokenTypeAccessToken,
+			Audience:         audience,
+			Resources:        resources,
+		})
+	}
+
+	t.Run("Token is for the requested resources", func(t *testing.T) {
+		tokenResp, errorResp := exchange("", "https://summarizer.example.com")
+		require.Nil(t, errorResp)
+
+		claims := tokenClaims(t, tokenResp.AccessToken)
+		assert.Equal(t, []interface{}{"https://summarizer.example.com"}, claims["aud"])
+		assert.Equal(t, "test-client", claims["act"].(map[string]interface{})["sub"])
+	})
+
+	t.Run("Audience and resources combine", func(t *testing.T) {
+		tokenResp, errorResp := exchange("api", "https://search.example.com")
+		require.Nil(t, errorResp)
+
+		claims := tokenClaims(t, tokenResp.AccessToken)
+		assert.Equal(t, []interface{}{"api", "https://search.example.com"}, claims["aud"])
+	})
+
+	t.Run("Unknown audience alongside a resource", func(t *testing.T) {
+		_, errorResp := exchange("https://evil.example.com", "https://search.example.com")
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_target", errorResp.Error)
+	})
+
+	t.Run("Exchanged tokens introspect as active", func(t *testing.T) {
+		tokenResp, errorResp := exchange("https://summarizer.example.com", "https://search.example.com")
+		require.Nil(t, errorResp)
+
+		introspection, err := oauthService.IntrospectToken(tokenResp.AccessToken)
+		require.NoError(t, err)
+		assert.True(t, introspection.Active)
+	})
+
+	t.Run("Unknown resource", func(t *testing.T) {
+		_, errorResp := exchange("", "https://evil.example.com")
+		require.NotNil(t, errorResp)
+		assert.Equal(t, "invalid_target", errorResp.Error)
+	})
+}
@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/handlers"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+)
+
+func TestTokenRequestEncodings(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+	defer oauthService.Stop()
+	grantConsent(t, oauthService, "test-client", "openid profile")
+	handler := handlers.NewOAuthHandler(oauthService, jwtService)
+
+	authorize := func() string {
+		authCode, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+			UserID:              "demo-user",
+			ResponseType:        "code",
+			ClientID:            "test-client",
+			RedirectURI:         "http://localhost:3000/callback",
+			Scope:               "openid profile",
+			CodeChallenge:       testCodeChallenge,
+			CodeChallengeMethod: "S256",
+		})
+		require.Nil(t, errorResp)
+		return authCode.Code
+	}
+
+	token := func(contentType string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/token", bytes.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rec := httptest.NewRecorder()
+		handler.HandleToken(rec, req)
+		return rec
+	}
+
+	formToken := func(form url.Values) *httptest.ResponseRecorder {
+		return token("application/x-www-form-urlencoded", []byte(form.Encode()))
+	}
+
+	jsonToken := func(params map[string]string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(params)
+		require.NoError(t, err)
+		return token("application/json; charset=utf-8", body)
+	}
+
+	decode := func(rec *httptest.ResponseRecorder) *models.TokenResponse {
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var tokenResp models.TokenResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &tokenResp))
+		return &tokenResp
+	}
+
+	t.Run("JSON and form requests produce the same tokens", func(t *testing.T) {
+		fromForm := decode(formToken(url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {authorize()},
+			"redirect_uri":  {"http://localhost:3000/callback"},
+			"client_id":     {"test-client"},
+			"code_verifier": {testCodeVerifier},
+		}))
+		fromJSON := decode(jsonToken(map[string]string{
+			"grant_type":    "authorization_code",
+			"code":          authorize(),
+			"redirect_uri":  "http://localhost:3000/callback",
+			"client_id":     "test-client",
+			"code_verifier": testCodeVerifier,
+		}))
+
+		assert.Equal(t, fromForm.TokenType, fromJSON.TokenType)
+		assert.Equal(t, fromForm.ExpiresIn, fromJSON.ExpiresIn)
+		assert.Equal(t, fromForm.Scope, fromJSON.Scope)
+		assert.NotEmpty(t, fromJSON.RefreshToken)
+		assert.NotEmpty(t, fromJSON.IDToken)
+
+		formClaims, err := jwtService.ValidateAccessToken(fromForm.AccessToken)
+		require.NoError(t, err)
+		jsonClaims, err := jwtService.ValidateAccessToken(fromJSON.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, formClaims.Subject, jsonClaims.Subject)
+		assert.Equal(t, formClaims.ClientID, jsonClaims.ClientID)
+		assert.Equal(t, formClaims.Scope, jsonClaims.Scope)
+
+		// Refresh grants accept JSON too
+		refreshed := decode(jsonToken(map[string]string{
+			"grant_type":    "refresh_token",
+			"refresh_token": fromJSON.RefreshToken,
+			"client_id":     "test-client",
+		}))
+		assert.Equal(t, fromJSON.Scope, refreshed.Scope)
+	})
+
+	t.Run("JSON request with Basic client credentials", func(t *testing.T) {
+		body, err := json.Marshal(map[string]string{
+			"grant_type":    "authorization_code",
+			"code":          authorize(),
+			"redirect_uri":  "http://localhost:3000/callback",
+			"code_verifier": testCodeVerifier,
+		})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/token", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("test-client", "")
+		rec := httptest.NewRecorder()
+		handler.HandleToken(rec, req)
+		decode(rec)
+	})
+
+	t.Run("Malformed or unsupported bodies", func(t *testing.T) {
+		for name, rec := range map[string]*httptest.ResponseRecorder{
+			"invalid JSON":         token("application/json", []byte(`{"grant_type":`)),
+			"JSON array":           token("application/json", []byte(`["authorization_code"]`)),
+			"non-string field":     token("application/json", []byte(`{"grant_type":"refresh_token","scope":["openid"]}`)),
+			"unsupported type":     token("text/plain", []byte("grant_type=refresh_token")),
+			"invalid content type": token("application/", []byte(`{}`)),
+		} {
+			assert.Equal(t, http.StatusBadRequest, rec.Code, name)
+			var errorResp models.ErrorResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &errorResp), name)
+			assert.Equal(t, "invalid_request", errorResp.Error, name)
+		}
+	})
+
+	t.Run("Missing parameters in JSON", func(t *testing.T) {
+		rec := token("application/json", []byte(`{"grant_type":"authorization_code"}`))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Missing required parameters")
+	})
+}
@@ -0,0 +1,216 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/handlers"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+)
+
+func TestHandleUserInfo(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	oauthService := services.NewOAuthService(cfg, jwtService)
+	handler := handlers.NewOAuthHandler(oauthService, jwtService)
+
+	userInfo := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/userinfo", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.HandleUserInfo(rec, req)
+		return rec
+	}
+
+	t.Run("Valid openid token", func(t *testing.T) {
+		tokens := issueTokens(t, oauthService, "openid profile")
+
+		rec := userInfo(tokens.AccessToken)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var resp models.UserInfoResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, "demo-user", resp.Sub)
+	})
+
+	t.Run("Token without openid scope", func(t *testing.T) {
+		tokens := issueTokens(t, oauthService, "profile")
+
+		rec := userInfo(tokens.AccessToken)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Contains(t, rec.Header().Get("WWW-Authenticate"), `error="insufficient_scope"`)
+	})
+
+	t.Run("Expired token", func(t *testing.T) {
+		token, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+		require.NoError(t, err)
+
+		expiredCfg := newTestConfig()
+		expiredCfg.JWT.TokenExpiration = -time.Minute
+		expiredToken, err := services.NewJWTService(fake.newClient(), expiredCfg).GenerateAccessToken("demo-user", "test-client", "openid")
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, userInfo(token).Code)
+
+		rec := userInfo(expiredToken)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+	})
+
+	t.Run("Missing bearer token", func(t *testing.T) {
+		rec := userInfo("")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Header().Get("WWW-Authenticate"), "Bearer")
+	})
+}
+
+func TestUserInfoClaims(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	verified := true
+	oauthService := services.NewOAuthService(cfg, jwtService,
+		services.WithUserInfoProvider(services.UserInfoProviderFunc(func(userID string) (*models.UserInfoResponse, error) {
+			if userID != "demo-user" {
+				return nil, services.ErrUserNotFound
+			}
+			return &models.UserInfoResponse{
+				Name:              "Demo User",
+				GivenName:         "Demo",
+				FamilyName:        "User",
+				PreferredUsername: "demo",
+				Email:             "demo@example.com",
+				EmailVerified:     &verified,
+			}, nil
+		})))
+	handler := handlers.NewOAuthHandler(oauthService, jwtService)
+
+	userInfo := func(t *testing.T, token string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodGet, "/userinfo", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.HandleUserInfo(rec, req)
+
+		var claims map[string]interface{}
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &claims))
+		}
+		return rec, claims
+	}
+
+	t.Run("Profile and email scopes", func(t *testing.T) {
+		tokens := issueTokens(t, oauthService, "openid profile email")
+
+		rec, claims := userInfo(t, tokens.AccessToken)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, map[string]interface{}{
+			"sub":                "demo-user",
+			"name":               "Demo User",
+			"given_name":         "Demo",
+			"family_name":        "User",
+			"preferred_username": "demo",
+			"email":              "demo@example.com",
+			"email_verified":     true,
+		}, claims)
+	})
+
+	t.Run("Profile scope only", func(t *testing.T) {
+		tokens := issueTokens(t, oauthService, "openid profile")
+
+		rec, claims := userInfo(t, tokens.AccessToken)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "Demo User", claims["name"])
+		assert.NotContains(t, claims, "email")
+		assert.NotContains(t, claims, "email_verified")
+	})
+
+	t.Run("Openid scope only", func(t *testing.T) {
+		tokens := issueTokens(t, oauthService, "openid")
+
+		rec, claims := userInfo(t, tokens.AccessToken)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, map[string]interface{}{"sub": "demo-user"}, claims)
+	})
+
+	t.Run("Unknown user", func(t *testing.T) {
+		token, err := jwtService.GenerateAccessToken("deleted-user", "test-client", "openid profile")
+		require.NoError(t, err)
+
+		rec, _ := userInfo(t, token)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+	})
+
+	t.Run("Malformed token", func(t *testing.T) {
+		rec, _ := userInfo(t, "not-a-jwt")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+	})
+}
+
+func TestIDTokenUserClaims(t *testing.T) {
+	fake := newFakeVault(t)
+	cfg := newTestConfig()
+	jwtService := services.NewJWTService(fake.newClient(), cfg)
+	verified := false
+	oauthService := services.NewOAuthService(cfg, jwtService,
+		services.WithUserInfoProvider(services.UserInfoProviderFunc(func(userID string) (*models.UserInfoResponse, error) {
+			return &models.UserInfoResponse{
+				Sub:           "ignored",
+				Name:          "Demo User",
+				Email:         "demo@example.com",
+				EmailVerified: &verified,
+			}, nil
+		})))
+
+	t.Run("Profile and email scopes", func(t *testing.T) {
+		claims := tokenClaims(t, issueTokens(t, oauthService, "openid profile email").IDToken)
+		assert.Equal(t, "demo-user", claims["sub"])
+		assert.Equal(t, "Demo User", claims["name"])
+		assert.Equal(t, "demo@example.com", claims["email"])
+		assert.Equal(t, false, claims["email_verified"])
+		assert.Equal(t, []interface{}{"test-client"}, claims["aud"])
+	})
+
+	t.Run("Profile scope only", func(t *testing.T) {
+		claims := tokenClaims(t, issueTokens(t, oauthService, "openid profile").IDToken)
+		assert.Equal(t, "Demo User", claims["name"])
+		assert.NotContains(t, claims, "email")
+		assert.NotContains(t, claims, "email_verified")
+	})
+
+	t.Run("Email scope only", func(t *testing.T) {
+		claims := tokenClaims(t, issueTokens(t, oauthService, "openid email").IDToken)
+		assert.Equal(t, "demo@example.com", claims["email"])
+		assert.NotContains(t, claims, "name")
+	})
+
+	t.Run("Openid scope only", func(t *testing.T) {
+		claims := tokenClaims(t, issueTokens(t, oauthService, "openid").IDToken)
+		assert.NotContains(t, claims, "name")
+		assert.NotContains(t, claims, "email")
+	})
+
+	t.Run("Provider failure still issues an ID token", func(t *testing.T) {
+		failing := services.NewOAuthService(cfg, jwtService,
+			services.WithUserInfoProvider(services.UserInfoProviderFunc(func(userID string) (*models.UserInfoResponse, error) {
+				return nil, services.ErrUserNotFound
+			})))
+
+		tokens := issueTokens(t, failing, "openid profile")
+		require.NotEmpty(t, tokens.IDToken)
+		claims := tokenClaims(t, tokens.IDToken)
+		assert.Equal(t, "demo-user", claims["sub"])
+		assert.NotContains(t, claims, "name")
+	})
+}
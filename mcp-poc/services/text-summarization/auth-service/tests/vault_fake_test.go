@@ -0,0 +1,582 @@
+package tests
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/config"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+	"auth-service/pkg/vault"
+)
+
+const testTransitKey = "jwt-signing-key"
+
+// fakeVault emulates the subset of the Vault transit engine used by the
+// auth service, signing with real RSA or ECDSA keys so tokens can be verified.
+type fakeVault struct {
+	t       testing.TB
+	server  *httptest.Server
+	mutex   sync.Mutex
+	keyType string
+	keys    map[int]crypto.Signer
+	created map[int]time.Time
+	latest  int
+
+	// minDecryptionVersion marks older versions as retired
+	minDecryptionVersion int
+
+	// verifyCalls counts requests to the verify endpoint
+	verifyCalls int
+
+	// unavailable makes every request fail, simulating a Vault outage
+	unavailable bool
+
+	// sealed is reported by the health endpoint
+	sealed bool
+
+	// failures holds statuses to fail the next requests with, in order
+	failures []int
+
+	// requests counts every request received
+	requests int
+
+	// denied holds paths the token lacks permission for
+	denied map[string]bool
+
+	// token, when set, is the only token accepted; requests with any other
+	// are refused with 403
+	token string
+
+	// signaturePrefix, when set, replaces the "vault:v<version>:" prefix of
+	// signatures
+	signaturePrefix string
+}
+
+// newFakeVault returns a fake whose transit key already exists as rsa-2048
+func newFakeVault(t testing.TB) *fakeVault {
+	t.Helper()
+
+	f := newEmptyFakeVault(t)
+	f.keyType = "rsa-2048"
+	f.addKeyVersion()
+	return f
+}
+
+// newEmptyFakeVault returns a fake with no transit key, so the client under
+// test creates it with the type it asks for
+func newEmptyFakeVault(t testing.TB) *fakeVault {
+	t.Helper()
+
+	f := &fakeVault{
+		t:                    t,
+		keys:                 make(map[int]crypto.Signer),
+		created:              make(map[int]time.Time),
+		minDecryptionVersion: 1,
+	}
+
+	f.server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.server.Close)
+
+	return f
+}
+
+// newClient returns a vault.Client talking to the fake server
+func (f *fakeVault) newClient(opts ...vault.Option) *vault.Client {
+	f.t.Helper()
+
+	client, err := vault.NewClient(f.server.URL, "test-token", testTransitKey, opts...)
+	require.NoError(f.t, err)
+	return client
+}
+
+func (f *fakeVault) addKeyVersion() {
+	var key crypto.Signer
+	var err error
+	switch f.keyType {
+	case "rsa-2048":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ecdsa-p256":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		err = fmt.Errorf("unsupported key type %q", f.keyType)
+	}
+	require.NoError(f.t, err)
+
+	f.latest++
+	f.keys[f.latest] = key
+	f.created[f.latest] = time.Now()
+}
+
+// recreateKey replaces the transit key with a new one, as if it had been
+// deleted and created again, so version 1 now names a different key
+func (f *fakeVault) recreateKey() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.keys = make(map[int]crypto.Signer)
+	f.created = make(map[int]time.Time)
+	f.latest = 0
+	f.addKeyVersion()
+}
+
+// verifyRequests returns how many times the verify endpoint was called
+func (f *fakeVault) verifyRequests() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.verifyCalls
+}
+
+// setUnavailable toggles the simulated Vault outage
+func (f *fakeVault) setUnavailable(unavailable bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.unavailable = unavailable
+}
+
+// setSealed changes what the health endpoint reports for the seal status
+func (f *fakeVault) setSealed(sealed bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.sealed = sealed
+}
+
+// setSignaturePrefix makes signatures start with prefix instead of
+// "vault:v<version>:", or restores that format when prefix is empty
+func (f *fakeVault) setSignaturePrefix(prefix string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.signaturePrefix = prefix
+}
+
+// failNext makes the next count requests fail with status
+func (f *fakeVault) failNext(count, status int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for i := 0; i < count; i++ {
+		f.failures = append(f.failures, status)
+	}
+}
+
+// deny makes requests for path fail with 403, as for a token whose policy
+// doesn't cover it
+func (f *fakeVault) deny(path string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.denied == nil {
+		f.denied = make(map[string]bool)
+	}
+	f.denied[path] = true
+}
+
+// acceptOnly makes the fake refuse every token but token, as when a token
+// is rotated and the old one revoked
+func (f *fakeVault) acceptOnly(token string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.token = token
+}
+
+// requestCount returns how many requests the fake has received
+func (f *fakeVault) requestCount() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.requests
+}
+
+// latestVersion returns the newest key version, safe to call while the
+// client under test is talking to the fake
+func (f *fakeVault) latestVersion() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.latest
+}
+
+func (f *fakeVault) handle(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.requests++
+	if len(f.failures) > 0 {
+		status := f.failures[0]
+		f.failures = f.failures[1:]
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	if f.unavailable {
+		http.Error(w, "vault is sealed", http.StatusServiceUnavailable)
+		return
+	}
+
+	if f.token != "" && r.Header.Get("X-Vault-Token") != f.token {
+		http.Error(w, "permission denied", http.StatusForbidden)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	if f.denied[path] {
+		http.Error(w, "permission denied", http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case path == "sys/health":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"initialized": true,
+			"sealed":      f.sealed,
+			"standby":     false,
+		})
+	case path == "transit/keys/"+testTransitKey && r.Method == http.MethodGet:
+		if f.latest == 0 {
+			// Vault answers reads of missing paths with an empty error list
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		f.writeData(w, f.keysResponse())
+	case path == "transit/keys/"+testTransitKey:
+		f.handleCreate(w, r)
+	case path == "transit/keys/"+testTransitKey+"/rotate":
+		f.addKeyVersion()
+		w.WriteHeader(http.StatusNoContent)
+	case path == "transit/sign/"+testTransitKey:
+		f.handleSign(w, r)
+	case path == "transit/verify/"+testTransitKey:
+		f.handleVerify(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeVault) keysResponse() map[string]interface{} {
+	keys := make(map[string]interface{}, len(f.keys))
+	for version, key := range f.keys {
+		if version < f.minDecryptionVersion {
+			continue
+		}
+		der, err := x509.MarshalPKIXPublicKey(key.Public())
+		require.NoError(f.t, err)
+
+		keys[strconv.Itoa(version)] = map[string]interface{}{
+			"name":          f.keyType,
+			"creation_time": f.created[version].Format(time.RFC3339Nano),
+			"public_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		}
+	}
+
+	return map[string]interface{}{
+		"type":                   f.keyType,
+		"latest_version":         f.latest,
+		"min_decryption_version": f.minDecryptionVersion,
+		"keys":                   keys,
+	}
+}
+
+func (f *fakeVault) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Type string `json:"type"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Like Vault, creating an existing key is a no-op
+	if f.latest == 0 {
+		f.keyType = body.Type
+		f.addKeyVersion()
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// signRequest holds the transit sign/verify parameters the fake honours
+type signRequest struct {
+	Input               string `json:"input"`
+	Signature           string `json:"signature"`
+	SignatureAlgorithm  string `json:"signature_algorithm"`
+	MarshalingAlgorithm string `json:"marshaling_algorithm"`
+	SaltLength          string `json:"salt_length"`
+	KeyVersion          int    `json:"key_version"`
+}
+
+// pssOptions returns the PSS salt length transit would use for the request
+func (r signRequest) pssOptions() *rsa.PSSOptions {
+	if r.SaltLength == "hash" {
+		return &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+	}
+	return nil
+}
+
+func (f *fakeVault) handleSign(w http.ResponseWriter, r *http.Request) {
+	var body signRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Like transit, take the input base64 encoded
+	input, err := base64.StdEncoding.DecodeString(body.Input)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Like transit, sign with the latest version unless one is named
+	version := f.latest
+	if body.KeyVersion != 0 {
+		version = body.KeyVersion
+	}
+	if _, ok := f.keys[version]; !ok {
+		http.Error(w, fmt.Sprintf("key version %d not found", version), http.StatusBadRequest)
+		return
+	}
+
+	digest := sha256.Sum256(input)
+	var signature []byte
+	switch key := f.keys[version].(type) {
+	case *rsa.PrivateKey:
+		if body.SignatureAlgorithm == "pkcs1v15" {
+			signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		} else {
+			// Vault defaults to PSS
+			signature, err = rsa.SignPSS(rand.Reader, key, crypto.SHA256, digest[:], body.pssOptions())
+		}
+	case *ecdsa.PrivateKey:
+		if body.MarshalingAlgorithm != "jws" {
+			signature, err = ecdsa.SignASN1(rand.Reader, key, digest[:])
+			break
+		}
+		var rInt, sInt *big.Int
+		rInt, sInt, err = ecdsa.Sign(rand.Reader, key, digest[:])
+		if err == nil {
+			signature = make([]byte, 64)
+			rInt.FillBytes(signature[:32])
+			sInt.FillBytes(signature[32:])
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	prefix := fmt.Sprintf("vault:v%d:", version)
+	if f.signaturePrefix != "" {
+		prefix = f.signaturePrefix
+	}
+	f.writeData(w, map[string]interface{}{
+		"signature":   prefix + base64.RawURLEncoding.EncodeToString(signature),
+		"key_version": version,
+	})
+}
+
+func (f *fakeVault) handleVerify(w http.ResponseWriter, r *http.Request) {
+	f.verifyCalls++
+
+	var body signRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Like transit, take the input base64 encoded and the signature as
+	// transit/sign returns it, naming the key version to check it with
+	input, err := base64.StdEncoding.DecodeString(body.Input)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	parts := strings.Split(body.Signature, ":")
+	if len(parts) != 3 || parts[0] != "vault" || !strings.HasPrefix(parts[1], "v") {
+		http.Error(w, "invalid signature format", http.StatusBadRequest)
+		return
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(parts[1], "v"))
+	if err != nil {
+		http.Error(w, "invalid signature format", http.StatusBadRequest)
+		return
+	}
+	key, ok := f.keys[version]
+	if !ok {
+		http.Error(w, fmt.Sprintf("key version %d not found", version), http.StatusBadRequest)
+		return
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	digest := sha256.Sum256(input)
+	f.writeData(w, map[string]interface{}{"valid": verifySignature(key.Public(), body, digest[:], signature)})
+}
+
+// verifySignature checks a JWS signature the way transit/verify would for the
+// given parameters
+func verifySignature(publicKey crypto.PublicKey, params signRequest, digest, signature []byte) bool {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		if params.SignatureAlgorithm == "pkcs1v15" {
+			return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, signature) == nil
+		}
+		return rsa.VerifyPSS(key, crypto.SHA256, digest, signature, params.pssOptions()) == nil
+	case *ecdsa.PublicKey:
+		if params.MarshalingAlgorithm != "jws" {
+			return ecdsa.VerifyASN1(key, digest, signature)
+		}
+		if len(signature) != 64 {
+			return false
+		}
+		rInt := new(big.Int).SetBytes(signature[:32])
+		sInt := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, digest, rInt, sInt)
+	}
+	return false
+}
+
+func (f *fakeVault) writeData(w http.ResponseWriter, data map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+// newTestConfig returns a config suitable for running full OAuth flows
+func newTestConfig() *config.Config {
+	return &config.Config{
+		JWT: config.JWTConfig{
+			Issuer:           "https://auth-service",
+			Audience:         "api",
+			ValidateAudience: true,
+			Algorithm:        "RS256",
+			TokenExpiration:  time.Hour,
+			RefreshTokenTTL:  24 * time.Hour,
+		},
+		OAuth: config.OAuthConfig{
+			ClientID:        "test-client",
+			RedirectURIs:    []string{"http://localhost:3000/callback"},
+			SupportedScopes: []string{"openid", "profile", "email"},
+			CodeExpiration:  10 * time.Minute,
+			PKCERequired:    true,
+		},
+	}
+}
+
+const (
+	testCodeVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testCodeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+// grantConsent records demo-user's approval of scope for clientID, so
+// authorization requests for it are issued a code
+func grantConsent(t *testing.T, oauthService *services.OAuthService, clientID, scope string) {
+	t.Helper()
+	require.NoError(t, oauthService.GrantConsent("demo-user", clientID, scope))
+}
+
+// issueTokens runs an authorization code flow, with demo-user approving
+// scope, and returns the token response
+func issueTokens(t *testing.T, oauthService *services.OAuthService, scope string) *models.TokenResponse {
+	t.Helper()
+
+	grantConsent(t, oauthService, "test-client", scope)
+	authCode, errorResp := oauthService.HandleAuthorizationRequest(&models.AuthorizationRequest{
+		UserID:              "demo-user",
+		ResponseType:        "code",
+		ClientID:            "test-client",
+		RedirectURI:         "http://localhost:3000/callback",
+		Scope:               scope,
+		CodeChallenge:       testCodeChallenge,
+		CodeChallengeMethod: "S256",
+	})
+	require.Nil(t, errorResp)
+
+	tokenResp, errorResp := oauthService.HandleTokenRequest(&models.TokenRequest{
+		GrantType:    "authorization_code",
+		Code:         authCode.Code,
+		RedirectURI:  authCode.RedirectURI,
+		ClientID:     authCode.ClientID,
+		CodeVerifier: testCodeVerifier,
+	})
+	require.Nil(t, errorResp)
+
+	return tokenResp
+}
+
+// signTestToken signs arbitrary claims through the Vault client the same way
+// JWTService does, for tests that need tokens the service would never mint
+func signTestToken(t *testing.T, client *vault.Client, claims map[string]interface{}) string {
+	t.Helper()
+
+	_, keyID, err := client.GetPublicKey()
+	require.NoError(t, err)
+
+	headerJSON, err := json.Marshal(map[string]interface{}{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	require.NoError(t, err)
+	claimsJSON, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	payload := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	signature, err := client.SignJWT([]byte(payload))
+	require.NoError(t, err)
+
+	return payload + "." + signature[strings.LastIndex(signature, ":")+1:]
+}
+
+// standardTestClaims returns valid registered claims for the test config
+func standardTestClaims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss": "https://auth-service",
+		"sub": "demo-user",
+		"aud": []string{"api"},
+		"exp": now.Add(time.Hour).Unix(),
+		"nbf": now.Unix(),
+		"iat": now.Unix(),
+		"jti": "test-jti",
+	}
+}
+
+// tokenKeyID returns the "kid" header of a signed token
+func tokenKeyID(t *testing.T, token string) string {
+	t.Helper()
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[0])
+	require.NoError(t, err)
+
+	var header struct {
+		KeyID string `json:"kid"`
+	}
+	require.NoError(t, json.Unmarshal(headerJSON, &header))
+	return header.KeyID
+}
+
+// tokenClaims returns the unverified claims of a signed token
+func tokenClaims(t *testing.T, token string) map[string]interface{} {
+	t.Helper()
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[1])
+	require.NoError(t, err)
+
+	var claims map[string]interface{}
+	require.NoError(t, json.Unmarshal(claimsJSON, &claims))
+	return claims
+}
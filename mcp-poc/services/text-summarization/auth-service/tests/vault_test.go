@@ -0,0 +1,366 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"auth-service/internal/config"
+	"auth-service/internal/services"
+	"auth-service/pkg/metrics"
+	"auth-service/pkg/vault"
+)
+
+// countingObserver records vault.Client events for assertions
+type countingObserver struct {
+	mutex      sync.Mutex
+	hits       int
+	misses     int
+	operations map[string]int
+	failures   map[string]int
+}
+
+func (c *countingObserver) KeyCacheHit() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.hits++
+}
+
+func (c *countingObserver) KeyCacheMiss() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.misses++
+}
+
+func (c *countingObserver) VaultOperation(operation string, duration time.Duration, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.operations == nil {
+		c.operations = make(map[string]int)
+		c.failures = make(map[string]int)
+	}
+	c.operations[operation]++
+	if err != nil {
+		c.failures[operation]++
+	}
+}
+
+func TestGetPublicKeyCacheObserver(t *testing.T) {
+	fake := newFakeVault(t)
+	observer := &countingObserver{}
+	client := fake.newClient(vault.WithObserver(observer))
+
+	_, firstKeyID, err := client.GetPublicKey()
+	require.NoError(t, err)
+	assert.Equal(t, 0, observer.hits)
+	assert.Equal(t, 1, observer.misses)
+
+	_, secondKeyID, err := client.GetPublicKey()
+	require.NoError(t, err)
+	assert.Equal(t, 1, observer.hits)
+	assert.Equal(t, 1, observer.misses)
+	assert.Equal(t, firstKeyID, secondKeyID)
+}
+
+func TestGetPublicKeyConcurrentMissCountedOnce(t *testing.T) {
+	fake := newFakeVault(t)
+	observer := &countingObserver{}
+	client := fake.newClient(vault.WithObserver(observer))
+
+	const callers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := client.GetPublicKey()
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// Callers that waited on the write lock find the refreshed cache
+	assert.Equal(t, 1, observer.misses)
+	assert.Equal(t, callers-1, observer.hits)
+
+	// Rotation clears the cache, so the next lookup is a miss again
+	require.NoError(t, client.RotateKey())
+	_, _, err := client.GetPublicKey()
+	require.NoError(t, err)
+	assert.Equal(t, 2, observer.misses)
+}
+
+func TestVaultObserverRecordsMetrics(t *testing.T) {
+	fake := newFakeVault(t)
+	client := fake.newClient(vault.WithObserver(metrics.VaultObserver{}))
+
+	hits := testutil.ToFloat64(metrics.KeyCacheHits)
+	misses := testutil.ToFloat64(metrics.KeyCacheMisses)
+
+	_, _, err := client.GetPublicKey()
+	require.NoError(t, err)
+	_, _, err = client.GetPublicKey()
+	require.NoError(t, err)
+
+	assert.Equal(t, hits+1, testutil.ToFloat64(metrics.KeyCacheHits))
+	assert.Equal(t, misses+1, testutil.ToFloat64(metrics.KeyCacheMisses))
+}
+
+func TestVaultOperationObserver(t *testing.T) {
+	fake := newFakeVault(t)
+	observer := &countingObserver{}
+	client := fake.newClient(vault.WithObserver(observer))
+
+	token, err := services.NewJWTService(client, newTestConfig()).GenerateAccessToken("demo-user", "test-client", "openid")
+	require.NoError(t, err)
+	_, err = client.VerifyJWT(token)
+	require.NoError(t, err)
+	require.NoError(t, client.RotateKey())
+
+	assert.Equal(t, map[string]int{
+		vault.OperationGetPublicKey: 1,
+		vault.OperationSign:         1,
+		vault.OperationVerify:       1,
+		vault.OperationRotateKey:    1,
+	}, observer.operations)
+	assert.Empty(t, observer.failures)
+
+	fake.setUnavailable(true)
+	_, err = client.SignJWT([]byte("payload"))
+	require.Error(t, err)
+	assert.Equal(t, 1, observer.failures[vault.OperationSign])
+}
+
+func TestVaultObserverRecordsOperationMetrics(t *testing.T) {
+	fake := newFakeVault(t)
+	client := fake.newClient(vault.WithObserver(metrics.VaultObserver{}))
+	jwtService := services.NewJWTService(client, newTestConfig())
+
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(metrics.VaultOperationDuration))
+
+	// signSamples returns how many signing durations have been observed
+	signSamples := func() uint64 {
+		families, err := registry.Gather()
+		require.NoError(t, err)
+		for _, family := range families {
+			for _, metric := range family.GetMetric() {
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "operation" && label.GetValue() == vault.OperationSign {
+						return metric.GetHistogram().GetSampleCount()
+					}
+				}
+			}
+		}
+		return 0
+	}
+
+	samples := signSamples()
+	successes := testutil.ToFloat64(metrics.VaultOperations.WithLabelValues(vault.OperationSign, "success"))
+	failures := testutil.ToFloat64(metrics.VaultOperations.WithLabelValues(vault.OperationSign, "error"))
+
+	for i := 0; i < 3; i++ {
+		_, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, samples+3, signSamples())
+	assert.Equal(t, successes+3, testutil.ToFloat64(metrics.VaultOperations.WithLabelValues(vault.OperationSign, "success")))
+
+	fake.setUnavailable(true)
+	_, err := jwtService.GenerateAccessToken("demo-user", "test-client", "openid")
+	require.Error(t, err)
+	assert.Equal(t, samples+4, signSamples())
+	assert.Equal(t, failures+1, testutil.ToFloat64(metrics.VaultOperations.WithLabelValues(vault.OperationSign, "error")))
+}
+
+func TestGetPublicKeySelectsHighestVersion(t *testing.T) {
+	fake := newFakeVault(t)
+	for i := 0; i < 11; i++ {
+		fake.addKeyVersion()
+	}
+	require.Len(t, fake.keys, 12)
+
+	client := fake.newClient()
+	publicKey, keyID, err := client.GetPublicKey()
+	require.NoError(t, err)
+
+	assert.Equal(t, testTransitKey+"-v12", keyID)
+	assert.Equal(t, fake.keys[12].Public(), publicKey)
+}
+
+func TestGetJWKSIncludesAllKeyVersions(t *testing.T) {
+	fake := newFakeVault(t)
+	client := fake.newClient()
+
+	_, previousKeyID, err := client.GetPublicKey()
+	require.NoError(t, err)
+
+	require.NoError(t, client.RotateKey())
+
+	_, currentKeyID, err := client.GetPublicKey()
+	require.NoError(t, err)
+	require.NotEqual(t, previousKeyID, currentKeyID)
+
+	jwks, err := client.GetJWKS()
+	require.NoError(t, err)
+	require.Len(t, jwks.Keys, 2)
+	assert.Equal(t, currentKeyID, jwks.Keys[0].KeyID)
+	assert.Equal(t, previousKeyID, jwks.Keys[1].KeyID)
+	assert.Equal(t, fake.keys[1].Public(), jwks.Keys[1].Key)
+
+	t.Run("Retired versions are omitted", func(t *testing.T) {
+		fake.mutex.Lock()
+		fake.minDecryptionVersion = 2
+		fake.mutex.Unlock()
+		require.NoError(t, client.RotateKey())
+
+		jwks, err := client.GetJWKS()
+		require.NoError(t, err)
+
+		var keyIDs []string
+		for _, key := range jwks.Keys {
+			keyIDs = append(keyIDs, key.KeyID)
+		}
+		assert.Equal(t, []string{testTransitKey + "-v3", testTransitKey + "-v2"}, keyIDs)
+	})
+}
+
+func TestVaultRetries(t *testing.T) {
+	fake := newFakeVault(t)
+	client := fake.newClient(vault.WithRetryPolicy(vault.RetryPolicy{
+		MaxRetries:     3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+		Timeout:        time.Second,
+	}))
+
+	t.Run("Transient failures", func(t *testing.T) {
+		fake.failNext(2, http.StatusServiceUnavailable)
+		requests := fake.requestCount()
+
+		signature, err := client.SignJWT([]byte("payload"))
+		require.NoError(t, err)
+		assert.NotEmpty(t, signature)
+		assert.Equal(t, requests+3, fake.requestCount())
+	})
+
+	t.Run("Retries exhausted", func(t *testing.T) {
+		token, err := services.NewJWTService(client, newTestConfig()).GenerateAccessToken("demo-user", "test-client", "openid")
+		require.NoError(t, err)
+		fake.failNext(4, http.StatusInternalServerError)
+		requests := fake.requestCount()
+
+		_, err = client.VerifyJWT(token)
+		assert.Error(t, err)
+		assert.Equal(t, requests+4, fake.requestCount())
+	})
+
+	t.Run("Permission denied is not retried", func(t *testing.T) {
+		fake.failNext(1, http.StatusForbidden)
+		requests := fake.requestCount()
+
+		_, err := client.SignJWT([]byte("payload"))
+		assert.ErrorContains(t, err, "403")
+		assert.Equal(t, requests+1, fake.requestCount())
+	})
+
+	t.Run("Rotation is not retried", func(t *testing.T) {
+		fake.failNext(1, http.StatusServiceUnavailable)
+		version := fake.latestVersion()
+		requests := fake.requestCount()
+
+		assert.Error(t, client.RotateKey())
+		assert.Equal(t, requests+1, fake.requestCount())
+		assert.Equal(t, version, fake.latestVersion())
+	})
+
+	t.Run("Timeout caps total retry time", func(t *testing.T) {
+		slow := fake.newClient(vault.WithRetryPolicy(vault.RetryPolicy{
+			MaxRetries:     10,
+			InitialBackoff: time.Second,
+			Timeout:        50 * time.Millisecond,
+		}))
+		fake.failNext(1, http.StatusBadGateway)
+
+		// The first backoff outlasts the timeout, so no retry is made
+		start := time.Now()
+		_, err := slow.SignJWT([]byte("payload"))
+		assert.Error(t, err)
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+}
+
+func TestVaultTokenFile(t *testing.T) {
+	fake := newFakeVault(t)
+	tokenFile := filepath.Join(t.TempDir(), "vault-token")
+	writeToken := func(token string) {
+		t.Helper()
+		// Written by rename, as Vault Agent does
+		tmp := tokenFile + ".tmp"
+		require.NoError(t, os.WriteFile(tmp, []byte(token+"\n"), 0o600))
+		require.NoError(t, os.Rename(tmp, tokenFile))
+	}
+
+	t.Run("Missing file", func(t *testing.T) {
+		_, err := vault.NewClient(fake.server.URL, "test-token", testTransitKey, vault.WithTokenFile(tokenFile))
+		assert.ErrorContains(t, err, "failed to read vault token file")
+	})
+
+	t.Run("Token is reloaded when the file changes", func(t *testing.T) {
+		writeToken("token-1")
+		fake.acceptOnly("token-1")
+		defer fake.acceptOnly("")
+
+		// The file takes precedence over the token passed in
+		client := fake.newClient(vault.WithTokenFile(tokenFile))
+		_, err := client.SignJWT([]byte("payload"))
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := client.WatchTokenFile(ctx, 10*time.Millisecond)
+
+		// The old token is revoked as soon as the new one is written
+		fake.acceptOnly("token-2")
+		writeToken("token-2")
+		assert.Eventually(t, func() bool {
+			_, err := client.SignJWT([]byte("payload"))
+			return err == nil
+		}, 2*time.Second, 20*time.Millisecond)
+
+		// An emptied file keeps the current token
+		require.NoError(t, os.WriteFile(tokenFile, nil, 0o600))
+		time.Sleep(50 * time.Millisecond)
+		_, err = client.SignJWT([]byte("payload"))
+		assert.NoError(t, err)
+
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("token file watcher did not stop")
+		}
+	})
+
+	t.Run("Nothing to watch", func(t *testing.T) {
+		_, open := <-fake.newClient().WatchTokenFile(context.Background(), time.Second)
+		assert.False(t, open)
+	})
+
+	t.Run("Loaded from the environment", func(t *testing.T) {
+		t.Setenv("VAULT_TOKEN_FILE", tokenFile)
+		t.Setenv("VAULT_TOKEN_FILE_INTERVAL", "5s")
+
+		cfg := config.Load()
+		assert.Equal(t, tokenFile, cfg.Vault.TokenFile)
+		assert.Equal(t, 5*time.Second, cfg.Vault.TokenFileInterval)
+	})
+}